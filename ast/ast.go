@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"monkey/token"
+	"reflect"
 	"strings"
 )
 
@@ -25,6 +26,7 @@ type Expression interface {
 // Program is the root node of every AST our parser produces
 type Program struct {
 	Statements []Statement
+	Resolved   bool // Resolved is set once the evaluator resolved the identifiers of the program
 }
 
 func (p *Program) String() string {
@@ -40,6 +42,9 @@ func (p *Program) String() string {
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
+	if ls.Exported {
+		out.WriteString("export ")
+	}
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
 	out.WriteString(" = ")
@@ -53,6 +58,60 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+func (ds *DestructureStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ds.TokenLiteral() + " ")
+	out.WriteString(ds.Pattern())
+	out.WriteString(" = ")
+
+	if ds.Value != nil {
+		out.WriteString(ds.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// Pattern returns the source text of the names bound, [a, b], {x, y} or x, y
+func (ds *DestructureStatement) Pattern() string {
+	names := make([]string, len(ds.Names))
+	for i, name := range ds.Names {
+		names[i] = name.Value
+	}
+	if ds.Tuple {
+		return strings.Join(names, ", ")
+	}
+	if ds.Hash {
+		return "{" + strings.Join(names, ", ") + "}"
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+func (es *ExportStatement) String() string {
+	names := make([]string, len(es.Names))
+	for i, name := range es.Names {
+		names[i] = name.Value
+	}
+	return es.TokenLiteral() + " " + strings.Join(names, ", ") + ";"
+}
+
+func (as *AssignStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(as.Name.String())
+	out.WriteString(" = ")
+
+	if as.Value != nil {
+		out.WriteString(as.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -79,6 +138,34 @@ func (i *Identifier) String() string {
 	return i.Value
 }
 
+// Exports returns the names bound by the exported let statements and listed
+// by the export statements at the top level of the program, or nil when it
+// exports nothing and every binding is visible to importers
+func (p *Program) Exports() map[string]bool {
+	var exports map[string]bool
+	export := func(name string) {
+		if exports == nil {
+			exports = map[string]bool{}
+		}
+		exports[name] = true
+	}
+	for _, s := range p.Statements {
+		switch s := s.(type) {
+		case *LetStatement:
+			if s != nil && s.Exported {
+				export(s.Name.Value)
+			}
+		case *ExportStatement:
+			if s != nil {
+				for _, name := range s.Names {
+					export(name.Value)
+				}
+			}
+		}
+	}
+	return exports
+}
+
 // TokenLiteral returns the literal value of the token
 func (p *Program) TokenLiteral() string {
 	if len(p.Statements) > 0 {
@@ -92,11 +179,51 @@ type LetStatement struct {
 	Token token.Token // token.LET
 	Name  *Identifier // Name is the identifier of the binding
 	Value Expression  // Value is the expression to be bound to the identifier
+	Doc   string      // Doc is the text of the comments directly above the statement
+
+	Exported bool // Exported is set for `export let`, binding a name importers can see
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 
+// DestructureStatement binds names to the elements of an array in order,
+// let [a, b] = pair;, to the fields of a hash they name, let {x, y} = point;,
+// or to the values of a tuple, let x, y = f();
+type DestructureStatement struct {
+	Token token.Token   // token.LET
+	Hash  bool          // Hash is set for {x, y}, binding fields, and unset for [a, b], binding elements
+	Tuple bool          // Tuple is set for x, y, binding the values of a tuple
+	Names []*Identifier // Names are the identifiers of the bindings
+	Value Expression    // Value is the expression destructured
+}
+
+func (ds *DestructureStatement) statementNode()       {}
+func (ds *DestructureStatement) TokenLiteral() string { return ds.Token.Literal }
+
+// ExportStatement makes names bound at the top level of a module visible to
+// importers, export a, b;
+type ExportStatement struct {
+	Token token.Token   // token.EXPORT
+	Names []*Identifier // Names are the identifiers of the exported bindings
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+
+// AssignStatement sets a variable bound before to a new value
+type AssignStatement struct {
+	Token token.Token // token.IDENT, the name assigned to
+	Name  *Identifier // Name is the identifier of the variable
+	Value Expression  // Value is the expression assigned to the variable
+	// Captured is set when the evaluator resolves assignments to a variable
+	// read by functions nested in the one binding it
+	Captured bool
+}
+
+func (as *AssignStatement) statementNode()       {}
+func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
+
 type ReturnStatement struct {
 	Token       token.Token // token.RETURN
 	ReturnValue Expression  // ReturnValue is the expression to be returned
@@ -117,6 +244,19 @@ func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 type Identifier struct {
 	Token token.Token // token.IDENT
 	Value string
+
+	// Binding locates the variable named, set when the evaluator resolves
+	// the program. It is nil for identifiers looked up by name.
+	Binding *Binding
+}
+
+// Binding locates the variable an identifier names: it is bound Depth
+// functions out from the identifier, in the slot Index of the locals of that
+// function. Index is -1 for variables bound outside of every function, which
+// are looked up by name from there.
+type Binding struct {
+	Depth int
+	Index int
 }
 
 func (i *Identifier) expressionNode()      {}
@@ -190,6 +330,15 @@ func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
+// NullLiteral is the null keyword, which produces the null value
+type NullLiteral struct {
+	Token token.Token // token.NULL
+}
+
+func (n *NullLiteral) expressionNode()      {}
+func (n *NullLiteral) TokenLiteral() string { return n.Token.Literal }
+func (n *NullLiteral) String() string       { return n.Token.Literal }
+
 type IfExpression struct {
 	Token       token.Token // The 'if' token
 	Condition   Expression  // The condition to be evaluated
@@ -215,9 +364,79 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// TryExpression runs its block and, when the block fails with an error,
+// its handler with the message of the error bound to its variable. Its value
+// is that of the block run last.
+type TryExpression struct {
+	Token    token.Token // The 'try' token
+	Block    *BlockStatement
+	Variable *Identifier // Variable is bound to the message of the error caught
+	Handler  *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	out.WriteString(" catch (")
+	out.WriteString(te.Variable.String())
+	out.WriteString(") ")
+	out.WriteString(te.Handler.String())
+
+	return out.String()
+}
+
+// WhileStatement runs its body for as long as its condition is truthy
+type WhileStatement struct {
+	Token     token.Token // The 'while' token
+	Condition Expression  // The condition checked before every run of the body
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// ForStatement runs its body once for every value of Iterable, the elements
+// of an array or the keys of a hash, bound to Variable
+type ForStatement struct {
+	Token    token.Token // The 'for' token
+	Variable *Identifier // Variable is bound to each value in turn
+	Iterable Expression  // Iterable is the array or hash iterated over
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for(")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
 type BlockStatement struct {
 	Token      token.Token // The { token
 	Statements []Statement
+	End        token.Token // The } token
 }
 
 func (bs *BlockStatement) statementNode()       {}
@@ -236,6 +455,10 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+
+	// Tail is set on calls whose value the function making them returns,
+	// when the evaluator resolves the program
+	Tail bool
 }
 
 func (ce *CallExpression) expressionNode()      {}
@@ -257,20 +480,63 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// MemberCallExpression calls a method, value.name(args): the function in
+// the field name of a hash, or else the builtin name called with value and
+// then args
+type MemberCallExpression struct {
+	Token     token.Token // The '(' token
+	Receiver  Expression  // The value the method is called on
+	Method    string      // The name of the method
+	Arguments []Expression
+}
+
+func (mc *MemberCallExpression) expressionNode()      {}
+func (mc *MemberCallExpression) TokenLiteral() string { return mc.Token.Literal }
+
+// Callee returns the source text of the method called, value.name
+func (mc *MemberCallExpression) Callee() string {
+	return mc.Receiver.String() + "." + mc.Method
+}
+
+func (mc *MemberCallExpression) String() string {
+	var out bytes.Buffer
+
+	args := []string{}
+
+	for _, a := range mc.Arguments {
+		args = append(args, a.String())
+	}
+
+	out.WriteString(mc.Callee())
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type ImportLiteral struct {
-	Token token.Token // the 'import' token
-	Path  string
+	Token    token.Token // the 'import' token
+	Path     string
+	Checksum string      // Checksum is the hex sha256 pinning a remote import, empty when there is none
+	Alias    *Identifier // Alias names the module the bindings are read from, import "m" as m;, nil when they are brought into scope
 }
 
 func (il *ImportLiteral) expressionNode()      {}
 func (il *ImportLiteral) TokenLiteral() string { return il.Token.Literal }
-func (il *ImportLiteral) String() string       { return il.Path }
+func (il *ImportLiteral) String() string {
+	if il.Alias != nil {
+		return il.Path + " as " + il.Alias.Value
+	}
+	return il.Path
+}
 
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
 	Parameters []*Identifier
 	Body       *BlockStatement
 	Name       string
+	Locals     []string // Locals names the slots of the variables bound in the function, set when it is resolved
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -312,9 +578,26 @@ func (tl *TensorLiteral) expressionNode()      {}
 func (tl *TensorLiteral) TokenLiteral() string { return tl.Token.Literal }
 func (tl *TensorLiteral) String() string       { return tl.Token.Literal } // ToDo make this awesome
 
+// TupleLiteral groups the values returned together by return a, b;
+type TupleLiteral struct {
+	Token    token.Token // The first ',' token
+	Elements []Expression
+}
+
+func (tl *TupleLiteral) expressionNode()      {}
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TupleLiteral) String() string {
+	elements := make([]string, len(tl.Elements))
+	for i, el := range tl.Elements {
+		elements[i] = el.String()
+	}
+	return strings.Join(elements, ", ")
+}
+
 type ArrayLiteral struct {
 	Token    token.Token // The '[' token
 	Elements []Expression
+	End      token.Token // The ']' token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
@@ -355,9 +638,54 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression reads part of an array or a string, arr[1:3]. Either bound
+// can be left out, arr[:2] or arr[2:], in which case Low or High is nil.
+type SliceExpression struct {
+	Token token.Token // The '[' token
+	Left  Expression
+	Low   Expression
+	High  Expression
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
+// MemberExpression reads a hash field by name, config.host, the same as
+// config["host"]
+type MemberExpression struct {
+	Token token.Token // The '.' token
+	Left  Expression  // The left expression, e.g. config
+	Field string      // The name of the field, e.g. host
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) String() string {
+	return "(" + me.Left.String() + "." + me.Field + ")"
+}
+
 type HashLiteral struct {
 	Token token.Token // The '{' token
 	Pairs map[Expression]Expression
+	Keys  []Expression // Keys holds the keys of Pairs in source order
+	End   token.Token  // The '}' token
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -376,3 +704,191 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// LineOf returns the source line a node starts on, or 0 when it is unknown
+func LineOf(node Node) int {
+	switch node := node.(type) {
+	case *Program:
+		if len(node.Statements) > 0 {
+			return LineOf(node.Statements[0])
+		}
+	case *LetStatement:
+		return node.Token.Line
+	case *AssignStatement:
+		return node.Token.Line
+	case *DestructureStatement:
+		return node.Token.Line
+	case *ExportStatement:
+		return node.Token.Line
+	case *ReturnStatement:
+		return node.Token.Line
+	case *ExpressionStatement:
+		return node.Token.Line
+	case *BlockStatement:
+		return node.Token.Line
+	case *WhileStatement:
+		return node.Token.Line
+	case *ForStatement:
+		return node.Token.Line
+	case *Identifier:
+		return node.Token.Line
+	case *IntegerLiteral:
+		return node.Token.Line
+	case *FloatLiteral:
+		return node.Token.Line
+	case *PrefixExpression:
+		return node.Token.Line
+	case *InfixExpression:
+		return node.Token.Line
+	case *Boolean:
+		return node.Token.Line
+	case *NullLiteral:
+		return node.Token.Line
+	case *TryExpression:
+		return node.Token.Line
+	case *IfExpression:
+		return node.Token.Line
+	case *CallExpression:
+		return node.Token.Line
+	case *MemberCallExpression:
+		return node.Token.Line
+	case *ImportLiteral:
+		return node.Token.Line
+	case *FunctionLiteral:
+		return node.Token.Line
+	case *StringLiteral:
+		return node.Token.Line
+	case *TensorLiteral:
+		return node.Token.Line
+	case *TupleLiteral:
+		return node.Token.Line
+	case *ArrayLiteral:
+		return node.Token.Line
+	case *IndexExpression:
+		return node.Token.Line
+	case *SliceExpression:
+		return node.Token.Line
+	case *MemberExpression:
+		return node.Token.Line
+	case *HashLiteral:
+		return node.Token.Line
+	}
+	return 0
+}
+
+// Inspect traverses the tree rooted at node in depth-first order, calling f
+// for each node before its children. Children are skipped when f returns
+// false. Hash literal pairs are visited in source order. Nil nodes, which
+// the parser leaves behind after syntax errors, are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || isNilNode(node) || !f(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, stmt := range node.Statements {
+			Inspect(stmt, f)
+		}
+	case *LetStatement:
+		Inspect(node.Name, f)
+		Inspect(node.Value, f)
+	case *AssignStatement:
+		Inspect(node.Name, f)
+		Inspect(node.Value, f)
+	case *DestructureStatement:
+		for _, name := range node.Names {
+			Inspect(name, f)
+		}
+		Inspect(node.Value, f)
+	case *ExportStatement:
+		for _, name := range node.Names {
+			Inspect(name, f)
+		}
+	case *ReturnStatement:
+		Inspect(node.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(node.Expression, f)
+	case *BlockStatement:
+		for _, stmt := range node.Statements {
+			Inspect(stmt, f)
+		}
+	case *WhileStatement:
+		Inspect(node.Condition, f)
+		Inspect(node.Body, f)
+	case *ForStatement:
+		Inspect(node.Variable, f)
+		Inspect(node.Iterable, f)
+		Inspect(node.Body, f)
+	case *PrefixExpression:
+		Inspect(node.Right, f)
+	case *InfixExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *IfExpression:
+		Inspect(node.Condition, f)
+		Inspect(node.Consequence, f)
+		if node.Alternative != nil {
+			Inspect(node.Alternative, f)
+		}
+	case *TryExpression:
+		Inspect(node.Block, f)
+		Inspect(node.Variable, f)
+		Inspect(node.Handler, f)
+	case *FunctionLiteral:
+		for _, param := range node.Parameters {
+			Inspect(param, f)
+		}
+		Inspect(node.Body, f)
+	case *CallExpression:
+		Inspect(node.Function, f)
+		for _, arg := range node.Arguments {
+			Inspect(arg, f)
+		}
+	case *MemberCallExpression:
+		Inspect(node.Receiver, f)
+		for _, arg := range node.Arguments {
+			Inspect(arg, f)
+		}
+	case *IndexExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Index, f)
+	case *SliceExpression:
+		Inspect(node.Left, f)
+		if node.Low != nil {
+			Inspect(node.Low, f)
+		}
+		if node.High != nil {
+			Inspect(node.High, f)
+		}
+	case *MemberExpression:
+		Inspect(node.Left, f)
+	case *ImportLiteral:
+		if node.Alias != nil {
+			Inspect(node.Alias, f)
+		}
+	case *ArrayLiteral:
+		for _, element := range node.Elements {
+			Inspect(element, f)
+		}
+	case *TupleLiteral:
+		for _, element := range node.Elements {
+			Inspect(element, f)
+		}
+	case *HashLiteral:
+		for _, key := range node.Keys {
+			Inspect(key, f)
+			Inspect(node.Pairs[key], f)
+		}
+	case *TensorLiteral:
+		Inspect(node.Shape, f)
+		Inspect(node.Data, f)
+	}
+}
+
+// isNilNode is a helper function that reports whether node is a nil pointer
+// wrapped in a non-nil interface
+func isNilNode(node Node) bool {
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
@@ -26,3 +26,57 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. Got %q", program.String())
 	}
 }
+
+func TestInspect(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+
+	// let f = fn(x) { g(x) };
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  ident("f"),
+				Value: &FunctionLiteral{
+					Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+					Parameters: []*Identifier{ident("x")},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&ExpressionStatement{Expression: &CallExpression{Function: ident("g"), Arguments: []Expression{ident("y")}}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var names []string
+	Inspect(program, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			names = append(names, ident.Value)
+		}
+		return true
+	})
+
+	expected := []string{"f", "x", "g", "y"}
+	if len(names) != len(expected) {
+		t.Fatalf("wrong identifiers. want=%q, got=%q", expected, names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("wrong identifier %d. want=%q, got=%q", i, name, names[i])
+		}
+	}
+
+	// Returning false skips the body of the function
+	var count int
+	Inspect(program, func(node Node) bool {
+		count++
+		_, isFunction := node.(*FunctionLiteral)
+		return !isFunction
+	})
+	if count != 4 {
+		t.Errorf("wrong number of nodes visited. want=4, got=%d", count)
+	}
+}
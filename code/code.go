@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 type Definition struct {
@@ -56,41 +57,67 @@ const (
 	OpGetFree
 	OpCurrentClosure
 	OpImport
+	OpGetExtended
+	OpConcat
+	OpFloorDiv
+	OpIter
+	OpIterNext
+	OpGreaterThanOrEqual
+	OpCallMethod
+	OpTry
+	OpEndTry
+	OpSlice
+	OpPow
+	OpTuple
+	OpUnpack
 )
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:       {"OpConstant", []int{2}},
-	OpAdd:            {"OpAdd", []int{}},
-	OpSub:            {"OpSub", []int{}},
-	OpMul:            {"OpMul", []int{}},
-	OpDiv:            {"OpDiv", []int{}},
-	OpPop:            {"OpPop", []int{}},
-	OpTrue:           {"OpTrue", []int{}},
-	OpFalse:          {"OpFalse", []int{}},
-	OpEqual:          {"OpEqual", []int{}},
-	OpNotEqual:       {"OpNotEqual", []int{}},
-	OpGreaterThan:    {"OpGreaterThan", []int{}},
-	OpMinus:          {"OpMinus", []int{}},
-	OpBang:           {"OpBang", []int{}},
-	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
-	OpJump:           {"OpJump", []int{2}},
-	OpNull:           {"OpNull", []int{}},
-	OpGetGlobal:      {"OpGetGlobal", []int{2}},
-	OpSetGlobal:      {"OpSetGlobal", []int{2}},
-	OpArray:          {"OpArray", []int{2}},
-	OpHash:           {"OpHash", []int{2}},
-	OpIndex:          {"OpIndex", []int{}},
-	OpTensor:         {"OpTensor", []int{2}},
-	OpCall:           {"OpCall", []int{1}},
-	OpReturnValue:    {"OpReturnValue", []int{}},
-	OpReturn:         {"OpReturn", []int{}},
-	OpSetLocal:       {"OpSetLocal", []int{1}},
-	OpGetLocal:       {"OpGetLocal", []int{1}},
-	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
-	OpClosure:        {"OpClosure", []int{2, 1}},
-	OpGetFree:        {"OpGetFree", []int{1}},
-	OpCurrentClosure: {"OpCurrentClosure", []int{}},
-	OpImport:         {"OpImport", []int{1}},
+	OpConstant:           {"OpConstant", []int{2}},
+	OpAdd:                {"OpAdd", []int{}},
+	OpSub:                {"OpSub", []int{}},
+	OpMul:                {"OpMul", []int{}},
+	OpDiv:                {"OpDiv", []int{}},
+	OpPop:                {"OpPop", []int{}},
+	OpTrue:               {"OpTrue", []int{}},
+	OpFalse:              {"OpFalse", []int{}},
+	OpEqual:              {"OpEqual", []int{}},
+	OpNotEqual:           {"OpNotEqual", []int{}},
+	OpGreaterThan:        {"OpGreaterThan", []int{}},
+	OpMinus:              {"OpMinus", []int{}},
+	OpBang:               {"OpBang", []int{}},
+	OpJumpNotTruthy:      {"OpJumpNotTruthy", []int{2}},
+	OpJump:               {"OpJump", []int{2}},
+	OpNull:               {"OpNull", []int{}},
+	OpGetGlobal:          {"OpGetGlobal", []int{2}},
+	OpSetGlobal:          {"OpSetGlobal", []int{2}},
+	OpArray:              {"OpArray", []int{2}},
+	OpHash:               {"OpHash", []int{2}},
+	OpIndex:              {"OpIndex", []int{}},
+	OpTensor:             {"OpTensor", []int{2}},
+	OpCall:               {"OpCall", []int{1}},
+	OpReturnValue:        {"OpReturnValue", []int{}},
+	OpReturn:             {"OpReturn", []int{}},
+	OpSetLocal:           {"OpSetLocal", []int{1}},
+	OpGetLocal:           {"OpGetLocal", []int{1}},
+	OpGetBuiltin:         {"OpGetBuiltin", []int{1}},
+	OpClosure:            {"OpClosure", []int{2, 1}},
+	OpGetFree:            {"OpGetFree", []int{1}},
+	OpCurrentClosure:     {"OpCurrentClosure", []int{}},
+	OpImport:             {"OpImport", []int{1}},
+	OpGetExtended:        {"OpGetExtended", []int{2}},
+	OpConcat:             {"OpConcat", []int{2}},
+	OpFloorDiv:           {"OpFloorDiv", []int{}},
+	OpIter:               {"OpIter", []int{}},
+	OpIterNext:           {"OpIterNext", []int{2}},
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	OpCallMethod:         {"OpCallMethod", []int{2, 1}},
+	OpTry:                {"OpTry", []int{2}},
+	OpEndTry:             {"OpEndTry", []int{}},
+	OpSlice:              {"OpSlice", []int{}},
+	OpPow:                {"OpPow", []int{}},
+	OpTuple:              {"OpTuple", []int{2}},
+	OpUnpack:             {"OpUnpack", []int{1}},
 }
 
 func Make(op Opcode, operands ...int) []byte {
@@ -143,6 +170,17 @@ func (ins Instructions) String() string {
 	return out.String()
 }
 
+// Disassemble returns the instruction starting at position ip in human readable form
+func (ins Instructions) Disassemble(ip int) string {
+	def, err := Lookup(ins[ip])
+	if err != nil {
+		return fmt.Sprintf("ERROR: %s", err)
+	}
+
+	operands, _ := ReadOperands(def, ins[ip+1:])
+	return ins.fmtInstruction(def, operands)
+}
+
 // fmtInstruction is to format the instruction
 func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	operandCount := len(def.OperandWidths)
@@ -189,3 +227,44 @@ func ReadUint8(ins Instructions) uint8 {
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
+
+// LineEntry records that the instructions starting at Pos were compiled from
+// source line Line
+type LineEntry struct {
+	Pos  int
+	Line int
+}
+
+// LineTable maps instruction positions to source lines. Entries are ordered
+// by position and only added when the line changes.
+type LineTable []LineEntry
+
+// LineFor returns the source line of the instruction at pos, or 0 if unknown
+func (lt LineTable) LineFor(pos int) int {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Pos > pos })
+	if i == 0 {
+		return 0
+	}
+	return lt[i-1].Line
+}
+
+// CallEntry records the source text of the callee of the call instruction at
+// Pos
+type CallEntry struct {
+	Pos    int
+	Callee string
+}
+
+// CallTable maps the positions of call instructions to the source text of
+// their callees, for errors. Entries are ordered by position.
+type CallTable []CallEntry
+
+// CalleeAt returns the source text of the callee of the call instruction at
+// pos, or an empty string if unknown
+func (ct CallTable) CalleeAt(pos int) string {
+	i := sort.Search(len(ct), func(i int) bool { return ct[i].Pos >= pos })
+	if i == len(ct) || ct[i].Pos != pos {
+		return ""
+	}
+	return ct[i].Callee
+}
@@ -57,6 +57,46 @@ func TestInstructionString(t *testing.T) {
 	}
 }
 
+// TestDisassemble is to test formatting a single instruction
+func TestDisassemble(t *testing.T) {
+	ins := Instructions{}
+	ins = append(ins, Make(OpAdd)...)
+	ins = append(ins, Make(OpClosure, 65535, 255)...)
+
+	if got := ins.Disassemble(0); got != "OpAdd" {
+		t.Errorf("wrong disassembly. want=%q, got=%q", "OpAdd", got)
+	}
+	if got := ins.Disassemble(1); got != "OpClosure 65535 255" {
+		t.Errorf("wrong disassembly. want=%q, got=%q", "OpClosure 65535 255", got)
+	}
+}
+
+// TestLineFor is to test looking up source lines
+func TestLineFor(t *testing.T) {
+	lines := LineTable{{Pos: 0, Line: 1}, {Pos: 4, Line: 3}, {Pos: 9, Line: 4}}
+
+	tests := []struct {
+		pos  int
+		line int
+	}{
+		{0, 1},
+		{3, 1},
+		{4, 3},
+		{8, 3},
+		{20, 4},
+	}
+
+	for _, tt := range tests {
+		if got := lines.LineFor(tt.pos); got != tt.line {
+			t.Errorf("wrong line for position %d. want=%d, got=%d", tt.pos, tt.line, got)
+		}
+	}
+
+	if got := (LineTable{}).LineFor(3); got != 0 {
+		t.Errorf("expected unknown line for empty table, got=%d", got)
+	}
+}
+
 // TestReadOperands is to test the reading of operands
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
@@ -4,12 +4,13 @@ package compiler
 
 import (
 	"fmt"
+	"io"
 	"monkey/ast"
 	"monkey/code"
-	"monkey/lexer"
+	"monkey/diagnostic"
+	"monkey/imports"
 	"monkey/object"
-	"monkey/parser"
-	"os"
+	"monkey/token"
 	"sort"
 )
 
@@ -22,6 +23,23 @@ type CompilationScope struct {
 	instructions    code.Instructions
 	lastInstruction EmittedInstruction
 	prevInstruction EmittedInstruction
+	lines           code.LineTable
+	calls           code.CallTable
+	captured        map[int]bool // captured holds the locals closures copy
+	assigns         []assignment // assigns holds the assignments to locals
+}
+
+// assignment is an assignment to the local variable at index
+type assignment struct {
+	index int
+	name  *ast.Identifier
+}
+
+// newCompileError is a helper function that returns the diagnostic of a
+// program that parses but cannot be compiled, such as one referring to an
+// undefined variable, at the position of tok
+func newCompileError(tok token.Token, format string, a ...interface{}) *diagnostic.Diagnostic {
+	return diagnostic.At(tok, format, a...)
 }
 
 type compiler struct {
@@ -31,6 +49,19 @@ type compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	line int // line is the source line of the node being compiled
+
+	file     string              // file is the module being compiled, empty for the main program
+	module   *Module             // module is set while a module is compiled on its own
+	imported map[string][]Symbol // imported maps the absolute paths of the modules imported at the top level to the symbols they expose
+	loading  map[string]bool     // loading holds the absolute paths of the modules being compiled
+	imports  *imports.Resolver   // imports finds the files imported, in the directories of MONKEY_PATH when nil
+
+	trace io.Writer // trace receives every instruction emitted, nil when not tracing
+
+	functions *functionCache    // functions holds the top-level functions compiled before by an Incremental, nil otherwise
+	uses      map[string]Symbol // uses collects the symbols of the outermost table resolved by the function being cached
 }
 
 func New() *compiler {
@@ -51,6 +82,8 @@ func New() *compiler {
 		symbolTable: symbolTable,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
+		imported:    map[string][]Symbol{},
+		loading:     map[string]bool{},
 	}
 }
 
@@ -62,7 +95,24 @@ func NewWithState(symbolTable *SymbolTable, constants []object.Object) *compiler
 	return compiler
 }
 
+// SetResolver makes the compiler find the files imported with r
+func (c *compiler) SetResolver(r *imports.Resolver) {
+	c.imports = r
+}
+
+// SetTrace makes the compiler write every instruction it emits to w, with its
+// position in the function it is emitted in. A nil w stops tracing.
+func (c *compiler) SetTrace(w io.Writer) {
+	c.trace = w
+}
+
 func (c *compiler) Compile(node ast.Node) error {
+	if line := ast.LineOf(node); line > 0 && line != c.line {
+		outer := c.line
+		c.line = line
+		defer func() { c.line = outer }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -86,9 +136,7 @@ func (c *compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.leaveBlockValue()
 
 		jumpPos := c.emit(code.OpJump, 9999)
 
@@ -103,14 +151,86 @@ func (c *compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.leaveBlockValue()
 		}
 
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
+	case *ast.TryExpression:
+		// emit an OpTry with the bogus position of the handler
+		tryPos := c.emit(code.OpTry, 9999)
+
+		err := c.Compile(node.Block)
+		if err != nil {
+			return err
+		}
+		c.leaveBlockValue()
+
+		c.emit(code.OpEndTry)
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		// The VM pushes the message of the error caught before jumping here
+		c.changeOperand(tryPos, len(c.currentInstructions()))
+		c.storeSymbol(c.symbolTable.Define(node.Variable.Value))
+
+		err = c.Compile(node.Handler)
+		if err != nil {
+			return err
+		}
+		c.leaveBlockValue()
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.WhileStatement:
+		conditionPos := len(c.currentInstructions())
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		// emit an OpJumpNotTruthy with a bogus value
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, conditionPos)
+
+		afterBodyPos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterBodyPos)
+
+	case *ast.ForStatement:
+		// The values iterated over and the index of the next one stay on
+		// the stack while the loop runs
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIter)
+
+		symbol := c.symbolTable.Define(node.Variable.Value)
+
+		// emit an OpIterNext with a bogus value
+		iterNextPos := c.emit(code.OpIterNext, 9999)
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, iterNextPos)
+
+		afterBodyPos := len(c.currentInstructions())
+		c.changeOperand(iterNextPos, afterBodyPos)
+
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
 			err := c.Compile(s)
@@ -138,11 +258,16 @@ func (c *compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return newCompileError(node.Token, "unknown operator %s", node.Operator)
 		}
 
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
+		}
+
+		// a < b is compiled as b > a, and a <= b as b >= a
+		if node.Operator == "<" || node.Operator == "<=" {
 			err := c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -153,7 +278,21 @@ func (c *compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			c.emit(code.OpGreaterThan)
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterThanOrEqual)
+			}
+			return nil
+		}
+
+		if operands := concatenation(node); operands != nil {
+			for _, operand := range operands {
+				if err := c.Compile(operand); err != nil {
+					return err
+				}
+			}
+			c.emit(code.OpConcat, len(operands))
 			return nil
 		}
 
@@ -176,14 +315,20 @@ func (c *compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "//":
+			c.emit(code.OpFloorDiv)
+		case "**":
+			c.emit(code.OpPow)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return newCompileError(node.Token, "unknown operator %s", node.Operator)
 		}
 
 	case *ast.IntegerLiteral:
@@ -200,6 +345,8 @@ func (c *compiler) Compile(node ast.Node) error {
 		} else {
 			c.emit(code.OpFalse)
 		}
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
@@ -216,10 +363,89 @@ func (c *compiler) Compile(node ast.Node) error {
 			c.emit(code.OpSetLocal, symbol.Index)
 		}
 
+	case *ast.ExportStatement:
+		// Exports are read off the program, the names only have to be globals
+		for _, name := range node.Names {
+			symbol, ok := c.symbolTable.Resolve(name.Value)
+			if !ok {
+				return newCompileError(name.Token, "undefined variable %s", name.Value)
+			}
+			if symbol.Scope != GlobalScope {
+				return newCompileError(name.Token, "cannot export %s, only globals can be exported", name.Value)
+			}
+		}
+
+	case *ast.DestructureStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		// The values of a tuple are pushed in order, the last one on top
+		if node.Tuple {
+			c.emit(code.OpUnpack, len(node.Names))
+			symbols := make([]Symbol, len(node.Names))
+			for i, name := range node.Names {
+				symbols[i] = c.symbolTable.Define(name.Value)
+			}
+			for i := len(symbols) - 1; i >= 0; i-- {
+				c.storeSymbol(symbols[i])
+			}
+			break
+		}
+
+		// The value is kept in a slot of its own while it is indexed
+		value := Symbol{Scope: LocalScope, Index: c.symbolTable.allocate()}
+		if c.symbolTable.Outer == nil {
+			value.Scope = GlobalScope
+		}
+		c.storeSymbol(value)
+
+		for i, name := range node.Names {
+			c.loadSymbol(value)
+			var index object.Object = object.NewInteger(int64(i))
+			if node.Hash {
+				index = &object.String{Value: name.Value}
+			}
+			c.emit(code.OpConstant, c.addConstant(index))
+			c.emit(code.OpIndex)
+			c.storeSymbol(c.symbolTable.Define(name.Value))
+		}
+
+	case *ast.AssignStatement:
+		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		if !ok {
+			return newCompileError(node.Name.Token, "cannot assign to undeclared variable %s", node.Name.Value)
+		}
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		switch symbol.Scope {
+		case GlobalScope:
+			if c.uses != nil {
+				c.uses[node.Name.Value] = symbol
+			}
+			c.emit(code.OpSetGlobal, symbol.Index)
+		case LocalScope:
+			scope := &c.scopes[c.scopeIndex]
+			scope.assigns = append(scope.assigns, assignment{symbol.Index, node.Name})
+			c.emit(code.OpSetLocal, symbol.Index)
+		case FreeScope:
+			// Closures hold copies of the variables of enclosing functions
+			return newCompileError(node.Name.Token, "cannot assign to %s, a variable of an enclosing function", node.Name.Value)
+		default:
+			return newCompileError(node.Name.Token, "cannot assign to %s", node.Name.Value)
+		}
+
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return newCompileError(node.Token, "undefined variable %s", node.Value)
+		}
+		if c.uses != nil && (symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope || symbol.Scope == ExtendedScope) {
+			c.uses[node.Value] = symbol
 		}
 
 		c.loadSymbol(symbol)
@@ -233,6 +459,16 @@ func (c *compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpArray, len(node.Elements))
 
+	case *ast.TupleLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpTuple, len(node.Elements))
+
 	case *ast.HashLiteral:
 		keys := []ast.Expression{}
 		for k := range node.Pairs {
@@ -266,41 +502,41 @@ func (c *compiler) Compile(node ast.Node) error {
 		}
 
 		c.emit(code.OpIndex)
-	case *ast.FunctionLiteral:
-		c.enterScope()
-
-		if node.Name != "" {
-			c.symbolTable.DefineFunctionName(node.Name)
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
 		}
 
-		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
+		// A left out bound is null, standing for the start or the end
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			err = c.Compile(bound)
+			if err != nil {
+				return err
+			}
 		}
 
-		err := c.Compile(node.Body)
+		c.emit(code.OpSlice)
+	case *ast.MemberExpression:
+		err := c.Compile(node.Left)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.replaceLastPopWithReturn()
-		}
-		if !c.lastInstructionIs(code.OpReturnValue) {
-			c.emit(code.OpReturn)
-		}
-
-		freeSymbols := c.symbolTable.FreeSymbols
-		numLocals := c.symbolTable.numDefinitions
-		instructions := c.leaveScope()
-		// fmt.Printf("instructions: %s\n", instructions.String())
-
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+		field := &object.String{Value: node.Field}
+		c.emit(code.OpConstant, c.addConstant(field))
+		c.emit(code.OpIndex)
+	case *ast.FunctionLiteral:
+		if c.functions != nil && c.scopeIndex == 0 {
+			return c.compileCachedFunction(node)
 		}
+		_, err := c.compileFunction(node)
+		return err
 
-		compiledFn := &object.CompiledFunction{Instructions: instructions, NumLocals: numLocals, NumParameters: len(node.Parameters)}
-		fnIndex := c.addConstant(compiledFn)
-		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
@@ -321,28 +557,35 @@ func (c *compiler) Compile(node ast.Node) error {
 			}
 		}
 
-		c.emit(code.OpCall, len(node.Arguments))
-	case *ast.ImportLiteral:
-		content, err := os.ReadFile(node.Path)
+		position := c.emit(code.OpCall, len(node.Arguments))
+		c.scopes[c.scopeIndex].calls = append(c.scopes[c.scopeIndex].calls, code.CallEntry{Pos: position, Callee: node.Function.String()})
+	case *ast.MemberCallExpression:
+		err := c.Compile(node.Receiver)
 		if err != nil {
 			return err
 		}
-		l := lexer.New(string(content))
-		p := parser.New(l)
 
-		program := p.ParseProgram()
-		err = c.Compile(program)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Woops! Compilation failed on import of %s:\n %s\n", node.Path, err)
-			return err
+		for _, arg := range node.Arguments {
+			err := c.Compile(arg)
+			if err != nil {
+				return err
+			}
 		}
-		c.emit(code.OpImport, c.addConstant(&object.String{Value: node.Path}))
+
+		method := &object.String{Value: node.Method}
+		position := c.emit(code.OpCallMethod, c.addConstant(method), len(node.Arguments))
+		c.scopes[c.scopeIndex].calls = append(c.scopes[c.scopeIndex].calls, code.CallEntry{Pos: position, Callee: node.Callee()})
+	case *ast.ImportLiteral:
+		return c.compileImport(node)
+
 	case *ast.TensorLiteral:
-		err := c.Compile(node.Shape)
-		if err != nil {
+		// The shape of nested data is inferred from the data
+		if node.Shape == nil {
+			c.emit(code.OpNull)
+		} else if err := c.Compile(node.Shape); err != nil {
 			return err
 		}
-		err = c.Compile(node.Data)
+		err := c.Compile(node.Data)
 		if err != nil {
 			return err
 		}
@@ -354,6 +597,139 @@ func (c *compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileFunction compiles a function literal into a closure and returns
+// the index of its compiled function in the constants
+func (c *compiler) compileFunction(node *ast.FunctionLiteral) (int, error) {
+	c.enterScope()
+
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	err := c.Compile(node.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	// Closures hold copies of the variables they capture, which would no
+	// longer follow the variable once it is assigned
+	scope := c.scopes[c.scopeIndex]
+	for _, a := range scope.assigns {
+		if scope.captured[a.index] {
+			return 0, newCompileError(a.name.Token, "cannot assign to %s, a variable captured by a closure", a.name.Value)
+		}
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	lines := c.scopes[c.scopeIndex].lines
+	calls := c.scopes[c.scopeIndex].calls
+	localNames := c.symbolTable.localNames()
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.loadSymbol(s)
+		if s.Scope == LocalScope {
+			c.markCaptured(s.Index)
+		}
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Name:          node.Name,
+		Lines:         lines,
+		Calls:         calls,
+		LocalNames:    localNames,
+		FreeNames:     symbolNames(freeSymbols),
+	}
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	return fnIndex, nil
+}
+
+// compileLogical compiles a && or || expression to jumps, so that the right
+// operand is only run when the left one does not decide the result. Both
+// push true or false.
+func (c *compiler) compileLogical(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	// emit an OpJumpNotTruthy with a bogus value
+	leftFalsePos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	var jumps []int // jumps holds the positions of the OpJumps to the end
+	if node.Operator == "||" {
+		c.emit(code.OpTrue)
+		jumps = append(jumps, c.emit(code.OpJump, 9999))
+		c.changeOperand(leftFalsePos, len(c.currentInstructions()))
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	rightFalsePos := c.emit(code.OpJumpNotTruthy, 9999)
+	c.emit(code.OpTrue)
+	jumps = append(jumps, c.emit(code.OpJump, 9999))
+
+	falsePos := len(c.currentInstructions())
+	c.changeOperand(rightFalsePos, falsePos)
+	if node.Operator == "&&" {
+		c.changeOperand(leftFalsePos, falsePos)
+	}
+	c.emit(code.OpFalse)
+
+	afterPos := len(c.currentInstructions())
+	for _, pos := range jumps {
+		c.changeOperand(pos, afterPos)
+	}
+	return nil
+}
+
+// concatenation returns the operands of a chain of + such as a + "b" + c,
+// from left to right, when it joins three operands or more and one of them is
+// a string literal, and nil otherwise. Such chains are compiled to OpConcat,
+// which builds the string once instead of once for every +.
+func concatenation(node *ast.InfixExpression) []ast.Expression {
+	var operands []ast.Expression
+	var expression ast.Expression = node
+	for {
+		infix, ok := expression.(*ast.InfixExpression)
+		if !ok || infix.Operator != "+" {
+			break
+		}
+		operands = append([]ast.Expression{infix.Right}, operands...)
+		expression = infix.Left
+	}
+	operands = append([]ast.Expression{expression}, operands...)
+
+	if len(operands) < 3 {
+		return nil
+	}
+	for _, operand := range operands {
+		if _, ok := operand.(*ast.StringLiteral); ok {
+			return operands
+		}
+	}
+	return nil
+}
+
 // replaceLastPopWithReturn replaces the last pop instruction with a return instruction
 func (c *compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
@@ -395,6 +771,18 @@ func (c *compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.trimLines(len(new))
+}
+
+// leaveBlockValue leaves the value of the block just compiled as a branch of
+// an if expression on the stack: the value of its last expression statement,
+// or null when the block ends with another statement or is empty
+func (c *compiler) leaveBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+		return
+	}
+	c.emit(code.OpNull)
 }
 
 // addConstant adds a constant to the compiler's constant pool and returns its position
@@ -407,18 +795,42 @@ func (c *compiler) addConstant(obj object.Object) int {
 func (c *compiler) emit(op code.Opcode, operands ...int) int {
 	instruction := code.Make(op, operands...)
 	position := c.addInstruction(instruction)
+	c.addLine(position)
 
 	_, err := code.Lookup(instruction[0])
 	if err != nil {
 		return c.addInstruction(instruction)
 	}
 
-	// fmt.Printf("opcode: %s, operands: %v\n", def.Name, operands)
+	if c.trace != nil {
+		fmt.Fprintf(c.trace, "emit %04d %s\n", position, code.Instructions(instruction).Disassemble(0))
+	}
 
 	c.setLastInstruction(op, position)
 	return position
 }
 
+// addLine records the current source line for the instruction at the given
+// position if it differs from the line of the instructions before it
+func (c *compiler) addLine(position int) {
+	c.trimLines(position)
+
+	lines := c.scopes[c.scopeIndex].lines
+	if n := len(lines); n > 0 && lines[n-1].Line == c.line {
+		return
+	}
+	c.scopes[c.scopeIndex].lines = append(lines, code.LineEntry{Pos: position, Line: c.line})
+}
+
+// trimLines drops the line entries for instructions at or after position
+func (c *compiler) trimLines(position int) {
+	lines := c.scopes[c.scopeIndex].lines
+	for len(lines) > 0 && lines[len(lines)-1].Pos >= position {
+		lines = lines[:len(lines)-1]
+	}
+	c.scopes[c.scopeIndex].lines = lines
+}
+
 // setLastInstruction sets the last instruction and the previous instruction
 func (c *compiler) setLastInstruction(op code.Opcode, position int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction
@@ -455,6 +867,16 @@ func (c *compiler) enterScope() {
 	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
 }
 
+// markCaptured notes that a closure copies the local variable at index of
+// the current scope
+func (c *compiler) markCaptured(index int) {
+	scope := &c.scopes[c.scopeIndex]
+	if scope.captured == nil {
+		scope.captured = map[int]bool{}
+	}
+	scope.captured[index] = true
+}
+
 // leaveScope leaves the current scope
 func (c *compiler) leaveScope() code.Instructions {
 	instructions := c.currentInstructions()
@@ -468,15 +890,29 @@ func (c *compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Calls:        c.scopes[c.scopeIndex].calls,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Lines        code.LineTable
+	Calls        code.CallTable
 }
 
 // loadSymbol function
+// storeSymbol emits the instruction setting the global or local variable s
+// to the value on top of the stack
+func (c *compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
 func (c *compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -489,5 +925,7 @@ func (c *compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetFree, s.Index)
 	case FunctionScope:
 		c.emit(code.OpCurrentClosure)
+	case ExtendedScope:
+		c.emit(code.OpGetExtended, c.addConstant(&object.String{Value: s.Name}))
 	}
 }
@@ -3,12 +3,16 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
 	"monkey/ast"
 	"monkey/code"
+	"monkey/diagnostic"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -56,12 +60,18 @@ func TestTensorLiteral(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
-// TestImportLiteral to test importing a monkey module/file
+// TestImportLiteral to test importing a monkey module/file, whose code is
+// linked in front of the import
 func TestImportLiteral(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "test.mky")
+	if err := os.WriteFile(lib, []byte("let test = fn(x) { x };"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
 	tests := []compilerTestCase{
 		{
 			input: `
-			import "../test.mky";
+			import "` + lib + `";
 			test(5);
 			`,
 			expectedConstants: []interface{}{
@@ -69,7 +79,7 @@ func TestImportLiteral(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpReturnValue),
 				},
-				"../test.mky",
+				lib,
 				5,
 			},
 			expectedInstructions: []code.Instructions{
@@ -286,6 +296,140 @@ func TestClosures(t *testing.T) {
 }
 
 // TestBuiltins is a function to test the builtin function compilation
+func TestCompileErrorPosition(t *testing.T) {
+	program := parse("let a = 1;\nlet b = fn() { a + c };")
+
+	err := New().Compile(program)
+	compileErr, ok := err.(*diagnostic.Diagnostic)
+	if !ok {
+		t.Fatalf("expected *diagnostic.Diagnostic. got=%T (%v)", err, err)
+	}
+
+	if compileErr.Message != "undefined variable c" {
+		t.Errorf("wrong message. got=%q", compileErr.Message)
+	}
+	if compileErr.Line != 2 || compileErr.Column != 20 {
+		t.Errorf("wrong position. want=2:20, got=%d:%d", compileErr.Line, compileErr.Column)
+	}
+	if compileErr.Severity != diagnostic.Error || compileErr.End != (diagnostic.Position{Line: 2, Column: 21}) {
+		t.Errorf("wrong severity or end. got=%s %+v", compileErr.Severity, compileErr.End)
+	}
+}
+
+func TestImportExports(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib.mky")
+	source := "let helper = fn(x) { x * 2 };\nexport let double = fn(x) { helper(x) };"
+	if err := os.WriteFile(lib, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	comp := New()
+	if err := comp.Compile(parse(`let helper = 1; import "` + lib + `"; import "` + lib + `";`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	helper, ok := comp.symbolTable.Resolve("helper")
+	if !ok || helper.Index != 0 {
+		t.Errorf("helper of the importer replaced by the module's. got=%+v", helper)
+	}
+	double, ok := comp.symbolTable.Resolve("double")
+	if !ok || double.Index != 2 {
+		t.Errorf("double not exported. got=%+v", double)
+	}
+
+	next := comp.symbolTable.Define("next")
+	if next.Index != 3 {
+		t.Errorf("slot of the hidden helper reused. got=%+v", next)
+	}
+}
+
+func TestModuleLinking(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"shared.mky": "let value = 41;",
+		"left.mky":   `import "./shared.mky"; let l = fn() { value };`,
+		"right.mky":  `import "./shared.mky"; export let r = fn() { value + 1 };`,
+	}
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input := `import "` + filepath.Join(dir, "left.mky") + `"; import "` + filepath.Join(dir, "right.mky") + `"; r();`
+	for run := 0; run < 2; run++ {
+		comp := New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		shared := 0
+		for _, constant := range comp.constants {
+			if integer, ok := constant.(*object.Integer); ok && integer.Value == 41 {
+				shared++
+			}
+		}
+		if shared != 1 {
+			t.Errorf("run %d: shared module linked %d times, want 1", run, shared)
+		}
+
+		for _, name := range []string{"l", "r", "value"} {
+			if _, ok := comp.symbolTable.Resolve(name); !ok {
+				t.Errorf("run %d: %s not visible to the program", run, name)
+			}
+		}
+	}
+
+	for _, name := range []string{"shared.mkyc", "left.mkyc", "right.mkyc"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("module not cached: %s", err)
+		}
+		if _, _, err := UnmarshalModule(data); err != nil {
+			t.Errorf("invalid cache %s: %s", name, err)
+		}
+	}
+}
+
+func TestModuleCycle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cycle.mky")
+	if err := os.WriteFile(file, []byte(`import "./cycle.mky"; let a = 1;`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := New().Compile(parse(`import "` + file + `";`))
+	if err == nil || err.Error() != "error in "+file+":1, imported from <main>:1: import cycle: "+file+" imports itself" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestImportErrorChain(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"helper.mky": "let a = 1;\nlet b = c;",
+		"mid.mky":    "let m = 1;\nimport \"./helper.mky\";",
+	}
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	helper, mid := filepath.Join(dir, "helper.mky"), filepath.Join(dir, "mid.mky")
+
+	err := New().Compile(parse("let x = 1;\n\nimport \"" + mid + "\";"))
+	importErr, ok := err.(*ImportError)
+	if !ok {
+		t.Fatalf("expected *ImportError. got=%T (%v)", err, err)
+	}
+	if importErr.Line != 3 {
+		t.Errorf("wrong line. want=3, got=%d", importErr.Line)
+	}
+	expected := "error in " + helper + ":2, imported from " + mid + ":2, imported from main.mky:3: undefined variable c"
+	if got := importErr.Chain("main.mky"); got != expected {
+		t.Errorf("wrong error.\nwant=%q\ngot= %q", expected, got)
+	}
+}
+
 func TestBuiltins(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -619,6 +763,18 @@ func TestIndexExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `{"a": 1}.a`,
+			expectedConstants: []interface{}{"a", 1, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -733,6 +889,18 @@ func TestStringExpression(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `"mon" + "key" + 1 + "banana"`,
+			expectedConstants: []interface{}{"mon", "key", 1, "banana"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConcat, 4),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -781,6 +949,14 @@ func TestGlobalLetStatements(t *testing.T) {
 // TestConditionals is a function to test the conditionals
 func TestConditionals(t *testing.T) {
 	tests := []compilerTestCase{
+		{
+			input:             "null;",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "if (true) { 10 }; 3333;",
 			expectedConstants: []interface{}{10, 3333},
@@ -814,6 +990,262 @@ func TestConditionals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestWhileStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 11),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpJump, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { while (false) { } }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 15),
+				code.Make(code.OpFalse),
+				code.Make(code.OpJumpNotTruthy, 11),
+				code.Make(code.OpJump, 4),
+				code.Make(code.OpNull),
+				code.Make(code.OpJump, 16),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDestructureStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let c = 1; let [a, b] = c; let {x} = c;",
+			expectedConstants: []interface{}{1, 0, 1, "x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 2),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 3),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 4),
+				code.Make(code.OpGetGlobal, 4),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 5),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1][1:2]; [1][:1];",
+			expectedConstants: []interface{}{1, 1, 2, 1, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTuples(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let f = fn() { return 1, 2; }; let a, b = f();",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpTuple, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpUnpack, 2),
+				code.Make(code.OpSetGlobal, 2),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "try { 1 } catch (e) { e };",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTry, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpEndTry),
+				code.Make(code.OpJump, 16),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a".upper(1)`,
+			expectedConstants: []interface{}{"a", 1, "upper"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCallMethod, 2, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "fn(a) { a = 2; }",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1;", "cannot assign to undeclared variable x"},
+		{"len = 1;", "cannot assign to len"},
+		{"fn(a) { fn() { a = 1; } }", "cannot assign to a, a variable of an enclosing function"},
+		{"fn() { let x = 1; let g = fn() { x }; x = 2; g() }", "cannot assign to x, a variable captured by a closure"},
+		{"fn(a) { a = 2; fn() { fn() { a } } }", "cannot assign to a, a variable captured by a closure"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		compileErr, ok := err.(*diagnostic.Diagnostic)
+		if !ok {
+			t.Fatalf("expected *diagnostic.Diagnostic. got=%T (%v)", err, err)
+		}
+		if compileErr.Message != tt.expected {
+			t.Errorf("wrong message. want=%q, got=%q", tt.expected, compileErr.Message)
+		}
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1; export a, b;", "undefined variable b"},
+		{"export len;", "cannot export len, only globals can be exported"},
+		{"fn(a) { export a; }", "cannot export a, only globals can be exported"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		compileErr, ok := err.(*diagnostic.Diagnostic)
+		if !ok {
+			t.Fatalf("expected *diagnostic.Diagnostic. got=%T (%v)", err, err)
+		}
+		if compileErr.Message != tt.expected {
+			t.Errorf("wrong message. want=%q, got=%q", tt.expected, compileErr.Message)
+		}
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "for (x in [1]) { x }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpIter),
+				code.Make(code.OpIterNext, 20),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpJump, 7),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 // TestBooleanExpressions is a function to test the boolean expressions
 func TestBooleanExpressions(t *testing.T) {
 	tests := []compilerTestCase{
@@ -853,6 +1285,56 @@ func TestBooleanExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 12),
+				code.Make(code.OpFalse),
+				code.Make(code.OpJumpNotTruthy, 12),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJump, 13),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 8),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJump, 17),
+				code.Make(code.OpFalse),
+				code.Make(code.OpJumpNotTruthy, 16),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJump, 17),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "1 == 2",
 			expectedConstants: []interface{}{1, 2},
@@ -1003,6 +1485,26 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "1 // 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpFloorDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 ** 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPow),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "-1",
 			expectedConstants: []interface{}{1},
@@ -1139,3 +1641,17 @@ func testIntegerObject(expected int64, actual object.Object) error {
 
 	return nil
 }
+
+func TestTrace(t *testing.T) {
+	var out bytes.Buffer
+	comp := New()
+	comp.SetTrace(&out)
+	if err := comp.Compile(parse("1 + 2;")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := "emit 0000 OpConstant 0\nemit 0003 OpConstant 1\nemit 0006 OpAdd\nemit 0007 OpPop\n"
+	if out.String() != expected {
+		t.Errorf("wrong trace.\nwant=%q\ngot=%q", expected, out.String())
+	}
+}
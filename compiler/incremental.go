@@ -0,0 +1,217 @@
+// compiler/incremental.go
+
+package compiler
+
+import (
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+	"strings"
+)
+
+// Incremental compiles the successive versions of a program, as an editor or
+// a watcher sees them, recompiling only the top-level functions that changed
+// since the versions before. The functions compiled are kept in a constant
+// pool shared by the versions: the bytecode of a version refers to the
+// compiled functions of the unchanged ones where they are, in the pool, and
+// to those of the changed ones appended to it.
+//
+// A function is unchanged when its source and its first line are the same
+// and the names it uses outside of itself, such as globals and builtins,
+// refer to the same bindings. Functions importing modules are always
+// recompiled, since the modules may have changed.
+//
+// Constants of earlier versions stay in the pool, where running bytecode may
+// still use them. Once the constants added since the last version compiled
+// from scratch outnumber both its own and maxStale, the next version is.
+type Incremental struct {
+	constants []object.Object
+	functions *functionCache
+	fresh     int // fresh is the size of the pool after the last version compiled from scratch
+	reused    int
+}
+
+// maxStale is the number of constants added to the pool of a small program
+// before it is compiled from scratch
+const maxStale = 1024
+
+// functionCache holds the compiled top-level functions, by their key
+type functionCache struct {
+	entries map[functionKey]cachedFunction
+	added   map[functionKey]cachedFunction // added holds the functions compiled by the version being compiled
+	reused  int                            // reused counts the functions of the version found in entries
+	source  *sourceText
+}
+
+// functionKey identifies the source of a top-level function
+type functionKey struct {
+	name   string
+	line   int
+	source string
+}
+
+// cachedFunction is a compiled top-level function
+type cachedFunction struct {
+	index int               // index is the constant holding the compiled function
+	uses  map[string]Symbol // uses maps the names the function uses outside of itself to their symbols
+}
+
+// NewIncremental returns an incremental compiler that has compiled nothing yet
+func NewIncremental() *Incremental {
+	return &Incremental{
+		constants: []object.Object{},
+		functions: &functionCache{entries: map[functionKey]cachedFunction{}},
+	}
+}
+
+// Compile compiles program, parsed from source, and returns its bytecode.
+// The compiled functions of the unchanged top-level functions of the
+// versions compiled before are reused. A version that fails to compile
+// leaves the compiler as it was.
+func (inc *Incremental) Compile(program *ast.Program, source string) (*Bytecode, error) {
+	if stale := len(inc.constants) - inc.fresh; stale > inc.fresh && stale > maxStale {
+		inc.constants = []object.Object{}
+		inc.functions.entries = map[functionKey]cachedFunction{}
+	}
+	fresh := len(inc.functions.entries) == 0
+
+	inc.functions.added = map[functionKey]cachedFunction{}
+	inc.functions.reused = 0
+	inc.functions.source = newSourceText(source)
+	defer func() {
+		inc.functions.added = nil
+		inc.functions.source = nil
+	}()
+
+	c := NewWithState(New().symbolTable, inc.constants)
+	c.functions = inc.functions
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+
+	bytecode := c.Bytecode()
+	inc.constants = bytecode.Constants
+	for key, fn := range inc.functions.added {
+		inc.functions.entries[key] = fn
+	}
+	inc.reused = inc.functions.reused
+	if fresh {
+		inc.fresh = len(inc.constants)
+	}
+	return bytecode, nil
+}
+
+// Reused returns the number of top-level functions the last version
+// compiled reused the compiled functions of
+func (inc *Incremental) Reused() int {
+	return inc.reused
+}
+
+// compileCachedFunction compiles a top-level function literal, unless it is
+// unchanged since a version compiled before
+func (c *compiler) compileCachedFunction(node *ast.FunctionLiteral) error {
+	key, ok := c.functions.key(node)
+	if !ok {
+		_, err := c.compileFunction(node)
+		return err
+	}
+
+	if fn, ok := c.functions.lookup(key); ok && c.resolvesTo(fn.uses) {
+		c.functions.reused++
+		c.emit(code.OpClosure, fn.index, 0)
+		return nil
+	}
+
+	c.uses = map[string]Symbol{}
+	index, err := c.compileFunction(node)
+	uses := c.uses
+	c.uses = nil
+	if err != nil {
+		return err
+	}
+	c.functions.added[key] = cachedFunction{index: index, uses: uses}
+	return nil
+}
+
+// resolvesTo reports whether the names used by a cached function resolve to
+// the symbols they resolved to when it was compiled
+func (c *compiler) resolvesTo(uses map[string]Symbol) bool {
+	for name, symbol := range uses {
+		if resolved, ok := c.symbolTable.Resolve(name); !ok || resolved != symbol {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns the key of a top-level function, false for functions that
+// cannot be cached
+func (f *functionCache) key(node *ast.FunctionLiteral) (functionKey, bool) {
+	imports := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if _, ok := n.(*ast.ImportLiteral); ok {
+			imports = true
+		}
+		return !imports
+	})
+	if imports {
+		return functionKey{}, false
+	}
+
+	source, ok := f.source.between(node.Token, node.Body.End)
+	if !ok {
+		return functionKey{}, false
+	}
+	return functionKey{name: node.Name, line: node.Token.Line, source: source}, true
+}
+
+// lookup returns the cached function of key, compiled by a version before
+// or earlier in this one
+func (f *functionCache) lookup(key functionKey) (cachedFunction, bool) {
+	if fn, ok := f.added[key]; ok {
+		return fn, true
+	}
+	fn, ok := f.entries[key]
+	return fn, ok
+}
+
+// sourceText is the source of a program, with the offsets its lines start at
+type sourceText struct {
+	text  string
+	lines []int
+}
+
+// newSourceText returns the source text
+func newSourceText(text string) *sourceText {
+	lines := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return &sourceText{text: text, lines: lines}
+}
+
+// between returns the text from the start of the token start to the end of
+// the token end, false when the tokens are not found in the text
+func (s *sourceText) between(start, end token.Token) (string, bool) {
+	from, ok := s.offset(start)
+	if !ok {
+		return "", false
+	}
+	to, ok := s.offset(end)
+	if !ok || to < from || !strings.HasPrefix(s.text[to:], end.Literal) {
+		return "", false
+	}
+	return s.text[from : to+len(end.Literal)], true
+}
+
+// offset returns the offset of tok in the text
+func (s *sourceText) offset(tok token.Token) (int, bool) {
+	if tok.Line < 1 || tok.Line > len(s.lines) || tok.Column < 1 {
+		return 0, false
+	}
+	offset := s.lines[tok.Line-1] + tok.Column - 1
+	return offset, offset < len(s.text)
+}
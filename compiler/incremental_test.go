@@ -0,0 +1,90 @@
+package compiler
+
+import (
+	"monkey/code"
+	"monkey/object"
+	"testing"
+)
+
+func TestIncremental(t *testing.T) {
+	versions := []struct {
+		source string
+		reused int
+		err    string
+	}{
+		{"let add = fn(a, b) { a + b };\nlet twice = fn(x) { x * 2 };\nadd(1, twice(2));", 0, ""},
+		// twice changed
+		{"let add = fn(a, b) { a + b };\nlet twice = fn(x) { x + x };\nadd(1, twice(2));", 1, ""},
+		// A failed version leaves the compiler as it was
+		{"let add = fn(a, b) { a + c };\nlet twice = fn(x) { x + x };\nadd(1, twice(2));", 1, "undefined variable c"},
+		{"let add = fn(a, b) { a + b };\nlet twice = fn(x) { x + x };\nadd(1, twice(2));", 2, ""},
+		// Only the layout of the top level changed
+		{"let add = fn(a, b) { a + b };\nlet twice = fn(x) { x + x };\n\nadd(1,\n  twice(2));", 2, ""},
+		// twice moved to another line
+		{"let add = fn(a, b) { a + b };\n\nlet twice = fn(x) { x + x };\nadd(1, twice(2));", 1, ""},
+		// scale now refers to another global
+		{"let base = 1;\nlet step = 2;\nlet scale = fn(x) { x * base };\nscale(3);", 0, ""},
+		{"let step = 2;\nlet base = 1;\nlet scale = fn(x) { x * base };\nscale(3);", 0, ""},
+		{"let step = 3;\nlet base = 1;\nlet scale = fn(x) { x * base };\nscale(3);", 1, ""},
+	}
+
+	inc := NewIncremental()
+	functions := map[string]*object.CompiledFunction{}
+	for _, v := range versions {
+		bytecode, err := inc.Compile(parse(v.source), v.source)
+		if v.err != "" {
+			if err == nil || err.Error() != v.err {
+				t.Errorf("wrong error for %q. want=%q, got=%v", v.source, v.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", v.source, err)
+		}
+		if inc.Reused() != v.reused {
+			t.Errorf("wrong number of functions reused for %q. want=%d, got=%d", v.source, v.reused, inc.Reused())
+		}
+
+		// The bytecode matches that of a fresh compilation but for the
+		// constants it uses
+		comp := New()
+		if err := comp.Compile(parse(v.source)); err != nil {
+			t.Fatalf("compiler error for %q: %s", v.source, err)
+		}
+		if len(bytecode.Instructions) != len(comp.Bytecode().Instructions) {
+			t.Errorf("wrong instructions for %q.\nwant=%q\ngot=%q", v.source, comp.Bytecode().Instructions, bytecode.Instructions)
+		}
+
+		// Reused functions are those compiled before
+		reused := 0
+		for name, fn := range closures(t, bytecode) {
+			if functions[name] == fn {
+				reused++
+			}
+			functions[name] = fn
+		}
+		if reused != v.reused {
+			t.Errorf("functions of %q were not reused. want=%d, got=%d", v.source, v.reused, reused)
+		}
+	}
+}
+
+// closures is a helper function that returns the named functions the
+// closures made by the instructions of bytecode are made of
+func closures(t *testing.T, bytecode *Bytecode) map[string]*object.CompiledFunction {
+	fns := map[string]*object.CompiledFunction{}
+	ins := bytecode.Instructions
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		if code.Opcode(ins[i]) == code.OpClosure {
+			fn := bytecode.Constants[operands[0]].(*object.CompiledFunction)
+			fns[fn.Name] = fn
+		}
+		i += 1 + read
+	}
+	return fns
+}
@@ -0,0 +1,469 @@
+// compiler/module.go
+
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/diagnostic"
+	"monkey/imports"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CacheExt is the extension of the files compiled modules are cached in,
+// next to their source. Such files can be imported without their source.
+const CacheExt = imports.CompiledExt
+
+// Module is an imported file compiled on its own, so it can be cached and
+// linked into every program that imports it. Its globals are numbered from
+// zero and its constants are its own until it is linked.
+type Module struct {
+	Bytecode *Bytecode
+	Globals  []string       // Globals names the globals of the module by index, empty for shadowed ones
+	Exports  []string       // Exports holds the names visible to importers, nil when all of them are
+	Imports  []ModuleImport // Imports holds the modules the module imports
+}
+
+// ModuleImport is an import statement of a module. The globals bound by the
+// imported module are linked to the bindings of the importer.
+type ModuleImport struct {
+	Path     string         `msgpack:"path"`     // Path is the path as written in the import statement
+	Checksum string         `msgpack:"checksum"` // Checksum pins a remote import
+	Globals  map[string]int `msgpack:"globals"`  // Globals maps the imported names to the globals of the module
+}
+
+// ImportError is an error in an imported file, or in an import statement of
+// one, with the chain of imports it was raised through. Line and Column are
+// the position of the import statement of the file being compiled.
+type ImportError struct {
+	Imports []object.ImportSite // Imports lists the sites the error propagated through, innermost first
+	Err     error
+	Line    int
+	Column  int
+}
+
+func (e *ImportError) Error() string {
+	return e.Chain("<main>")
+}
+
+// Chain returns the error with its import chain, main naming the program
+// the imports started from
+func (e *ImportError) Chain(main string) string {
+	return object.ImportChain(e.Imports, main) + ": " + e.Err.Error()
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// importError is a helper function that adds the import statement node of
+// the file being compiled to the chain of an error raised by importing filename
+func (c *compiler) importError(err error, filename string, node *ast.ImportLiteral) *ImportError {
+	chained := chainImport(err, filename, object.ImportSite{File: c.file, Line: node.Token.Line})
+	chained.Line, chained.Column = node.Token.Line, node.Token.Column
+	return chained
+}
+
+// chainImport is a helper function that adds site to the chain of an error
+// raised by importing filename. Errors without a line of filename are errors
+// of the import statement at site itself.
+func chainImport(err error, filename string, site object.ImportSite) *ImportError {
+	chained := &ImportError{Err: err}
+	switch err := err.(type) {
+	case *ImportError:
+		chained.Err = err.Err
+		chained.Imports = append(append([]object.ImportSite{}, err.Imports...), site)
+	case *diagnostic.Diagnostic:
+		chained.Imports = []object.ImportSite{{File: filename, Line: err.Line}, site}
+	default:
+		chained.Imports = []object.ImportSite{site}
+	}
+	return chained
+}
+
+// exported returns the names of the module visible to importers
+func (m *Module) exported() []string {
+	if m.Exports != nil {
+		return m.Exports
+	}
+
+	var names []string
+	for _, name := range m.Globals {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// compileImport links the module imported by node into the program, or
+// records the import when a module is compiled on its own. Modules imported
+// at the top level are only linked once, later imports only bring their
+// names back into scope, or bind them in a hash to the alias of the import.
+func (c *compiler) compileImport(node *ast.ImportLiteral) error {
+	filename, path, err := c.resolveImport(node.Path, node.Checksum, c.file)
+	if err != nil {
+		return err
+	}
+
+	symbols, ok := c.imported[path]
+	if !ok || c.scopeIndex != 0 {
+		mod, err := loadModule(filename, c.imports, c.loading)
+		if err != nil {
+			return c.importError(err, filename, node)
+		}
+
+		if c.module != nil {
+			symbols = c.recordImport(node, mod)
+		} else if symbols, err = c.link(mod, filename); err != nil {
+			return c.importError(err, filename, node)
+		}
+		if c.scopeIndex == 0 {
+			c.imported[path] = symbols
+		}
+	}
+
+	if node.Alias != nil {
+		c.compileNamespace(node.Alias, symbols)
+		return nil
+	}
+	c.symbolTable.expose(symbols)
+	c.emit(code.OpImport, c.addConstant(&object.String{Value: node.Path}))
+	return nil
+}
+
+// compileNamespace binds alias to a hash of the symbols exposed by an
+// imported module, import "m" as m, which is also the value of the import
+func (c *compiler) compileNamespace(alias *ast.Identifier, symbols []Symbol) {
+	sorted := append([]Symbol{}, symbols...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	for _, symbol := range sorted {
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: symbol.Name}))
+		c.loadSymbol(symbol)
+	}
+	c.emit(code.OpHash, len(sorted)*2)
+
+	symbol := c.symbolTable.Define(alias.Value)
+	c.storeSymbol(symbol)
+	c.loadSymbol(symbol)
+}
+
+// resolveImport returns the file imported by path from the file importer and
+// its absolute path, which identifies the module
+func (c *compiler) resolveImport(path, checksum, importer string) (string, string, error) {
+	dir := ""
+	if importer != "" {
+		dir = imports.Dir(importer)
+	}
+
+	filename, err := c.imports.Resolve(path, checksum, dir)
+	if err != nil {
+		return "", "", err
+	}
+	abs, err := imports.Abs(filename)
+	if err != nil {
+		return "", "", err
+	}
+	return filename, abs, nil
+}
+
+// recordImport gives the names exported by an imported module globals of
+// the module being compiled, to be linked to the imported module later
+func (c *compiler) recordImport(node *ast.ImportLiteral, mod *Module) []Symbol {
+	globals := c.globals()
+	imp := ModuleImport{Path: node.Path, Checksum: node.Checksum, Globals: map[string]int{}}
+
+	var symbols []Symbol
+	for _, name := range mod.exported() {
+		symbol := Symbol{Name: name, Scope: GlobalScope, Index: globals.allocate()}
+		imp.Globals[name] = symbol.Index
+		symbols = append(symbols, symbol)
+	}
+
+	c.module.Imports = append(c.module.Imports, imp)
+	return symbols
+}
+
+// link appends the code of a module compiled from filename to the program.
+// The modules it imports are linked first, then its globals are given slots
+// of the program and its constants are appended to the program's. It returns
+// the symbols the module exposes to the importer.
+func (c *compiler) link(mod *Module, filename string) ([]Symbol, error) {
+	globals := c.globals()
+	slots := make([]int, len(mod.Globals))
+	linked := make([]bool, len(mod.Globals))
+
+	for _, imp := range mod.Imports {
+		importedFile, path, err := c.resolveImport(imp.Path, imp.Checksum, filename)
+		if err != nil {
+			return nil, err
+		}
+
+		symbols, ok := c.imported[path]
+		if !ok {
+			// Compiled modules do not keep the lines of their imports
+			importedMod, err := loadModule(importedFile, c.imports, c.loading)
+			if err != nil {
+				return nil, chainImport(err, importedFile, object.ImportSite{File: filename})
+			}
+			if symbols, err = c.link(importedMod, importedFile); err != nil {
+				return nil, chainImport(err, importedFile, object.ImportSite{File: filename})
+			}
+			c.imported[path] = symbols
+		}
+
+		for name, slot := range imp.Globals {
+			symbol, ok := findSymbol(symbols, name)
+			if !ok {
+				return nil, fmt.Errorf("%s does not export %s, imported by %s", imp.Path, name, filename)
+			}
+			slots[slot] = symbol.Index
+			linked[slot] = true
+		}
+	}
+
+	visible := map[string]bool{}
+	for _, name := range mod.exported() {
+		visible[name] = true
+	}
+
+	var symbols []Symbol
+	for slot, name := range mod.Globals {
+		if !linked[slot] {
+			slots[slot] = globals.allocate()
+		}
+		if name != "" && visible[name] {
+			symbols = append(symbols, Symbol{Name: name, Scope: GlobalScope, Index: slots[slot]})
+		}
+	}
+
+	offset := len(c.constants)
+	for _, constant := range mod.Bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			relocated := *fn
+			relocated.Instructions = relocate(fn.Instructions, offset, slots, 0)
+			constant = &relocated
+		}
+		c.constants = append(c.constants, constant)
+	}
+
+	position := len(c.currentInstructions())
+	c.addInstruction(relocate(mod.Bytecode.Instructions, offset, slots, position))
+	for _, entry := range mod.Bytecode.Lines {
+		c.scopes[c.scopeIndex].lines = append(c.scopes[c.scopeIndex].lines, code.LineEntry{Pos: position + entry.Pos, Line: entry.Line})
+	}
+
+	return symbols, nil
+}
+
+// findSymbol is a helper function that returns the symbol with the given name
+func findSymbol(symbols []Symbol, name string) (Symbol, bool) {
+	for _, symbol := range symbols {
+		if symbol.Name == name {
+			return symbol, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// relocate returns a copy of the instructions of a module with its constants
+// moved by offset, its globals moved to their slots in the program and its
+// jumps moved by jumpOffset
+func relocate(ins code.Instructions, offset int, slots []int, jumpOffset int) code.Instructions {
+	relocated := make(code.Instructions, 0, len(ins))
+
+	for i := 0; i < len(ins); {
+		op := code.Opcode(ins[i])
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			relocated = append(relocated, ins[i:]...)
+			break
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		switch op {
+		case code.OpConstant, code.OpClosure, code.OpImport, code.OpCallMethod:
+			operands[0] += offset
+		case code.OpGetGlobal, code.OpSetGlobal:
+			operands[0] = slots[operands[0]]
+		case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext, code.OpTry:
+			operands[0] += jumpOffset
+		}
+
+		relocated = append(relocated, code.Make(op, operands...)...)
+		i += 1 + read
+	}
+
+	return relocated
+}
+
+// globals returns the symbol table of the globals
+func (c *compiler) globals() *SymbolTable {
+	table := c.symbolTable
+	for table.Outer != nil {
+		table = table.Outer
+	}
+	return table
+}
+
+// loadModule returns the module compiled from filename, reading it from its
+// cache file when the cache was made from the same source. The files it
+// imports are found with resolver. Modules being loaded are kept in loading
+// to report import cycles. A precompiled module, import "lib.mkyc", is read
+// as it is.
+func loadModule(filename string, resolver *imports.Resolver, loading map[string]bool) (*Module, error) {
+	if imports.IsCompiled(filename) {
+		data, err := imports.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		mod, _, err := UnmarshalModule(data)
+		return mod, err
+	}
+
+	source, err := imports.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// The standard library is not cached, there is nowhere to write it to
+	cached := !imports.IsStd(filename)
+	hash := moduleHash(source)
+	cache := cachePath(filename)
+	if data, err := os.ReadFile(cache); err == nil && cached {
+		if mod, cachedHash, err := UnmarshalModule(data); err == nil && cachedHash == hash {
+			return mod, nil
+		}
+	}
+
+	abs, err := imports.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if loading[abs] {
+		return nil, fmt.Errorf("import cycle: %s imports itself", filename)
+	}
+	loading[abs] = true
+	defer delete(loading, abs)
+
+	// The first parser error is reported, the others often follow from it
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		first := errors[0]
+		first.Message = strings.TrimPrefix(first.Message, fmt.Sprintf("On line %d, ", first.Line))
+		first.File = filename
+		return nil, &first
+	}
+
+	mod, err := compileModule(filename, program, resolver, loading)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cache is only an optimization, a directory that cannot be
+	// written to compiles the module on every run
+	if data, err := mod.MarshalBinary(hash); cached && err == nil {
+		_ = os.WriteFile(cache, data, 0644)
+	}
+
+	return mod, nil
+}
+
+// WriteModule compiles filename on its own into a module written to output,
+// in the format modules are cached in, so it can be imported or run without
+// its source. The files it imports are found with resolver.
+func WriteModule(filename, output string, resolver *imports.Resolver) error {
+	mod, err := loadModule(filename, resolver, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	source, err := imports.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	data, err := mod.MarshalBinary(moduleHash(source))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, data, 0644)
+}
+
+// LoadProgram links the module compiled to the file filename, and the
+// modules it imports, found with resolver, into a program of its own
+func LoadProgram(filename string, resolver *imports.Resolver) (*Bytecode, error) {
+	mod, err := loadModule(filename, resolver, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	c := New()
+	c.imports = resolver
+	if _, err := c.link(mod, filename); err != nil {
+		return nil, err
+	}
+	return c.Bytecode(), nil
+}
+
+// compileModule compiles the program of a module on its own
+func compileModule(filename string, program *ast.Program, resolver *imports.Resolver, loading map[string]bool) (*Module, error) {
+	c := New()
+	c.file = filename
+	c.imports = resolver
+	c.module = &Module{}
+	c.loading = loading
+
+	if err := c.Compile(program); err != nil {
+		if d, ok := err.(*diagnostic.Diagnostic); ok && d.File == "" {
+			d.File = filename
+		}
+		return nil, err
+	}
+
+	mod := c.module
+	mod.Bytecode = c.Bytecode()
+	mod.Globals = make([]string, c.symbolTable.numDefinitions)
+	for _, symbol := range c.symbolTable.store {
+		if symbol.Scope == GlobalScope {
+			mod.Globals[symbol.Index] = symbol.Name
+		}
+	}
+
+	if exports := program.Exports(); exports != nil {
+		mod.Exports = make([]string, 0, len(exports))
+		for name := range exports {
+			mod.Exports = append(mod.Exports, name)
+		}
+		sort.Strings(mod.Exports)
+	}
+
+	return mod, nil
+}
+
+// cachePath returns the file the module compiled from filename is cached in
+func cachePath(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + CacheExt
+}
+
+// moduleHash returns the key of a cached module. Builtins are compiled to
+// their index, so the names of the builtins are part of it.
+func moduleHash(source []byte) string {
+	h := sha256.New()
+	h.Write(source)
+	for _, def := range object.Builtins {
+		h.Write([]byte{0})
+		h.Write([]byte(def.Name))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
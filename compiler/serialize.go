@@ -0,0 +1,182 @@
+// compiler/serialize.go
+
+package compiler
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/object"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// BytecodeVersion identifies the layout of serialized bytecode and is bumped
+// whenever it changes, so stale bytecode is rejected instead of misread
+const BytecodeVersion = 1
+
+type serializedBytecode struct {
+	Version      int                  `msgpack:"version"`
+	Instructions []byte               `msgpack:"instructions"`
+	Lines        []code.LineEntry     `msgpack:"lines"`
+	Calls        []code.CallEntry     `msgpack:"calls,omitempty"`
+	Constants    []serializedConstant `msgpack:"constants"`
+}
+
+// serializedConstant is a constant pool entry tagged with its object type
+type serializedConstant struct {
+	Type     object.ObjectType   `msgpack:"type"`
+	Integer  int64               `msgpack:"integer,omitempty"`
+	Float    float64             `msgpack:"float,omitempty"`
+	String   string              `msgpack:"string,omitempty"`
+	Function *serializedFunction `msgpack:"function,omitempty"`
+}
+
+type serializedFunction struct {
+	Instructions  []byte           `msgpack:"instructions"`
+	NumLocals     int              `msgpack:"num_locals"`
+	NumParameters int              `msgpack:"num_parameters"`
+	Name          string           `msgpack:"name"`
+	Lines         []code.LineEntry `msgpack:"lines"`
+	Calls         []code.CallEntry `msgpack:"calls,omitempty"`
+	LocalNames    []string         `msgpack:"local_names,omitempty"`
+	FreeNames     []string         `msgpack:"free_names,omitempty"`
+}
+
+// MarshalBinary encodes the bytecode so it can be stored and run later
+// without the source
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	out, err := serializeBytecode(b)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(out)
+}
+
+// UnmarshalBytecode decodes bytecode encoded with MarshalBinary
+func UnmarshalBytecode(data []byte) (*Bytecode, error) {
+	var in serializedBytecode
+	if err := msgpack.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("invalid bytecode: %s", err)
+	}
+	return deserializeBytecode(in)
+}
+
+type serializedModule struct {
+	Bytecode serializedBytecode `msgpack:"bytecode"`
+	Hash     string             `msgpack:"hash"`
+	Globals  []string           `msgpack:"globals"`
+	Exports  []string           `msgpack:"exports"`
+	Imports  []ModuleImport     `msgpack:"imports"`
+}
+
+// MarshalBinary encodes the module with the hash of the source it was
+// compiled from, so a cached module can be checked for staleness
+func (m *Module) MarshalBinary(hash string) ([]byte, error) {
+	bytecode, err := serializeBytecode(m.Bytecode)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(serializedModule{
+		Bytecode: bytecode,
+		Hash:     hash,
+		Globals:  m.Globals,
+		Exports:  m.Exports,
+		Imports:  m.Imports,
+	})
+}
+
+// UnmarshalModule decodes a module encoded with MarshalBinary and returns
+// it with the hash of its source
+func UnmarshalModule(data []byte) (*Module, string, error) {
+	var in serializedModule
+	if err := msgpack.Unmarshal(data, &in); err != nil {
+		return nil, "", fmt.Errorf("invalid module: %s", err)
+	}
+
+	bytecode, err := deserializeBytecode(in.Bytecode)
+	if err != nil {
+		return nil, "", err
+	}
+	return &Module{Bytecode: bytecode, Globals: in.Globals, Exports: in.Exports, Imports: in.Imports}, in.Hash, nil
+}
+
+// serializeBytecode is a helper function that converts bytecode to its encoded form
+func serializeBytecode(b *Bytecode) (serializedBytecode, error) {
+	out := serializedBytecode{
+		Version:      BytecodeVersion,
+		Instructions: b.Instructions,
+		Lines:        b.Lines,
+		Calls:        b.Calls,
+		Constants:    make([]serializedConstant, len(b.Constants)),
+	}
+
+	for i, constant := range b.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer:
+			out.Constants[i] = serializedConstant{Type: constant.Type(), Integer: constant.Value}
+		case *object.Float:
+			out.Constants[i] = serializedConstant{Type: constant.Type(), Float: constant.Value}
+		case *object.String:
+			out.Constants[i] = serializedConstant{Type: constant.Type(), String: constant.Value}
+		case *object.CompiledFunction:
+			out.Constants[i] = serializedConstant{Type: constant.Type(), Function: &serializedFunction{
+				Instructions:  constant.Instructions,
+				NumLocals:     constant.NumLocals,
+				NumParameters: constant.NumParameters,
+				Name:          constant.Name,
+				Lines:         constant.Lines,
+				Calls:         constant.Calls,
+				LocalNames:    constant.LocalNames,
+				FreeNames:     constant.FreeNames,
+			}}
+		default:
+			return out, fmt.Errorf("cannot serialize constant of type %s", constant.Type())
+		}
+	}
+
+	return out, nil
+}
+
+// deserializeBytecode is a helper function that converts encoded bytecode back
+func deserializeBytecode(in serializedBytecode) (*Bytecode, error) {
+	if in.Version != BytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d, want %d", in.Version, BytecodeVersion)
+	}
+
+	bytecode := &Bytecode{
+		Instructions: in.Instructions,
+		Lines:        in.Lines,
+		Calls:        in.Calls,
+		Constants:    make([]object.Object, len(in.Constants)),
+	}
+
+	for i, constant := range in.Constants {
+		switch constant.Type {
+		case object.INTEGER_OBJ:
+			bytecode.Constants[i] = &object.Integer{Value: constant.Integer}
+		case object.FLOAT_OBJ:
+			bytecode.Constants[i] = &object.Float{Value: constant.Float}
+		case object.STRING_OBJ:
+			bytecode.Constants[i] = &object.String{Value: constant.String}
+		case object.COMPILED_FUNCTION_OBJ:
+			if constant.Function == nil {
+				return nil, fmt.Errorf("invalid bytecode: constant %d has no function", i)
+			}
+			fn := constant.Function
+			bytecode.Constants[i] = &object.CompiledFunction{
+				Instructions:  fn.Instructions,
+				NumLocals:     fn.NumLocals,
+				NumParameters: fn.NumParameters,
+				Name:          fn.Name,
+				Lines:         fn.Lines,
+				Calls:         fn.Calls,
+				LocalNames:    fn.LocalNames,
+				FreeNames:     fn.FreeNames,
+			}
+		default:
+			return nil, fmt.Errorf("invalid bytecode: unknown constant type %s", constant.Type)
+		}
+	}
+
+	return bytecode, nil
+}
@@ -0,0 +1,58 @@
+package compiler
+
+import (
+	"monkey/object"
+	"reflect"
+	"testing"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	program := parse(`let add = fn(a, b) { a + b };
+puts(add(1, 2), 2.5, "three");`)
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+
+	decoded, err := UnmarshalBytecode(data)
+	if err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+
+	if !reflect.DeepEqual(decoded.Instructions, bytecode.Instructions) {
+		t.Errorf("wrong instructions.\nwant=%q\ngot=%q", bytecode.Instructions, decoded.Instructions)
+	}
+	if !reflect.DeepEqual(decoded.Lines, bytecode.Lines) {
+		t.Errorf("wrong lines. want=%v, got=%v", bytecode.Lines, decoded.Lines)
+	}
+	if !reflect.DeepEqual(decoded.Calls, bytecode.Calls) {
+		t.Errorf("wrong calls. want=%v, got=%v", bytecode.Calls, decoded.Calls)
+	}
+	if len(decoded.Constants) != len(bytecode.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(bytecode.Constants), len(decoded.Constants))
+	}
+	for i, constant := range bytecode.Constants {
+		if !reflect.DeepEqual(decoded.Constants[i], constant) {
+			t.Errorf("constant %d wrong. want=%+v, got=%+v", i, constant, decoded.Constants[i])
+		}
+	}
+}
+
+func TestUnmarshalBytecodeErrors(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Boolean{Value: true}}}
+	if _, err := bytecode.MarshalBinary(); err == nil {
+		t.Errorf("expected an error serializing a boolean constant")
+	}
+
+	if _, err := UnmarshalBytecode([]byte("not bytecode")); err == nil {
+		t.Errorf("expected an error decoding garbage")
+	}
+}
@@ -2,6 +2,11 @@
 
 package compiler
 
+import (
+	"monkey/object"
+	"sort"
+)
+
 type SymbolScope string
 
 const (
@@ -10,6 +15,7 @@ const (
 	BuiltinScope  SymbolScope = "BUILTIN"
 	FreeScope     SymbolScope = "FREE"
 	FunctionScope SymbolScope = "FUNCTION"
+	ExtendedScope SymbolScope = "EXTENDED"
 )
 
 type Symbol struct {
@@ -23,6 +29,8 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 	FreeSymbols    []Symbol
+
+	Functions *object.Registry // Functions holds the extension functions of the program, set on the outermost table
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -72,6 +80,113 @@ func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	return symbol
 }
 
+// SymbolTableSnapshot is the saved state of a SymbolTable
+type SymbolTableSnapshot struct {
+	store          map[string]Symbol
+	numDefinitions int
+	freeSymbols    []Symbol
+}
+
+// Snapshot records the definitions of the table so they can be rolled back
+// when a compilation fails halfway through
+func (s *SymbolTable) Snapshot() SymbolTableSnapshot {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+
+	free := make([]Symbol, len(s.FreeSymbols))
+	copy(free, s.FreeSymbols)
+
+	return SymbolTableSnapshot{store: store, numDefinitions: s.numDefinitions, freeSymbols: free}
+}
+
+// Restore rolls the table back to the snapshot. Symbols defined after the
+// snapshot was taken are dropped unless keep returns true for them.
+func (s *SymbolTable) Restore(snapshot SymbolTableSnapshot, keep func(Symbol) bool) {
+	current := s.store
+
+	s.store = make(map[string]Symbol, len(snapshot.store))
+	for name, symbol := range snapshot.store {
+		s.store[name] = symbol
+	}
+	s.numDefinitions = snapshot.numDefinitions
+	s.FreeSymbols = snapshot.freeSymbols
+
+	for name, symbol := range current {
+		if old, ok := snapshot.store[name]; ok && old == symbol {
+			continue
+		}
+		if keep == nil || !keep(symbol) {
+			continue
+		}
+
+		s.store[name] = symbol
+		if symbol.Scope != FunctionScope && symbol.Scope != BuiltinScope && symbol.Index >= s.numDefinitions {
+			s.numDefinitions = symbol.Index + 1
+		}
+	}
+}
+
+// expose puts symbols back into the table under their names
+func (s *SymbolTable) expose(symbols []Symbol) {
+	for _, symbol := range symbols {
+		s.store[symbol.Name] = symbol
+	}
+}
+
+// allocate reserves a slot no name refers to
+func (s *SymbolTable) allocate() int {
+	s.numDefinitions++
+	return s.numDefinitions - 1
+}
+
+// Symbols returns the symbols defined directly in this table ordered by scope and index
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.store))
+	for _, symbol := range s.store {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Scope != symbols[j].Scope {
+			return symbols[i].Scope < symbols[j].Scope
+		}
+		return symbols[i].Index < symbols[j].Index
+	})
+	return symbols
+}
+
+// localNames returns the names of the locals defined in this table, indexed
+// like the locals themselves, or nil when there are none
+func (s *SymbolTable) localNames() []string {
+	if s.numDefinitions == 0 {
+		return nil
+	}
+	names := make([]string, s.numDefinitions)
+	for _, symbol := range s.store {
+		if symbol.Scope == LocalScope {
+			names[symbol.Index] = symbol.Name
+		}
+	}
+	return names
+}
+
+// symbolNames is a helper function that returns the names of the symbols, or nil when there are none
+func symbolNames(symbols []Symbol) []string {
+	if len(symbols) == 0 {
+		return nil
+	}
+	names := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		names[i] = symbol.Name
+	}
+	return names
+}
+
+// Resolve returns the symbol a name refers to. Names defined nowhere refer
+// to the functions of the registry of the outermost table, or else those
+// plugins registered with object.RegisterFunction, which are looked up by
+// name when the program runs.
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	if !ok && s.Outer != nil {
@@ -80,12 +195,17 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 			return obj, ok
 		}
 
-		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope || obj.Scope == ExtendedScope {
 			return obj, ok
 		}
 
 		free := s.defineFree(obj)
 		return free, true
 	}
+	if !ok {
+		if _, registered := s.Functions.Lookup(name); registered {
+			return Symbol{Name: name, Scope: ExtendedScope}, true
+		}
+	}
 	return obj, ok
 }
@@ -3,6 +3,7 @@
 package compiler
 
 import (
+	"monkey/object"
 	"testing"
 )
 
@@ -187,6 +188,30 @@ func TestDefineResolveBuiltins(t *testing.T) {
 	}
 }
 
+// TestResolveExtended tests that functions registered by plugins resolve in
+// every scope unless a definition shadows them
+func TestResolveExtended(t *testing.T) {
+	object.RegisterFunction("resolved", object.Extended{Fn: func(args ...object.Object) object.Object { return nil }})
+
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+
+	expected := Symbol{Name: "resolved", Scope: ExtendedScope}
+	for _, table := range []*SymbolTable{global, local} {
+		if result, ok := table.Resolve("resolved"); !ok || result != expected {
+			t.Errorf("expected resolved to resolve to %+v, got=%+v", expected, result)
+		}
+	}
+	if len(local.FreeSymbols) != 0 {
+		t.Errorf("extended function captured as a free variable: %+v", local.FreeSymbols)
+	}
+
+	local.Define("resolved")
+	if result, _ := local.Resolve("resolved"); result.Scope != LocalScope {
+		t.Errorf("definition does not shadow the extended function. got=%+v", result)
+	}
+}
+
 // TestDefine is a test case for Define
 func TestDefine(t *testing.T) {
 	expected := map[string]Symbol{
@@ -235,6 +260,60 @@ func TestDefine(t *testing.T) {
 	}
 }
 
+// TestSymbols is a test case for listing the symbols of a table
+func TestSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("b")
+	global.Define("a")
+
+	expected := []Symbol{
+		{Name: "len", Scope: BuiltinScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 0},
+		{Name: "a", Scope: GlobalScope, Index: 1},
+	}
+
+	symbols := global.Symbols()
+	if len(symbols) != len(expected) {
+		t.Fatalf("wrong number of symbols. want=%d, got=%d", len(expected), len(symbols))
+	}
+	for i, sym := range expected {
+		if symbols[i] != sym {
+			t.Errorf("expected symbol %d to be %+v, got=%+v", i, sym, symbols[i])
+		}
+	}
+}
+
+// TestSnapshotRestore is a test case for rolling back definitions
+func TestSnapshotRestore(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	snapshot := global.Snapshot()
+	global.Define("b")
+	global.Define("c")
+	global.Define("a")
+
+	global.Restore(snapshot, func(s Symbol) bool { return s.Name == "c" })
+
+	a, ok := global.Resolve("a")
+	if !ok || a.Index != 0 {
+		t.Errorf("expected a to be restored to index 0, got=%+v", a)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("expected b to be rolled back")
+	}
+	c, ok := global.Resolve("c")
+	if !ok || c.Index != 2 {
+		t.Errorf("expected c to be kept at index 2, got=%+v", c)
+	}
+
+	d := global.Define("d")
+	if d.Index != 3 {
+		t.Errorf("expected d to be defined after the kept symbols, got=%+v", d)
+	}
+}
+
 // TestResolveNestedLocal is a test case for nested locals
 func testResolveNestedLocal(t *testing.T) {
 	global := NewSymbolTable()
@@ -0,0 +1,294 @@
+// cover/cover.go
+
+// Package cover measures the lines of Monkey scripts their runs cover, for
+// `monkey test --cover`. The evaluator counts, through its hooks, the
+// statements it runs by the file and line they start on. The lines of a
+// file that can be covered are those statements start on.
+package cover
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/imports"
+	"monkey/lexer"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Profile counts the statements run by the evaluator with its hooks
+// installed, by file and line. Statements run by pmap() are counted on
+// several goroutines at once, so the counts are kept holding mu.
+type Profile struct {
+	mu    sync.Mutex
+	main  string                 // main is the file of the program running, whose statements have no file
+	hits  map[string]map[int]int // hits maps the absolute paths of the files run to the statements run by line
+	paths map[string]string      // paths maps the files of the statements run to their absolute paths, empty for those left out
+}
+
+// New returns a profile that has not counted anything yet
+func New() *Profile {
+	return &Profile{hits: map[string]map[int]int{}, paths: map[string]string{}}
+}
+
+// Hooks returns the evaluator hooks feeding the profile
+func (p *Profile) Hooks() *evaluator.Hooks {
+	return &evaluator.Hooks{OnStatement: p.onStatement}
+}
+
+// SetMain sets the file of the programs run next, the statements of which
+// are reported without a file
+func (p *Profile) SetMain(filename string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.main = filename
+}
+
+// onStatement counts a statement run. The standard library, which is
+// embedded, is left out.
+func (p *Profile) onStatement(ev evaluator.StatementEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	file := ev.File
+	if file == "" {
+		file = p.main
+	}
+	file = p.path(file)
+	if file == "" {
+		return
+	}
+
+	lines, ok := p.hits[file]
+	if !ok {
+		lines = map[int]int{}
+		p.hits[file] = lines
+	}
+	lines[ev.Line]++
+}
+
+// path is a helper function that returns the absolute path of the file of
+// a statement run, found once per file, or "" for a file left out
+func (p *Profile) path(file string) string {
+	if path, ok := p.paths[file]; ok {
+		return path
+	}
+	path := ""
+	if !imports.IsStd(file) {
+		path = file
+		if abs, err := filepath.Abs(file); err == nil {
+			path = abs
+		}
+	}
+	p.paths[file] = path
+	return path
+}
+
+// File is the coverage of a file
+type File struct {
+	Path    string // Path is the path of the file, relative to the current directory when it is below it
+	Source  string
+	Lines   []Line // Lines holds the lines of the file that can be covered, in order
+	Covered int    // Covered is the number of Lines run at least once
+}
+
+// Line is a line of a file statements start on
+type Line struct {
+	Number int
+	Count  int // Count is the number of statements run starting on the line
+}
+
+// Percent returns the part of the lines of the file that are covered, as a
+// percentage
+func (f File) Percent() float64 {
+	return percent(f.Covered, len(f.Lines))
+}
+
+// Files returns the coverage of the files run for which include returns true,
+// sorted by path. include is given the absolute path of each file.
+func (p *Profile) Files(include func(path string) bool) ([]File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var paths []string
+	for path := range p.hits {
+		if include(path) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	files := make([]File, 0, len(paths))
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		file := File{Path: relative(path), Source: string(source)}
+		for _, number := range statementLines(string(source)) {
+			line := Line{Number: number, Count: p.hits[path][number]}
+			if line.Count > 0 {
+				file.Covered++
+			}
+			file.Lines = append(file.Lines, line)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// statementLines is a helper function that returns the lines statements of
+// source start on, in order
+func statementLines(source string) []int {
+	program := parser.New(lexer.New(source)).ParseProgram()
+
+	seen := map[int]bool{}
+	var lines []int
+	ast.Inspect(program, func(node ast.Node) bool {
+		if _, ok := node.(ast.Statement); !ok {
+			return true
+		}
+		if _, ok := node.(*ast.BlockStatement); ok {
+			return true
+		}
+		if line := ast.LineOf(node); line > 0 && !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+		return true
+	})
+	sort.Ints(lines)
+	return lines
+}
+
+// relative is a helper function that returns path relative to the current
+// directory when it is below it
+func relative(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// percent is a helper function that returns covered out of total as a
+// percentage, 100 for nothing to cover
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// WriteReport writes the coverage of each file and of all of them as a table
+func WriteReport(w io.Writer, files []File) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	covered, total := 0, 0
+	for _, f := range files {
+		fmt.Fprintf(tw, "%s\t%.1f%%\t(%d/%d lines)\n", f.Path, f.Percent(), f.Covered, len(f.Lines))
+		covered += f.Covered
+		total += len(f.Lines)
+	}
+	fmt.Fprintf(tw, "total\t%.1f%%\t(%d/%d lines)\n", percent(covered, total), covered, total)
+	return tw.Flush()
+}
+
+// WriteProfile writes the number of statements run on every line that can
+// be covered, one path:line count per line after a mode: count header
+func WriteProfile(w io.Writer, files []File) error {
+	if _, err := fmt.Fprintln(w, "mode: count"); err != nil {
+		return err
+	}
+	for _, f := range files {
+		for _, line := range f.Lines {
+			if _, err := fmt.Fprintf(w, "%s:%d %d\n", f.Path, line.Number, line.Count); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// htmlLine is a line of source shown in the HTML report
+type htmlLine struct {
+	Number int
+	Text   string
+	Count  int
+	Class  string // Class is covered, uncovered or empty for lines without statements
+}
+
+// htmlFile is a file shown in the HTML report
+type htmlFile struct {
+	Path    string
+	Percent float64
+	Lines   []htmlLine
+}
+
+// WriteHTML writes the coverage as an HTML page showing the source of every
+// file, its covered lines in green and the others in red
+func WriteHTML(w io.Writer, files []File) error {
+	page := make([]htmlFile, len(files))
+	for i, f := range files {
+		counts := map[int]int{}
+		for _, line := range f.Lines {
+			counts[line.Number] = line.Count
+		}
+
+		page[i] = htmlFile{Path: f.Path, Percent: f.Percent()}
+		for n, text := range strings.Split(strings.TrimSuffix(f.Source, "\n"), "\n") {
+			line := htmlLine{Number: n + 1, Text: text}
+			if count, ok := counts[line.Number]; ok {
+				line.Count, line.Class = count, "uncovered"
+				if count > 0 {
+					line.Class = "covered"
+				}
+			}
+			page[i].Lines = append(page[i].Lines, line)
+		}
+	}
+	return htmlTemplate.Execute(w, page)
+}
+
+var htmlTemplate = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Monkey coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; }
+td { padding: 0 8px; white-space: pre; }
+td.number, td.count { color: #999; text-align: right; }
+tr.covered td.source { background: #d7f5d7; }
+tr.uncovered td.source { background: #f8d4d4; }
+</style>
+</head>
+<body>
+<h1>Monkey coverage</h1>
+<ul>
+{{- range $i, $f := .}}
+<li><a href="#file{{$i}}">{{$f.Path}}</a> {{printf "%.1f" $f.Percent}}%</li>
+{{- end}}
+</ul>
+{{- range $i, $f := .}}
+<h2 id="file{{$i}}">{{$f.Path}} ({{printf "%.1f" $f.Percent}}%)</h2>
+<table>
+{{- range $f.Lines}}
+<tr class="{{.Class}}"><td class="number">{{.Number}}</td><td class="count">{{if .Class}}{{.Count}}{{end}}</td><td class="source">{{.Text}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,106 @@
+package cover
+
+import (
+	"bytes"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.mky")
+	source := `let abs = fn(x) {
+    if (x < 0) {
+        return -x;
+    }
+    x
+};
+let unused = fn() {
+    1
+};
+`
+	if err := os.WriteFile(lib, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main_test.mky")
+	program := `import "` + lib + `"; abs(-1); abs(-2);`
+
+	profile := New()
+	profile.SetMain(main)
+	env := object.NewEnvironment()
+	env.SetContext(evaluator.WithHooks(nil, profile.Hooks()))
+	if result := evaluator.Eval(parser.New(lexer.New(program)).ParseProgram(), env); result != nil && result.Type() == object.ERROR_OBJ {
+		t.Fatalf("evaluation failed: %s", result.Inspect())
+	}
+
+	files, err := profile.Files(func(path string) bool { return path == lib })
+	if err != nil {
+		t.Fatalf("Files failed: %s", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("wrong number of files. want=1, got=%d", len(files))
+	}
+
+	expected := []Line{{1, 1}, {2, 2}, {3, 2}, {5, 0}, {7, 1}, {8, 0}}
+	f := files[0]
+	if len(f.Lines) != len(expected) {
+		t.Fatalf("wrong lines. want=%v, got=%v", expected, f.Lines)
+	}
+	for i, line := range expected {
+		if f.Lines[i] != line {
+			t.Errorf("wrong line %d. want=%v, got=%v", i, line, f.Lines[i])
+		}
+	}
+	if f.Covered != 4 {
+		t.Errorf("wrong number of lines covered. want=4, got=%d", f.Covered)
+	}
+
+	var out bytes.Buffer
+	if err := WriteProfile(&out, files); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "mode: count\n") || !strings.Contains(out.String(), ":5 0\n") {
+		t.Errorf("wrong profile:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := WriteHTML(&out, files); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []string{`<tr class="covered"><td class="number">3</td><td class="count">2</td>`, `<tr class="uncovered"><td class="number">5</td>`, `<tr class=""><td class="number">4</td>`} {
+		if !strings.Contains(out.String(), row) {
+			t.Errorf("HTML report has no %s", row)
+		}
+	}
+}
+
+func TestProfileParallel(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.mky")
+	if err := os.WriteFile(lib, []byte("let double = fn(x) {\n    x * 2\n};\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	elements := strings.TrimSuffix(strings.Repeat("1, ", 1000), ", ")
+	program := `import "` + lib + `"; pmap([` + elements + `], fn(x) { double(x) }, 8);`
+
+	profile := New()
+	env := object.NewEnvironment()
+	env.SetContext(evaluator.WithHooks(nil, profile.Hooks()))
+	if result := evaluator.Eval(parser.New(lexer.New(program)).ParseProgram(), env); result != nil && result.Type() == object.ERROR_OBJ {
+		t.Fatalf("evaluation failed: %s", result.Inspect())
+	}
+
+	files, err := profile.Files(func(path string) bool { return path == lib })
+	if err != nil {
+		t.Fatalf("Files failed: %s", err)
+	}
+	if len(files) != 1 || len(files[0].Lines) != 2 || files[0].Lines[1] != (Line{2, 1000}) {
+		t.Errorf("wrong coverage of the calls made by pmap. got=%+v", files)
+	}
+}
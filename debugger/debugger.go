@@ -0,0 +1,332 @@
+// debugger/debugger.go
+
+// Package debugger implements an interactive source-level debugger for
+// Monkey programs. It pauses before statements on the evaluator and at line
+// changes on the VM, and reads commands such as break, step, next, continue
+// and print from its input while the program is paused.
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/imports"
+	"monkey/object"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrQuit is returned when the program was stopped with the quit command
+var ErrQuit = errors.New("debugger: quit")
+
+// quitRequest is raised as a panic to unwind the program on quit
+type quitRequest struct{}
+
+// mode is how far the program runs before pausing again
+type mode int
+
+const (
+	modeStep     mode = iota // modeStep pauses at the next statement
+	modeNext                 // modeNext pauses at the next statement outside of calls made from here
+	modeContinue             // modeContinue only pauses at breakpoints
+)
+
+// location is a line in a file, the file being empty for the main program
+type location struct {
+	file string
+	line int
+}
+
+// frame gives access to the variables of a paused program
+type frame interface {
+	// lookup evaluates an expression or looks up a variable
+	lookup(expression string) (object.Object, error)
+	// variables returns the local and global variables by name
+	variables() (locals, globals map[string]object.Object)
+}
+
+// Debugger runs a program and pauses it at breakpoints and while stepping
+type Debugger struct {
+	filename string
+	in       *bufio.Scanner
+	out      io.Writer
+
+	sources     map[string][]string // sources holds the lines of each file shown so far
+	breakpoints map[location]bool
+	mode        mode
+	depth       int    // depth is the call depth of the pause a next command was given at
+	last        string // last is the last command, repeated by an empty line
+	detached    bool   // detached is set once the input ends and the program runs to completion
+	inspecting  bool   // inspecting is set while print evaluates an expression, which must not pause
+}
+
+// New returns a debugger for the program in filename, reading commands from
+// in and writing to out. The program pauses before its first statement.
+func New(filename, source string, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		filename:    filename,
+		in:          bufio.NewScanner(in),
+		out:         out,
+		sources:     map[string][]string{"": strings.Split(source, "\n")},
+		breakpoints: map[location]bool{},
+		mode:        modeStep,
+	}
+}
+
+// shouldPause reports whether the program pauses at loc, depth calls deep
+func (d *Debugger) shouldPause(loc location, depth int) bool {
+	if d.detached || d.inspecting || loc.line == 0 {
+		return false
+	}
+	if d.breakpoints[loc] {
+		return true
+	}
+
+	switch d.mode {
+	case modeStep:
+		return true
+	case modeNext:
+		return depth <= d.depth
+	default:
+		return false
+	}
+}
+
+// pause shows where the program stopped and handles commands until one of
+// them resumes it
+func (d *Debugger) pause(loc location, depth int, f frame) {
+	d.printLine(loc)
+
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			d.detached = true
+			return
+		}
+
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+
+		command, args, _ := strings.Cut(line, " ")
+		args = strings.TrimSpace(args)
+
+		switch command {
+		case "":
+		case "s", "step":
+			d.mode = modeStep
+			return
+		case "n", "next":
+			d.mode = modeNext
+			d.depth = depth
+			return
+		case "c", "continue":
+			d.mode = modeContinue
+			return
+		case "b", "break":
+			d.setBreakpoint(args, true)
+		case "d", "delete":
+			d.setBreakpoint(args, false)
+		case "breakpoints":
+			d.listBreakpoints()
+		case "p", "print":
+			d.print(f, args)
+		case "vars":
+			d.printVariables(f)
+		case "l", "list":
+			d.list(loc)
+		case "h", "help":
+			fmt.Fprint(d.out, help)
+		case "q", "quit":
+			panic(quitRequest{})
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, type help for a list\n", command)
+		}
+	}
+}
+
+const help = `Commands:
+  step, s              run to the next statement, entering calls
+  next, n              run to the next statement, stepping over calls
+  continue, c          run until a breakpoint is reached
+  break, b [file:]line set a breakpoint
+  delete, d [file:]line
+                       remove a breakpoint
+  breakpoints          list the breakpoints
+  print, p <expr>      print the value of an expression
+  vars                 print the variables in scope
+  list, l              show the source around the current line
+  quit, q              stop the program
+An empty line repeats the last command.
+`
+
+// setBreakpoint sets or removes the breakpoint at a [file:]line location
+func (d *Debugger) setBreakpoint(arg string, set bool) {
+	loc, err := d.parseLocation(arg)
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+
+	if set {
+		d.breakpoints[loc] = true
+		fmt.Fprintf(d.out, "Breakpoint set at %s\n", d.describe(loc))
+		return
+	}
+	if !d.breakpoints[loc] {
+		fmt.Fprintf(d.out, "No breakpoint at %s\n", d.describe(loc))
+		return
+	}
+	delete(d.breakpoints, loc)
+	fmt.Fprintf(d.out, "Breakpoint deleted at %s\n", d.describe(loc))
+}
+
+// parseLocation parses a breakpoint location. A line without a file, or
+// with the name of the program being debugged, is in the main program.
+func (d *Debugger) parseLocation(arg string) (location, error) {
+	file := ""
+	lineText := arg
+	if i := strings.LastIndex(arg, ":"); i >= 0 {
+		file, lineText = arg[:i], arg[i+1:]
+		if file == d.filename {
+			file = ""
+		}
+	}
+
+	line, err := strconv.Atoi(lineText)
+	if err != nil || line < 1 {
+		return location{}, fmt.Errorf("invalid location %q, expected [file:]line", arg)
+	}
+	return location{file: file, line: line}, nil
+}
+
+// listBreakpoints prints the breakpoints ordered by file and line
+func (d *Debugger) listBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints")
+		return
+	}
+
+	locations := make([]location, 0, len(d.breakpoints))
+	for loc := range d.breakpoints {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].file != locations[j].file {
+			return locations[i].file < locations[j].file
+		}
+		return locations[i].line < locations[j].line
+	})
+
+	for _, loc := range locations {
+		fmt.Fprintln(d.out, d.describe(loc))
+	}
+}
+
+// print prints the value of an expression in the paused frame
+func (d *Debugger) print(f frame, expression string) {
+	if expression == "" {
+		fmt.Fprintln(d.out, "usage: print <expr>")
+		return
+	}
+
+	d.inspecting = true
+	value, err := f.lookup(expression)
+	d.inspecting = false
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	fmt.Fprintln(d.out, value.Inspect())
+}
+
+// printVariables prints the local and global variables of the paused frame
+func (d *Debugger) printVariables(f frame) {
+	locals, globals := f.variables()
+	if locals != nil {
+		d.printScope("Locals", locals)
+	}
+	d.printScope("Globals", globals)
+}
+
+// printScope is a helper function that prints a set of variables sorted by name
+func (d *Debugger) printScope(title string, variables map[string]object.Object) {
+	fmt.Fprintf(d.out, "%s:\n", title)
+	if len(variables) == 0 {
+		fmt.Fprintln(d.out, "  (none)")
+		return
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(d.out, "  %s = %s\n", name, variables[name].Inspect())
+	}
+}
+
+// list prints the lines around a location, marking the location itself
+func (d *Debugger) list(loc location) {
+	lines := d.source(loc.file)
+	for n := loc.line - 3; n <= loc.line+3; n++ {
+		if n < 1 || n > len(lines) {
+			continue
+		}
+		marker := "  "
+		if n == loc.line {
+			marker = "=>"
+		}
+		fmt.Fprintf(d.out, "%s %4d  %s\n", marker, n, lines[n-1])
+	}
+}
+
+// printLine prints a location with its source line
+func (d *Debugger) printLine(loc location) {
+	text := ""
+	if lines := d.source(loc.file); loc.line <= len(lines) {
+		text = strings.TrimSpace(lines[loc.line-1])
+	}
+	fmt.Fprintf(d.out, "%s\t%s\n", d.describe(loc), text)
+}
+
+// describe returns a location as file:line
+func (d *Debugger) describe(loc location) string {
+	file := loc.file
+	if file == "" {
+		file = d.filename
+	}
+	return fmt.Sprintf("%s:%d", file, loc.line)
+}
+
+// source returns the lines of a file, reading imported files on first use
+func (d *Debugger) source(file string) []string {
+	if lines, ok := d.sources[file]; ok {
+		return lines
+	}
+
+	content, err := imports.ReadFile(file)
+	if err != nil {
+		d.sources[file] = nil
+		return nil
+	}
+	d.sources[file] = strings.Split(string(content), "\n")
+	return d.sources[file]
+}
+
+// recoverQuit turns the panic raised by the quit command into ErrQuit
+func recoverQuit(err *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(quitRequest); !ok {
+			panic(r)
+		}
+		*err = ErrQuit
+	}
+}
@@ -0,0 +1,107 @@
+package debugger
+
+import (
+	"bytes"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+const source = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let x = add(1, 2);
+let y = x * 2;`
+
+// run is a helper function that debugs source on an engine with the given commands
+func run(t *testing.T, engine string, commands string) string {
+	t.Helper()
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	var out bytes.Buffer
+	d := New("test.mky", source, strings.NewReader(commands), &out)
+
+	switch engine {
+	case "eval":
+		if _, err := d.RunEvaluator(program, object.NewEnvironment()); err != nil && err != ErrQuit {
+			t.Fatalf("evaluator error: %s", err)
+		}
+	case "vm":
+		symbolTable := compiler.NewSymbolTable()
+		for i, v := range object.Builtins {
+			symbolTable.DefineBuiltin(i, v.Name)
+		}
+		comp := compiler.NewWithState(symbolTable, []object.Object{})
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if err := d.RunVM(comp.Bytecode(), symbolTable); err != nil && err != ErrQuit {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+
+	return out.String()
+}
+
+// stops is a helper function that returns the locations the debugger paused at
+func stops(out string) []string {
+	var locations []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimPrefix(line, "(debug) ")
+		if strings.HasPrefix(line, "test.mky:") {
+			location, _, _ := strings.Cut(line, "\t")
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		commands string
+		expected []string
+	}{
+		{"s\ns\ns\ns\ns\n", []string{"test.mky:1", "test.mky:5", "test.mky:2", "test.mky:3", "test.mky:6"}},
+		{"n\nn\nn\n", []string{"test.mky:1", "test.mky:5", "test.mky:6"}},
+		{"b 3\nc\nn\nc\n", []string{"test.mky:1", "test.mky:3", "test.mky:6"}},
+		{"b test.mky:2\nd 2\nc\n", []string{"test.mky:1"}},
+	}
+
+	for _, engine := range []string{"eval", "vm"} {
+		for _, tt := range tests {
+			got := stops(run(t, engine, tt.commands))
+			if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("%s: wrong stops for %q. want=%v, got=%v", engine, tt.commands, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestInspection(t *testing.T) {
+	for _, engine := range []string{"eval", "vm"} {
+		out := run(t, engine, "b 3\nc\np sum\np a\nvars\nq\n")
+
+		for _, want := range []string{"(debug) 3\n", "(debug) 1\n", "Locals:\n  a = 1\n  b = 2\n  sum = 3\nGlobals:\n  add = "} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: output does not contain %q:\n%s", engine, want, out)
+			}
+		}
+		if strings.Contains(out, "test.mky:6") {
+			t.Errorf("%s: program kept running after quit:\n%s", engine, out)
+		}
+	}
+
+	out := run(t, "eval", "p 1 + 2 * 3\nq\n")
+	if !strings.Contains(out, "(debug) 7\n") {
+		t.Errorf("expression not evaluated:\n%s", out)
+	}
+}
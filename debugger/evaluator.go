@@ -0,0 +1,71 @@
+// debugger/evaluator.go
+
+package debugger
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/diagnostic"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+)
+
+// RunEvaluator evaluates a program in env under the debugger, pausing
+// before statements, and returns the result of the program
+func (d *Debugger) RunEvaluator(program *ast.Program, env *object.Environment) (result object.Object, err error) {
+	defer recoverQuit(&err)
+
+	env.SetContext(evaluator.WithHooks(env.Context(), &evaluator.Hooks{OnStatement: func(ev evaluator.StatementEvent) {
+		loc := location{file: ev.File, line: ev.Line}
+		if d.shouldPause(loc, ev.Depth) {
+			d.pause(loc, ev.Depth, &evaluatorFrame{env: ev.Env, globals: env})
+		}
+	}}))
+
+	return evaluator.Eval(program, env), nil
+}
+
+// evaluatorFrame is a program paused on the evaluator
+type evaluatorFrame struct {
+	env     *object.Environment // env is the environment of the paused statement
+	globals *object.Environment
+}
+
+// lookup evaluates an expression in the paused environment
+func (f *evaluatorFrame) lookup(expression string) (object.Object, error) {
+	p := parser.New(lexer.New(expression))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s", strings.Join(diagnostic.Messages(p.Errors()), "\n"))
+	}
+
+	result := evaluator.Eval(program, f.env)
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, fmt.Errorf("%s", errObj.Message)
+	}
+	if result == nil {
+		return evaluator.NULL, nil
+	}
+	return result, nil
+}
+
+// variables returns the variables of the innermost environment and the
+// globals. There are no locals at the top level.
+func (f *evaluatorFrame) variables() (locals, globals map[string]object.Object) {
+	if f.env != f.globals {
+		locals = bindings(f.env)
+	}
+	return locals, bindings(f.globals)
+}
+
+// bindings is a helper function that returns the variables bound directly in env
+func bindings(env *object.Environment) map[string]object.Object {
+	variables := map[string]object.Object{}
+	for _, name := range env.Names() {
+		variables[name], _ = env.Get(name)
+	}
+	return variables
+}
@@ -0,0 +1,117 @@
+// debugger/vm.go
+
+package debugger
+
+import (
+	"fmt"
+	"monkey/compiler"
+	"monkey/object"
+	"monkey/token"
+	"monkey/vm"
+)
+
+// RunVM runs bytecode under the debugger, pausing whenever execution moves
+// to another source line. Globals are named using the symbol table the
+// program was compiled with.
+func (d *Debugger) RunVM(bytecode *compiler.Bytecode, symbols *compiler.SymbolTable) (err error) {
+	defer recoverQuit(&err)
+
+	machine := vm.New(bytecode)
+	lines := []int{0} // lines holds the current line of each active frame
+	frameDepth := 1
+
+	machine.SetHooks(&vm.Hooks{OnInstruction: func(ev vm.InstructionEvent) {
+		depth := ev.FrameDepth - 1
+		for len(lines) <= depth {
+			lines = append(lines, 0)
+		}
+		if ev.FrameDepth > frameDepth {
+			lines[depth] = 0 // a new call starts on no line
+		}
+		frameDepth = ev.FrameDepth
+
+		line := ev.Function.Lines.LineFor(ev.IP)
+		if line == lines[depth] {
+			return
+		}
+		lines[depth] = line
+
+		loc := location{line: line}
+		if d.shouldPause(loc, depth) {
+			d.pause(loc, depth, &vmFrame{machine: machine, symbols: symbols, depth: depth})
+		}
+	}})
+
+	return machine.Run()
+}
+
+// vmFrame is a program paused on the VM
+type vmFrame struct {
+	machine *vm.VM
+	symbols *compiler.SymbolTable
+	depth   int
+}
+
+// lookup returns the value of a variable. The VM cannot evaluate
+// expressions, so only names are supported.
+func (f *vmFrame) lookup(name string) (object.Object, error) {
+	if token.LookupIdent(name) != token.IDENT || !isIdentifier(name) {
+		return nil, fmt.Errorf("only variable names can be printed on the VM")
+	}
+
+	if value, ok := f.machine.Variables()[name]; ok && f.depth > 0 {
+		return value, nil
+	}
+
+	symbol, ok := f.symbols.Resolve(name)
+	if !ok {
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		if value := f.machine.Global(symbol.Index); value != nil {
+			return value, nil
+		}
+		return nil, fmt.Errorf("%s is not set yet", name)
+	case compiler.BuiltinScope:
+		return object.Builtins[symbol.Index].Builtin, nil
+	case compiler.ExtendedScope:
+		if extended, ok := object.GetExtendedFunction(name); ok {
+			return &extended, nil
+		}
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	default:
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+}
+
+// variables returns the locals of the current call and the globals set so far
+func (f *vmFrame) variables() (locals, globals map[string]object.Object) {
+	if f.depth > 0 {
+		locals = f.machine.Variables()
+	}
+
+	globals = map[string]object.Object{}
+	for _, symbol := range f.symbols.Symbols() {
+		if symbol.Scope != compiler.GlobalScope {
+			continue
+		}
+		if value := f.machine.Global(symbol.Index); value != nil {
+			globals[symbol.Name] = value
+		}
+	}
+	return locals, globals
+}
+
+// isIdentifier is a helper function that reports whether s is a valid identifier
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, ch := range s {
+		if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+// diagnostic/diagnostic.go
+
+// Package diagnostic describes the problems the lexer, the parser and the
+// compiler find in Monkey source, for the tools reporting them such as the
+// REPL, the language server and editor plugins
+package diagnostic
+
+import (
+	"fmt"
+	"monkey/token"
+	"strconv"
+	"strings"
+)
+
+// Severity is how serious a problem is
+type Severity int
+
+const (
+	Error Severity = iota + 1
+	Warning
+	Information
+	Hint
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Information:
+		return "information"
+	case Hint:
+		return "hint"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Position is a place in source. Lines and columns count from one, columns
+// in bytes.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// Diagnostic is a problem found in source. It is an error, so that the
+// compiler can return it as one.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	File     string // File is the file the problem is in, empty for the source checked
+	Line     int    // Line and Column are where the problem starts, 0 when it is unknown
+	Column   int
+	End      Position // End is just past the source the problem covers, the zero Position when unknown
+}
+
+// At returns the error diagnostic of a problem with tok, covering it
+func At(tok token.Token, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Message:  fmt.Sprintf(format, a...),
+		Line:     tok.Line,
+		Column:   tok.Column,
+		End:      Position{Line: tok.Line, Column: tok.Column + len(tok.Literal)},
+	}
+}
+
+// Error returns the message of the diagnostic
+func (d Diagnostic) Error() string {
+	return d.Message
+}
+
+// String returns the diagnostic as file:line:column: severity: message,
+// leaving out the parts that are unknown
+func (d Diagnostic) String() string {
+	var parts []string
+	if d.File != "" {
+		parts = append(parts, d.File)
+	}
+	if d.Line > 0 {
+		parts = append(parts, strconv.Itoa(d.Line))
+		if d.Column > 0 {
+			parts = append(parts, strconv.Itoa(d.Column))
+		}
+	}
+	position := strings.Join(parts, ":")
+	if position != "" {
+		position += ": "
+	}
+	return fmt.Sprintf("%s%s: %s", position, d.Severity, d.Message)
+}
+
+// Messages returns the messages of diagnostics
+func Messages(diagnostics []Diagnostic) []string {
+	messages := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		messages[i] = d.Message
+	}
+	return messages
+}
@@ -0,0 +1,36 @@
+package diagnostic
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		diagnostic Diagnostic
+		expected   string
+	}{
+		{Diagnostic{Severity: Error, Message: "undefined variable x", File: "lib.mky", Line: 3, Column: 7}, "lib.mky:3:7: error: undefined variable x"},
+		{Diagnostic{Severity: Warning, Message: "unused variable y", Line: 2, Column: 5}, "2:5: warning: unused variable y"},
+		{Diagnostic{Severity: Error, Message: "import cycle", File: "lib.mky"}, "lib.mky: error: import cycle"},
+		{Diagnostic{Severity: Hint, Message: "no position"}, "hint: no position"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.diagnostic.String(); got != tt.expected {
+			t.Errorf("wrong string. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestAt(t *testing.T) {
+	d := At(token.Token{Type: token.IDENT, Literal: "count", Line: 4, Column: 9}, "undefined variable %s", "count")
+
+	expected := Diagnostic{Severity: Error, Message: "undefined variable count", Line: 4, Column: 9, End: Position{Line: 4, Column: 14}}
+	if *d != expected {
+		t.Errorf("wrong diagnostic. want=%#v, got=%#v", expected, *d)
+	}
+	if d.Error() != "undefined variable count" {
+		t.Errorf("wrong error. got=%q", d.Error())
+	}
+}
@@ -0,0 +1,103 @@
+// doc/doc.go
+
+// Package doc extracts documentation from Monkey programs and the builtins
+// and renders it as Markdown
+package doc
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+	"strings"
+)
+
+// Entry documents a single top-level binding or builtin
+type Entry struct {
+	Name      string
+	Signature string // Signature shows how a function is called, e.g. add(a, b)
+	Doc       string // Doc is the documentation text, possibly spanning several lines
+	Line      int    // Line is the source line of the definition, 0 for builtins
+}
+
+// FromProgram returns an entry for each top-level let statement of the
+// program, only the exported ones when it has any. A name bound more than
+// once is documented by its last definition.
+func FromProgram(program *ast.Program) []Entry {
+	var entries []Entry
+	index := map[string]int{}
+	exports := program.Exports()
+
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || let == nil || (exports != nil && !exports[let.Name.Value]) {
+			continue
+		}
+
+		entry := FromLet(let)
+		if i, ok := index[entry.Name]; ok {
+			entries[i] = entry
+			continue
+		}
+		index[entry.Name] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// FromLet returns the entry documenting a let statement
+func FromLet(let *ast.LetStatement) Entry {
+	entry := Entry{Name: let.Name.Value, Signature: let.Name.Value, Doc: let.Doc, Line: let.Token.Line}
+
+	if fn, ok := let.Value.(*ast.FunctionLiteral); ok {
+		params := make([]string, len(fn.Parameters))
+		for i, param := range fn.Parameters {
+			params[i] = param.Value
+		}
+		entry.Signature = fmt.Sprintf("%s(%s)", let.Name.Value, strings.Join(params, ", "))
+	}
+
+	return entry
+}
+
+// Builtins returns an entry for each builtin function
+func Builtins() []Entry {
+	entries := make([]Entry, 0, len(object.Builtins))
+	for _, def := range object.Builtins {
+		entries = append(entries, FromBuiltin(def.Name, def.Builtin))
+	}
+	return entries
+}
+
+// FromBuiltin returns the entry documenting a builtin
+func FromBuiltin(name string, builtin *object.Builtin) Entry {
+	signature := builtin.Usage
+	if signature == "" {
+		signature = name + "(...)"
+	}
+	return Entry{Name: name, Signature: signature, Doc: builtin.Doc}
+}
+
+// Markdown renders the entries as a Markdown document with the given title
+func Markdown(title string, entries []Entry) string {
+	var out strings.Builder
+
+	out.WriteString("# " + title + "\n")
+	for _, entry := range entries {
+		out.WriteString("\n## " + entry.Name + "\n\n")
+		out.WriteString("```\n" + entry.Signature + "\n```\n")
+		if entry.Doc != "" {
+			out.WriteString("\n" + entry.Doc + "\n")
+		}
+	}
+
+	return out.String()
+}
+
+// Text renders a single entry for a terminal
+func Text(entry Entry) string {
+	if entry.Doc == "" {
+		return entry.Signature + "\n    (undocumented)"
+	}
+	return entry.Signature + "\n    " + strings.ReplaceAll(entry.Doc, "\n", "\n    ")
+}
@@ -0,0 +1,58 @@
+package doc
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func TestFromProgram(t *testing.T) {
+	input := `# Adds two numbers.
+let add = fn(a, b) { a + b };
+let x = 1;
+# The answer
+let x = 42;
+puts(x);`
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	expected := []Entry{
+		{Name: "add", Signature: "add(a, b)", Doc: "Adds two numbers.", Line: 2},
+		{Name: "x", Signature: "x", Doc: "The answer", Line: 5},
+	}
+
+	entries := FromProgram(program)
+	if len(entries) != len(expected) {
+		t.Fatalf("wrong number of entries. want=%d, got=%d (%+v)", len(expected), len(entries), entries)
+	}
+	for i, entry := range expected {
+		if entries[i] != entry {
+			t.Errorf("entry %d wrong. want=%+v, got=%+v", i, entry, entries[i])
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	entries := []Entry{
+		{Name: "add", Signature: "add(a, b)", Doc: "Adds two numbers."},
+		{Name: "x", Signature: "x"},
+	}
+
+	expected := "# math\n\n## add\n\n```\nadd(a, b)\n```\n\nAdds two numbers.\n\n## x\n\n```\nx\n```\n"
+	if actual := Markdown("math", entries); actual != expected {
+		t.Errorf("wrong markdown.\nwant=%q\ngot=%q", expected, actual)
+	}
+}
+
+func TestBuiltinsAreDocumented(t *testing.T) {
+	for _, entry := range Builtins() {
+		if entry.Doc == "" || !strings.HasPrefix(entry.Signature, entry.Name+"(") {
+			t.Errorf("builtin %s is missing documentation: %+v", entry.Name, entry)
+		}
+	}
+}
@@ -10,6 +10,11 @@ var builtins = map[string]*object.Builtin{
 	"last":   object.GetBuiltInByName("last"),
 	"rest":   object.GetBuiltInByName("rest"),
 	"push":   object.GetBuiltInByName("push"),
+	"pop":    object.GetBuiltInByName("pop"),
+	"join":   object.GetBuiltInByName("join"),
+	"exp":    object.GetBuiltInByName("exp"),
 	"puts":   object.GetBuiltInByName("puts"),
 	"random": object.GetBuiltInByName("random"),
+	"args":   object.GetBuiltInByName("args"),
+	"exit":   object.GetBuiltInByName("exit"),
 }
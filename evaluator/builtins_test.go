@@ -39,6 +39,21 @@ func TestIncorrectParamCount(t *testing.T) {
 	}
 }
 
+// TestExit tests that exit() unwinds the program with the requested status
+func TestExit(t *testing.T) {
+	defer func() {
+		exit, ok := recover().(*object.ExitRequest)
+		if !ok {
+			t.Fatalf("expected an *object.ExitRequest panic")
+		}
+		if exit.Code != 3 {
+			t.Errorf("wrong exit code. expected=3, got=%d", exit.Code)
+		}
+	}()
+
+	testEval(`let f = fn() { exit(3); }; f(); 1;`)
+}
+
 func TestBuiltins(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -76,6 +91,14 @@ func TestBuiltins(t *testing.T) {
 
 		// puts tests
 		{`puts("hello", 123, [1, 2, 3])`, object.NULL_OBJ, nil, false, ""},
+
+		// args tests
+		{`args()`, object.ARRAY_OBJ, []int{}, false, ""},
+		{`args(1)`, object.ERROR_OBJ, nil, true, "args() takes no arguments"},
+
+		// exit tests
+		{`exit("now")`, object.ERROR_OBJ, nil, true, "argument to `exit` must be INTEGER, got STRING"},
+		{`exit(256)`, object.ERROR_OBJ, nil, true, "exit status must be between 0 and 255, got 256"},
 	}
 
 	for _, tt := range tests {
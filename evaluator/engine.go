@@ -0,0 +1,62 @@
+// evaluator/engine.go
+
+package evaluator
+
+import (
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+// engine is the Engine of the contexts the evaluator calls builtins with
+type engine struct{}
+
+// Engine is the engine of the evaluator, for the contexts set on
+// environments. The evaluator adds it to the contexts set without one.
+var Engine object.Engine = engine{}
+
+// defaultContext is the context of the builtins called where no context is
+// set
+var defaultContext = &object.Context{Engine: Engine}
+
+// builtinContext is a helper function that returns the context builtins are
+// called with in ctx, which may be nil, with the engine of the evaluator
+func builtinContext(ctx *object.Context) *object.Context {
+	if ctx == nil {
+		return defaultContext
+	}
+	if ctx.Engine == nil {
+		withEngine := *ctx
+		withEngine.Engine = Engine
+		return &withEngine
+	}
+	return ctx
+}
+
+// Call calls fn, a function or a builtin, with args in ctx like Apply
+func (engine) Call(ctx *object.Context, fn object.Object, args ...object.Object) object.Object {
+	if function, ok := fn.(*object.Function); ok && len(function.Parameters) != len(args) {
+		return newError("wrong number of arguments: want=%d, got=%d", len(function.Parameters), len(args))
+	}
+	canonicalArgs := make([]object.Object, len(args))
+	for i, arg := range args {
+		canonicalArgs[i] = canonical(arg)
+	}
+	return applyFunction(fn, canonicalArgs, ctx)
+}
+
+// Eval evaluates src in ctx, in an environment of its own
+func (engine) Eval(ctx *object.Context, src string) object.Object {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return &object.Error{Message: errors[0].Message}
+	}
+
+	env := object.NewEnvironment()
+	env.SetContext(ctx)
+	if evaluated := Eval(program, env); evaluated != nil {
+		return evaluated
+	}
+	return NULL
+}
@@ -2,11 +2,15 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
 	"monkey/ast"
+	"monkey/imports"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
-	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Define constants for the Boolean object
@@ -16,11 +20,17 @@ var (
 	NULL  = &object.Null{}
 )
 
+// MaxCallDepth is the number of calls a program may have in progress, past
+// which a call fails with a stack overflow instead of exhausting the Go
+// stack. Calls in tail position do not count, having returned when made.
+const MaxCallDepth = 50000
+
 // Eval is a function that evaluates an AST node
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
+		Resolve(node)
 		return evalProgram(node, env)
 
 	case *ast.ExpressionStatement:
@@ -28,7 +38,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	// Expressions
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return object.NewInteger(node.Value)
 
 	case *ast.FloatLiteral:
 		return &object.Float{Value: node.Value}
@@ -36,6 +46,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
+	case *ast.NullLiteral:
+		return NULL
+
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
@@ -44,6 +57,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
+		if left, ok := node.Left.(*ast.InfixExpression); ok && node.Operator == "+" && left.Operator == "+" {
+			return evalSumExpression(node, env)
+		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -60,6 +79,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
@@ -72,7 +100,30 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		bind(node.Name, val, env)
+
+	case *ast.DestructureStatement:
+		return evalDestructureStatement(node, env)
+
+	case *ast.ExportStatement:
+		// Only the names have to be bound, importers read the exports off the program
+		for _, name := range node.Names {
+			if val := evalIdentifier(name, env); isError(val) {
+				return val
+			}
+		}
+
+	case *ast.AssignStatement:
+		if node.Captured {
+			return newError("cannot assign to %s, a variable captured by a closure", node.Name.Value)
+		}
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if errObj := assign(node.Name, val, env); errObj != nil {
+			return errObj
+		}
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
@@ -80,19 +131,34 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Parameters: params, Body: body, Env: env, Name: node.Name, Locals: node.Locals}
 
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
+		if !object.IsCallable(function) {
+			return newError("cannot call %s value `%s` at line %d", function.Type(), node.Function.String(), node.Token.Line)
+		}
 		args := evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		// The function making a tail call returns first, see applyFunction
+		if fn, ok := function.(*object.Function); ok && node.Tail {
+			return &tailCall{fn: fn, args: args, node: node}
+		}
+
+		result := applyFunction(function, args, env.Context())
+		if errObj, ok := result.(*object.Error); ok {
+			errObj.Stack = append(errObj.Stack, fmt.Sprintf("at %s (line %d)", calleeName(node.Function, function), node.Token.Line))
+		}
+		return result
+
+	case *ast.MemberCallExpression:
+		return evalMemberCall(node, env)
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
@@ -100,6 +166,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.ArrayLiteral:
 		return evalArrayLiteral(node, env)
 
+	case *ast.TupleLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &object.Tuple{Elements: elements}
+
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -110,6 +183,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
+	case *ast.MemberExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		return evalIndexExpression(left, &object.String{Value: node.Field})
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	case *ast.ImportLiteral:
@@ -132,15 +213,27 @@ func evalArrayLiteral(node *ast.ArrayLiteral, env *object.Environment) object.Ob
 
 // evalTensorLiteral is a helpter function that takes in an tensor literal
 func evalTensorLiteral(node *ast.TensorLiteral, env *object.Environment) object.Object {
-	var dataElements []float64
-	var shapeElements []int64
-
 	data := Eval(node.Data, env)
 	if isError(data) {
 		return data
 	}
 
-	for _, element := range data.(*object.Array).Elements {
+	// Literals of nested data have no shape
+	if node.Shape == nil {
+		dataArray, ok := data.(*object.Array)
+		if !ok {
+			return newError("On line %d, tensor data must be an array", node.Token.Line)
+		}
+		tensor, err := object.NestedTensor(dataArray)
+		if err != nil {
+			return newError("On line %d, %s", node.Token.Line, err)
+		}
+		return tensor
+	}
+
+	dataArray := data.(*object.Array)
+	dataElements := make([]float64, 0, len(dataArray.Elements))
+	for _, element := range dataArray.Elements {
 		// If element is an integer convert to float
 		if element.Type() == object.INTEGER_OBJ {
 			dataElements = append(dataElements, float64(element.(*object.Integer).Value))
@@ -155,6 +248,7 @@ func evalTensorLiteral(node *ast.TensorLiteral, env *object.Environment) object.
 
 	shape := Eval(node.Shape, env).(*object.Array)
 
+	shapeElements := make([]int64, 0, len(shape.Elements))
 	for _, element := range shape.Elements {
 		// tensor shape must be of type integer
 		if element.Type() != object.INTEGER_OBJ {
@@ -163,30 +257,219 @@ func evalTensorLiteral(node *ast.TensorLiteral, env *object.Environment) object.
 		shapeElements = append(shapeElements, element.(*object.Integer).Value)
 	}
 
-	return &object.Tensor{Data: dataElements, Shape: shapeElements}
+	tensor := &object.Tensor{Data: dataElements, Shape: shapeElements}
+	if err := tensor.Validate(); err != nil {
+		return newError("On line %d, %s", node.Token.Line, err)
+	}
+	return tensor
 }
 
 // evalImportLiteral is a helper function that takes in an import literal and an
-// environment and evaluates the import literal
+// environment and evaluates the import literal. Each file is evaluated once,
+// in an environment of its own, and its bindings are copied into env every
+// time it is imported, or into a hash bound to the alias of the import, only
+// the exported ones when the file exports any. A
+// file that changed on disk since is evaluated again. Relative paths are
+// resolved from the directory of the file whose top level encloses env.
 func evalImportLiteral(node *ast.ImportLiteral, env *object.Environment) object.Object {
-	// Read the file from the node.path into a string
-	fileContent, err := os.ReadFile(node.Path)
+	importer := env.File()
+	dir := ""
+	if importer != "" {
+		dir = imports.Dir(importer)
+	}
+	filename, err := env.Context().Resolver().Resolve(node.Path, node.Checksum, dir)
+	if err != nil {
+		return newError("On line %d, error reading import file: %s", node.Token.Line, err.Error())
+	}
+	if imports.IsCompiled(filename) {
+		return newError("On line %d, error reading import file: %s holds bytecode, which only the vm engine can import", node.Token.Line, filename)
+	}
+	path, err := imports.Abs(filename)
+	if err != nil {
+		return newError("On line %d, error reading import file: %s", node.Token.Line, err.Error())
+	}
+	modTime, err := imports.ModTime(path)
+	if err != nil {
+		return newError("On line %d, error reading import file: %s", node.Token.Line, err.Error())
+	}
+
+	mod, err := importModule(filename, path, modTime, importer, env.Context())
 	if err != nil {
 		return newError("On line %d, error reading import file: %s", node.Token.Line, err.Error())
 	}
+	if mod == nil {
+		return newError("On line %d, import cycle: %s imports itself", node.Token.Line, filename)
+	}
+	if errObj, ok := mod.result.(*object.Error); ok {
+		return importError(errObj, filename, importer, node)
+	}
+
+	// import "m" as m binds a hash of the bindings instead, read as m.name
+	var namespace *object.Hash
+	if node.Alias != nil {
+		namespace = &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	}
+	for _, name := range mod.env.Names() {
+		if mod.exports != nil && !mod.exports[name] {
+			continue
+		}
+		value, _ := mod.env.Get(name)
+		if namespace != nil {
+			key := &object.String{Value: name}
+			namespace.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: value}
+			continue
+		}
+		env.Set(name, value)
+	}
+	if namespace != nil {
+		bind(node.Alias, namespace, env)
+		return namespace
+	}
+	return mod.result
+}
+
+// importError is a helper function that returns the error of an imported
+// file with the import statement of importer added to its import chain. The
+// error is then raised at the line of the import statement.
+func importError(errObj *object.Error, filename, importer string, node *ast.ImportLiteral) *object.Error {
+	chained := *errObj
+	chained.Imports = append([]object.ImportSite{}, errObj.Imports...)
+	if len(chained.Imports) == 0 {
+		chained.Imports = []object.ImportSite{{File: filename, Line: errObj.Line}}
+	}
+	chained.Imports = append(chained.Imports, object.ImportSite{File: importer, Line: node.Token.Line})
+	chained.Line = node.Token.Line
+	return &chained
+}
 
-	// convert fileContent to a string
-	fileContentString := string(fileContent)
+// module is an imported file that has been evaluated
+type module struct {
+	env     *object.Environment // env holds the bindings of the module
+	exports map[string]bool     // exports holds the names visible to importers, nil for all of them
+	result  object.Object       // result is the value of the last statement
+	modTime time.Time           // modTime is the modification time of the file when it was evaluated
+	loading bool                // loading is set while the module is being evaluated
+	loader  string              // loader is the absolute path of the file importing the module, empty for the main program
+}
+
+// Modules caches the modules imported in a context by absolute path, so
+// that each interpreter evaluates the files it imports on its own. Functions
+// called on several goroutines, by pmap(), may import at the same time: the
+// cache is only read and changed holding its mutex, which is never held
+// while a module is evaluated, as the module may import others.
+type Modules struct {
+	mu      sync.Mutex
+	modules map[string]*module
+}
+
+// NewModules returns an empty module cache, for the Modules of a context
+func NewModules() *Modules {
+	return &Modules{modules: map[string]*module{}}
+}
 
-	l := lexer.New(fileContentString)
+// processModules caches the modules imported in contexts without a cache
+var processModules = NewModules()
+
+// modulesOf is a helper function that returns the module cache of ctx, that
+// of the process when it has none
+func modulesOf(ctx *object.Context) *Modules {
+	if ctx != nil {
+		if cache, ok := ctx.Modules.(*Modules); ok && cache != nil {
+			return cache
+		}
+	}
+	return processModules
+}
+
+// importModule is a helper function that returns the module of the file at
+// path imported by importer, evaluating it unless it is cached, or nil when
+// the file imports itself. A module being evaluated by another goroutine is
+// evaluated again rather than waited for. A module evaluated calls builtins
+// in ctx.
+func importModule(filename, path string, modTime time.Time, importer string, ctx *object.Context) (*module, error) {
+	loader := ""
+	if importer != "" {
+		abs, err := imports.Abs(importer)
+		if err != nil {
+			return nil, err
+		}
+		loader = abs
+	}
+
+	cache := modulesOf(ctx)
+	cache.mu.Lock()
+	if mod, ok := cache.modules[path]; ok && mod.modTime.Equal(modTime) {
+		if !mod.loading {
+			cache.mu.Unlock()
+			return mod, nil
+		}
+		if cache.importsItself(path, loader) {
+			cache.mu.Unlock()
+			return nil, nil
+		}
+	}
+	cache.mu.Unlock()
+
+	return loadModule(filename, path, modTime, loader, ctx)
+}
+
+// importsItself is a helper function that reports whether importing the
+// file at path from the file at loader closes a cycle of files being
+// evaluated. It is called holding the mutex of the cache.
+func (cache *Modules) importsItself(path, loader string) bool {
+	for loader != "" {
+		if loader == path {
+			return true
+		}
+		mod, ok := cache.modules[loader]
+		if !ok || !mod.loading {
+			return false
+		}
+		loader = mod.loader
+	}
+	return false
+}
+
+// loadModule is a helper function that reads and evaluates a module,
+// caching it under its absolute path in the cache of ctx. Modules that fail
+// are not cached.
+func loadModule(filename, path string, modTime time.Time, loader string, ctx *object.Context) (*module, error) {
+	fileContent, err := imports.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := lexer.New(string(fileContent))
 	p := parser.New(l)
 	program := p.ParseProgram()
-	evaluated := Eval(program, env)
-	if evaluated != nil {
-		return evaluated
+	// The first parser error is reported, the others often follow from it
+	if details := p.Errors(); len(details) != 0 {
+		message := strings.TrimPrefix(details[0].Message, fmt.Sprintf("On line %d, ", details[0].Line))
+		return &module{result: &object.Error{Message: message, Line: details[0].Line}}, nil
 	}
 
-	return NULL
+	mod := &module{env: object.NewModuleEnvironment(filename), exports: program.Exports(), modTime: modTime, loading: true, loader: loader}
+	cache := modulesOf(ctx)
+	cache.mu.Lock()
+	cache.modules[path] = mod
+	cache.mu.Unlock()
+
+	// The top level of the module calls builtins in the context of the import
+	mod.env.SetContext(ctx)
+	evaluated := Eval(program, mod.env)
+	mod.env.SetContext(nil)
+	if evaluated == nil {
+		evaluated = NULL
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	mod.loading = false
+	mod.result = evaluated
+	if isError(evaluated) && cache.modules[path] == mod {
+		delete(cache.modules, path)
+	}
+	return mod, nil
 }
 
 // evalHashLiteral is a helper function that takes in a hash literal and an
@@ -223,6 +506,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExpression(&object.Array{Elements: left.(*object.Tuple).Elements}, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -230,6 +515,32 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+// evalSliceExpression is a helper function that takes in a slice expression
+// and evaluates it, a left out bound standing for the start or the end
+func evalSliceExpression(se *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(se.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	bounds := []object.Object{NULL, NULL}
+	for i, bound := range []ast.Expression{se.Low, se.High} {
+		if bound == nil {
+			continue
+		}
+		bounds[i] = Eval(bound, env)
+		if isError(bounds[i]) {
+			return bounds[i]
+		}
+	}
+
+	result, err := object.Slice(left, bounds[0], bounds[1])
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
+
 // evalHashIndexExpression is a helper function that takes in two objects and
 // evaluates the hash index expression
 func evalHashIndexExpression(hash, index object.Object) object.Object {
@@ -248,12 +559,16 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 }
 
 // evalArrayIndexExpression is a helper function that takes in two objects and
-// evaluates the array index expression
+// evaluates the array index expression. Negative indices count from the end,
+// arr[-1] being the last element.
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
 	idx := index.(*object.Integer).Value
 	max := int64(len(arrayObject.Elements) - 1)
 
+	if idx < 0 {
+		idx += max + 1
+	}
 	if idx < 0 || idx > max {
 		return NULL
 	}
@@ -261,14 +576,51 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+func init() {
+	object.RegisterCaller(object.FUNCTION_OBJ, Apply)
+}
+
+// Apply calls fn, a function or a builtin, with args and returns its result.
+// Calling a function with a different number of arguments than it takes is
+// an error. Booleans and null passed from outside the evaluator are replaced
+// by its own.
+func Apply(fn object.Object, args ...object.Object) object.Object {
+	return engine{}.Call(nil, fn, args...)
+}
+
 // applyFunction is a helper function that takes in a function and a slice of
-// arguments and applies the function to the arguments
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// arguments and applies the function to the arguments. The builtins called
+// are called in ctx, when it is set, else in the context of the environment
+// of the function.
+func applyFunction(fn object.Object, args []object.Object, ctx *object.Context) object.Object {
 	switch function := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(function, args)
-		evaluated := Eval(function.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		// A function returning a tail call returns it unmade, and the call
+		// is made here in a loop, so that recursion in tail position does
+		// not grow the Go stack
+		var call *tailCall
+		if ctx == nil {
+			ctx = callContext(function)
+		}
+		for {
+			// Programs loop by calling functions, which stop once ctx is done
+			if err := ctx.Err(); err != nil {
+				return newError("execution stopped: %s", err)
+			}
+			extendedEnv := extendFunctionEnv(function, args)
+			extendedEnv.SetContext(ctx)
+			evaluated := callFunction(function, extendedEnv, ctx)
+
+			next, ok := evaluated.(*tailCall)
+			if !ok {
+				// Of the tail calls made, only the last one is on the stack
+				if errObj, ok := evaluated.(*object.Error); ok && call != nil {
+					errObj.Stack = append(errObj.Stack, fmt.Sprintf("at %s (line %d)", calleeName(call.node.Function, call.fn), call.node.Token.Line))
+				}
+				return evaluated
+			}
+			call, function, args = next, next.fn, next.args
+		}
 
 	case *object.Extended:
 		if result := function.Fn(args...); result != nil {
@@ -277,23 +629,114 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 		return NULL
 
 	case *object.Builtin:
-		if result := function.Fn(args...); result != nil {
-			return result
+		if function.ContextFn == nil {
+			return canonical(function.Fn(args...))
 		}
-		return NULL
+		return canonical(function.ContextFn(builtinContext(ctx), args...))
 
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// callFunction is a helper function that evaluates the body of fn in env
+// as a call in progress in ctx, until it returns or a panic, such as that
+// of exit(), unwinds it
+func callFunction(fn *object.Function, env *object.Environment, ctx *object.Context) object.Object {
+	if ctx.Depth >= MaxCallDepth {
+		return newError("stack overflow")
+	}
+	enterFunction(ctx, fn)
+	defer leaveFunction(ctx, fn)
+	return unwrapReturnValue(Eval(fn.Body, env))
+}
+
+// callContext is a helper function that returns the context of a call of fn
+// made outside of any: a copy of the context fn was defined in, counting
+// the calls in progress of its own
+func callContext(fn *object.Function) *object.Context {
+	ctx := object.Context{Engine: Engine}
+	if outer := fn.Env.Context(); outer != nil {
+		ctx = *builtinContext(outer)
+		ctx.Depth = 0
+	}
+	return &ctx
+}
+
+// tailCall is a call in tail position, returned by the function making it
+// for applyFunction to make
+type tailCall struct {
+	fn   *object.Function
+	args []object.Object
+	node *ast.CallExpression
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call of " + tc.node.Function.String() }
+
+// evalMemberCall is a helper function that calls the function in the field
+// of a hash named by the method, or else the builtin method of the receiver
+// with the receiver before the arguments
+func evalMemberCall(node *ast.MemberCallExpression, env *object.Environment) object.Object {
+	receiver := Eval(node.Receiver, env)
+	if isError(receiver) {
+		return receiver
+	}
+
+	function, isField := object.Field(receiver, node.Method)
+	if isField && !object.IsCallable(function) {
+		return newError("cannot call %s value `%s` at line %d", function.Type(), node.Callee(), node.Token.Line)
+	}
+	if !isField {
+		method, ok := object.LookupMethod(receiver, node.Method)
+		if !ok {
+			return newError("unknown method %s of %s at line %d", node.Method, receiver.Type(), node.Token.Line)
+		}
+		function = method
+	}
+
+	args := evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+	if !isField {
+		args = append([]object.Object{receiver}, args...)
+	}
+
+	result := applyFunction(function, args, env.Context())
+	if errObj, ok := result.(*object.Error); ok {
+		name := node.Method
+		if fn, ok := function.(*object.Function); ok && fn.Name != "" {
+			name = fn.Name
+		}
+		errObj.Stack = append(errObj.Stack, fmt.Sprintf("at %s (line %d)", name, node.Token.Line))
+	}
+	return result
+}
+
+// calleeName is a helper function that returns the name a function was
+// called by, for use in stack traces
+func calleeName(callee ast.Expression, fn object.Object) string {
+	if function, ok := fn.(*object.Function); ok && function.Name != "" {
+		return function.Name
+	}
+	if ident, ok := callee.(*ast.Identifier); ok {
+		return ident.Value
+	}
+	return "<anonymous>"
+}
+
 // extendFunctionEnv is a helper function that takes in a function and a slice of
 // arguments and extends the function's environment with the arguments
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewFunctionEnvironment(fn.Env, fn.Locals)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if binding := param.Binding; binding != nil && binding.Index >= 0 {
+			env.SetSlot(binding.Index, args[paramIdx])
+		} else {
+			env.Set(param.Value, args[paramIdx])
+		}
 	}
 
 	return env
@@ -343,10 +786,13 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	var result object.Object
 
 	for _, statement := range block.Statements {
+		onStatement(statement, env)
 		result = Eval(statement, env)
+		onStatementDone(statement, env)
 
 		// Check if the result is a return value or an error
-		if result != nil && result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ {
+		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
+			setErrorLine(result, statement)
 			return result
 		}
 	}
@@ -354,13 +800,23 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// setErrorLine is a helper function that records the line of the statement
+// an error was raised in, the innermost statement it propagates out of
+func setErrorLine(result object.Object, statement ast.Statement) {
+	if errObj, ok := result.(*object.Error); ok && errObj.Line == 0 {
+		errObj.Line = ast.LineOf(statement)
+	}
+}
+
 // evalProgram is a helper function that takes in a program and evaluates the
 // program
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range program.Statements {
+		onStatement(statement, env)
 		result = Eval(statement, env)
+		onStatementDone(statement, env)
 
 		switch result := result.(type) {
 		// Check if the result is a return value
@@ -369,6 +825,7 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 
 		// Check if the result is an error
 		case *object.Error:
+			setErrorLine(result, statement)
 			return result
 		}
 	}
@@ -395,6 +852,84 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	return NULL
 }
 
+// evalTryExpression is a helper function that evaluates the block of a try
+// expression and, when it fails with an error, its handler with the message
+// of the error bound to the variable. Errors stopping the program once the
+// context of env is done are not caught.
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Block, env)
+	errObj, ok := result.(*object.Error)
+	if !ok || env.Context().Err() != nil {
+		return result
+	}
+
+	bind(te.Variable, &object.String{Value: errObj.Message}, env)
+	return Eval(te.Handler, env)
+}
+
+// evalWhileStatement is a helper function that takes in a while statement and
+// evaluates its body for as long as its condition is truthy. The statement
+// itself is null, as on the VM; a return or an error in the body ends the
+// loop and is passed on. Loops stop once the context of env is done, like calls.
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		if err := env.Context().Err(); err != nil {
+			return newError("execution stopped: %s", err)
+		}
+
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		result := Eval(ws.Body, env)
+		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
+			return result
+		}
+	}
+}
+
+// evalForStatement is a helper function that takes in a for statement and
+// evaluates its body once for every value of its iterable, bound to its
+// variable like a let statement would. Like a while statement it is null,
+// and a return or an error in the body ends the loop.
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	values, ok := object.Iteration(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+
+	for _, value := range values {
+		if err := env.Context().Err(); err != nil {
+			return newError("execution stopped: %s", err)
+		}
+
+		bind(fs.Variable, value, env)
+		result := Eval(fs.Body, env)
+		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
+			return result
+		}
+	}
+	return NULL
+}
+
+// bind is a helper function that binds the variable ident names to val, in
+// its slot when it was resolved to one
+func bind(ident *ast.Identifier, val object.Object, env *object.Environment) {
+	if binding := ident.Binding; binding != nil && binding.Index >= 0 {
+		env.SetSlot(binding.Index, val)
+	} else {
+		env.Set(ident.Value, val)
+	}
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -411,6 +946,20 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+// canonical is a helper function that returns the result of a builtin with
+// booleans and nulls replaced by the values the evaluator compares them to.
+// Plugins and extensions make their own.
+func canonical(result object.Object) object.Object {
+	switch result := result.(type) {
+	case nil, *object.Null:
+		return NULL
+	case *object.Boolean:
+		return nativeBoolToBooleanObject(result.Value)
+	default:
+		return result
+	}
+}
+
 // nativeBoolToBooleanObject is a helper function that takes in a boolean and
 // returns a pointer to a Boolean object
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -442,21 +991,96 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.FLOAT_OBJ && right.Type() == object.FLOAT_OBJ:
 		return evalFloatInfixExpression(operator, left, right)
-	case left.Type() == object.TENSOR_OBJ && right.Type() == object.TENSOR_OBJ:
+	case left.Type() == object.TENSOR_OBJ && (right.Type() == object.TENSOR_OBJ || isNumber(right)),
+		isNumber(left) && right.Type() == object.TENSOR_OBJ:
 		return evalTensorInfixExpression(operator, left, right)
+	// An integer and a float compare by value, floor divide and raise to a
+	// power as floats
+	case isNumber(left) && isNumber(right) && (isComparison(operator) || operator == "//" || operator == "**"):
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// evalSumExpression is a helper function that evaluates a chain of +, such
+// as a + b + c, from left to right. Consecutive strings are concatenated in
+// one builder rather than into a new string for every +.
+func evalSumExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	var operands []ast.Expression
+	var expression ast.Expression = node
+	for {
+		infix, ok := expression.(*ast.InfixExpression)
+		if !ok || infix.Operator != "+" {
+			break
+		}
+		operands = append(operands, infix.Right)
+		expression = infix.Left
+	}
+	operands = append(operands, expression)
+
+	result := Eval(operands[len(operands)-1], env)
+	if isError(result) {
+		return result
+	}
+	var builder strings.Builder
+	building := false
+	for i := len(operands) - 2; i >= 0; i-- {
+		right := Eval(operands[i], env)
+		if isError(right) {
+			return right
+		}
+
+		if str, ok := right.(*object.String); ok && result.Type() == object.STRING_OBJ {
+			if !building {
+				builder.Reset()
+				builder.WriteString(result.(*object.String).Value)
+				building = true
+			}
+			builder.WriteString(str.Value)
+			continue
+		}
+		if building {
+			result, building = &object.String{Value: builder.String()}, false
+		}
+		result = evalInfixExpression("+", result, right)
+		if isError(result) {
+			return result
+		}
+	}
+	if building {
+		result = &object.String{Value: builder.String()}
+	}
+	return result
+}
+
+// evalLogicalExpression is a helper function that evaluates a && or ||
+// expression to a boolean. The right operand is only evaluated when the left
+// one does not decide the result: when it is truthy for &&, falsy for ||.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	if isTruthy(left) == (node.Operator == "||") {
+		return nativeBoolToBooleanObject(isTruthy(left))
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 // evalStringInfixExpression is a helper function that takes in an operator and
 // two objects and evaluates the infix expression
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
@@ -469,6 +1093,20 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	case "+":
 		return &object.String{Value: leftVal + rightVal}
 
+	// Strings compare by value, ordered byte by byte
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -484,16 +1122,32 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	// Perform the operation
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return object.NewInteger(leftVal + rightVal)
 
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return object.NewInteger(leftVal - rightVal)
 
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return object.NewInteger(leftVal * rightVal)
 
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("division by zero: %d / 0", leftVal)
+		}
+		return object.NewInteger(leftVal / rightVal)
+
+	case "//":
+		if rightVal == 0 {
+			return newError("division by zero: %d // 0", leftVal)
+		}
+		return object.NewInteger(object.FloorDivide(leftVal, rightVal))
+
+	case "**":
+		// A negative exponent gives a fraction
+		if rightVal < 0 {
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		return object.NewInteger(object.Power(leftVal, rightVal))
 
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -501,6 +1155,12 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
 
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 
@@ -538,49 +1198,90 @@ func dataEqual(data1, data2 []float64) bool {
 	return true
 }
 
-// evalTensorInfixExpression is a helper function that takes in an operator, and two tensor objects
-// and returns a tensor object.
+// evalTensorInfixExpression is a helper function that takes in an operator, and
+// two tensors or a tensor and a number, and returns the tensor of the operator
+// applied element-wise, broadcasting the operands to a common shape. The
+// comparisons return masks, while == and != compare the tensors as a whole.
 func evalTensorInfixExpression(operator string, left, right object.Object) object.Object {
-	// Get the values from the objects
-	leftVal := left.(*object.Tensor)
-	rightVal := right.(*object.Tensor)
+	switch operator {
+	case "+", "-", "*", "/":
+	case "==":
+		return nativeBoolToBooleanObject(tensorsEqual(left, right))
+	case "!=":
+		return nativeBoolToBooleanObject(!tensorsEqual(left, right))
+	case "<":
+		return evalTensorComparison(left, right, func(x, y float64) bool { return x < y })
+	case ">":
+		return evalTensorComparison(left, right, func(x, y float64) bool { return x > y })
+	case "<=":
+		return evalTensorComparison(left, right, func(x, y float64) bool { return x <= y })
+	case ">=":
+		return evalTensorComparison(left, right, func(x, y float64) bool { return x >= y })
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 
-	var resultData []float64
+	result, err := object.Arithmetic(operator, asTensor(left), asTensor(right))
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
 
-	if !shapesEqual(leftVal.Shape, rightVal.Shape) {
-		return newError("tensors operations are not using the same shape %+v %+v", leftVal.Shape, rightVal.Shape)
+// tensorsEqual is a helper function that reports whether two operands are
+// tensors of the same shape and elements. A tensor never equals a number.
+func tensorsEqual(left, right object.Object) bool {
+	leftTensor, ok := left.(*object.Tensor)
+	if !ok {
+		return false
 	}
+	rightTensor, ok := right.(*object.Tensor)
+	return ok && leftTensor.Equal(rightTensor)
+}
 
-	// Perform the operation
-	switch operator {
-	case "+":
-		for index := range leftVal.Data {
-			resultData = append(resultData, leftVal.Data[index]+rightVal.Data[index])
-		}
-		return &object.Tensor{Shape: leftVal.Shape, Data: resultData}
-	case "-":
-		for index := range leftVal.Data {
-			resultData = append(resultData, leftVal.Data[index]-rightVal.Data[index])
-		}
-		return &object.Tensor{Shape: leftVal.Shape, Data: resultData}
+// evalTensorComparison is a helper function that compares the elements of two
+// tensors, or a tensor and a number, and returns the mask of the results
+func evalTensorComparison(left, right object.Object, fn func(x, y float64) bool) object.Object {
+	result, err := object.Compare(asTensor(left), asTensor(right), fn)
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
 
-	case "*":
-		for index := range leftVal.Data {
-			resultData = append(resultData, leftVal.Data[index]*rightVal.Data[index])
-		}
-		return &object.Tensor{Shape: leftVal.Shape, Data: resultData}
+// asTensor is a helper function that returns a tensor operand, turning
+// numbers into tensors of no dimension
+func asTensor(obj object.Object) *object.Tensor {
+	if scalar, ok := object.ScalarTensor(obj); ok {
+		return scalar
+	}
+	return obj.(*object.Tensor)
+}
 
-	case "/":
-		for index := range leftVal.Data {
-			resultData = append(resultData, leftVal.Data[index]/rightVal.Data[index])
-		}
-		return &object.Tensor{Shape: leftVal.Shape, Data: resultData}
+// isNumber is a helper function that reports whether obj is a number
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
 
+// isComparison is a helper function that reports whether operator compares
+// its operands
+func isComparison(operator string) bool {
+	switch operator {
+	case "<", ">", "<=", ">=", "==", "!=":
+		return true
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return false
 	}
 }
 
+// toFloat is a helper function that converts a number to a float
+func toFloat(obj object.Object) *object.Float {
+	if integer, ok := obj.(*object.Integer); ok {
+		return &object.Float{Value: float64(integer.Value)}
+	}
+	return obj.(*object.Float)
+}
+
 // evalFloatInfixExpression is a helper function that takes in an operator and
 // two objects and evaluates the infix expression
 // to copypasta or not to copypasta
@@ -603,12 +1304,24 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 	case "/":
 		return &object.Float{Value: leftVal / rightVal}
 
+	case "//":
+		return &object.Float{Value: math.Floor(leftVal / rightVal)}
+
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
 
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 
@@ -638,7 +1351,7 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	value := right.(*object.Integer).Value
 
 	// Perform the operation
-	return &object.Integer{Value: -value}
+	return object.NewInteger(-value)
 }
 
 // evalBangOperatorExpression is a helper function that takes in an object and
@@ -659,16 +1372,123 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 	}
 }
 
+// lookup is a helper function that returns the value of the variable an
+// identifier names, from its slot when it was resolved to one that is set
+func lookup(ident *ast.Identifier, env *object.Environment) (object.Object, bool) {
+	if binding := ident.Binding; binding != nil {
+		if binding.Index >= 0 {
+			if val, ok := env.Slot(binding.Depth, binding.Index); ok {
+				return val, true
+			}
+		} else if outer := env.Outer(binding.Depth); outer != nil {
+			return outer.Get(ident.Value)
+		}
+	}
+	return env.Get(ident.Value)
+}
+
+// evalDestructureStatement is a helper function that binds the names of a
+// destructuring let to the elements or fields of its value, read as index
+// expressions would read them, or to the values of its tuple
+func evalDestructureStatement(node *ast.DestructureStatement, env *object.Environment) object.Object {
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if node.Tuple {
+		values, err := object.Unpack(val, len(node.Names))
+		if err != nil {
+			return newError("%s", err)
+		}
+		for i, name := range node.Names {
+			bind(name, values[i], env)
+		}
+		return nil
+	}
+
+	for i, name := range node.Names {
+		var index object.Object = object.NewInteger(int64(i))
+		if node.Hash {
+			index = &object.String{Value: name.Value}
+		}
+		element := evalIndexExpression(val, index)
+		if isError(element) {
+			return element
+		}
+		bind(name, element, env)
+	}
+	return nil
+}
+
+// assign is a helper function that sets the variable an identifier names,
+// in its slot when it was resolved to one that is set, and returns an error
+// when the variable is not bound. The variables of enclosing functions
+// cannot be assigned, as on the VM, whose closures hold copies of them: a
+// function sets its own variables and those of the top level.
+func assign(ident *ast.Identifier, val object.Object, env *object.Environment) *object.Error {
+	if binding := ident.Binding; binding != nil {
+		if binding.Index >= 0 {
+			if _, ok := env.Slot(binding.Depth, binding.Index); ok {
+				if binding.Depth > 0 {
+					return newError("cannot assign to %s, a variable of an enclosing function", ident.Value)
+				}
+				env.SetSlot(binding.Index, val)
+				return nil
+			}
+		} else if outer := env.Outer(binding.Depth); outer != nil {
+			binder := outer.Binder(ident.Value)
+			if binder == nil {
+				return newError("cannot assign to undeclared variable %s", ident.Value)
+			}
+			if errObj := assignShared(binder, env); errObj != nil {
+				return errObj
+			}
+			binder.Assign(ident.Value, val)
+			return nil
+		}
+	}
+
+	binder := env.Binder(ident.Value)
+	if binder == nil {
+		return newError("cannot assign to undeclared variable %s", ident.Value)
+	}
+	if binder != env && binder.Outer(1) != nil {
+		return newError("cannot assign to %s, a variable of an enclosing function", ident.Value)
+	}
+	if errObj := assignShared(binder, env); errObj != nil {
+		return errObj
+	}
+	binder.Assign(ident.Value, val)
+	return nil
+}
+
+// assignShared is a helper function that returns an error when a call made
+// by pmap assigns a variable of the top level binder shared with the other
+// calls. Those of the modules it is importing and of the programs it
+// evaluates, in its own context, are its own.
+func assignShared(binder, env *object.Environment) *object.Error {
+	ctx := env.Context()
+	if ctx == nil || !ctx.Parallel || binder.Outer(1) != nil || binder.Context() == ctx {
+		return nil
+	}
+	return newError("cannot assign to global variables inside pmap")
+}
+
 // evalIdentifier is a helper function that takes in an identifier and evaluates
 // the identifier
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	if val, ok := env.Get(node.Value); ok {
+	if val, ok := lookup(node, env); ok {
 		return val
 	}
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
 	}
-	if extended, ok := object.GetExtendedFunction(node.Value); ok {
+	// Builtins registered by plugins
+	if builtin := object.GetBuiltInByName(node.Value); builtin != nil {
+		return builtin
+	}
+	if extended, ok := env.Context().Function(node.Value); ok {
 		return &extended
 	}
 
@@ -1,10 +1,16 @@
 package evaluator
 
 import (
+	"context"
+	"fmt"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestEvalImportLiteral is a function that tests the evaluation of import
@@ -23,6 +29,228 @@ func TestEvalImportLiteral(t *testing.T) {
 	}
 }
 
+// TestImportCache tests that a module imported several times is evaluated
+// once, that import cycles are reported and that changed modules are reloaded
+func TestImportCache(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	shared := write("shared.mky", "let value = 1;")
+	left := write("left.mky", `import "`+shared+`"; let l = value;`)
+	right := write("right.mky", `import "`+shared+`"; let r = value;`)
+
+	evaluations := 0
+	hooks := &Hooks{OnStatement: func(ev StatementEvent) {
+		if ev.File == shared {
+			evaluations++
+		}
+	}}
+
+	testIntegerObject(t, testEvalHooked(`import "`+left+`"; import "`+right+`"; l + r;`, hooks), 2)
+	if evaluations != 1 {
+		t.Errorf("shared module evaluated %d times, want 1", evaluations)
+	}
+
+	write("shared.mky", "let value = 5;")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(shared, later, later); err != nil {
+		t.Fatal(err)
+	}
+	testIntegerObject(t, testEval(`import "`+shared+`"; value;`), 5)
+
+	cycle := filepath.Join(dir, "cycle.mky")
+	write("cycle.mky", `import "`+cycle+`";`)
+	expected := "On line 1, import cycle: " + cycle + " imports itself"
+	errObj, ok := testEval(`import "` + cycle + `";`).(*object.Error)
+	if !ok || errObj.Message != expected {
+		t.Errorf("wrong cycle error. want=%q, got=%+v", expected, errObj)
+	}
+
+	// A context with a cache of its own evaluates the modules again
+	evaluations = 0
+	ctx := WithHooks(nil, hooks)
+	ctx.Modules = NewModules()
+	env := object.NewEnvironment()
+	env.SetContext(ctx)
+	testIntegerObject(t, Eval(parser.New(lexer.New(`import "`+left+`"; l;`)).ParseProgram(), env), 5)
+	if evaluations != 1 {
+		t.Errorf("shared module evaluated %d times in a new cache, want 1", evaluations)
+	}
+}
+
+// TestParallelImports tests that functions called on several goroutines by
+// pmap import modules, relative to the file defining them, without taking
+// each other for import cycles
+func TestParallelImports(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "value.mky"), []byte("let value = 10;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapper := filepath.Join(dir, "mapper.mky")
+	if err := os.WriteFile(mapper, []byte(`let add = fn(x) { import "./value.mky"; x + value };`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	evaluated := testEval(`import "` + mapper + `"; pmap([1, 2, 3, 4, 5, 6, 7, 8], add, 4)`)
+	if expected := "[11, 12, 13, 14, 15, 16, 17, 18]"; evaluated.Inspect() != expected {
+		t.Errorf("wrong result. want=%s, got=%s", expected, evaluated.Inspect())
+	}
+}
+
+// TestImportExports tests that only the exported bindings of a module that
+// exports any are visible to importers
+func TestImportExports(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib.mky")
+	source := "let helper = fn(x) { x * 2 };\nexport let double = fn(x) { helper(x) };"
+	if err := os.WriteFile(lib, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testIntegerObject(t, testEval(`import "`+lib+`"; double(4);`), 8)
+
+	errObj, ok := testEval(`import "` + lib + `"; helper(4);`).(*object.Error)
+	if !ok || errObj.Message != "identifier not found: helper" {
+		t.Errorf("helper visible to the importer. got=%+v", errObj)
+	}
+
+	// An export list exports names bound by any statement
+	listed := filepath.Join(t.TempDir(), "listed.mky")
+	source = "let helper = fn(x) { x * 2 };\nlet [one, two] = [1, 2];\nexport one, helper;"
+	if err := os.WriteFile(listed, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testIntegerObject(t, testEval(`import "`+listed+`"; helper(one);`), 2)
+
+	errObj, ok = testEval(`import "` + listed + `"; two;`).(*object.Error)
+	if !ok || errObj.Message != "identifier not found: two" {
+		t.Errorf("two visible to the importer. got=%+v", errObj)
+	}
+
+	errObj, ok = testEval("let a = 1; export a, b;").(*object.Error)
+	if !ok || errObj.Message != "identifier not found: b" {
+		t.Errorf("export of an unbound name. got=%+v", errObj)
+	}
+}
+
+func TestImportCompiled(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib.mkyc")
+	if err := os.WriteFile(lib, []byte{0x80}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "On line 1, error reading import file: " + lib + " holds bytecode, which only the vm engine can import"
+	errObj, ok := testEval(`import "` + lib + `";`).(*object.Error)
+	if !ok || errObj.Message != expected {
+		t.Errorf("wrong error for a compiled module. got=%+v", errObj)
+	}
+}
+
+// TestImportAlias tests binding the bindings of a module to a name of their
+// own, import "m" as m
+func TestImportAlias(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib.mky")
+	source := "let helper = fn(x) { x * 2 };\nexport let double = fn(x) { helper(x) };\nexport let two = 2;"
+	if err := os.WriteFile(lib, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`import "` + lib + `" as lib; lib.double(lib.two);`, 4},
+		{`let f = fn(x) { import "` + lib + `" as m; m.double(x) }; f(5);`, 10},
+		{`import "` + lib + `" as lib; double(1);`, "identifier not found: double"},
+		{`import "` + lib + `" as lib; lib.helper(1);`, "unknown method helper of HASH at line 1"},
+		{`import "std/math" as math; math.max(3, 7);`, 7},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong result for %q. want error %q, got=%+v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
+// TestImportPackage tests importing a directory whose index file imports the
+// other files of the package relative to itself
+func TestImportPackage(t *testing.T) {
+	stats := filepath.Join(t.TempDir(), "stats")
+	files := map[string]string{
+		"index.mky": `import "./sum.mky"; export let mean = fn(a) { total(a) / 2 };`,
+		"sum.mky":   "let total = fn(a) { a[0] + a[1] };",
+	}
+	if err := os.Mkdir(stats, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(stats, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testIntegerObject(t, testEval(`import "`+stats+`"; mean([4, 6]);`), 5)
+}
+
+func TestImportErrorChain(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"helper.mky": "let a = 1;\nlet b = a + true;",
+		"mid.mky":    "let m = 1;\nimport \"./helper.mky\";",
+		"broken.mky": "let = 5;",
+	}
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	helper, mid, broken := filepath.Join(dir, "helper.mky"), filepath.Join(dir, "mid.mky"), filepath.Join(dir, "broken.mky")
+
+	tests := []struct {
+		input    string
+		message  string
+		expected string
+	}{
+		{"let x = 1;\nimport \"" + mid + "\";", "type mismatch: INTEGER + BOOLEAN",
+			"error in " + helper + ":2, imported from " + mid + ":2, imported from main.mky:2"},
+		{"import \"" + broken + "\";", "expected next token to be IDENT, got = instead",
+			"error in " + broken + ":1, imported from main.mky:1"},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("no error for %q", tt.input)
+			continue
+		}
+		if errObj.Message != tt.message {
+			t.Errorf("wrong message. want=%q, got=%q", tt.message, errObj.Message)
+		}
+		if chain := object.ImportChain(errObj.Imports, "main.mky"); chain != tt.expected {
+			t.Errorf("wrong import chain. want=%q, got=%q", tt.expected, chain)
+		}
+	}
+}
+
+func TestImportStd(t *testing.T) {
+	input := `import "std/arrays"; import "std/math"; sum(map(range(4), fn(x) { pow(x, 2) }));`
+	testIntegerObject(t, testEval(input), 14)
+}
+
 func TestEvalIntegerExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -41,6 +269,17 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 // 2", 3},
+		{"-7 // 2", -4},
+		{"7 // -2", -4},
+		{"-7 // -2", 3},
+		{"-7 / 2", -3},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"7 ** 0", 1},
+		{"2 * 3 ** 2", 18},
 	}
 
 	for _, tt := range tests {
@@ -58,6 +297,14 @@ func TestEvalFloatExpression(t *testing.T) {
 		{"10.1", 10.1},
 		{"5.5 + 10.12", 15.62},
 		{"3.2 * 10.0", 32.0},
+		{"7.5 // 2.0", 3.0},
+		{"-7.5 // 2.0", -4.0},
+		{"7 // 2.0", 3.0},
+		{"-7.0 // 2", -4.0},
+		{"2 ** -1", 0.5},
+		{"4.0 ** 0.5", 2.0},
+		{"2 ** 0.5 ** 2", 1.189207115002721},
+		{"1.5 ** 2", 2.25},
 	}
 
 	for _, tt := range tests {
@@ -94,6 +341,36 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"2 == 2.5", false},
+		{"1 < 1.5", true},
+		{"2.5 > 3", false},
+		{"1 <= 1", true},
+		{"1 >= 2", false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"b" > "abc"`, true},
+		{`"a" <= "a"`, true},
+		{`"" >= "a"`, false},
+		{`"Z" < "a"`, true},
+		{`"ab" == "a" + "b"`, true},
+		{`"a" != "a"`, false},
+		{"2 >= 1", true},
+		{"1.5 <= 1", false},
+		{"1 >= 1.0", true},
+		{"[1, 2.0][0] == [1.0, 2][0]", true},
+		{"1 == true", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"0 || \"\"", true},
+		{"1 > 2 || 2 > 1 && 3 > 2", true},
+		{"false && undefined()", false},
+		{"true || undefined()", true},
 	}
 
 	for _, tt := range tests {
@@ -138,6 +415,9 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 }", 10},
 		{"if (1 > 2) { 10 }", nil},
+		{"if (null) { 10 }", nil},
+		{"if (null) { 10 } else { null }", nil},
+		{"null", nil},
 	}
 
 	for _, tt := range tests {
@@ -152,6 +432,206 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 }
 
+// TestWhileStatements is a function that tests the evaluation of while
+// statements
+func TestWhileStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 5) { let i = i + 1; }; i", 5},
+		{"let f = fn(n) { let total = 0; while (n > 0) { let total = total + n; let n = n - 1; }; total }; f(4)", 10},
+		{"let f = fn() { while (true) { return 3; } }; f()", 3},
+		{"while (false) { 10 }", nil},
+		{"let f = fn() { while (false) { 10 } }; f()", nil},
+		{"if (true) { while (false) { 10 } }", nil},
+		{"let f = fn() { while (false) { } }; let v = f(); if (v == null) { 1 } else { 2 }", 1},
+		{"let f = fn() { while (false) { } }; len([f(), f()])", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+// TestAssignStatements is a function that tests the evaluation of
+// assignments to declared variables
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = x + 1; x", 2},
+		{"let i = 0; while (i < 5) { i = i + 1; }; i", 5},
+		{"let count = 0; let inc = fn() { count = count + 1; }; inc(); inc(); count", 2},
+		{"let f = fn(n) { let total = 0; for (x in [1, 2, 3]) { total = total + x * n; }; total }; f(2)", 12},
+		{"let f = fn() { let x = 1; let g = fn() { x = 10; }; g(); x }; f()", "cannot assign to x, a variable of an enclosing function"},
+		{"let f = fn() { let x = 1; let g = fn() { let x = 2; x = 10; x }; g() + x }; f()", 11},
+		{"let f = fn() { let x = 1; let g = fn() { x }; x = 2; g() }; f()", "cannot assign to x, a variable captured by a closure"},
+		{"let f = fn() { let i = 0; let g = fn() { i }; while (i < 3) { i = i + 1; }; g() }; f()", "cannot assign to i, a variable captured by a closure"},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } }; g = 1; g }; f()", 1},
+		{"x = 1", "cannot assign to undeclared variable x"},
+		{"let f = fn() { y = 1; }; f()", "cannot assign to undeclared variable y"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong result for %q. want error %q, got=%+v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
+// TestTuples is a function that tests returning several values and binding
+// them with a let statement
+func TestTuples(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let divmod = fn(a, b) { return a // b, a - a // b * b; }; let q, r = divmod(7, 2); q * 10 + r", 31},
+		{"let f = fn() { return 1, 2, 3; }; let t = f(); t[0] + t[-1]", 4},
+		{"let f = fn() { return 1, 2; }; let g = fn() { let a, b = f(); b - a }; g()", 1},
+		{"let f = fn() { return 1, 2; }; f()", "(1, 2)"},
+		{"let f = fn() { return 1, 2; }; let a, b, c = f();", "cannot unpack 2 values into 3 names"},
+		{"let a, b = [1, 2];", "cannot unpack ARRAY into 2 names"},
+		{"let f = fn() { return 1, -true; }; f()", "unknown operator: -BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			var got string
+			switch result := evaluated.(type) {
+			case *object.Tuple:
+				got = result.Inspect()
+			case *object.Error:
+				got = result.Message
+			}
+			if got != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%+v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
+// TestTryExpressions is a function that tests the evaluation of try
+// expressions
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { 1 + true } catch (e) { e }", "type mismatch: INTEGER + BOOLEAN"},
+		{"let x = try { len(1) } catch (e) { 0 }; x + 1", 1},
+		{"let f = fn() { -true }; try { f() } catch (e) { e }", "unknown operator: -BOOLEAN"},
+		{"let f = fn() { try { return 5; } catch (e) { 0 }; 10 }; f()", 5},
+		{"try { try { 1 + true } catch (e) { -e } } catch (e) { e }", "unknown operator: -STRING"},
+		{"try { 1 } catch (e) { 2 }; e", "identifier not found: e"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			var got string
+			switch result := evaluated.(type) {
+			case *object.String:
+				got = result.Value
+			case *object.Error:
+				got = result.Message
+			}
+			if got != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%+v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
+// TestDestructureStatements is a function that tests the evaluation of
+// destructuring let statements
+func TestDestructureStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b] = [1]; b", nil},
+		{`let {x, y} = {"x": 3, "y": 4}; x * y`, 12},
+		{`let {z} = {"x": 3}; z`, nil},
+		{"let f = fn(pair) { let [a, b] = pair; a - b }; f([5, 2])", 3},
+		{"let a = 1; let b = 2; let [a, b] = [b, a]; a * 10 + b", 21},
+		{"let [a] = 5;", "index operator not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong result for %q. want error %q, got=%+v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
+// TestForStatements is a function that tests the evaluation of for
+// statements
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let total = 0; for (x in [1, 2, 3]) { let total = total + x; }; total", 6},
+		{"let f = fn(xs) { let total = 0; for (x in xs) { let total = total + x; }; total }; f([4, 5])", 9},
+		{`let total = 0; for (k in {1: "a", 2: "b", 3: "c"}) { let total = total + k; }; total`, 6},
+		{`let n = 0; for (k in {3: "c", 1: "a", 4: "d", 2: "b"}) { n = n * 10 + k; }; n`, 1234},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x > 1) { return x; } } }; f()", 2},
+		{"for (x in [1, 2]) { x }; x", 2},
+		{"for (x in []) { x }", nil},
+		{"for (x in 5) { x }", "cannot iterate over INTEGER"},
+		{"let f = fn() { for (x in []) { x } }; let v = f(); if (v == null) { 1 } else { 2 }", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong error for %s. want=%q, got=%+v", tt.input, expected, evaluated)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 // TestReturnStatements is a function that tests the evaluation of return
 // statements
 func TestReturnStatements(t *testing.T) {
@@ -199,7 +679,17 @@ func TestErrorHandling(t *testing.T) {
 		{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"foobar", "identifier not found: foobar"},
 		{"\"Hello\" - \"World\"", "unknown operator: STRING - STRING"},
+		{`"a" < 1`, "type mismatch: STRING < INTEGER"},
 		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{"let x = 5;\nx(1);", "cannot call INTEGER value `x` at line 2"},
+		{"1 // 0", "division by zero: 1 // 0"},
+		{"let f = fn(x) { 10 / x }; f(0);", "division by zero: 10 / 0"},
+		{`astype(@[1.0, 2.0], "int64") / 0`, "division by zero: int64 tensor / 0"},
+		{`astype(@[1.0, 2.0], "int64") / astype(@[1.0, 0.0], "int64")`, "division by zero: int64 tensor / 0"},
+		{`div_(astype(@[1.0], "int64"), 0)`, "division by zero: int64 tensor / 0"},
+		{"let x = 5; x.y", "index operator not supported: INTEGER"},
+		{"1 / 2.0", "type mismatch: INTEGER / FLOAT"},
+		{`let f = fn() { "f" }; f()();`, "cannot call STRING value `f()` at line 1"},
 	}
 
 	for _, tt := range tests {
@@ -218,7 +708,130 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+// TestErrorStack is a function that tests the call stack recorded on errors
+func TestErrorStack(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + true;
+};
+let outer = fn(y) {
+  inner(y);
+};
+outer(1);`
+
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+
+	expected := []string{"at inner (line 5)", "at outer (line 7)"}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack. expected=%q, got=%q", expected, errObj.Stack)
+	}
+	for i, frame := range expected {
+		if errObj.Stack[i] != frame {
+			t.Errorf("wrong stack frame %d. expected=%q, got=%q", i, frame, errObj.Stack[i])
+		}
+	}
+}
+
+// TestTailCalls is a function that tests that calls in tail position do not
+// grow the stack
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = fn(n, acc) { if (n == 0) { return acc; } sum(n - 1, acc + n) }; sum(200000, 0)", 20000100000},
+		{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(200001)", false},
+		{"let count = fn(n) { while (true) { if (n == 0) { return 0; } return count(n - 1); } }; count(200000)", 0},
+		{"let f = fn(n) { try { return g(n); } catch (e) { -1 } }; let g = fn(n) { n + true }; f(1)", -1},
+		{"let f = fn(n) { n * 2 }; let g = fn(n) { 1 + f(n) }; g(2)", 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+
+	input := `let inner = fn(x) {
+  x + true;
+};
+let middle = fn(y) { inner(y) };
+let outer = fn(y) {
+  middle(y);
+};
+outer(1);`
+
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+	expected := []string{"at inner (line 4)", "at outer (line 8)"}
+	if strings.Join(errObj.Stack, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("wrong stack. expected=%q, got=%q", expected, errObj.Stack)
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { return 0; } return 1 + f(n - 1); }; f(3000000)"
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok || errObj.Message != "stack overflow" {
+		t.Fatalf("expected a stack overflow. got=%v", errObj)
+	}
+
+	testIntegerObject(t, testEval("let f = fn(n) { if (n == 0) { return 0; } return 1 + f(n - 1); }; f(10000)"), 10000)
+}
+
 // TestLetStatements is a function that tests the evaluation of let statements
+func TestStatementHook(t *testing.T) {
+	input := `let f = fn(x) {
+  let y = x * 2;
+  y
+};
+f(1);`
+
+	type stop struct{ line, depth int }
+	var stops []stop
+	testEvalHooked(input, &Hooks{OnStatement: func(ev StatementEvent) {
+		stops = append(stops, stop{ev.Line, ev.Depth})
+	}})
+
+	expected := []stop{{1, 0}, {5, 0}, {2, 1}, {3, 1}}
+	if len(stops) != len(expected) {
+		t.Fatalf("wrong statements. want=%v, got=%v", expected, stops)
+	}
+	for i, want := range expected {
+		if stops[i] != want {
+			t.Errorf("wrong statement %d. want=%v, got=%v", i, want, stops[i])
+		}
+	}
+}
+
+func TestDepthAfterExit(t *testing.T) {
+	env := object.NewEnvironment()
+	ctx := WithHooks(nil, nil)
+	env.SetContext(ctx)
+	program := parser.New(lexer.New("let f = fn(n) { if (n == 0) { exit(3) } f(n - 1) + 1 }; f(5);")).ParseProgram()
+
+	func() {
+		defer func() {
+			if _, ok := recover().(*object.ExitRequest); !ok {
+				t.Fatalf("exit() did not unwind the program")
+			}
+		}()
+		Eval(program, env)
+	}()
+
+	if ctx.Depth != 0 {
+		t.Errorf("wrong depth after exit(). want=0, got=%d", ctx.Depth)
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -324,6 +937,110 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringBuilding(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "key"; "mon" + name + "-" + name`, "monkey-key"},
+		{`let b = string_builder("mon"); append(b, "key"); append(b, "-", "banana"); build(b)`, "monkey-banana"},
+		{`let b = string_builder(); build(b)`, ""},
+		{`"mon" + "key" + 1`, "ERROR: type mismatch: STRING + INTEGER"},
+		{`append(string_builder(), 1)`, "ERROR: arguments appended by `append` must be STRING, got INTEGER"},
+		{`build("monkey")`, "ERROR: argument to `build` must be STRING_BUILDER, got STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if str, ok := evaluated.(*object.String); ok {
+			if str.Value != tt.expected {
+				t.Errorf("wrong result of %s. want=%q, got=%q", tt.input, tt.expected, str.Value)
+			}
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result of %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// TestParallelMap tests mapping functions over arrays with pmap
+func TestParallelMap(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`pmap([1, 2, 3], fn(x) { x * 2 }, 2)`, "[2, 4, 6]"},
+		{`let k = 10; pmap([1, 2, 3, 4, 5], fn(x) { let y = x + k; y * y })`, "[121, 144, 169, 196, 225]"},
+		{`pmap([], fn(x) { x }, 4)`, "[]"},
+		{`pmap([[1], 2, 3], fn(x) { first(x) }, 3)`, "ERROR: argument to `first` must be ARRAY, got INTEGER"},
+		{`pmap([1], fn(x) { x }, 0)`, "ERROR: number of workers given to `pmap` must be positive, got 0"},
+		{`pmap([1], 1)`, "ERROR: second argument to `pmap` must be a function, got INTEGER"},
+		{`let total = 0; pmap([1, 2, 3], fn(x) { total = total + x; }, 3)`, "ERROR: cannot assign to global variables inside pmap"},
+		{`pmap([1, 2], fn(x) { let y = x; y = y * 10; eval("let z = 1; z = z + 1; z") + y })`, "[12, 22]"},
+	}
+
+	for _, tt := range tests {
+		if evaluated := testEval(tt.input); evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result of %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// exit() in a call unwinds the caller of pmap
+	defer func() {
+		if exit, ok := recover().(*object.ExitRequest); !ok || exit.Code != 3 {
+			t.Errorf("exit() in pmap did not unwind the caller. got=%v", exit)
+		}
+	}()
+	testEval(`pmap([1, 2, 3, 4], fn(x) { exit(3) }, 2)`)
+}
+
+// TestContextBuiltins tests the builtins calling the evaluator back
+func TestContextBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let k = 10; map([1, 2, 3], fn(x) { x + k })`, "[11, 12, 13]"},
+		{`map([], 1)`, "ERROR: second argument to `map` must be a function, got INTEGER"},
+		{`map([[1], 2], first)`, "ERROR: argument to `first` must be ARRAY, got INTEGER"},
+		{`sort([3, 1.5, 2])`, "[1.500000, 2, 3]"},
+		{`sort(["b", "c", "a"])`, "[a, b, c]"},
+		{`sort([[2, "b"], [1, "a"], [2, "a"]], fn(x, y) { first(x) < first(y) })`, "[[1, a], [2, b], [2, a]]"},
+		{`sort([1, "a"])`, "ERROR: cannot compare STRING and INTEGER in `sort` without a function"},
+		{`sort([1, 2], fn(x, y) { x })`, "ERROR: function given to `sort` must return BOOLEAN, got INTEGER"},
+		{`let x = 1; eval("let x = 20; x + 1") + x`, "22"},
+		{`eval("map([1], fn(x) { x * 3 })")`, "[3]"},
+		{`eval("let")`, "ERROR: On line 1, expected next token to be IDENT, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		if evaluated := testEval(tt.input); evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result of %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	env := object.NewEnvironment()
+	env.SetContext(&object.Context{Context: ctx})
+	program := parser.New(lexer.New(`let spin = fn(n) { spin(n + 1) }; 1 + 1; spin(0)`)).ParseProgram()
+
+	evaluated := Eval(program, env)
+	if evaluated.Inspect() != "ERROR: execution stopped: context canceled" {
+		t.Errorf("canceled program was not stopped. got=%s", evaluated.Inspect())
+	}
+
+	program = parser.New(lexer.New(`while (true) { 1 }`)).ParseProgram()
+	evaluated = Eval(program, env)
+	if evaluated.Inspect() != "ERROR: execution stopped: context canceled" {
+		t.Errorf("canceled loop was not stopped. got=%s", evaluated.Inspect())
+	}
+}
+
 // TestBuiltinFunctions is a function that tests the evaluation of built-in
 // functions
 func TestBuiltinFunctions(t *testing.T) {
@@ -337,6 +1054,13 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("hello world")`, 11},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`reshape(@[2,2],[1.0,2.0,3.0,4.0], [3])`, "cannot reshape tensor of shape [2 2] into [3]"},
+		{`reshape([1], [1])`, "argument to `reshape` must be TENSOR, got ARRAY"},
+		{`transpose(@[2],[1.0,2.0], [1])`, "axes [1] do not permute the 1 axes of the tensor"},
+		{`astype(@[1.0], "int8")`, `unknown dtype "int8", want float64, float32, int64 or bool`},
+		{`dtype(1)`, "argument to `dtype` must be TENSOR, got INTEGER"},
+		{`where(@[1.0], "a", 0)`, "arguments to `where` must be tensors or numbers, got STRING"},
+		{`@[1.0, 2.0] > @[1.0, 2.0, 3.0]`, "shapes [2] and [3] cannot be broadcast together"},
 	}
 
 	for _, tt := range tests {
@@ -358,6 +1082,46 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+// TestMethodCalls is a function that tests calling builtins and functions
+// in hash fields as methods
+func TestMethodCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3].len()", 3},
+		{`"abc".upper()`, "ABC"},
+		{`"ABC".lower().len()`, 3},
+		{`{"a": 1}.keys().first()`, "a"},
+		{`{"a": 1, "b": 2}.values().len()`, 2},
+		{`let r = {"double": fn(x) { x * 2 }}; r.double(4)`, 8},
+		{`let r = {"keys": fn() { 7 }}; r.keys()`, 7},
+		{"[3, 1, 2].sort().first()", 1},
+		{"[1, 2].map(fn(x) { x * 10 }).last()", 20},
+		{`"a".len(1)`, &object.Error{Message: "wrong number of arguments. got=2, want=1"}},
+		{"let x = 5; x.len()", &object.Error{Message: "unknown method len of INTEGER at line 1"}},
+		{`let h = {"a": 1};` + "\nh.a()", &object.Error{Message: "cannot call INTEGER value `h.a` at line 2"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%+v", tt.input, expected, evaluated)
+			}
+		case *object.Error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected.Message {
+				t.Errorf("wrong result for %q. want error %q, got=%+v", tt.input, expected.Message, evaluated)
+			}
+		}
+	}
+}
+
 // TestTensorLiteral
 func TestTensorLiteral(t *testing.T) {
 	input := "@[3,3],[1.0,2.0,3.0,4.0,5.0,6.0,7.0,8.0,9.0];"
@@ -404,7 +1168,9 @@ func TestArrayIndexExpressions(t *testing.T) {
 		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
 		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
 		{"[1, 2, 3][3]", nil},
-		{"[1, 2, 3][-1]", nil},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", nil},
 	}
 
 	for _, tt := range tests {
@@ -418,6 +1184,56 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 }
 
+// TestSliceExpressions is a function that tests the evaluation of slices of
+// arrays and strings
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3, 4][1:3]", []int64{2, 3}},
+		{"[1, 2, 3, 4][:2]", []int64{1, 2}},
+		{"[1, 2, 3, 4][2:]", []int64{3, 4}},
+		{"[1, 2, 3][:]", []int64{1, 2, 3}},
+		{"[1, 2, 3][1:99]", []int64{2, 3}},
+		{"[1, 2, 3][2:1]", []int64{}},
+		{"let a = [1, 2, 3]; let b = a[:]; push(b, 4); len(a)", 3},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[3:]`, "lo"},
+		{`"hello"[-3:-1]`, "ll"},
+		{"5[1:2]", "slice operator not supported: INTEGER"},
+		{`[1][true:]`, "slice bound must be INTEGER, got BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []int64:
+			array, ok := evaluated.(*object.Array)
+			if !ok || len(array.Elements) != len(expected) {
+				t.Errorf("wrong result for %q. want=%v, got=%+v", tt.input, expected, evaluated)
+				continue
+			}
+			for i, element := range expected {
+				testIntegerObject(t, array.Elements[i], element)
+			}
+		case string:
+			var got string
+			switch result := evaluated.(type) {
+			case *object.String:
+				got = result.Value
+			case *object.Error:
+				got = result.Message
+			}
+			if got != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%+v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
 // TestHashLiterals is a function that tests the evaluation of hash literals
 func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
@@ -481,6 +1297,58 @@ func TestTensorMath(t *testing.T) {
 			input:    `let x = @[3],[1.0,2.0,3.0]; let y = @[3],[1.0,1.0,1.0]; let z = x / y; z;`,
 			expected: object.Tensor{Shape: []int64{3}, Data: []float64{1.0, 2.0, 3.0}},
 		},
+		{
+			input:    `let x = @[2,1],[1.0,2.0]; let y = @[2,2],[1.0,2.0,3.0,4.0]; x + y;`,
+			expected: object.Tensor{Shape: []int64{2, 2}, Data: []float64{2.0, 3.0, 5.0, 6.0}},
+		},
+		{
+			input:    `let x = @[2],[1.0,2.0]; 2 * x - 0.5;`,
+			expected: object.Tensor{Shape: []int64{2}, Data: []float64{1.5, 3.5}},
+		},
+		{
+			input:    `let x = @[2],[1.0,2.0]; let y = @[3],[1.0,2.0,3.0]; x + y;`,
+			expected: "shapes [2] and [3] cannot be broadcast together",
+		},
+		{
+			input:    `let x = @[2],[1.0,2.0]; let y = @[3],[1.0,2.0,3.0]; x - y;`,
+			expected: "shapes [2] and [3] cannot be broadcast together",
+		},
+		{
+			input:    `let x = @[2,2],[1.0,2.0,3.0,4.0]; let y = @[3],[1.0,2.0,3.0]; x * y;`,
+			expected: "shapes [2 2] and [3] cannot be broadcast together",
+		},
+		{
+			input:    `let x = @[3],[1.0,2.0,3.0]; let y = @[2],[1.0,2.0]; x / y;`,
+			expected: "shapes [3] and [2] cannot be broadcast together",
+		},
+		{
+			input:    `let x = @[2,3],[1.0,2.0,3.0,4.0,5.0,6.0]; transpose(reshape(x, [3, -1]));`,
+			expected: object.Tensor{Shape: []int64{2, 3}, Data: []float64{1.0, 3.0, 5.0, 2.0, 4.0, 6.0}},
+		},
+		{
+			input:    `let x = @[[1.0, 2.0], [3.0, 4.0]]; x + @[1, 2];`,
+			expected: object.Tensor{Shape: []int64{2, 2}, Data: []float64{2.0, 4.0, 4.0, 6.0}},
+		},
+		{
+			input:    `@[[1.0, 2.0], [3.0]];`,
+			expected: "On line 1, nested tensor data is not rectangular: got an array of length 1 where an array of length 2 is expected",
+		},
+		{
+			input:    `@[[1.0, 2.0], 3.0];`,
+			expected: "On line 1, nested tensor data is not rectangular: got FLOAT where an array of length 2 is expected",
+		},
+		{
+			input:    `@[1.0, [2.0]];`,
+			expected: "On line 1, nested tensor data is not rectangular: got an array of length 1 where a number is expected",
+		},
+		{
+			input:    `@[["a"]];`,
+			expected: "On line 1, tensor data must be numbers, got STRING",
+		},
+		{
+			input:    `@[2,2],[1.0,2.0,3.0];`,
+			expected: "On line 1, tensor of shape [2 2] holds 3 elements, want 4",
+		},
 	}
 
 	for _, tt := range tests {
@@ -488,12 +1356,157 @@ func TestTensorMath(t *testing.T) {
 		switch expected := tt.expected.(type) {
 		case object.Tensor:
 			testTensorObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong error. want=%q, got=%v", expected, evaluated)
+			}
 		case nil:
 			testNullObject(t, evaluated)
 		}
 	}
 }
 
+func TestTensorConstructors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`zeros([2, 2])`, object.Tensor{Shape: []int64{2, 2}, Data: []float64{0, 0, 0, 0}}},
+		{`ones(3)`, object.Tensor{Shape: []int64{3}, Data: []float64{1, 1, 1}}},
+		{`eye(2)`, object.Tensor{Shape: []int64{2, 2}, Data: []float64{1, 0, 0, 1}}},
+		{`arange(3)`, object.Tensor{Shape: []int64{3}, Data: []float64{0, 1, 2}}},
+		{`arange(1, 2, 0.25)`, object.Tensor{Shape: []int64{4}, Data: []float64{1, 1.25, 1.5, 1.75}}},
+		{`arange(3, 0, -1)`, object.Tensor{Shape: []int64{3}, Data: []float64{3, 2, 1}}},
+		{`arange(3, 0)`, object.Tensor{Shape: []int64{0}, Data: []float64{}}},
+		{`zeros([2, -1])`, "shape given to `zeros` has a negative dimension: [2 -1]"},
+		{`ones("a")`, "shape given to `ones` must be an integer or an array of integers, got a"},
+		{`arange(0, 1, 0)`, "step of `arange` must not be 0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case object.Tensor:
+			testTensorObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong error. want=%q, got=%v", expected, evaluated)
+			}
+		}
+	}
+
+	for input, expected := range map[string]string{
+		`dtype(ones(2))`:                                    "float64",
+		`dtype(astype(ones(2), "float32") * 2)`:             "float32",
+		`astype(@[2.5, -1.5], "int64") + 1`:                 "@[2], [3, 0], int64",
+		`astype(@[7.0], "int64") / astype(@[2.0], "int64")`: "@[1], [3], int64",
+		`astype(@[1.0, 2.0], "int64") / 0.0`:                "@[2], [+Inf, +Inf]",
+		`let t = @[0.2, 0.7, 0.9]; t > 0.5`:                 "@[3], [false, true, true], bool",
+		`let t = @[0.2, 0.7, 0.9]; t < @[0.5, 0.5, 1.0]`:    "@[3], [true, false, true], bool",
+		`let t = @[0.2, 0.7, 0.9]; count_nonzero(0.5 < t)`:  "2",
+		`let t = @[0.2, 0.7, 0.9]; t >= 0.7`:                "@[3], [false, true, true], bool",
+		`let t = @[0.2, 0.7, 0.9]; t <= 0.7`:                "@[3], [true, true, false], bool",
+		`let t = @[0.2, 0.7, 0.9]; where(t > 0.5, t, 0)`:    "@[3], [0.000000, 0.700000, 0.900000]",
+		`let t = @[1.0, 2.0]; let u = t; add_(t, 1); u`:     "@[2], [2.000000, 3.000000]",
+		`mul_(sub_(@[[1.0, 2.0], [3.0, 4.0]], @[1, 2]), 2)`: "@[2, 2], [0.000000, 0.000000, 4.000000, 4.000000]",
+		`div_(astype(@[7.0], "int64"), 2)`:                  "@[1], [3], int64",
+		`add_(@[1.0], @[1.0, 2.0])`:                         "ERROR: shape [2] cannot be broadcast in place into shape [1]",
+	} {
+		if evaluated := testEval(input); evaluated.Inspect() != expected {
+			t.Errorf("wrong result of %s. want=%s, got=%s", input, expected, evaluated.Inspect())
+		}
+	}
+
+	// Seeded random tensors repeat
+	first := testEval(`seed(7); rand([2, 3])`)
+	second := testEval(`seed(7); rand([2, 3])`)
+	if first.Inspect() != second.Inspect() {
+		t.Errorf("seeded random tensors differ: %s and %s", first.Inspect(), second.Inspect())
+	}
+	normal, ok := testEval(`seed(7); randn(100)`).(*object.Tensor)
+	if !ok || len(normal.Data) != 100 {
+		t.Fatalf("wrong normal tensor. got=%v", normal)
+	}
+	for _, v := range testEval(`rand(100)`).(*object.Tensor).Data {
+		if v < 0 || v >= 1 {
+			t.Errorf("uniform random value out of [0, 1): %f", v)
+		}
+	}
+}
+
+func TestTensorGradients(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let w = requires_grad(@[1.0, 2.0]); backward(sum(w * w)); grad(w)`, object.Tensor{Shape: []int64{2}, Data: []float64{2, 4}}},
+		{`let w = requires_grad(@[[1.0, 2.0], [3.0, 4.0]]); backward(mean(matmul(@[[1.0, 1.0]], w))); grad(w)`, object.Tensor{Shape: []int64{2, 2}, Data: []float64{0.5, 0.5, 0.5, 0.5}}},
+		{`let b = requires_grad(@[1.0]); backward(sum(@[1.0, 2.0, 3.0] - b)); grad(b)`, object.Tensor{Shape: []int64{1}, Data: []float64{-3}}},
+		{`grad(requires_grad(@[1.0]))`, nil},
+		{`backward(sum(@[1.0]))`, "loss was not computed from a tensor requiring gradients"},
+		{`matmul(@[1.0, 2.0], @[[1.0]])`, "cannot multiply matrices of shapes [2] and [1 1]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case object.Tensor:
+			testTensorObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong error. want=%q, got=%v", expected, evaluated)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestOptimizers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let w = requires_grad(@[1.0, -2.0]); backward(sum(w * w)); sgd_step([w], [grad(w)], 0.25)`, "[@[2], [0.500000, -1.000000]]"},
+		{`let w = requires_grad(@[1.0]); backward(sum(w * 3)); let next = sgd_step([w], [grad(w)], 1)[0]; backward(sum(next * next)); grad(next)`, "@[1], [-4.000000]"},
+		{`let w = @[1.0, -2.0]; adam_step({}, [w], [@[2.0, -4.0]], {"lr": 0.1})["params"]`, "[@[2], [0.900000, -1.900000]]"},
+		{`let w = @[1.0, -2.0]; let g = @[2.0, -4.0]; let first = adam_step({}, [w], [g], {"lr": 0.1}); adam_step(first["state"], first["params"], [g])["params"]`, "[@[2], [0.800000, -1.800000]]"},
+		{`let w = @[1.0]; adam_step({}, [w], [@[1.0]])["state"]["step"]`, "1"},
+		{`sgd_step([@[1.0]], [@[1.0, 2.0]], 1)`, "ERROR: gradient of shape [2] does not match parameter of shape [1]"},
+		{`sgd_step([@[1.0]], [1], 1)`, "ERROR: grads given to `sgd_step` must be an array of tensors, got INTEGER"},
+		{`adam_step({}, [@[1.0]], [@[1.0]], {"momentum": 0.9})`, "ERROR: unknown option of `adam_step`: momentum"},
+	}
+
+	for _, tt := range tests {
+		if evaluated := testEval(tt.input); evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result of %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTensorSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "weights.npy")
+
+	input := fmt.Sprintf(`let w = astype(@[[1.0, 2.0], [3.0, 4.0]], "float32"); tensor_save(w, %q); tensor_load(%q) == w;`, path, path)
+	if evaluated := testEval(input); evaluated != TRUE {
+		t.Errorf("tensor changed by saving and loading it. got=%s", evaluated.Inspect())
+	}
+
+	if loaded := testEval(fmt.Sprintf(`dtype(tensor_load(%q))`, path)); loaded.Inspect() != "float32" {
+		t.Errorf("wrong dtype of the loaded tensor. got=%s", loaded.Inspect())
+	}
+
+	object.SetCapabilities(nil)
+	defer object.SetCapabilities(object.Capabilities)
+	errObj, ok := testEval(fmt.Sprintf(`tensor_load(%q)`, path)).(*object.Error)
+	if !ok || errObj.Message != "`tensor_load` needs the fs capability, which is not granted" {
+		t.Errorf("wrong error without the fs capability. got=%v", errObj)
+	}
+}
+
 // TestHashIndexExpressions is a function that tests the evaluation of hash index
 // expressions
 func TestHashIndexExpressions(t *testing.T) {
@@ -509,6 +1522,11 @@ func TestHashIndexExpressions(t *testing.T) {
 		{`{5: 5}[5]`, 5},
 		{`{true: 5}[true]`, 5},
 		{`{false: 5}[false]`, 5},
+		// Dot access
+		{`{"foo": 5}.foo`, 5},
+		{`{"foo": 5}.bar`, nil},
+		{`let config = {"db": {"port": 5}}; config.db.port`, 5},
+		{`let f = fn(r) { r.x * 2 }; f({"x": 3})`, 6},
 	}
 
 	for _, tt := range tests {
@@ -543,6 +1561,15 @@ func testEval(input string) object.Object {
 	return Eval(program, env)
 }
 
+// testEvalHooked is a helper function that evaluates input observed by hooks
+func testEvalHooked(input string, hooks *Hooks) object.Object {
+	program := parser.New(lexer.New(input)).ParseProgram()
+	env := object.NewEnvironment()
+	env.SetContext(WithHooks(nil, hooks))
+
+	return Eval(program, env)
+}
+
 // func testTensorObject is a helper function that takes in a testing object, an object, and a tensor.
 // It tests where the object is a tensor and whether the tensor is equal to the expected tensor
 func testTensorObject(t *testing.T, obj object.Object, expected object.Tensor) bool {
@@ -0,0 +1,96 @@
+// evaluator/hooks.go
+
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// StatementEvent describes a statement that is about to be evaluated
+type StatementEvent struct {
+	Statement ast.Statement
+	Line      int
+	File      string              // File is the path of the imported file holding the statement, empty for the main program
+	Env       *object.Environment // Env is the environment the statement is evaluated in
+	Depth     int                 // Depth is the number of function calls in progress, 0 at the top level
+}
+
+// CallEvent describes a call of a Monkey function
+type CallEvent struct {
+	Function *object.Function
+	Name     string // Name is the name the function was defined with, empty for anonymous functions
+	Line     int    // Line is the line the body of the function starts on
+	File     string // File is the path of the imported file defining the function, empty for the main program
+	Depth    int    // Depth is the number of function calls in progress, including this one
+}
+
+// Hooks lets callers observe the evaluator. Nil hooks are skipped.
+type Hooks struct {
+	OnStatement     func(ev StatementEvent)
+	OnStatementDone func(ev StatementEvent) // OnStatementDone is called once the statement of an OnStatement event is evaluated
+	OnCall          func(ev CallEvent)
+	OnReturn        func(ev CallEvent) // OnReturn is called once the call of an OnCall event returns
+}
+
+// WithHooks returns a copy of ctx, which may be nil, in which programs are
+// evaluated observed by hooks, or by none when hooks is nil. Each context
+// has its hooks, so runs observed at once do not see each other.
+func WithHooks(ctx *object.Context, hooks *Hooks) *object.Context {
+	withHooks := object.Context{Engine: Engine}
+	if ctx != nil {
+		withHooks = *ctx
+	}
+	withHooks.Hooks = hooks
+	return &withHooks
+}
+
+// hooksOf is a helper function that returns the hooks installed in ctx, nil
+// when there are none
+func hooksOf(ctx *object.Context) *Hooks {
+	if ctx == nil {
+		return nil
+	}
+	h, _ := ctx.Hooks.(*Hooks)
+	return h
+}
+
+// onStatement is a helper function that calls the statement hook, if any
+func onStatement(stmt ast.Statement, env *object.Environment) {
+	ctx := env.Context()
+	if h := hooksOf(ctx); h != nil && h.OnStatement != nil {
+		h.OnStatement(StatementEvent{Statement: stmt, Line: ast.LineOf(stmt), File: env.File(), Env: env, Depth: ctx.Depth})
+	}
+}
+
+// onStatementDone is a helper function that calls the statement done hook,
+// if any
+func onStatementDone(stmt ast.Statement, env *object.Environment) {
+	ctx := env.Context()
+	if h := hooksOf(ctx); h != nil && h.OnStatementDone != nil {
+		h.OnStatementDone(StatementEvent{Statement: stmt, Line: ast.LineOf(stmt), File: env.File(), Env: env, Depth: ctx.Depth})
+	}
+}
+
+// enterFunction is a helper function that counts a call of fn in progress
+// in ctx and calls the call hook, if any
+func enterFunction(ctx *object.Context, fn *object.Function) {
+	ctx.Depth++
+	if h := hooksOf(ctx); h != nil && h.OnCall != nil {
+		h.OnCall(callEvent(fn, ctx.Depth))
+	}
+}
+
+// leaveFunction is a helper function that calls the return hook, if any,
+// and counts the call of fn in ctx as done
+func leaveFunction(ctx *object.Context, fn *object.Function) {
+	ctx.Depth--
+	if h := hooksOf(ctx); h != nil && h.OnReturn != nil {
+		h.OnReturn(callEvent(fn, ctx.Depth+1))
+	}
+}
+
+// callEvent is a helper function that returns the event of a call of fn
+func callEvent(fn *object.Function, depth int) CallEvent {
+	return CallEvent{Function: fn, Name: fn.Name, Line: ast.LineOf(fn.Body), File: fn.Env.File(), Depth: depth}
+}
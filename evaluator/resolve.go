@@ -0,0 +1,348 @@
+// evaluator/resolve.go
+
+package evaluator
+
+import "monkey/ast"
+
+// Programs are resolved before they are evaluated: the variables bound in
+// each function, its parameters, the names of its let statements and the
+// variables of its for loops and catch clauses, get slots in the
+// environments of its calls, and the identifiers naming them get the slot to
+// read instead of looking the name up in every environment out to the one
+// binding it. Variables bound outside of functions, in the environment of
+// the program, are still looked up by name.
+//
+// A slot is empty until its let statement runs, and reading an empty slot
+// falls back to looking the name up, so that a variable read before it is
+// bound is found further out, as when every variable was looked up by name.
+// Functions that may bind names unknown until they run, by importing a
+// module, keep looking up by name the names that may be bound there.
+//
+// Closures made by the virtual machine hold copies of the variables they
+// capture, so the assignments to a variable some nested function reads are
+// marked, and fail when they run, for both engines to give the same values.
+
+// scope is a function being resolved
+type scope struct {
+	name     string // name is the name of the function, bound inside it
+	locals   []string
+	index    map[string]int
+	dynamic  bool                   // dynamic is set for functions binding names at run time
+	captured map[int]bool           // captured holds the slots nested functions read
+	assigns  []*ast.AssignStatement // assigns holds the assignments to the slots
+	outer    *scope
+}
+
+// Resolve resolves the functions of a program, unless they were resolved
+// before. Eval resolves the programs it is given; a program resolved first
+// can then be evaluated by several goroutines at once, which only read it.
+func Resolve(program *ast.Program) {
+	if program.Resolved {
+		return
+	}
+	for _, statement := range program.Statements {
+		resolveNode(statement, nil)
+	}
+	program.Resolved = true
+}
+
+// resolveNode is a helper function that resolves the identifiers of node,
+// found in the function s, nil outside of functions
+func resolveNode(node ast.Node, s *scope) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		resolveNode(node.Value, s)
+		resolveIdentifier(node.Name, s)
+	case *ast.AssignStatement:
+		resolveNode(node.Value, s)
+		resolveIdentifier(node.Name, s)
+		node.Captured = false
+		if b := node.Name.Binding; b != nil && b.Depth == 0 && b.Index >= 0 {
+			s.assigns = append(s.assigns, node)
+		}
+	case *ast.DestructureStatement:
+		resolveNode(node.Value, s)
+		for _, name := range node.Names {
+			resolveIdentifier(name, s)
+		}
+	case *ast.ExportStatement:
+		for _, name := range node.Names {
+			resolveIdentifier(name, s)
+		}
+	case *ast.ReturnStatement:
+		resolveNode(node.ReturnValue, s)
+	case *ast.ExpressionStatement:
+		resolveNode(node.Expression, s)
+	case *ast.BlockStatement:
+		for _, statement := range node.Statements {
+			resolveNode(statement, s)
+		}
+	case *ast.WhileStatement:
+		resolveNode(node.Condition, s)
+		resolveNode(node.Body, s)
+	case *ast.ForStatement:
+		resolveNode(node.Iterable, s)
+		resolveIdentifier(node.Variable, s)
+		resolveNode(node.Body, s)
+	case *ast.Identifier:
+		resolveIdentifier(node, s)
+	case *ast.PrefixExpression:
+		resolveNode(node.Right, s)
+	case *ast.InfixExpression:
+		resolveNode(node.Left, s)
+		resolveNode(node.Right, s)
+	case *ast.TryExpression:
+		resolveNode(node.Block, s)
+		resolveIdentifier(node.Variable, s)
+		resolveNode(node.Handler, s)
+	case *ast.IfExpression:
+		resolveNode(node.Condition, s)
+		resolveNode(node.Consequence, s)
+		if node.Alternative != nil {
+			resolveNode(node.Alternative, s)
+		}
+	case *ast.CallExpression:
+		resolveNode(node.Function, s)
+		for _, argument := range node.Arguments {
+			resolveNode(argument, s)
+		}
+	case *ast.MemberCallExpression:
+		resolveNode(node.Receiver, s)
+		for _, argument := range node.Arguments {
+			resolveNode(argument, s)
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			resolveNode(element, s)
+		}
+	case *ast.TupleLiteral:
+		for _, element := range node.Elements {
+			resolveNode(element, s)
+		}
+	case *ast.IndexExpression:
+		resolveNode(node.Left, s)
+		resolveNode(node.Index, s)
+	case *ast.SliceExpression:
+		resolveNode(node.Left, s)
+		resolveNode(node.Low, s)
+		resolveNode(node.High, s)
+	case *ast.MemberExpression:
+		resolveNode(node.Left, s)
+	case *ast.HashLiteral:
+		for key, value := range node.Pairs {
+			resolveNode(key, s)
+			resolveNode(value, s)
+		}
+	case *ast.TensorLiteral:
+		if node.Shape != nil {
+			resolveNode(node.Shape, s)
+		}
+		resolveNode(node.Data, s)
+	case *ast.FunctionLiteral:
+		resolveFunction(node, s)
+	case *ast.ImportLiteral:
+		if node.Alias != nil {
+			resolveIdentifier(node.Alias, s)
+		}
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Boolean, *ast.NullLiteral, *ast.StringLiteral:
+	}
+}
+
+// resolveFunction is a helper function that gives the variables bound in fn
+// their slots and resolves its body
+func resolveFunction(fn *ast.FunctionLiteral, outer *scope) {
+	s := &scope{name: fn.Name, index: map[string]int{}, captured: map[int]bool{}, outer: outer}
+	for _, param := range fn.Parameters {
+		s.declare(param.Value)
+	}
+	declare(fn.Body, s)
+
+	for _, param := range fn.Parameters {
+		resolveIdentifier(param, s)
+	}
+	resolveNode(fn.Body, s)
+	fn.Locals = s.locals
+	for _, assign := range s.assigns {
+		assign.Captured = s.captured[assign.Name.Binding.Index]
+	}
+
+	markTail(lastExpression(fn.Body))
+	markReturns(fn.Body)
+}
+
+// markReturns is a helper function that marks the calls returned by the
+// return statements found in node as tail calls. Those in try blocks are
+// left alone, as the block would not catch their errors once the function
+// returned.
+func markReturns(node ast.Node) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FunctionLiteral:
+			// Nested functions mark their own
+			return false
+		case *ast.TryExpression:
+			markReturns(node.Handler)
+			return false
+		case *ast.ReturnStatement:
+			markTail(node.ReturnValue)
+		}
+		return true
+	})
+}
+
+// markTail is a helper function that marks exp as a tail call, or the calls
+// giving its value when it is an if expression
+func markTail(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		exp.Tail = true
+	case *ast.IfExpression:
+		markTail(lastExpression(exp.Consequence))
+		if exp.Alternative != nil {
+			markTail(lastExpression(exp.Alternative))
+		}
+	}
+}
+
+// lastExpression is a helper function that returns the expression of the
+// last statement of block, which gives the value of the block, or nil when
+// it is not an expression statement
+func lastExpression(block *ast.BlockStatement) ast.Expression {
+	if block == nil || len(block.Statements) == 0 {
+		return nil
+	}
+	if es, ok := block.Statements[len(block.Statements)-1].(*ast.ExpressionStatement); ok {
+		return es.Expression
+	}
+	return nil
+}
+
+// declare is a helper function that declares the names bound by the let
+// statements of the function s found in node, and notes whether it imports
+func declare(node ast.Node, s *scope) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		s.declare(node.Name.Value)
+		declare(node.Value, s)
+	case *ast.AssignStatement:
+		// Assignments set variables bound elsewhere
+		declare(node.Value, s)
+	case *ast.DestructureStatement:
+		for _, name := range node.Names {
+			s.declare(name.Value)
+		}
+		declare(node.Value, s)
+	case *ast.ReturnStatement:
+		declare(node.ReturnValue, s)
+	case *ast.ExpressionStatement:
+		declare(node.Expression, s)
+	case *ast.BlockStatement:
+		for _, statement := range node.Statements {
+			declare(statement, s)
+		}
+	case *ast.WhileStatement:
+		declare(node.Condition, s)
+		declare(node.Body, s)
+	case *ast.ForStatement:
+		s.declare(node.Variable.Value)
+		declare(node.Iterable, s)
+		declare(node.Body, s)
+	case *ast.PrefixExpression:
+		declare(node.Right, s)
+	case *ast.InfixExpression:
+		declare(node.Left, s)
+		declare(node.Right, s)
+	case *ast.TryExpression:
+		s.declare(node.Variable.Value)
+		declare(node.Block, s)
+		declare(node.Handler, s)
+	case *ast.IfExpression:
+		declare(node.Condition, s)
+		declare(node.Consequence, s)
+		if node.Alternative != nil {
+			declare(node.Alternative, s)
+		}
+	case *ast.CallExpression:
+		declare(node.Function, s)
+		for _, argument := range node.Arguments {
+			declare(argument, s)
+		}
+	case *ast.MemberCallExpression:
+		declare(node.Receiver, s)
+		for _, argument := range node.Arguments {
+			declare(argument, s)
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			declare(element, s)
+		}
+	case *ast.TupleLiteral:
+		for _, element := range node.Elements {
+			declare(element, s)
+		}
+	case *ast.IndexExpression:
+		declare(node.Left, s)
+		declare(node.Index, s)
+	case *ast.SliceExpression:
+		declare(node.Left, s)
+		declare(node.Low, s)
+		declare(node.High, s)
+	case *ast.MemberExpression:
+		declare(node.Left, s)
+	case *ast.HashLiteral:
+		for key, value := range node.Pairs {
+			declare(key, s)
+			declare(value, s)
+		}
+	case *ast.TensorLiteral:
+		if node.Shape != nil {
+			declare(node.Shape, s)
+		}
+		declare(node.Data, s)
+	case *ast.ImportLiteral:
+		// Only imports with an alias bind a name known before they run
+		if node.Alias != nil {
+			s.declare(node.Alias.Value)
+		} else {
+			s.dynamic = true
+		}
+	case *ast.FunctionLiteral, *ast.Identifier, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Boolean, *ast.NullLiteral, *ast.StringLiteral:
+		// The lets of nested functions bind their own variables
+	default:
+		// Nodes unknown here may bind names, which are then looked up
+		s.dynamic = true
+	}
+}
+
+// declare gives name a slot, unless it has one
+func (s *scope) declare(name string) {
+	if _, ok := s.index[name]; !ok {
+		s.index[name] = len(s.locals)
+		s.locals = append(s.locals, name)
+	}
+}
+
+// resolveIdentifier is a helper function that sets the binding of ident,
+// found in the function s. Identifiers that functions binding names at run
+// time may shadow are left to be looked up by name. The slots of enclosing
+// functions found are noted as captured, unless the identifier names the
+// function holding it, which the virtual machine binds inside the function.
+func resolveIdentifier(ident *ast.Identifier, s *scope) {
+	ident.Binding = nil
+	var inner *scope
+	for depth := 0; s != nil; depth, inner, s = depth+1, s, s.outer {
+		if s.dynamic {
+			return
+		}
+		if index, ok := s.index[ident.Value]; ok {
+			ident.Binding = &ast.Binding{Depth: depth, Index: index}
+			if inner != nil && inner.name != ident.Value {
+				s.captured[index] = true
+			}
+			return
+		}
+		if s.outer == nil {
+			ident.Binding = &ast.Binding{Depth: depth + 1, Index: -1}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvedVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// A variable read before its let is found further out
+		{`let x = 1; let f = fn() { let y = x; let x = 2; y * 10 + x }; f()`, 12},
+		{`let x = 1; let f = fn() { let x = x + 1; x }; f() + f()`, 4},
+		{`let f = fn(a, a) { a }; f(1, 2)`, 2},
+		{`let adder = fn(n) { fn(m) { let s = n + m; fn() { s * 10 } } }; adder(2)(3)()`, 50},
+		{`let f = fn() { let g = fn(k) { if (k > 0) { g(k - 1) + k } else { 0 } }; g(4) }; f()`, 10},
+		{`let n = 5; let f = fn(len) { len + n }; f(1)`, 6},
+		{`let f = fn() { len("abc") }; f()`, 3},
+		{`let f = fn(x) { if (x > 0) { let y = x * 2; y } else { let y = 0; y } }; f(4) + f(-1)`, 8},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestResolveBindings(t *testing.T) {
+	program := parser.New(lexer.New(`let g = 1; fn(a) { let b = a; fn(c) { a + b + c + g } }`)).ParseProgram()
+	Resolve(program)
+
+	outer := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(outer.Locals) != 2 || outer.Locals[0] != "a" || outer.Locals[1] != "b" {
+		t.Fatalf("wrong locals of the outer function. got=%v", outer.Locals)
+	}
+	inner := outer.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+
+	sum := inner.Body.Statements[0].(*ast.ExpressionStatement).Expression
+	var identifiers []*ast.Identifier
+	for {
+		infix, ok := sum.(*ast.InfixExpression)
+		if !ok {
+			identifiers = append([]*ast.Identifier{sum.(*ast.Identifier)}, identifiers...)
+			break
+		}
+		identifiers = append([]*ast.Identifier{infix.Right.(*ast.Identifier)}, identifiers...)
+		sum = infix.Left
+	}
+
+	expected := []ast.Binding{{Depth: 1, Index: 0}, {Depth: 1, Index: 1}, {Depth: 0, Index: 0}, {Depth: 2, Index: -1}}
+	for i, ident := range identifiers {
+		if ident.Binding == nil || *ident.Binding != expected[i] {
+			t.Errorf("wrong binding of %s. want=%v, got=%v", ident.Value, expected[i], ident.Binding)
+		}
+	}
+}
+
+func TestImportInFunction(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.mky")
+	if err := os.WriteFile(lib, []byte(`let x = 2;`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The imported x shadows the global one, which is then not read from a slot
+	input := `let x = 1; let f = fn() { import "` + lib + `"; x }; f()`
+	testIntegerObject(t, testEval(input), 2)
+}
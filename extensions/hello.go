@@ -5,19 +5,31 @@ import (
 	"monkey/object"
 )
 
+// Version is the version of the plugin, listed by plugins()
+var Version = "1.0.0"
+
 func Hello(args ...object.Object) object.Object {
 	value := "Hello, World!"
-	if len(args) > 2 || len(args) < 1 {
-		return &object.String{Value: "not enough arguments"}
+	if len(args) == 1 {
+		value = "Hello, " + args[0].(*object.String).Value + "!"
 	}
 
 	return &object.String{Value: value}
 }
 
-// Register the Hello function as an object.Extended
-// This allows the function to be called from the Monkey interpreter
-func Register() {
-	object.RegisterFunction("hello", object.Extended{Fn: Hello})
+// Register returns the functions of the plugin with their parameters and
+// documentation. They become builtins, callable from both engines and
+// documented like the others.
+func Register() []object.Plugin {
+	return []object.Plugin{
+		{
+			Name:     "hello",
+			Params:   []object.Param{{Name: "name", Type: object.STRING_OBJ}},
+			Variadic: true,
+			Doc:      "Returns a greeting for name, or for the world when it is omitted.",
+			Fn:       Hello,
+		},
+	}
 }
 
 // Build the plugin
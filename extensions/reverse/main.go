@@ -0,0 +1,36 @@
+// reverse/main.go
+
+//go:build wasip1
+
+// Reverse is an example of an extension compiled to WebAssembly. Unlike
+// plugins it loads on every platform and runs sandboxed.
+package main
+
+import (
+	"monkey/object"
+	"monkey/wasmext/guest"
+)
+
+func Reverse(args ...object.Object) object.Object {
+	runes := []rune(args[0].(*object.String).Value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return &object.String{Value: string(runes)}
+}
+
+func init() {
+	guest.Register([]object.Plugin{
+		{
+			Name:   "reverse",
+			Params: []object.Param{{Name: "text", Type: object.STRING_OBJ}},
+			Doc:    "Returns text with its characters in reverse order.",
+			Fn:     Reverse,
+		},
+	})
+}
+
+func main() {}
+
+// Build the extension
+// GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o extensions/reverse.wasm ./extensions/reverse
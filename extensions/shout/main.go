@@ -0,0 +1,35 @@
+// shout/main.go
+
+// Shout is an example of an extension run as a process. Extensions written
+// in Go answer the interpreter with exthost.Serve, those written in other
+// languages implement the protocol described in package exthost.
+package main
+
+import (
+	"log"
+	"monkey/exthost"
+	"monkey/object"
+	"os"
+	"strings"
+)
+
+func Shout(args ...object.Object) object.Object {
+	return &object.String{Value: strings.ToUpper(args[0].(*object.String).Value) + "!"}
+}
+
+func main() {
+	fns := []object.Plugin{
+		{
+			Name:   "shout",
+			Params: []object.Param{{Name: "text", Type: object.STRING_OBJ}},
+			Doc:    "Returns text in upper case followed by an exclamation mark.",
+			Fn:     Shout,
+		},
+	}
+	if err := exthost.Serve(os.Stdin, os.Stdout, fns); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Build the extension
+// go build -o extensions/shout.ext ./extensions/shout
@@ -0,0 +1,298 @@
+// exthost/exthost.go
+
+// Package exthost runs extensions as child processes. Unlike plugins, they
+// can be written in any language and cannot crash the interpreter.
+//
+// The interpreter and the extension exchange messages over the standard
+// input and output of the extension. Each message is a msgpack map preceded
+// by its length as a 4-byte big-endian integer. The interpreter first sends
+// a FunctionCall of type "describe", answered by a FunctionResponse whose
+// result lists the functions of the extension as FunctionSpec maps. Calls
+// are FunctionCalls of type "call", answered by a FunctionResponse of type
+// "result" holding the value returned or an error message.
+//
+// Integers, floats, strings, booleans, null, arrays and hashes are passed as
+// the matching msgpack values, tensors as arrays of rows of floats. Values
+// are converted with object.ToGo and object.FromGo. Functions are passed as
+// an object.Callback, which the extension calls back while it handles the
+// call with callback messages.
+package exthost
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/object"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// maxMessageSize bounds the messages read, so a broken extension cannot make
+// the interpreter allocate without limit
+const maxMessageSize = 64 << 20
+
+// Process is an extension running as a child process
+type Process struct {
+	name string
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	out  io.ReadCloser
+
+	mu  sync.Mutex // mu serializes the calls, the extension answers them in order
+	err error      // err is set once the process can no longer be called
+
+	// callingBack is set while a function the extension asked for is
+	// called back, the extension waiting for its result cannot be called
+	callingBack atomic.Bool
+}
+
+// Start starts the extension at path. It inherits the environment only when
+// the env capability is granted.
+func Start(path string, args ...string) (*Process, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = os.Stderr
+	if !object.Granted(object.CapEnv) {
+		cmd.Env = []string{}
+	}
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &Process{name: filepath.Base(path), cmd: cmd, in: in, out: out}, nil
+}
+
+// Client sends messages to an extension and returns its answers. Requests
+// to call back a function the extension makes before it answers are
+// answered by callback.
+type Client interface {
+	RoundTrip(call object.FunctionCall, callback Callback) (object.FunctionResponse, error)
+}
+
+// Callback answers a request of an extension to call back a function
+type Callback func(req object.FunctionResponse) object.FunctionCall
+
+// Functions asks the extension for its functions and returns them as
+// plugin functions, which call the extension
+func (p *Process) Functions() ([]object.Plugin, error) {
+	return Functions(p.name, p)
+}
+
+// Functions asks the extension named name for its functions through c and
+// returns them as plugin functions, which call the extension through c
+func Functions(name string, c Client) ([]object.Plugin, error) {
+	resp, err := c.RoundTrip(object.FunctionCall{Type: "describe"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("extension %s: %s", name, *resp.Error)
+	}
+
+	// The result was decoded without knowing its type, it is decoded again
+	// as the list of specs it holds
+	data, err := msgpack.Marshal(resp.Result)
+	if err != nil {
+		return nil, err
+	}
+	var specs []object.FunctionSpec
+	if err := msgpack.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("extension %s: invalid description: %s", name, err)
+	}
+
+	fns := make([]object.Plugin, len(specs))
+	for i, spec := range specs {
+		params := make([]object.Param, len(spec.Params))
+		for j, param := range spec.Params {
+			params[j] = object.Param{Name: param.Name, Type: object.ObjectType(param.Type)}
+		}
+		needs := make([]object.Capability, len(spec.Needs))
+		for j, c := range spec.Needs {
+			needs[j] = object.Capability(c)
+		}
+		fns[i] = object.Plugin{Name: spec.Name, Params: params, Variadic: spec.Variadic, Doc: spec.Doc, Needs: needs, Version: spec.Version, Fn: caller(c, spec.Name)}
+	}
+	return fns, nil
+}
+
+// caller is a helper function that returns the implementation of the
+// function name of the extension c sends messages to
+func caller(c Client, name string) object.BuiltInFunction {
+	return func(args ...object.Object) object.Object {
+		values := newCodec(true, nil)
+		encoded := make([]interface{}, len(args))
+		for i, arg := range args {
+			value, err := values.encodeValue(arg)
+			if err != nil {
+				return &object.Error{Message: fmt.Sprintf("argument %d to `%s`: %s", i+1, name, err)}
+			}
+			encoded[i] = value
+		}
+
+		call := object.FunctionCall{Type: "call", Name: name, Args: encoded}
+		resp, err := c.RoundTrip(call, func(req object.FunctionResponse) object.FunctionCall {
+			return callBack(values, req)
+		})
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		if resp.Error != nil {
+			return &object.Error{Message: *resp.Error}
+		}
+
+		result, err := values.decodeValue(resp.Result)
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("result of `%s`: %s", name, err)}
+		}
+		return result
+	}
+}
+
+// callBack is a helper function that calls back the function an extension
+// asks for, the first element of the result of req followed by its
+// arguments, and returns the answer
+func callBack(values *codec, req object.FunctionResponse) object.FunctionCall {
+	items, ok := req.Result.([]interface{})
+	if !ok || len(items) == 0 {
+		return resultError("invalid callback request")
+	}
+	fn, err := values.decodeValue(items[0])
+	if err != nil {
+		return resultError(err.Error())
+	}
+
+	args := make([]object.Object, len(items)-1)
+	for i, item := range items[1:] {
+		if args[i], err = values.decodeValue(item); err != nil {
+			return resultError(err.Error())
+		}
+	}
+
+	result := object.Call(fn, args...)
+	if errObj, ok := result.(*object.Error); ok {
+		return resultError(errObj.Message)
+	}
+	value, err := values.encodeValue(result)
+	if err != nil {
+		return resultError(err.Error())
+	}
+	return object.FunctionCall{Type: "result", Args: []interface{}{value}}
+}
+
+// resultError is a helper function that returns an answer failing a callback
+func resultError(message string) object.FunctionCall {
+	return object.FunctionCall{Type: "result", Error: &message}
+}
+
+// RoundTrip sends a message to the extension and reads its answer. An
+// extension that fails to answer is stopped and fails every later call. The
+// extension cannot be called while it calls back a function.
+func (p *Process) RoundTrip(call object.FunctionCall, callback Callback) (object.FunctionResponse, error) {
+	if p.callingBack.Load() {
+		return object.FunctionResponse{}, fmt.Errorf("extension %s is waiting for a callback", p.name)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return object.FunctionResponse{}, p.err
+	}
+
+	resp, err := p.exchange(call, callback)
+	if err != nil {
+		p.err = fmt.Errorf("extension %s stopped: %s", p.name, err)
+		p.in.Close()
+		_ = p.cmd.Process.Kill()
+		_ = p.cmd.Wait()
+		return object.FunctionResponse{}, p.err
+	}
+	return resp, nil
+}
+
+// exchange is a helper function that writes call and reads the response,
+// answering the callback requests read first
+func (p *Process) exchange(call object.FunctionCall, callback Callback) (object.FunctionResponse, error) {
+	for {
+		data, err := object.SerializeFunctionCall(call)
+		if err != nil {
+			return object.FunctionResponse{}, err
+		}
+		if err := WriteMessage(p.in, data); err != nil {
+			return object.FunctionResponse{}, err
+		}
+
+		data, err = ReadMessage(p.out)
+		if err != nil {
+			return object.FunctionResponse{}, err
+		}
+		resp, err := object.DeserializeFunctionResponse(data)
+		if err != nil || resp.Type != "callback" {
+			return resp, err
+		}
+		if callback == nil {
+			return object.FunctionResponse{}, errors.New("unexpected callback request")
+		}
+		p.callingBack.Store(true)
+		call = callback(resp)
+		p.callingBack.Store(false)
+	}
+}
+
+// Close stops the extension, letting it exit once its input ends
+func (p *Process) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return nil
+	}
+	p.err = fmt.Errorf("extension %s was closed", p.name)
+	p.in.Close()
+	return p.cmd.Wait()
+}
+
+// WriteMessage writes a message of the protocol, preceded by its length
+func WriteMessage(w io.Writer, data []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// ReadMessage reads a message written by WriteMessage
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxMessageSize {
+		return nil, errors.New("message too large")
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
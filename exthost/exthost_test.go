@@ -0,0 +1,155 @@
+package exthost
+
+import (
+	"monkey/object"
+	"os"
+	"strings"
+	"testing"
+)
+
+// extensionEnv makes the test binary run as the extension of the tests
+const extensionEnv = "MONKEY_TEST_EXTENSION"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(extensionEnv) {
+	case "serve":
+		err := Serve(os.Stdin, os.Stdout, []object.Plugin{
+			{Name: "shout", Params: []object.Param{{Name: "s", Type: object.STRING_OBJ}}, Doc: "Shouts s.", Fn: func(args ...object.Object) object.Object {
+				return &object.String{Value: strings.ToUpper(args[0].(*object.String).Value) + "!"}
+			}},
+			{Name: "echo", Params: []object.Param{{Name: "value"}}, Fn: func(args ...object.Object) object.Object {
+				return args[0]
+			}},
+			{Name: "apply", Params: []object.Param{{Name: "fn"}, {Name: "args"}}, Variadic: true, Fn: func(args ...object.Object) object.Object {
+				return object.Call(args[0], args[1:]...)
+			}},
+			{Name: "fail", Needs: []object.Capability{object.CapEnv}, Fn: func(args ...object.Object) object.Object {
+				return &object.Error{Message: "failed on purpose"}
+			}},
+			{Name: "crash", Fn: func(args ...object.Object) object.Object {
+				os.Exit(3)
+				return nil
+			}},
+		})
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// start is a helper function that starts the test binary as an extension
+func start(t *testing.T) (*Process, map[string]object.Plugin) {
+	t.Helper()
+
+	t.Setenv(extensionEnv, "serve")
+	p, err := Start(os.Args[0])
+	if err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+
+	fns, err := p.Functions()
+	if err != nil {
+		t.Fatalf("Functions failed: %s", err)
+	}
+	builtins := map[string]object.Plugin{}
+	for _, fn := range fns {
+		builtins[fn.Name] = fn
+	}
+	return p, builtins
+}
+
+func TestProcess(t *testing.T) {
+	p, builtins := start(t)
+	defer p.Close()
+
+	if len(builtins) != 5 || builtins["shout"].Usage() != "shout(s)" || builtins["shout"].Doc != "Shouts s." {
+		t.Fatalf("wrong functions. got=%v", builtins)
+	}
+
+	if result, ok := builtins["shout"].Fn(&object.String{Value: "hi"}).(*object.String); !ok || result.Value != "HI!" {
+		t.Errorf("wrong result of shout. got=%v", result)
+	}
+
+	pairs := map[object.HashKey]object.HashPair{}
+	key := &object.String{Value: "a"}
+	pairs[key.HashKey()] = object.HashPair{Key: key, Value: &object.Float{Value: 1.5}}
+	value := &object.Array{Elements: []object.Object{
+		&object.Integer{Value: 7},
+		&object.Boolean{Value: true},
+		&object.Null{},
+		&object.Hash{Pairs: pairs},
+	}}
+	result := builtins["echo"].Fn(value)
+	if result.Inspect() != value.Inspect() {
+		t.Errorf("value changed by the round trip. want=%s, got=%s", value.Inspect(), result.Inspect())
+	}
+
+	if needs := builtins["fail"].Needs; len(needs) != 1 || needs[0] != object.CapEnv {
+		t.Errorf("wrong capabilities. got=%v", needs)
+	}
+	if errObj, ok := builtins["fail"].Fn().(*object.Error); !ok || errObj.Message != "failed on purpose" {
+		t.Errorf("wrong error. got=%v", errObj)
+	}
+	tensor := &object.Tensor{Shape: []int64{2, 2}, Data: []float64{1, 2, 3, 4}}
+	if result := builtins["echo"].Fn(tensor); result.Inspect() != "[[1.000000, 2.000000], [3.000000, 4.000000]]" {
+		t.Errorf("tensor not passed as its rows. got=%s", result.Inspect())
+	}
+	if errObj, ok := builtins["echo"].Fn(&object.Error{}).(*object.Error); !ok || !strings.Contains(errObj.Message, "cannot pass ERROR") {
+		t.Errorf("wrong error for an error. got=%v", errObj)
+	}
+}
+
+func TestProcessCallbacks(t *testing.T) {
+	p, builtins := start(t)
+	defer p.Close()
+
+	sum := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		total := int64(0)
+		for _, arg := range args {
+			total += arg.(*object.Integer).Value
+		}
+		return &object.Integer{Value: total}
+	}}
+	result := builtins["apply"].Fn(sum, &object.Integer{Value: 2}, &object.Integer{Value: 3})
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 5 {
+		t.Errorf("wrong result of the callback. got=%v", result)
+	}
+
+	if result := builtins["echo"].Fn(sum); result != sum {
+		t.Errorf("function not passed back as itself. got=%v", result)
+	}
+
+	fail := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.Error{Message: "callback failed"}
+	}}
+	if errObj, ok := builtins["apply"].Fn(fail).(*object.Error); !ok || errObj.Message != "callback failed" {
+		t.Errorf("wrong error of the callback. got=%v", errObj)
+	}
+
+	nested := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return builtins["shout"].Fn(args...)
+	}}
+	if errObj, ok := builtins["apply"].Fn(nested, &object.String{Value: "hi"}).(*object.Error); !ok || !strings.Contains(errObj.Message, "waiting for a callback") {
+		t.Errorf("wrong error of a nested call. got=%v", errObj)
+	}
+	if result := builtins["shout"].Fn(&object.String{Value: "hi"}); result.Inspect() != "HI!" {
+		t.Errorf("extension not callable after a nested call. got=%s", result.Inspect())
+	}
+}
+
+func TestProcessCrash(t *testing.T) {
+	p, builtins := start(t)
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		errObj, ok := builtins["crash"].Fn().(*object.Error)
+		if !ok || !strings.HasPrefix(errObj.Message, "extension ") || !strings.Contains(errObj.Message, " stopped: ") {
+			t.Errorf("wrong error after a crash. got=%v", errObj)
+		}
+	}
+	if errObj, ok := builtins["shout"].Fn(&object.String{Value: "hi"}).(*object.Error); !ok {
+		t.Errorf("crashed extension still called. got=%v", errObj)
+	}
+}
@@ -0,0 +1,172 @@
+// exthost/serve.go
+
+package exthost
+
+import (
+	"fmt"
+	"io"
+	"monkey/object"
+)
+
+// Handler answers the messages of the protocol with the functions of an
+// extension written in Go
+type Handler struct {
+	fns   map[string]object.Plugin
+	specs []object.FunctionSpec
+}
+
+// NewHandler returns a handler for the functions fns
+func NewHandler(fns []object.Plugin) *Handler {
+	h := &Handler{fns: map[string]object.Plugin{}, specs: make([]object.FunctionSpec, len(fns))}
+	for i, fn := range fns {
+		h.fns[fn.Name] = fn
+		params := make([]object.ParamSpec, len(fn.Params))
+		for j, param := range fn.Params {
+			params[j] = object.ParamSpec{Name: param.Name, Type: string(param.Type)}
+		}
+		needs := make([]string, len(fn.Needs))
+		for j, c := range fn.Needs {
+			needs[j] = string(c)
+		}
+		h.specs[i] = object.FunctionSpec{Name: fn.Name, Params: params, Variadic: fn.Variadic, Doc: fn.Doc, Needs: needs, Version: fn.Version}
+	}
+	return h
+}
+
+// Exchange sends a callback request to the interpreter and returns its answer
+type Exchange func(req object.FunctionResponse) (object.FunctionCall, error)
+
+// Handle answers a serialized FunctionCall with a serialized FunctionResponse.
+// Functions passed to the call are called back through exchange.
+func (h *Handler) Handle(data []byte, exchange Exchange) ([]byte, error) {
+	call, err := object.DeserializeFunctionCall(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp object.FunctionResponse
+	switch call.Type {
+	case "describe":
+		resp = object.FunctionResponse{Type: "describe", Result: h.specs}
+	case "call":
+		resp = h.call(call, exchange)
+	default:
+		resp = errorResponse(fmt.Sprintf("unknown message type %q", call.Type))
+	}
+	return object.SerializeFunctionResponse(resp)
+}
+
+// Serve implements the extension side of the protocol for extensions written
+// in Go, answering the messages read from in with the functions fns until in
+// ends. An extension usually calls it with os.Stdin and os.Stdout.
+func Serve(in io.Reader, out io.Writer, fns []object.Plugin) error {
+	h := NewHandler(fns)
+	exchange := func(req object.FunctionResponse) (object.FunctionCall, error) {
+		data, err := object.SerializeFunctionResponse(req)
+		if err != nil {
+			return object.FunctionCall{}, err
+		}
+		if err := WriteMessage(out, data); err != nil {
+			return object.FunctionCall{}, err
+		}
+		if data, err = ReadMessage(in); err != nil {
+			return object.FunctionCall{}, err
+		}
+		return object.DeserializeFunctionCall(data)
+	}
+
+	for {
+		data, err := ReadMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if data, err = h.Handle(data, exchange); err != nil {
+			return err
+		}
+		if err := WriteMessage(out, data); err != nil {
+			return err
+		}
+	}
+}
+
+// call is a helper function that answers a call to one of the functions
+func (h *Handler) call(call object.FunctionCall, exchange Exchange) object.FunctionResponse {
+	fn, ok := h.fns[call.Name]
+	if !ok {
+		return errorResponse(fmt.Sprintf("unknown function %s", call.Name))
+	}
+
+	returned := false
+	defer func() { returned = true }()
+
+	var values *codec
+	values = newCodec(false, func(id uint32) object.Object {
+		return &object.Builtin{Usage: "callback", Fn: func(args ...object.Object) object.Object {
+			if returned {
+				return &object.Error{Message: "callback called after the call it was passed to returned"}
+			}
+			if exchange == nil {
+				return &object.Error{Message: "callbacks are not supported"}
+			}
+			return callHost(values, exchange, object.Callback{ID: id}, args)
+		}}
+	})
+
+	args := make([]object.Object, len(call.Args))
+	for i, value := range call.Args {
+		arg, err := values.decodeValue(value)
+		if err != nil {
+			return errorResponse(err.Error())
+		}
+		args[i] = arg
+	}
+
+	result := fn.Fn(args...)
+	if errObj, ok := result.(*object.Error); ok {
+		return errorResponse(errObj.Message)
+	}
+	value, err := values.encodeValue(result)
+	if err != nil {
+		return errorResponse(err.Error())
+	}
+	return object.FunctionResponse{Type: "result", Result: value}
+}
+
+// callHost is a helper function that asks the interpreter to call back the
+// function callback stands for with args
+func callHost(values *codec, exchange Exchange, callback object.Callback, args []object.Object) object.Object {
+	items := []interface{}{callback}
+	for _, arg := range args {
+		value, err := values.encodeValue(arg)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		items = append(items, value)
+	}
+
+	reply, err := exchange(object.FunctionResponse{Type: "callback", Result: items})
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	if reply.Error != nil {
+		return &object.Error{Message: *reply.Error}
+	}
+	if len(reply.Args) != 1 {
+		return &object.Error{Message: "invalid answer to a callback"}
+	}
+
+	result, err := values.decodeValue(reply.Args[0])
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+// errorResponse is a helper function that returns a response failing a call
+func errorResponse(message string) object.FunctionResponse {
+	return object.FunctionResponse{Type: "result", Error: &message}
+}
@@ -0,0 +1,122 @@
+// exthost/values.go
+
+package exthost
+
+import (
+	"fmt"
+	"monkey/object"
+)
+
+// codec converts the values of a call to and from msgpack values. Functions
+// are passed as numbered callbacks, which are valid until the call returns.
+type codec struct {
+	ids       map[object.Object]uint32
+	functions map[uint32]object.Object
+
+	// share numbers the functions encoded so that the other side can call
+	// them back. The interpreter shares its functions, extensions only pass
+	// back the callbacks they were given.
+	share bool
+	// remote returns the function standing for a callback numbered by the
+	// other side, nil when callbacks received are not callable
+	remote func(id uint32) object.Object
+}
+
+// newCodec returns a codec sharing the functions it encodes when share is set
+func newCodec(share bool, remote func(id uint32) object.Object) *codec {
+	return &codec{ids: map[object.Object]uint32{}, functions: map[uint32]object.Object{}, share: share, remote: remote}
+}
+
+// encodeValue returns the msgpack value an object is passed to an extension as
+func (c *codec) encodeValue(obj object.Object) (interface{}, error) {
+	return c.encode(object.ToGo(obj))
+}
+
+// encode is a helper function that replaces the objects object.ToGo left in
+// a value by callbacks, or fails for those that are not functions
+func (c *codec) encode(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case object.Object:
+		if id, ok := c.ids[value]; ok {
+			return object.Callback{ID: id}, nil
+		}
+		if !c.share || !object.IsCallable(value) {
+			return nil, fmt.Errorf("cannot pass %s to an extension", value.Type())
+		}
+		id := uint32(len(c.functions))
+		c.ids[value], c.functions[id] = id, value
+		return object.Callback{ID: id}, nil
+	case []interface{}:
+		for i, element := range value {
+			encoded, err := c.encode(element)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = encoded
+		}
+	case map[interface{}]interface{}:
+		for key, element := range value {
+			encoded, err := c.encode(element)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = encoded
+		}
+	}
+	return value, nil
+}
+
+// decodeValue returns the object for a msgpack value returned by an extension
+func (c *codec) decodeValue(value interface{}) (object.Object, error) {
+	value, err := c.decode(value)
+	if err != nil {
+		return nil, err
+	}
+	obj := object.FromGo(value)
+	if errObj, ok := obj.(*object.Error); ok {
+		return nil, fmt.Errorf("%s", errObj.Message)
+	}
+	return obj, nil
+}
+
+// decode is a helper function that replaces the callbacks of a value by the
+// functions they stand for
+func (c *codec) decode(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case *object.Callback:
+		if fn, ok := c.functions[value.ID]; ok {
+			return fn, nil
+		}
+		if c.remote == nil {
+			return nil, fmt.Errorf("unknown callback %d", value.ID)
+		}
+		fn := c.remote(value.ID)
+		c.ids[fn], c.functions[value.ID] = value.ID, fn
+		return fn, nil
+	case []interface{}:
+		for i, element := range value {
+			decoded, err := c.decode(element)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = decoded
+		}
+	case map[interface{}]interface{}:
+		for key, element := range value {
+			decoded, err := c.decode(element)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = decoded
+		}
+	case map[string]interface{}:
+		for key, element := range value {
+			decoded, err := c.decode(element)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = decoded
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,498 @@
+// format/format.go
+
+// Package format prints Monkey programs in their canonical layout. Unlike the
+// String methods of the AST, which are meant for debugging, the printer keeps
+// comments, blank lines between statements and the choice of one or several
+// lines for blocks, arrays and hashes.
+package format
+
+import (
+	"monkey/ast"
+	"monkey/diagnostic"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+	"strings"
+)
+
+// indent is the text a nested block is indented with
+const indent = "    "
+
+// Operator precedences, matching the parser
+const (
+	_ int = iota
+	lowest
+	or
+	and
+	equals
+	lessGreater
+	sum
+	product
+	prefix
+	power
+	call
+)
+
+var precedences = map[string]int{
+	"||": or,
+	"&&": and,
+	"==": equals,
+	"!=": equals,
+	"<":  lessGreater,
+	">":  lessGreater,
+	"<=": lessGreater,
+	">=": lessGreater,
+	"+":  sum,
+	"-":  sum,
+	"*":  product,
+	"/":  product,
+	"//": product,
+	"**": power,
+}
+
+// Error is returned when the source does not parse
+type Error struct {
+	Errors []diagnostic.Diagnostic
+}
+
+func (e *Error) Error() string {
+	return strings.Join(diagnostic.Messages(e.Errors), "\n")
+}
+
+// Source formats a Monkey program. The result ends with a single newline.
+func Source(src string) (string, error) {
+	l := lexer.New(src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", &Error{Errors: p.Errors()}
+	}
+
+	pr := &printer{comments: l.Comments()}
+	pr.statements(program.Statements, 0)
+	pr.flushComments(int(^uint(0)>>1), 0)
+
+	out := strings.TrimRight(pr.out.String(), "\n")
+	if out == "" {
+		return "", nil
+	}
+	return out + "\n", nil
+}
+
+// printer writes the canonical form of a program while interleaving the
+// comments taken from the lexer
+type printer struct {
+	out      strings.Builder
+	comments []token.Token // comments not printed yet, in source order
+	line     int           // line is the last source line printed so far
+}
+
+// statements prints a list of statements, one per line at the given depth
+func (p *printer) statements(stmts []ast.Statement, depth int) {
+	for i, stmt := range stmts {
+		start := ast.LineOf(stmt)
+		p.flushComments(start, depth)
+		if i > 0 && start > p.line+1 {
+			p.out.WriteString("\n")
+		}
+
+		var next ast.Statement
+		if i+1 < len(stmts) {
+			next = stmts[i+1]
+		}
+
+		p.out.WriteString(strings.Repeat(indent, depth))
+		p.statement(stmt, depth, needsSemicolon(stmt, next))
+		p.trailingComments(depth)
+		p.out.WriteString("\n")
+	}
+}
+
+// needsSemicolon reports whether an expression statement must end with a
+// semicolon. One ending in a block can go without unless the next statement
+// would otherwise continue it, as in `if (x) { a }; -1`.
+func needsSemicolon(stmt ast.Statement, next ast.Statement) bool {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return true
+	}
+	switch es.Expression.(type) {
+	case *ast.IfExpression, *ast.TryExpression:
+	default:
+		return true
+	}
+
+	nextExpression, ok := next.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+	switch nextExpression.Token.Type {
+	case token.MINUS, token.LPAREN, token.LBRACKET:
+		return true
+	default:
+		return false
+	}
+}
+
+// statement prints a single statement without indentation or newline. The
+// terminating semicolon of expression statements is only printed when
+// semicolon is set.
+func (p *printer) statement(stmt ast.Statement, depth int, semicolon bool) {
+	p.mark(stmt)
+
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		if stmt.Exported {
+			p.out.WriteString("export ")
+		}
+		p.out.WriteString("let " + stmt.Name.Value + " = ")
+		p.expression(stmt.Value, depth, lowest)
+		p.out.WriteString(";")
+
+	case *ast.DestructureStatement:
+		p.out.WriteString("let " + stmt.Pattern() + " = ")
+		p.expression(stmt.Value, depth, lowest)
+		p.out.WriteString(";")
+
+	case *ast.ExportStatement:
+		p.out.WriteString(stmt.String())
+
+	case *ast.AssignStatement:
+		p.out.WriteString(stmt.Name.Value + " = ")
+		p.expression(stmt.Value, depth, lowest)
+		p.out.WriteString(";")
+
+	case *ast.ReturnStatement:
+		p.out.WriteString("return ")
+		p.expression(stmt.ReturnValue, depth, lowest)
+		p.out.WriteString(";")
+
+	case *ast.ExpressionStatement:
+		p.expression(stmt.Expression, depth, lowest)
+		if semicolon {
+			p.out.WriteString(";")
+		}
+
+	case *ast.WhileStatement:
+		p.out.WriteString("while (")
+		p.expression(stmt.Condition, depth, lowest)
+		p.out.WriteString(") ")
+		p.block(stmt.Body, depth)
+
+	case *ast.ForStatement:
+		p.out.WriteString("for (" + stmt.Variable.Value + " in ")
+		p.expression(stmt.Iterable, depth, lowest)
+		p.out.WriteString(") ")
+		p.block(stmt.Body, depth)
+
+	case *ast.BlockStatement:
+		p.block(stmt, depth)
+	}
+}
+
+// expression prints an expression, wrapping it in parentheses when it binds
+// more loosely than its context requires
+func (p *printer) expression(exp ast.Expression, depth int, context int) {
+	p.mark(exp)
+
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		p.out.WriteString(exp.Value)
+
+	case *ast.IntegerLiteral:
+		p.out.WriteString(exp.Token.Literal)
+
+	case *ast.FloatLiteral:
+		p.out.WriteString(exp.Token.Literal)
+
+	case *ast.StringLiteral:
+		p.out.WriteString(`"` + exp.Value + `"`)
+
+	case *ast.Boolean:
+		p.out.WriteString(exp.Token.Literal)
+
+	case *ast.NullLiteral:
+		p.out.WriteString(exp.Token.Literal)
+
+	case *ast.ImportLiteral:
+		p.out.WriteString(`import "` + exp.Path + `"`)
+		if exp.Checksum != "" {
+			p.out.WriteString(" sha256:" + exp.Checksum)
+		}
+		if exp.Alias != nil {
+			p.out.WriteString(" as " + exp.Alias.Value)
+		}
+
+	case *ast.PrefixExpression:
+		p.out.WriteString(exp.Operator)
+		p.operand(exp.Right, depth, prefix, true)
+
+	case *ast.InfixExpression:
+		precedence := precedences[exp.Operator]
+		open := context > precedence
+		if open {
+			p.out.WriteString("(")
+		}
+		if exp.Operator == "**" {
+			// ** is right associative and takes a prefix operator on its
+			// right without parentheses
+			p.operand(exp.Left, depth, precedence+1, true)
+			p.out.WriteString(" ** ")
+			p.operand(exp.Right, depth, precedence-1, false)
+		} else {
+			p.operand(exp.Left, depth, precedence, true)
+			p.out.WriteString(" " + exp.Operator + " ")
+			p.operand(exp.Right, depth, precedence, false)
+		}
+		if open {
+			p.out.WriteString(")")
+		}
+
+	case *ast.CallExpression:
+		p.operand(exp.Function, depth, call, true)
+		p.out.WriteString("(")
+		for i, arg := range exp.Arguments {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.expression(arg, depth, lowest)
+		}
+		p.out.WriteString(")")
+
+	case *ast.MemberCallExpression:
+		p.operand(exp.Receiver, depth, call, true)
+		p.out.WriteString("." + exp.Method + "(")
+		for i, arg := range exp.Arguments {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.expression(arg, depth, lowest)
+		}
+		p.out.WriteString(")")
+
+	case *ast.IndexExpression:
+		p.operand(exp.Left, depth, call, true)
+		p.out.WriteString("[")
+		p.expression(exp.Index, depth, lowest)
+		p.out.WriteString("]")
+
+	case *ast.SliceExpression:
+		p.operand(exp.Left, depth, call, true)
+		p.out.WriteString("[")
+		if exp.Low != nil {
+			p.expression(exp.Low, depth, lowest)
+		}
+		p.out.WriteString(":")
+		if exp.High != nil {
+			p.expression(exp.High, depth, lowest)
+		}
+		p.out.WriteString("]")
+
+	case *ast.MemberExpression:
+		p.operand(exp.Left, depth, call, true)
+		p.out.WriteString("." + exp.Field)
+
+	case *ast.TryExpression:
+		p.out.WriteString("try ")
+		p.block(exp.Block, depth)
+		p.out.WriteString(" catch (" + exp.Variable.Value + ") ")
+		p.block(exp.Handler, depth)
+
+	case *ast.IfExpression:
+		p.out.WriteString("if (")
+		p.expression(exp.Condition, depth, lowest)
+		p.out.WriteString(") ")
+		p.block(exp.Consequence, depth)
+		if exp.Alternative != nil {
+			p.out.WriteString(" else ")
+			p.block(exp.Alternative, depth)
+		}
+
+	case *ast.FunctionLiteral:
+		params := make([]string, len(exp.Parameters))
+		for i, param := range exp.Parameters {
+			params[i] = param.Value
+		}
+		p.out.WriteString("fn(" + strings.Join(params, ", ") + ") ")
+		p.block(exp.Body, depth)
+
+	case *ast.TupleLiteral:
+		for i, el := range exp.Elements {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.expression(el, depth, lowest)
+		}
+
+	case *ast.ArrayLiteral:
+		p.list("[", "]", exp.Token, exp.End, exp.Elements, depth, func(i int) {
+			p.expression(exp.Elements[i], depth+1, lowest)
+		})
+
+	case *ast.HashLiteral:
+		p.list("{", "}", exp.Token, exp.End, exp.Keys, depth, func(i int) {
+			p.expression(exp.Keys[i], depth+1, lowest)
+			p.out.WriteString(": ")
+			p.expression(exp.Pairs[exp.Keys[i]], depth+1, lowest)
+		})
+
+	case *ast.TensorLiteral:
+		p.out.WriteString("@")
+		if exp.Shape == nil {
+			p.expression(exp.Data, depth, lowest)
+			return
+		}
+		p.expression(exp.Shape, depth, lowest)
+		p.out.WriteString(", ")
+		p.expression(exp.Data, depth, lowest)
+	}
+}
+
+// operand prints the operand of an operator with the given precedence. Left
+// operands of equal precedence need no parentheses since operators are left
+// associative.
+func (p *printer) operand(exp ast.Expression, depth int, precedence int, left bool) {
+	switch exp.(type) {
+	case *ast.InfixExpression:
+		if !left {
+			precedence++
+		}
+		p.expression(exp, depth, precedence)
+	case *ast.TensorLiteral:
+		// The data of a tensor literal with a shape would swallow any
+		// operator after it
+		if exp.(*ast.TensorLiteral).Shape != nil && precedence > lowest {
+			p.out.WriteString("(")
+			p.expression(exp, depth, lowest)
+			p.out.WriteString(")")
+			return
+		}
+		p.expression(exp, depth, lowest)
+	case *ast.PrefixExpression:
+		if precedence > prefix {
+			p.out.WriteString("(")
+			p.expression(exp, depth, lowest)
+			p.out.WriteString(")")
+			return
+		}
+		p.expression(exp, depth, lowest)
+	default:
+		p.expression(exp, depth, lowest)
+	}
+}
+
+// block prints a block statement. Blocks that fit on one line in the source
+// and hold at most one statement stay on one line.
+func (p *printer) block(block *ast.BlockStatement, depth int) {
+	inline := block.End.Line == block.Token.Line && len(block.Statements) <= 1
+
+	if len(block.Statements) == 0 && !p.commentsBefore(block.End.Line) {
+		p.out.WriteString("{}")
+		p.markLine(block.End.Line)
+		return
+	}
+
+	if inline {
+		p.out.WriteString("{ ")
+		p.statement(block.Statements[0], depth, false)
+		p.out.WriteString(" }")
+		p.markLine(block.End.Line)
+		return
+	}
+
+	p.out.WriteString("{")
+	p.trailingComments(depth + 1)
+	p.out.WriteString("\n")
+	p.statements(block.Statements, depth+1)
+	p.flushComments(block.End.Line, depth+1)
+	p.out.WriteString(strings.Repeat(indent, depth) + "}")
+	p.markLine(block.End.Line)
+}
+
+// list prints the elements of an array or hash between open and close. A
+// list spread over several lines in the source is printed one element per line.
+func (p *printer) list(open, close string, start, end token.Token, elements []ast.Expression, depth int, element func(i int)) {
+	p.out.WriteString(open)
+
+	if len(elements) == 0 || end.Line == start.Line {
+		for i := range elements {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			element(i)
+		}
+		p.out.WriteString(close)
+		p.markLine(end.Line)
+		return
+	}
+
+	for i := range elements {
+		if i > 0 {
+			p.out.WriteString(",")
+		}
+		p.trailingComments(depth + 1)
+		p.out.WriteString("\n")
+		p.flushComments(ast.LineOf(elements[i]), depth+1)
+		p.out.WriteString(strings.Repeat(indent, depth+1))
+		element(i)
+	}
+	p.trailingComments(depth + 1)
+	p.out.WriteString("\n")
+	p.flushComments(end.Line, depth+1)
+	p.out.WriteString(strings.Repeat(indent, depth) + close)
+	p.markLine(end.Line)
+}
+
+// mark records that the source line of node has been printed
+func (p *printer) mark(node ast.Node) {
+	p.markLine(ast.LineOf(node))
+}
+
+// markLine records that the given source line has been printed
+func (p *printer) markLine(line int) {
+	if line > p.line {
+		p.line = line
+	}
+}
+
+// commentsBefore reports whether a comment precedes the given line
+func (p *printer) commentsBefore(line int) bool {
+	return len(p.comments) > 0 && p.comments[0].Line < line
+}
+
+// flushComments prints the comments found before the given source line, each
+// on a line of its own, keeping a single blank line where the source had any
+func (p *printer) flushComments(line int, depth int) {
+	for p.commentsBefore(line) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		if p.line > 0 && comment.Line > p.line+1 {
+			p.out.WriteString("\n")
+		}
+		p.out.WriteString(strings.Repeat(indent, depth) + comment.Literal + "\n")
+		p.markLine(comment.Line)
+	}
+}
+
+// trailingComments prints the comment ending the last printed source line
+// after the code on that line. Comments left over from earlier lines, such as
+// ones inside an expression, follow on lines of their own.
+func (p *printer) trailingComments(depth int) {
+	var earlier []token.Token
+	for len(p.comments) > 0 && p.comments[0].Line <= p.line {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		if comment.Line == p.line {
+			p.out.WriteString(" " + comment.Literal)
+		} else {
+			earlier = append(earlier, comment)
+		}
+	}
+
+	for _, comment := range earlier {
+		p.out.WriteString("\n" + strings.Repeat(indent, depth) + comment.Literal)
+	}
+}
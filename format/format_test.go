@@ -0,0 +1,155 @@
+package format
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3;", "let x = 1 + 2 * 3;\n"},
+		{"let x = (1 + 2) * 3;", "let x = (1 + 2) * 3;\n"},
+		{"let x = ((1 - 2) - 3);", "let x = 1 - 2 - 3;\n"},
+		{"let x = 1 - (2 - 3);", "let x = 1 - (2 - 3);\n"},
+		{"-(a + b); !-x; (-f)(x)", "-(a + b);\n!-x;\n(-f)(x);\n"},
+		{`puts("hi",  [1,2],{"a":1, "b": 2})`, "puts(\"hi\", [1, 2], {\"a\": 1, \"b\": 2});\n"},
+		{"let add = fn(a,b){a+b};", "let add = fn(a, b) { a + b };\n"},
+		{
+			"let f = fn(x) { let y = x; y };",
+			"let f = fn(x) {\n    let y = x;\n    y;\n};\n",
+		},
+		{
+			"if (x) { 1 } else { 2 }\nputs(x);",
+			"if (x) { 1 } else { 2 }\nputs(x);\n",
+		},
+		{
+			"for(x in [1,2]){puts(x)}",
+			"for (x in [1, 2]) { puts(x) }\n",
+		},
+		{
+			"(-a.b).c[1].d(e)",
+			"(-a.b).c[1].d(e);\n",
+		},
+		{
+			"xs.map(fn(x){x*2}).len()",
+			"xs.map(fn(x) { x * 2 }).len();\n",
+		},
+		{
+			"let [a,b]=pair;let {x}=p",
+			"let [a, b] = pair;\nlet {x} = p;\n",
+		},
+		{
+			"let x=try{f()}catch(e){0};try{g()}catch(err){err}",
+			"let x = try { f() } catch (e) { 0 };\ntry { g() } catch (err) { err }\n",
+		},
+		{
+			"a[1:n+1];a[:2];s[2:]",
+			"a[1:n + 1];\na[:2];\ns[2:];\n",
+		},
+		{
+			"(a**b)**c;a**(b**c);(-a)**-b;-(a**b)",
+			"(a ** b) ** c;\na ** b ** c;\n(-a) ** -b;\n-a ** b;\n",
+		},
+		{
+			"let f=fn(){return a,b+1;};let x,y=f()",
+			"let f = fn() { return a, b + 1; };\nlet x, y = f();\n",
+		},
+		{
+			`import "std/math"as math;math.abs(-1)`,
+			"import \"std/math\" as math;\nmath.abs(-1);\n",
+		},
+		{
+			"while(i<3){i=i+1}",
+			"while (i < 3) { i = i + 1; }\n",
+		},
+		{
+			"(a||b)&&c||(d&&e)",
+			"(a || b) && c || d && e;\n",
+		},
+		{
+			"if (x) { 1 };\n-1;",
+			"if (x) { 1 };\n-1;\n",
+		},
+		{
+			"let a = 1;\n\n\n\nlet b = 2;",
+			"let a = 1;\n\nlet b = 2;\n",
+		},
+		{
+			"# header\nlet a = 1;   # one\n\n# two\nlet b = 2;\n# end",
+			"# header\nlet a = 1; # one\n\n# two\nlet b = 2;\n# end\n",
+		},
+		{
+			"let f = fn() { # start\n  # inside\n  1\n  # last\n};",
+			"let f = fn() { # start\n    # inside\n    1;\n    # last\n};\n",
+		},
+		{
+			"let h = {\n\"a\": 1, # first\n# second\n\"b\": 2\n};",
+			"let h = {\n    \"a\": 1, # first\n    # second\n    \"b\": 2\n};\n",
+		},
+		{"let t = (@[2], [1, 2]) + x;", "let t = (@[2], [1, 2]) + x;\n"},
+		{"let t = @[[1, 2],[3, 4]] + x;", "let t = @[[1, 2], [3, 4]] + x;\n"},
+		{`import "helper.mky";`, "import \"helper.mky\";\n"},
+		{"export   let a=1;", "export let a = 1;\n"},
+		{"let a=1;export a ,b", "let a = 1;\nexport a, b;\n"},
+		{`import "https://example.com/a.mky" sha256:` + strings.Repeat("ab", 32) + `;`, `import "https://example.com/a.mky" sha256:` + strings.Repeat("ab", 32) + ";\n"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		actual, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("Source(%q) failed: %s", tt.input, err)
+			continue
+		}
+		if actual != tt.expected {
+			t.Errorf("Source(%q) wrong.\nexpected=%q\ngot=%q", tt.input, tt.expected, actual)
+		}
+
+		again, err := Source(actual)
+		if err != nil || again != actual {
+			t.Errorf("formatting %q is not idempotent. got=%q (%v)", actual, again, err)
+		}
+	}
+}
+
+func TestSourceKeepsMeaning(t *testing.T) {
+	src, err := os.ReadFile("../helper.mky")
+	if err != nil {
+		t.Fatalf("could not read helper.mky: %s", err)
+	}
+
+	formatted, err := Source(string(src))
+	if err != nil {
+		t.Fatalf("format error: %s", err)
+	}
+
+	if parse(t, formatted) != parse(t, string(src)) {
+		t.Errorf("formatting changed the program:\n%s", formatted)
+	}
+}
+
+func TestSourceParseError(t *testing.T) {
+	_, err := Source("let = 1;")
+	formatErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error. got=%T (%v)", err, err)
+	}
+	if len(formatErr.Errors) == 0 || formatErr.Errors[0].Line != 1 {
+		t.Errorf("wrong errors: %+v", formatErr.Errors)
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program.String()
+}
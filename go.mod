@@ -2,9 +2,13 @@ module monkey
 
 go 1.19
 
+require (
+	github.com/tetratelabs/wazero v1.7.0
+	github.com/vmihailenco/msgpack v4.0.4+incompatible
+)
+
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/vmihailenco/msgpack v4.0.4+incompatible // indirect
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/protobuf v1.26.0 // indirect
 )
@@ -0,0 +1,210 @@
+// imports/imports.go
+
+// Package imports finds the files named by import statements. A path that is
+// absolute or starts with ./ or ../ names a file directly, relative to the
+// directory of the importing file. Any other path is looked up in the current
+// directory first, then in the directory of the script run and then in each
+// directory of the search path, which is read from the MONKEY_PATH
+// environment variable. Each interpreter finds its imports with a Resolver of
+// its own, holding these directories.
+//
+// A path naming a directory imports the package in it, whose entry point is
+// the index.mky file of the directory. The files of a package import each
+// other with paths starting with ./ so they can live anywhere.
+//
+// Paths starting with std/ import the standard library, which is embedded in
+// the interpreter. Its files are named std:<file> and are read with ReadFile.
+//
+// URLs import remote modules, pinned by the sha256 checksum given after them.
+// They are downloaded once to the cache directory of the resolver and must
+// not import relative paths.
+package imports
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"monkey/std"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StdPrefix starts the import paths of the standard library modules
+const StdPrefix = "std/"
+
+// stdScheme starts the names of the standard library files
+const stdScheme = "std:"
+
+// Index is the file imported for a directory
+const Index = "index.mky"
+
+// CompiledExt is the extension of the files holding modules compiled to
+// bytecode, which the VM imports without compiling them again
+const CompiledExt = ".mkyc"
+
+// EnvVar is the environment variable listing the directories searched for imports
+const EnvVar = "MONKEY_PATH"
+
+// Resolver finds the files named by the imports of an interpreter. A nil
+// Resolver finds them like the one NewResolver returns.
+type Resolver struct {
+	SearchPath []string // SearchPath holds the directories searched for imports that are not relative
+	// ScriptDir is the directory of the script run, searched for imports
+	// that are not relative right after the current directory. It is empty
+	// outside of scripts, in the REPL or for programs given on the command
+	// line.
+	ScriptDir string
+	CacheDir  string // CacheDir is the directory remote modules are downloaded to
+}
+
+// NewResolver returns a resolver searching the directories listed in
+// MONKEY_PATH and downloading remote modules under the user's cache
+// directory
+func NewResolver() *Resolver {
+	return &Resolver{SearchPath: filepath.SplitList(os.Getenv(EnvVar)), CacheDir: defaultCacheDir()}
+}
+
+// SetScript makes the directory of the script filename searched for imports
+func (r *Resolver) SetScript(filename string) {
+	r.ScriptDir = filepath.Dir(filename)
+}
+
+// AddSearchPath puts the directories of a list separated like MONKEY_PATH
+// in front of the search path
+func (r *Resolver) AddSearchPath(list string) {
+	r.SearchPath = append(filepath.SplitList(list), r.SearchPath...)
+}
+
+// IsRelative reports whether path is explicitly relative to the current directory
+func IsRelative(path string) bool {
+	return path == "." || path == ".." ||
+		strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") ||
+		strings.HasPrefix(path, "."+string(filepath.Separator)) ||
+		strings.HasPrefix(path, ".."+string(filepath.Separator))
+}
+
+// IsCompiled reports whether filename holds a module compiled to bytecode
+func IsCompiled(filename string) bool {
+	return filepath.Ext(filename) == CompiledExt
+}
+
+// IsStd reports whether filename names a file of the standard library
+func IsStd(filename string) bool {
+	return strings.HasPrefix(filename, stdScheme)
+}
+
+// Resolve returns the name of the file imported by path from a file in dir,
+// an empty dir standing for the current directory of the main program. A
+// remote import is downloaded and checked against checksum. The error wraps
+// os.ErrNotExist when the file is not found.
+func (r *Resolver) Resolve(path, checksum, dir string) (string, error) {
+	if r == nil {
+		r = NewResolver()
+	}
+	if IsRemote(path) {
+		return r.Fetch(path, checksum)
+	}
+	if strings.HasPrefix(path, StdPrefix) {
+		return lookupStd(strings.TrimPrefix(path, StdPrefix), path)
+	}
+	if filepath.IsAbs(path) {
+		return lookup(path)
+	}
+	if IsRelative(path) && dir != "" && filepath.Clean(dir) == filepath.Clean(r.CacheDir) {
+		return "", fmt.Errorf("remote modules cannot import relative paths such as %s", path)
+	}
+	if IsRelative(path) && IsStd(dir) {
+		return lookupStd(pathpkg.Join(strings.TrimPrefix(dir, stdScheme), path), path)
+	}
+	if IsRelative(path) {
+		if dir != "" {
+			path = filepath.Join(dir, path)
+		}
+		return lookup(path)
+	}
+
+	filename, err := lookup(path)
+	for _, dir := range append([]string{r.ScriptDir}, r.SearchPath...) {
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if dir != "" {
+			filename, err = lookup(filepath.Join(dir, path))
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return "", &os.PathError{Op: "import", Path: path, Err: os.ErrNotExist}
+	}
+	return filename, err
+}
+
+// lookupStd is a helper function that returns the standard library file
+// imported by name, with or without its .mky extension
+func lookupStd(name, importPath string) (string, error) {
+	for _, candidate := range []string{name + ".mky", name, pathpkg.Join(name, Index)} {
+		if info, err := fs.Stat(std.FS, candidate); err == nil && !info.IsDir() {
+			return stdScheme + candidate, nil
+		}
+	}
+	return "", &os.PathError{Op: "import", Path: importPath, Err: os.ErrNotExist}
+}
+
+// ReadFile returns the content of a file returned by Resolve
+func ReadFile(filename string) ([]byte, error) {
+	if IsStd(filename) {
+		return fs.ReadFile(std.FS, strings.TrimPrefix(filename, stdScheme))
+	}
+	return os.ReadFile(filename)
+}
+
+// ModTime returns the modification time of a file returned by Resolve, the
+// zero time for the standard library which never changes
+func ModTime(filename string) (time.Time, error) {
+	if IsStd(filename) {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Abs returns the absolute name of a file returned by Resolve, which
+// identifies the module in it
+func Abs(filename string) (string, error) {
+	if IsStd(filename) {
+		return filename, nil
+	}
+	return filepath.Abs(filename)
+}
+
+// Dir returns the directory of a file returned by Resolve, to resolve the
+// relative imports of the file from
+func Dir(filename string) string {
+	if IsStd(filename) {
+		return stdScheme + pathpkg.Dir(strings.TrimPrefix(filename, stdScheme))
+	}
+	return filepath.Dir(filename)
+}
+
+// lookup is a helper function that returns the file imported by path, the
+// index file of the package when path is a directory
+func lookup(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+
+	index := filepath.Join(path, Index)
+	if _, err := os.Stat(index); err != nil {
+		return "", fmt.Errorf("package %s has no %s: %w", path, Index, err)
+	}
+	return index, nil
+}
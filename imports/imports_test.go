@@ -0,0 +1,126 @@
+package imports
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	for _, file := range []string{
+		filepath.Join(first, "a.mky"),
+		filepath.Join(second, "a.mky"),
+		filepath.Join(second, "b.mky"),
+		filepath.Join(second, "stats", Index),
+		filepath.Join(first, "empty", "other.mky"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Resolver{}
+	r.AddSearchPath(second)
+	r.AddSearchPath(first)
+
+	tests := []struct {
+		path     string
+		expected string // expected is empty when the import is not found
+	}{
+		{"a.mky", filepath.Join(first, "a.mky")},
+		{"b.mky", filepath.Join(second, "b.mky")},
+		{"stats", filepath.Join(second, "stats", Index)},
+		{filepath.Join(second, "stats"), filepath.Join(second, "stats", Index)},
+		{filepath.Join(first, "a.mky"), filepath.Join(first, "a.mky")},
+		{filepath.Join(first, "c.mky"), ""},
+		{filepath.Join(first, "empty"), ""},
+		{"./b.mky", ""},
+		{"c.mky", ""},
+	}
+
+	for _, path := range []string{"std/strings", "std/strings.mky"} {
+		if got, err := r.Resolve(path, "", ""); err != nil || got != "std:strings.mky" {
+			t.Errorf("wrong file for %q. got=%q (%v)", path, got, err)
+		}
+	}
+	if _, err := r.Resolve("std/missing", "", ""); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wrong error for a missing standard module. got=%v", err)
+	}
+
+	if got, err := r.Resolve("../b.mky", "", filepath.Join(second, "stats")); err != nil || got != filepath.Join(second, "b.mky") {
+		t.Errorf("wrong file for ../b.mky imported from a package. got=%q (%v)", got, err)
+	}
+
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.path, "", "")
+		if tt.expected == "" {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("wrong error for %q. got=%v", tt.path, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q) failed: %s", tt.path, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("wrong file for %q. want=%q, got=%q", tt.path, tt.expected, got)
+		}
+	}
+}
+
+// TestResolveScriptDir tests that imports are looked up in the directory of
+// the script run before the search path
+func TestResolveScriptDir(t *testing.T) {
+	script, lib := t.TempDir(), t.TempDir()
+	for _, file := range []string{
+		filepath.Join(script, "helper.mky"),
+		filepath.Join(lib, "helper.mky"),
+		filepath.Join(lib, "shared.mky"),
+	} {
+		if err := os.WriteFile(file, []byte("1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Resolver{SearchPath: []string{lib}}
+	r.SetScript(filepath.Join(script, "main.mky"))
+
+	if got, err := r.Resolve("helper.mky", "", ""); err != nil || got != filepath.Join(script, "helper.mky") {
+		t.Errorf("wrong file for helper.mky. got=%q (%v)", got, err)
+	}
+	if got, err := r.Resolve("shared.mky", "", ""); err != nil || got != filepath.Join(lib, "shared.mky") {
+		t.Errorf("wrong file for shared.mky. got=%q (%v)", got, err)
+	}
+	if _, err := r.Resolve("./helper.mky", "", ""); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("./helper.mky not relative to the current directory. got=%v", err)
+	}
+}
+
+// TestResolvers tests that resolvers search their own directories, and that
+// a nil resolver searches those of MONKEY_PATH
+func TestResolvers(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(first, "a.mky"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := &Resolver{SearchPath: []string{first}}, &Resolver{SearchPath: []string{second}}
+	if got, err := a.Resolve("a.mky", "", ""); err != nil || got != filepath.Join(first, "a.mky") {
+		t.Errorf("wrong file for a.mky. got=%q (%v)", got, err)
+	}
+	if _, err := b.Resolve("a.mky", "", ""); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a.mky found outside the search path. got=%v", err)
+	}
+
+	t.Setenv(EnvVar, first)
+	var r *Resolver
+	if got, err := r.Resolve("a.mky", "", ""); err != nil || got != filepath.Join(first, "a.mky") {
+		t.Errorf("wrong file for a.mky with MONKEY_PATH. got=%q (%v)", got, err)
+	}
+}
@@ -0,0 +1,128 @@
+// imports/remote.go
+
+package imports
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AllowRemote enables remote imports. It is cleared in sandbox mode, unless
+// the net capability is allowed.
+var AllowRemote = true
+
+// maxRemoteSize is the largest remote module that is downloaded
+const maxRemoteSize = 10 << 20
+
+// client downloads remote modules
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// ErrRemoteDisabled is returned for remote imports in sandbox mode
+var ErrRemoteDisabled = errors.New("remote imports are disabled in sandbox mode")
+
+// defaultCacheDir is a helper function that returns the directory remote
+// modules are cached in, under the user's cache directory
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "monkey", "modules")
+}
+
+// IsRemote reports whether path imports a module over HTTP
+func IsRemote(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// Fetch returns the local copy of the remote module at url, downloading it
+// to the cache directory when it is not cached yet. The module must have the
+// given hex sha256, so a changed module is never run.
+func (r *Resolver) Fetch(url, checksum string) (string, error) {
+	if r == nil {
+		r = NewResolver()
+	}
+	if !AllowRemote {
+		return "", ErrRemoteDisabled
+	}
+	if checksum == "" {
+		return "", fmt.Errorf("remote import %s has no checksum, add sha256:<hex> after the URL", url)
+	}
+
+	filename := filepath.Join(r.CacheDir, checksum+".mky")
+	if source, err := os.ReadFile(filename); err == nil && sum(source) == checksum {
+		return filename, nil
+	}
+
+	source, err := download(url)
+	if err != nil {
+		return "", err
+	}
+	if got := sum(source); got != checksum {
+		return "", fmt.Errorf("checksum mismatch for %s: want sha256:%s, got sha256:%s", url, checksum, got)
+	}
+
+	if err := writeCache(filename, source); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// download is a helper function that returns the body of a successful GET request
+func download(url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %s", url, err)
+	}
+	if len(body) > maxRemoteSize {
+		return nil, fmt.Errorf("downloading %s: module larger than %d bytes", url, maxRemoteSize)
+	}
+	return body, nil
+}
+
+// writeCache is a helper function that writes a downloaded module to the
+// cache, through a temporary file so concurrent runs never see half of it
+func writeCache(filename string, source []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(source); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// sum is a helper function that returns the hex sha256 of data
+func sum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
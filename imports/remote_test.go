@@ -0,0 +1,67 @@
+package imports
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	source := "export let answer = 42;"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/lib.mky" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(source))
+	}))
+	defer server.Close()
+
+	saved := AllowRemote
+	defer func() { AllowRemote = saved }()
+	r := &Resolver{CacheDir: t.TempDir()}
+
+	checksum := sum([]byte(source))
+	for i := 0; i < 2; i++ {
+		filename, err := r.Resolve(server.URL+"/lib.mky", checksum, "")
+		if err != nil {
+			t.Fatalf("Resolve failed: %s", err)
+		}
+		content, err := os.ReadFile(filename)
+		if err != nil || string(content) != source {
+			t.Fatalf("wrong cached module %q (%v)", content, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("module downloaded %d times, want 1", requests)
+	}
+
+	tests := []struct {
+		url      string
+		checksum string
+		expected string // expected is found in the error
+	}{
+		{server.URL + "/lib.mky", strings.Repeat("0", 64), "checksum mismatch"},
+		{server.URL + "/lib.mky", "", "has no checksum"},
+		{server.URL + "/missing.mky", strings.Repeat("1", 64), "404"},
+	}
+	for _, tt := range tests {
+		_, err := r.Resolve(tt.url, tt.checksum, "")
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error for %s. want %q in %v", tt.url, tt.expected, err)
+		}
+	}
+
+	if _, err := r.Resolve("./other.mky", "", r.CacheDir); err == nil {
+		t.Errorf("relative import from a remote module resolved")
+	}
+
+	AllowRemote = false
+	if _, err := r.Resolve(server.URL+"/lib.mky", checksum, ""); err != ErrRemoteDisabled {
+		t.Errorf("wrong error in sandbox mode. got=%v", err)
+	}
+}
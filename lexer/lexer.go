@@ -1,23 +1,48 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
 	"monkey/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
+// Error is a malformed token, found at a line and a column
+type Error struct {
+	Message string
+	Line    int
+	Column  int
+}
+
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int
 	ch           byte
 	line         int
+	lineStart    int // position of the first character of the current line
+
+	comments []token.Token      // comments skipped so far, in source order
+	errors   []Error            // errors holds the malformed tokens read so far, in source order
+	previous [2]token.TokenType // previous holds the types of the last two tokens read
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
-	l.readChar()
+	l := &Lexer{}
+	l.Reset(input)
 	return l
 }
 
+// Reset starts lexing input over, reusing the lexer and the buffer of its
+// comments, so lexing many inputs does not allocate a lexer for each. The
+// comments returned by Comments before are overwritten.
+func (l *Lexer) Reset(input string) {
+	*l = Lexer{input: input, line: 1, comments: l.comments[:0], errors: l.errors[:0]}
+	l.readChar()
+}
+
 func (l *Lexer) readChar() {
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
@@ -30,95 +55,196 @@ func (l *Lexer) readChar() {
 }
 
 func (l *Lexer) NextToken() token.Token {
+	tok := l.nextToken()
+	l.previous[0], l.previous[1] = l.previous[1], tok.Type
+	return tok
+}
+
+func (l *Lexer) nextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace() // skipWhitespace is a helper function
 
+	line, column := l.line, l.position-l.lineStart+1
+
 	switch l.ch {
 	case '=':
 		if l.peekCharacter() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.EQ, Literal: literal} // EQ stands for equal
+			tok = l.twoCharToken(token.EQ) // EQ stands for equal
 		} else {
-			tok = newToken(token.ASSIGN, l.ch)
+			tok = l.newToken(token.ASSIGN)
 		}
 	case '!':
 		if l.peekCharacter() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal} // NOT_EQ stands for not equal
+			tok = l.twoCharToken(token.NOT_EQ) // NOT_EQ stands for not equal
 		} else {
-			tok = newToken(token.BANG, l.ch)
+			tok = l.newToken(token.BANG)
 		}
 	case ';':
-		tok = newToken(token.SEMICOLON, l.ch)
+		tok = l.newToken(token.SEMICOLON)
 	case ':':
-		tok = newToken(token.COLON, l.ch)
+		tok = l.newToken(token.COLON)
 	case '(':
-		tok = newToken(token.LPAREN, l.ch)
+		tok = l.newToken(token.LPAREN)
 	case ')':
-		tok = newToken(token.RPAREN, l.ch)
+		tok = l.newToken(token.RPAREN)
 	case ',':
-		tok = newToken(token.COMMA, l.ch)
+		tok = l.newToken(token.COMMA)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newToken(token.PLUS)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newToken(token.MINUS)
 
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekCharacter() == '/' {
+			tok = l.twoCharToken(token.FLOOR_DIV) // FLOOR_DIV stands for floor division
+		} else {
+			tok = l.newToken(token.SLASH)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekCharacter() == '*' {
+			tok = l.twoCharToken(token.POWER) // POWER stands for exponentiation
+		} else {
+			tok = l.newToken(token.ASTERISK)
+		}
+	case '&':
+		if l.peekCharacter() == '&' {
+			tok = l.twoCharToken(token.AND) // AND stands for logical and
+		} else {
+			tok = l.readIllegal(line, column)
+		}
+	case '|':
+		if l.peekCharacter() == '|' {
+			tok = l.twoCharToken(token.OR) // OR stands for logical or
+		} else {
+			tok = l.readIllegal(line, column)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch) // LT stands for less than
+		if l.peekCharacter() == '=' {
+			tok = l.twoCharToken(token.LT_EQ) // LT_EQ stands for less than or equal
+		} else {
+			tok = l.newToken(token.LT) // LT stands for less than
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch) // GT stands for greater than
+		if l.peekCharacter() == '=' {
+			tok = l.twoCharToken(token.GT_EQ) // GT_EQ stands for greater than or equal
+		} else {
+			tok = l.newToken(token.GT) // GT stands for greater than
+		}
 	case '{':
-		tok = newToken(token.LBRACE, l.ch)
+		tok = l.newToken(token.LBRACE)
 	case '}':
-		tok = newToken(token.RBRACE, l.ch)
+		tok = l.newToken(token.RBRACE)
 	case '[':
-		tok = newToken(token.LBRACKET, l.ch)
+		tok = l.newToken(token.LBRACKET)
 	case ']':
-		tok = newToken(token.RBRACKET, l.ch)
+		tok = l.newToken(token.RBRACKET)
 	case '@':
-		tok = newToken(token.AT, l.ch)
+		tok = l.newToken(token.AT)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString(line, column)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
-		if isLetter(l.ch) { // isLetter is a helper function
+		if isLetter(l.ch) && l.previous == [2]token.TokenType{token.IMPORT, token.STRING} && l.checksumEnd() != -1 {
+			tok = l.readChecksum()
+			tok.Line, tok.Column = line, column
+			return tok
+		} else if isLetter(l.ch) { // isLetter is a helper function
 			tok.Literal = l.readIdentifier()          // readIdentifier is a helper function
 			tok.Type = token.LookupIdent(tok.Literal) // LookupIdent is a helper function
+			tok.Line, tok.Column = line, column
 			return tok
-		} else if isDigit(l.ch) { // isDigit is a helper function
-			return l.readNumber() // readNumber is a helper function
+		} else if isDigit(l.ch) || isDecimal(l.ch) && isDigit(l.peekCharacter()) { // isDigit is a helper function
+			tok = l.readNumber(line, column) // readNumber is a helper function
+			tok.Line, tok.Column = line, column
+			return tok
+		} else if isDecimal(l.ch) {
+			tok = l.newToken(token.DOT)
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = l.readIllegal(line, column)
 		}
 	}
 
-	tok.Line = l.line
+	tok.Line, tok.Column = line, column
 
 	l.readChar()
 	return tok
 }
 
-func (l *Lexer) readString() string { // readString is a helper function
-	position := l.position + 1
+// readString is a helper function that reads a string literal starting at
+// line and column. A string running to the end of the input is an ILLEGAL
+// token holding the rest of the input.
+func (l *Lexer) readString(line, column int) token.Token {
+	position := l.position
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		if l.ch == '"' {
+			return token.Token{Type: token.STRING, Literal: l.input[position+1 : l.position]}
+		}
+		if l.readPosition > len(l.input) {
+			l.addError(line, column, "unterminated string literal starting at %d:%d", line, column)
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[position:]}
 		}
 	}
-	return l.input[position:l.position]
+}
+
+// readChecksum is a helper function that reads the checksum pinning a
+// remote import, such as sha256:<hex>, following the path of the import.
+// The name of the algorithm, the colon and the letters and digits after it
+// make up the literal, which the parser checks.
+func (l *Lexer) readChecksum() token.Token {
+	position := l.position
+	for end := l.checksumEnd(); l.position < end; {
+		l.readChar()
+	}
+	return token.Token{Type: token.CHECKSUM, Literal: l.input[position:l.position]}
+}
+
+// checksumEnd is a helper function that returns the position after the
+// checksum starting at the current character, -1 when the letters and
+// digits there are not followed by a colon
+func (l *Lexer) checksumEnd() int {
+	end := l.position
+	for end < len(l.input) && (isLetter(l.input[end]) || isDigit(l.input[end])) {
+		end++
+	}
+	if end == len(l.input) || l.input[end] != ':' {
+		return -1
+	}
+	end++
+	for end < len(l.input) && (isLetter(l.input[end]) || isDigit(l.input[end])) {
+		end++
+	}
+	return end
+}
+
+// readIllegal is a helper function that reads a character starting no
+// token, at line and column. The whole character is read when it is
+// encoded on several bytes, a byte when it is not valid UTF-8.
+func (l *Lexer) readIllegal(line, column int) token.Token {
+	r, size := utf8.DecodeRuneInString(l.input[l.position:])
+	literal := l.input[l.position : l.position+size]
+	l.readPosition = l.position + size
+	if r == utf8.RuneError && size == 1 {
+		l.addError(line, column, "illegal byte %#x at %d:%d", l.ch, line, column)
+	} else {
+		l.addError(line, column, "illegal character %q at %d:%d", r, line, column)
+	}
+	return token.Token{Type: token.ILLEGAL, Literal: literal}
+}
+
+// addError is a helper function that records a malformed token
+func (l *Lexer) addError(line, column int, format string, a ...interface{}) {
+	message := fmt.Sprintf("On line %d, ", line) + fmt.Sprintf(format, a...)
+	l.errors = append(l.errors, Error{Message: message, Line: line, Column: column})
+}
+
+// Errors returns the malformed tokens the lexer has read so far: strings
+// left unterminated and characters starting no token
+func (l *Lexer) Errors() []Error {
+	return l.errors
 }
 
 func (l *Lexer) peekCharacter() byte { // peekCharacter is a helper function
@@ -129,27 +255,156 @@ func (l *Lexer) peekCharacter() byte { // peekCharacter is a helper function
 	}
 }
 
-func (l *Lexer) skipWhitespace() { // skipWhitespace is a helper function
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+func (l *Lexer) skipWhitespace() { // skipWhitespace is a helper function that also skips comments
+	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' || l.ch == '#' {
+		if l.ch == '#' {
+			l.readComment()
+			continue
+		}
 		if l.ch == '\n' {
 			l.line++
+			l.lineStart = l.readPosition
 		}
 		l.readChar()
 	}
 }
 
-func (l *Lexer) readNumber() token.Token { // readNumber is a helper function
-	var tok token.Token
+// readComment is a helper function that reads a # comment up to the end of the line
+func (l *Lexer) readComment() {
+	tok := token.Token{Type: token.COMMENT, Line: l.line, Column: l.position - l.lineStart + 1}
+
 	position := l.position
-	tok.Type = token.INT
-	for isDigit(l.ch) || isDecimal(l.ch) { // isDigit is a helper function
-		if isDecimal(l.ch) {
-			tok.Type = token.FLOAT
-		}
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
-	tok.Literal = l.input[position:l.position]
-	return tok
+	tok.Literal = strings.TrimRight(l.input[position:l.position], " \t\r")
+
+	l.comments = append(l.comments, tok)
+}
+
+// Comments returns the comments the lexer has skipped so far. The parser
+// ignores comments; tools such as the formatter read them from here.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+// readNumber is a helper function that reads a number literal starting at
+// line and column. The digits, underscores and decimal points following each
+// other make up the literal, which is an ILLEGAL token unless it has at most
+// one decimal point, between two digits, and its underscores each separate
+// two digits. Integers have no leading zero and fit in an int64. A 0x, 0o or 0b prefix starts a hexadecimal, octal or binary
+// integer instead, made up of the letters and digits after it.
+func (l *Lexer) readNumber(line, column int) token.Token {
+	position := l.position
+	if l.ch == '0' && basePrefix(l.peekCharacter()) != "" {
+		l.readChar()
+		l.readChar()
+		for isLetter(l.ch) || isDigit(l.ch) {
+			l.readChar()
+		}
+	} else {
+		for isDigit(l.ch) || isDecimal(l.ch) || l.ch == '_' {
+			l.readChar()
+		}
+	}
+	literal := l.input[position:l.position]
+
+	if problem := numberProblem(literal); problem != "" {
+		l.addError(line, column, "malformed number literal %s at %d:%d: %s", literal, line, column, problem)
+		return token.Token{Type: token.ILLEGAL, Literal: literal}
+	}
+	if strings.IndexByte(literal, '.') != -1 {
+		return token.Token{Type: token.FLOAT, Literal: literal}
+	}
+	return token.Token{Type: token.INT, Literal: literal}
+}
+
+// numberProblem is a helper function that returns what is wrong with a
+// number literal, empty when it is well formed
+func numberProblem(literal string) string {
+	if len(literal) >= 2 && literal[0] == '0' {
+		if base := basePrefix(literal[1]); base != "" {
+			return prefixedProblem(literal, base)
+		}
+	}
+	if isDecimal(literal[0]) {
+		return "expected a digit before the decimal point"
+	}
+	if strings.Count(literal, ".") > 1 {
+		return "more than one decimal point"
+	}
+	if isDecimal(literal[len(literal)-1]) {
+		return "expected a digit after the decimal point"
+	}
+	if problem := underscoreProblem(literal, isDigit); problem != "" || strings.IndexByte(literal, '.') != -1 {
+		return problem
+	}
+	if len(literal) > 1 && literal[0] == '0' {
+		return "leading zero in a decimal integer, write 0o for an octal one"
+	}
+	return rangeProblem(literal)
+}
+
+// prefixedProblem is a helper function that returns what is wrong with an
+// integer literal written with the prefix of base, empty when it is well
+// formed. The prefix counts as a digit before an underscore.
+func prefixedProblem(literal, base string) string {
+	digits := literal[2:]
+	if strings.Trim(digits, "_") == "" {
+		return "expected a digit after the " + literal[:2] + " prefix"
+	}
+	isBaseDigit := func(ch byte) bool {
+		switch base {
+		case "binary":
+			return ch == '0' || ch == '1'
+		case "octal":
+			return '0' <= ch && ch <= '7'
+		}
+		return isDigit(ch) || 'a' <= ch|0x20 && ch|0x20 <= 'f'
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] != '_' && !isBaseDigit(digits[i]) {
+			return fmt.Sprintf("invalid digit %q in %s literal", digits[i], base)
+		}
+	}
+	if problem := underscoreProblem("0"+digits, isBaseDigit); problem != "" {
+		return problem
+	}
+	return rangeProblem(literal)
+}
+
+// rangeProblem is a helper function that returns a problem when a well
+// formed integer literal is too large for an int64
+func rangeProblem(literal string) string {
+	if _, err := strconv.ParseInt(literal, 0, 64); errors.Is(err, strconv.ErrRange) {
+		return "value out of range for int64"
+	}
+	return ""
+}
+
+// underscoreProblem is a helper function that returns a problem unless
+// every underscore in a number literal is between two digits
+func underscoreProblem(literal string, isDigit func(byte) bool) string {
+	for i := 0; i < len(literal); i++ {
+		if literal[i] == '_' && (i == 0 || i == len(literal)-1 || !isDigit(literal[i-1]) || !isDigit(literal[i+1])) {
+			return "'_' must separate two digits"
+		}
+	}
+	return ""
+}
+
+// basePrefix is a helper function that returns the name of the base a
+// letter after a leading 0 selects, empty when it selects none
+func basePrefix(ch byte) string {
+	switch ch {
+	case 'x', 'X':
+		return "hexadecimal"
+	case 'o', 'O':
+		return "octal"
+	case 'b', 'B':
+		return "binary"
+	}
+	return ""
 }
 
 func isDigit(ch byte) bool { // isDigit is a helper function
@@ -172,6 +427,17 @@ func (l *Lexer) readIdentifier() string { // readIdentifier is a helper function
 	return l.input[position:l.position]
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
-	return token.Token{Type: tokenType, Literal: string(ch)}
+// newToken is a helper function that returns a token of the current
+// character. Literals are slices of the input, so tokens are made without
+// copying it.
+func (l *Lexer) newToken(tokenType token.TokenType) token.Token {
+	return token.Token{Type: tokenType, Literal: l.input[l.position:l.readPosition]}
+}
+
+// twoCharToken is a helper function that returns a token of the current
+// character and the next one, which it reads
+func (l *Lexer) twoCharToken(tokenType token.TokenType) token.Token {
+	position := l.position
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: l.input[position:l.readPosition]}
 }
@@ -31,6 +31,15 @@ func TestNextToken(t *testing.T) {
 	{"foo": "bar"}
 	let fl = 5.1;
 	let tens = @[1],[1.0];
+	7 // 2;
+	2 ** 3;
+	while (x) { x }
+	for (x in y) {}
+	1 <= 2 >= 3;
+	a && b || c;
+	null;
+	config.host;
+	try {} catch (e) {}
 	`
 
 	tests := []struct {
@@ -140,6 +149,56 @@ func TestNextToken(t *testing.T) {
 		{token.FLOAT, "1.0"},
 		{token.RBRACKET, "]"},
 		{token.SEMICOLON, ";"},
+		{token.INT, "7"},
+		{token.FLOOR_DIV, "//"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.INT, "2"},
+		{token.POWER, "**"},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.WHILE, "while"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "x"},
+		{token.RBRACE, "}"},
+		{token.FOR, "for"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.IN, "in"},
+		{token.IDENT, "y"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		{token.INT, "1"},
+		{token.LT_EQ, "<="},
+		{token.INT, "2"},
+		{token.GT_EQ, ">="},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.SEMICOLON, ";"},
+		{token.NULL, "null"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "config"},
+		{token.DOT, "."},
+		{token.IDENT, "host"},
+		{token.SEMICOLON, ";"},
+		{token.TRY, "try"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		{token.CATCH, "catch"},
+		{token.LPAREN, "("},
+		{token.IDENT, "e"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
 		{token.EOF, ""},
 	}
 
@@ -161,3 +220,201 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestComments(t *testing.T) {
+	input := `# leading
+let x = 5; # trailing
+#`
+
+	l := New(input)
+
+	expected := []token.TokenType{token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON, token.EOF}
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+
+	comments := []token.Token{
+		{Type: token.COMMENT, Literal: "# leading", Line: 1, Column: 1},
+		{Type: token.COMMENT, Literal: "# trailing", Line: 2, Column: 12},
+		{Type: token.COMMENT, Literal: "#", Line: 3, Column: 1},
+	}
+	if len(l.Comments()) != len(comments) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d", len(comments), len(l.Comments()))
+	}
+	for i, comment := range comments {
+		if l.Comments()[i] != comment {
+			t.Errorf("comments[%d] wrong. expected=%+v, got=%+v", i, comment, l.Comments()[i])
+		}
+	}
+}
+
+// TestNextTokenAllocations tests that tokens are made without allocating
+func TestNextTokenAllocations(t *testing.T) {
+	input := `let add = fn(x, y) { x + y == 3 != 4; }; # comment
+	"string" [1.5, 2] @{} !true`
+
+	l := New(input)
+	allocs := testing.AllocsPerRun(100, func() {
+		l.Reset(input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("lexing allocated %v times, want 0", allocs)
+	}
+	if comments := l.Comments(); len(comments) != 1 || comments[0].Literal != "# comment" {
+		t.Errorf("wrong comments after Reset: %v", comments)
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	input := "let b = é;\n\"ok\" \xff \"open"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "b"},
+		{token.ASSIGN, "="},
+		{token.ILLEGAL, "é"},
+		{token.SEMICOLON, ";"},
+		{token.STRING, "ok"},
+		{token.ILLEGAL, "\xff"},
+		{token.ILLEGAL, "\"open"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	expected := []Error{
+		{Message: "On line 1, illegal character 'é' at 1:9", Line: 1, Column: 9},
+		{Message: "On line 2, illegal byte 0xff at 2:6", Line: 2, Column: 6},
+		{Message: "On line 2, unterminated string literal starting at 2:8", Line: 2, Column: 8},
+	}
+	if errors := l.Errors(); len(errors) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%d, got=%v", len(expected), errors)
+	}
+	for i, err := range l.Errors() {
+		if err != expected[i] {
+			t.Errorf("errors[%d] wrong. want=%+v, got=%+v", i, expected[i], err)
+		}
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token
+	}{
+		{`import "lib.mky" sha256:0b8f as lib;`, []token.Token{
+			{Type: token.IMPORT, Literal: "import"},
+			{Type: token.STRING, Literal: "lib.mky"},
+			{Type: token.CHECKSUM, Literal: "sha256:0b8f"},
+			{Type: token.IDENT, Literal: "as"},
+			{Type: token.IDENT, Literal: "lib"},
+			{Type: token.SEMICOLON, Literal: ";"},
+		}},
+		{`import "lib.mky" sha256: 0b8f;`, []token.Token{
+			{Type: token.IMPORT, Literal: "import"},
+			{Type: token.STRING, Literal: "lib.mky"},
+			{Type: token.CHECKSUM, Literal: "sha256:"},
+			{Type: token.ILLEGAL, Literal: "0b8f"},
+			{Type: token.SEMICOLON, Literal: ";"},
+		}},
+		// Checksums only follow the path of an import
+		{`{sha: 0}`, []token.Token{
+			{Type: token.LBRACE, Literal: "{"},
+			{Type: token.IDENT, Literal: "sha"},
+			{Type: token.COLON, Literal: ":"},
+			{Type: token.INT, Literal: "0"},
+			{Type: token.RBRACE, Literal: "}"},
+		}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for i, expected := range tt.expected {
+			tok := l.NextToken()
+			if tok.Type != expected.Type || tok.Literal != expected.Literal {
+				t.Errorf("%q: wrong token %d. expected=%s %q, got=%s %q", tt.input, i, expected.Type, expected.Literal, tok.Type, tok.Literal)
+			}
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("%q: expected EOF, got=%s %q", tt.input, tok.Type, tok.Literal)
+		}
+	}
+
+	// A checksum of hex digits produces no malformed number literal
+	l := New(`import "lib.mky" sha256:0b8f;`)
+	for l.NextToken().Type != token.EOF {
+	}
+	if len(l.Errors()) != 0 {
+		t.Errorf("unexpected errors: %v", l.Errors())
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedError   string
+	}{
+		{"42", token.INT, "42", ""},
+		{"4.25", token.FLOAT, "4.25", ""},
+		{"0.5", token.FLOAT, "0.5", ""},
+		{"1.2.3", token.ILLEGAL, "1.2.3", "On line 1, malformed number literal 1.2.3 at 1:1: more than one decimal point"},
+		{"1.", token.ILLEGAL, "1.", "On line 1, malformed number literal 1. at 1:1: expected a digit after the decimal point"},
+		{".5", token.ILLEGAL, ".5", "On line 1, malformed number literal .5 at 1:1: expected a digit before the decimal point"},
+		{"1..5", token.ILLEGAL, "1..5", "On line 1, malformed number literal 1..5 at 1:1: more than one decimal point"},
+		{"0xFF", token.INT, "0xFF", ""},
+		{"0o755", token.INT, "0o755", ""},
+		{"0b1010", token.INT, "0b1010", ""},
+		{"1_000_000", token.INT, "1_000_000", ""},
+		{"0x_ff_ff", token.INT, "0x_ff_ff", ""},
+		{"1_000.5", token.FLOAT, "1_000.5", ""},
+		{"0x", token.ILLEGAL, "0x", "On line 1, malformed number literal 0x at 1:1: expected a digit after the 0x prefix"},
+		{"0b102", token.ILLEGAL, "0b102", "On line 1, malformed number literal 0b102 at 1:1: invalid digit '2' in binary literal"},
+		{"0o8", token.ILLEGAL, "0o8", "On line 1, malformed number literal 0o8 at 1:1: invalid digit '8' in octal literal"},
+		{"0xfg", token.ILLEGAL, "0xfg", "On line 1, malformed number literal 0xfg at 1:1: invalid digit 'g' in hexadecimal literal"},
+		{"1__0", token.ILLEGAL, "1__0", "On line 1, malformed number literal 1__0 at 1:1: '_' must separate two digits"},
+		{"10_", token.ILLEGAL, "10_", "On line 1, malformed number literal 10_ at 1:1: '_' must separate two digits"},
+		{"1_.5", token.ILLEGAL, "1_.5", "On line 1, malformed number literal 1_.5 at 1:1: '_' must separate two digits"},
+		{"0x1__f", token.ILLEGAL, "0x1__f", "On line 1, malformed number literal 0x1__f at 1:1: '_' must separate two digits"},
+		{"0", token.INT, "0", ""},
+		{"09", token.ILLEGAL, "09", "On line 1, malformed number literal 09 at 1:1: leading zero in a decimal integer, write 0o for an octal one"},
+		{"0_1", token.ILLEGAL, "0_1", "On line 1, malformed number literal 0_1 at 1:1: leading zero in a decimal integer, write 0o for an octal one"},
+		{"9223372036854775807", token.INT, "9223372036854775807", ""},
+		{"9223372036854775808", token.ILLEGAL, "9223372036854775808", "On line 1, malformed number literal 9223372036854775808 at 1:1: value out of range for int64"},
+		{"0xFFFFFFFFFFFFFFFFFF", token.ILLEGAL, "0xFFFFFFFFFFFFFFFFFF", "On line 1, malformed number literal 0xFFFFFFFFFFFFFFFFFF at 1:1: value out of range for int64"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("wrong token for %q. expected=%s %q, got=%s %q", tt.input, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("%q not read whole, next token %s %q", tt.input, next.Type, next.Literal)
+		}
+
+		errors := l.Errors()
+		switch {
+		case tt.expectedError == "" && len(errors) != 0:
+			t.Errorf("unexpected errors for %q: %v", tt.input, errors)
+		case tt.expectedError != "" && (len(errors) != 1 || errors[0].Message != tt.expectedError):
+			t.Errorf("wrong errors for %q. want=%q, got=%v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// lsp/analysis.go
+
+package lsp
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/diagnostic"
+	"monkey/doc"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"strings"
+)
+
+// document is the analysis of one version of an open file. Positions in the
+// protocol count characters from zero while tokens count bytes from one;
+// the two agree for the ASCII identifiers the analysis deals with.
+type document struct {
+	uri         string
+	lines       []string
+	program     *ast.Program
+	diagnostics []Diagnostic
+
+	identifiers []*ast.Identifier                     // identifiers holds every identifier in source order
+	definitions map[*ast.Identifier]*ast.Identifier   // definitions maps each use of a name to the identifier binding it
+	lets        map[*ast.Identifier]*ast.LetStatement // lets maps the names bound by let statements to their statement
+	scopes      []*scope
+}
+
+// scope holds the names bound by the program or by a function. Blocks do
+// not open scopes of their own: a let inside an if binds in the function.
+type scope struct {
+	start, end token.Token // start and end delimit a function, both are zero for the program
+	names      []*ast.Identifier
+	outer      *scope
+}
+
+// lookup returns the latest binding of name visible from the scope
+func (s *scope) lookup(name string) *ast.Identifier {
+	for ; s != nil; s = s.outer {
+		for i := len(s.names) - 1; i >= 0; i-- {
+			if s.names[i].Value == name {
+				return s.names[i]
+			}
+		}
+	}
+	return nil
+}
+
+// contains reports whether a position falls inside the scope
+func (s *scope) contains(pos Position) bool {
+	if s.outer == nil {
+		return true
+	}
+	return !before(pos, s.start) && before(pos, s.end)
+}
+
+// before reports whether a position comes before the start of tok
+func before(pos Position, tok token.Token) bool {
+	return pos.Line < tok.Line-1 || pos.Line == tok.Line-1 && pos.Character < tok.Column-1
+}
+
+// analyze parses and compiles source with comp, collecting its diagnostics
+// and the bindings of every name, or from scratch when comp is nil. A
+// program with syntax errors is still analyzed as far as it parsed.
+func analyze(uri, source string, comp *compiler.Incremental) *document {
+	d := &document{
+		uri:         uri,
+		lines:       strings.Split(source, "\n"),
+		diagnostics: []Diagnostic{},
+		definitions: map[*ast.Identifier]*ast.Identifier{},
+		lets:        map[*ast.Identifier]*ast.LetStatement{},
+	}
+
+	p := parser.New(lexer.New(source))
+	d.program = p.ParseProgram()
+	for _, err := range p.Errors() {
+		// Editors show the position, so the message need not repeat it
+		err.Message = strings.TrimPrefix(err.Message, fmt.Sprintf("On line %d, ", err.Line))
+		d.addDiagnostic(err)
+	}
+
+	if len(d.diagnostics) == 0 {
+		if err := compile(d.program, source, comp); err != nil {
+			switch err := err.(type) {
+			case *diagnostic.Diagnostic:
+				d.addDiagnostic(*err)
+			case *compiler.ImportError:
+				d.addDiagnostic(diagnostic.Diagnostic{Severity: diagnostic.Error, Message: err.Error(), Line: err.Line, Column: err.Column})
+			default:
+				d.addDiagnostic(diagnostic.Diagnostic{Severity: diagnostic.Error, Message: err.Error()})
+			}
+		}
+	}
+
+	global := &scope{}
+	d.scopes = append(d.scopes, global)
+	var unresolved []*ast.Identifier
+	d.walk(d.program, global, &unresolved)
+
+	// Functions may refer to globals bound after them
+	for _, use := range unresolved {
+		if def := global.lookup(use.Value); def != nil {
+			d.definitions[use] = def
+		}
+	}
+
+	return d
+}
+
+// compile is a helper function that compiles program, parsed from source,
+// with comp, or from scratch when comp is nil
+func compile(program *ast.Program, source string, comp *compiler.Incremental) error {
+	if comp == nil {
+		return compiler.New().Compile(program)
+	}
+	_, err := comp.Compile(program, source)
+	return err
+}
+
+// addDiagnostic records a diagnostic, covering its span when it is known
+// and otherwise the word found at its position. The severities of
+// diagnostics are those of the protocol.
+func (d *document) addDiagnostic(diag diagnostic.Diagnostic) {
+	start := Position{}
+	if diag.Line > 0 {
+		start = Position{Line: diag.Line - 1, Character: diag.Column - 1}
+	}
+	end := start
+	switch {
+	case diag.Line == 0 || diag.Column == 0:
+		start.Character = 0
+		end.Character = len(d.line(start.Line))
+	case diag.End.Line > diag.Line || diag.End.Line == diag.Line && diag.End.Column > diag.Column:
+		end = Position{Line: diag.End.Line - 1, Character: diag.End.Column - 1}
+	default:
+		end.Character = start.Character + 1
+		text := d.line(start.Line)
+		for end.Character < len(text) && isWordByte(text[end.Character]) && isWordByte(text[start.Character]) {
+			end.Character++
+		}
+	}
+
+	d.diagnostics = append(d.diagnostics, Diagnostic{
+		Range:    Range{Start: start, End: end},
+		Severity: int(diag.Severity),
+		Source:   "monkey",
+		Message:  diag.Message,
+	})
+}
+
+// line returns a zero-based line of the source, empty past its end
+func (d *document) line(n int) string {
+	if n < len(d.lines) {
+		return d.lines[n]
+	}
+	return ""
+}
+
+// isWordByte reports whether ch may appear in an identifier or number
+func isWordByte(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '_' || ch == '.'
+}
+
+// walk records the identifiers below node, resolving each use of a name
+// against the scope. Uses that are not bound yet are added to unresolved.
+func (d *document) walk(node ast.Node, s *scope, unresolved *[]*ast.Identifier) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			// The name is bound before the value so functions can recurse
+			d.bind(node.Name, s)
+			d.lets[node.Name] = node
+			d.walk(node.Value, s, unresolved)
+			return false
+
+		case *ast.DestructureStatement:
+			d.walk(node.Value, s, unresolved)
+			for _, name := range node.Names {
+				d.bind(name, s)
+			}
+			return false
+
+		case *ast.ImportLiteral:
+			d.bind(node.Alias, s)
+			return false
+
+		case *ast.TryExpression:
+			d.walk(node.Block, s, unresolved)
+			d.bind(node.Variable, s)
+			d.walk(node.Handler, s, unresolved)
+			return false
+
+		case *ast.FunctionLiteral:
+			if node.Body == nil {
+				return false
+			}
+			inner := &scope{start: node.Token, end: node.Body.End, outer: s}
+			d.scopes = append(d.scopes, inner)
+			for _, param := range node.Parameters {
+				d.bind(param, inner)
+			}
+			d.walk(node.Body, inner, unresolved)
+			return false
+
+		case *ast.Identifier:
+			d.identifiers = append(d.identifiers, node)
+			if def := s.lookup(node.Value); def != nil {
+				d.definitions[node] = def
+			} else {
+				*unresolved = append(*unresolved, node)
+			}
+		}
+		return true
+	})
+}
+
+// bind records ident as a new binding in the scope
+func (d *document) bind(ident *ast.Identifier, s *scope) {
+	if ident == nil {
+		return
+	}
+	d.identifiers = append(d.identifiers, ident)
+	s.names = append(s.names, ident)
+}
+
+// identifierAt returns the identifier under or just before the cursor
+func (d *document) identifierAt(pos Position) *ast.Identifier {
+	for _, ident := range d.identifiers {
+		start := ident.Token.Column - 1
+		if ident.Token.Line-1 == pos.Line && start <= pos.Character && pos.Character <= start+len(ident.Value) {
+			return ident
+		}
+	}
+	return nil
+}
+
+// definition returns the identifier binding the name at the cursor, which
+// is the identifier itself for a binding, or nil for builtins and unknown names
+func (d *document) definition(pos Position) *ast.Identifier {
+	ident := d.identifierAt(pos)
+	if ident == nil {
+		return nil
+	}
+	if def, ok := d.definitions[ident]; ok {
+		return def
+	}
+	if d.isBinding(ident) {
+		return ident
+	}
+	return nil
+}
+
+// isBinding reports whether ident is bound by a let statement or a parameter
+func (d *document) isBinding(ident *ast.Identifier) bool {
+	for _, s := range d.scopes {
+		for _, name := range s.names {
+			if name == ident {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hover describes the name at the cursor in Markdown
+func (d *document) hover(pos Position) *Hover {
+	ident := d.identifierAt(pos)
+	if ident == nil {
+		return nil
+	}
+
+	var text string
+	if def := d.definition(pos); def != nil {
+		if let, ok := d.lets[def]; ok {
+			entry := doc.FromLet(let)
+			text = codeBlock(entry.Signature)
+			if entry.Doc != "" {
+				text += "\n" + entry.Doc
+			}
+		} else {
+			text = codeBlock("(parameter) " + def.Value)
+		}
+	} else if builtin := object.GetBuiltInByName(ident.Value); builtin != nil {
+		entry := doc.FromBuiltin(ident.Value, builtin)
+		text = codeBlock(entry.Signature) + "\n" + entry.Doc
+	} else {
+		return nil
+	}
+
+	r := identifierRange(ident)
+	return &Hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+// codeBlock is a helper function that wraps code in a Markdown code block
+func codeBlock(code string) string {
+	return "```monkey\n" + code + "\n```\n"
+}
+
+// completions returns the names visible at the cursor followed by the
+// builtins and keywords. Editors filter them by what has been typed.
+func (d *document) completions(pos Position) []CompletionItem {
+	items := []CompletionItem{}
+	seen := map[string]bool{}
+
+	for i := len(d.scopes) - 1; i >= 0; i-- {
+		s := d.scopes[i]
+		if !s.contains(pos) {
+			continue
+		}
+		for _, name := range s.names {
+			if seen[name.Value] {
+				continue
+			}
+			seen[name.Value] = true
+
+			item := CompletionItem{Label: name.Value, Kind: kindVariable}
+			if let, ok := d.lets[name]; ok {
+				if _, isFunction := let.Value.(*ast.FunctionLiteral); isFunction {
+					item.Kind = kindFunction
+					item.Detail = doc.FromLet(let).Signature
+				}
+			}
+			items = append(items, item)
+		}
+	}
+
+	for _, entry := range doc.Builtins() {
+		if !seen[entry.Name] {
+			items = append(items, CompletionItem{Label: entry.Name, Kind: kindFunction, Detail: entry.Signature})
+		}
+	}
+	for _, keyword := range token.Keywords() {
+		items = append(items, CompletionItem{Label: keyword, Kind: kindKeyword})
+	}
+
+	return items
+}
+
+// identifierRange returns the range an identifier covers
+func identifierRange(ident *ast.Identifier) Range {
+	start := Position{Line: ident.Token.Line - 1, Character: ident.Token.Column - 1}
+	return Range{Start: start, End: Position{Line: start.Line, Character: start.Character + len(ident.Value)}}
+}
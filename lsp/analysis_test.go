@@ -0,0 +1,130 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+)
+
+const source = `# Adds one.
+let inc = fn(x) { x + 1 };
+let twice = fn(f, y) { f(f(y)) };
+puts(twice(inc, 1));`
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []Diagnostic
+	}{
+		{source, []Diagnostic{}},
+		{"let a = 1;\nputs(b);", []Diagnostic{
+			{Range: Range{Start: Position{1, 5}, End: Position{1, 6}}, Severity: severityError, Source: "monkey", Message: "undefined variable b"},
+		}},
+		{"let = 5;", []Diagnostic{
+			{Range: Range{Start: Position{0, 4}, End: Position{0, 5}}, Severity: severityError, Source: "monkey", Message: "expected next token to be IDENT, got = instead"},
+			{Range: Range{Start: Position{0, 4}, End: Position{0, 5}}, Severity: severityError, Source: "monkey", Message: "no prefix parse function for = found"},
+		}},
+	}
+
+	for _, tt := range tests {
+		d := analyze("file:///test.mky", tt.input, nil)
+		if len(d.diagnostics) != len(tt.expected) {
+			t.Fatalf("wrong diagnostics for %q. want=%+v, got=%+v", tt.input, tt.expected, d.diagnostics)
+		}
+		for i, want := range tt.expected {
+			if d.diagnostics[i] != want {
+				t.Errorf("wrong diagnostic %d for %q. want=%+v, got=%+v", i, tt.input, want, d.diagnostics[i])
+			}
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		pos      Position
+		expected *Range // expected is nil when there is no definition
+	}{
+		{Position{3, 6}, &Range{Position{2, 4}, Position{2, 9}}},    // twice
+		{Position{3, 12}, &Range{Position{1, 4}, Position{1, 7}}},   // inc
+		{Position{2, 25}, &Range{Position{2, 15}, Position{2, 16}}}, // f, a parameter
+		{Position{1, 5}, &Range{Position{1, 4}, Position{1, 7}}},    // inc, the binding itself
+		{Position{3, 1}, nil}, // puts, a builtin
+		{Position{0, 3}, nil}, // a comment
+	}
+
+	d := analyze("file:///test.mky", source, nil)
+	for _, tt := range tests {
+		def := d.definition(tt.pos)
+		if tt.expected == nil {
+			if def != nil {
+				t.Errorf("unexpected definition at %+v. got=%s", tt.pos, def.Value)
+			}
+			continue
+		}
+		if def == nil {
+			t.Errorf("no definition at %+v", tt.pos)
+			continue
+		}
+		if got := identifierRange(def); got != *tt.expected {
+			t.Errorf("wrong definition at %+v. want=%+v, got=%+v", tt.pos, *tt.expected, got)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	tests := []struct {
+		pos      Position
+		expected string // expected is found in the hover text, empty when there is none
+	}{
+		{Position{3, 13}, "```monkey\ninc(x)\n```\n\nAdds one."},
+		{Position{3, 1}, "```monkey\nputs(values...)\n```"},
+		{Position{1, 18}, "(parameter) x"},
+		{Position{1, 20}, ""},
+	}
+
+	d := analyze("file:///test.mky", source, nil)
+	for _, tt := range tests {
+		hover := d.hover(tt.pos)
+		if tt.expected == "" {
+			if hover != nil {
+				t.Errorf("unexpected hover at %+v. got=%q", tt.pos, hover.Contents.Value)
+			}
+			continue
+		}
+		if hover == nil {
+			t.Errorf("no hover at %+v", tt.pos)
+			continue
+		}
+		if !strings.Contains(hover.Contents.Value, tt.expected) {
+			t.Errorf("wrong hover at %+v. want %q in %q", tt.pos, tt.expected, hover.Contents.Value)
+		}
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	d := analyze("file:///test.mky", source, nil)
+
+	labels := func(pos Position) map[string]CompletionItem {
+		items := map[string]CompletionItem{}
+		for _, item := range d.completions(pos) {
+			items[item.Label] = item
+		}
+		return items
+	}
+
+	inside := labels(Position{2, 25})
+	for _, name := range []string{"f", "y", "inc", "twice", "len", "let"} {
+		if _, ok := inside[name]; !ok {
+			t.Errorf("%q missing from completions inside twice", name)
+		}
+	}
+	if inside["inc"].Detail != "inc(x)" || inside["inc"].Kind != kindFunction {
+		t.Errorf("wrong completion for inc. got=%+v", inside["inc"])
+	}
+
+	outside := labels(Position{3, 0})
+	for _, name := range []string{"f", "x", "y"} {
+		if _, ok := outside[name]; ok {
+			t.Errorf("parameter %q completed outside its function", name)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// lsp/server.go
+
+// Package lsp implements a Language Server Protocol server for Monkey. It
+// reports parser and compiler errors as diagnostics, describes builtins and
+// let bindings on hover, jumps to the let or parameter binding a name and
+// completes names, builtins and keywords.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/compiler"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ErrNoShutdown is returned by Serve when the client exits or disconnects
+// without asking the server to shut down first
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+// Server answers the requests of a single client over a stream
+type Server struct {
+	in      *bufio.Reader
+	out     io.Writer
+	version string
+
+	documents map[string]*document             // documents maps the URI of each open file to its analysis
+	compilers map[string]*compiler.Incremental // compilers maps the URI of each open file to the compiler of its versions
+	shutdown  bool
+}
+
+// NewServer returns a server reading requests from in and writing responses
+// to out. The version is reported to the client.
+func NewServer(in io.Reader, out io.Writer, version string) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		version:   version,
+		documents: map[string]*document{},
+		compilers: map[string]*compiler.Incremental{},
+	}
+}
+
+// Serve handles messages until the client sends exit. It returns nil when
+// the client shut the server down properly.
+func (s *Server) Serve() error {
+	for {
+		body, err := s.readMessage()
+		if err == io.EOF {
+			return ErrNoShutdown
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+// readMessage reads the body of the next message, framed by a Content-Length header
+func (s *Server) readMessage() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("lsp: invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// write sends a message to the client
+func (s *Server) write(message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// reply answers a request with a result or an error
+func (s *Server) reply(id *json.RawMessage, result interface{}, respErr *responseError) error {
+	return s.write(response{JSONRPC: "2.0", ID: id, Result: result, Error: respErr})
+}
+
+// handle dispatches a request or notification. Unknown notifications are
+// ignored as the protocol requires.
+func (s *Server) handle(req *request) error {
+	var result interface{}
+	var err error
+
+	switch req.Method {
+	case "initialize":
+		result = initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   1,
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				CompletionProvider: completionProvider{},
+			},
+			ServerInfo: serverInfo{Name: "monkey", Version: s.version},
+		}
+
+	case "shutdown":
+		s.shutdown = true
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// The server asks for full text sync, so the last change is the whole document
+		return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.documents, params.TextDocument.URI)
+		delete(s.compilers, params.TextDocument.URI)
+		return s.publish(params.TextDocument.URI, []Diagnostic{})
+
+	case "textDocument/hover":
+		result, err = s.withDocument(req, func(d *document, pos Position) interface{} {
+			if hover := d.hover(pos); hover != nil {
+				return hover
+			}
+			return nil
+		})
+
+	case "textDocument/definition":
+		result, err = s.withDocument(req, func(d *document, pos Position) interface{} {
+			if def := d.definition(pos); def != nil {
+				return Location{URI: d.uri, Range: identifierRange(def)}
+			}
+			return nil
+		})
+
+	case "textDocument/completion":
+		result, err = s.withDocument(req, func(d *document, pos Position) interface{} {
+			return d.completions(pos)
+		})
+
+	default:
+		if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+			return nil
+		}
+		return s.reply(req.ID, nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + req.Method})
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+	if respErr, ok := err.(*responseError); ok {
+		return s.reply(req.ID, nil, respErr)
+	}
+	return s.reply(req.ID, result, nil)
+}
+
+// withDocument decodes the document and position of a request and answers
+// it with f. Documents that are not open have no answer.
+func (s *Server) withDocument(req *request, f func(d *document, pos Position) interface{}) (interface{}, error) {
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	d, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+	return f(d, params.Position), nil
+}
+
+// update analyzes a new version of a document and publishes its diagnostics.
+// The functions unchanged since the version before are not recompiled.
+func (s *Server) update(uri, text string) error {
+	comp, ok := s.compilers[uri]
+	if !ok {
+		comp = compiler.NewIncremental()
+		s.compilers[uri] = comp
+	}
+	d := analyze(uri, text, comp)
+	s.documents[uri] = d
+	return s.publish(uri, d.diagnostics)
+}
+
+// publish sends the diagnostics of a document to the client
+func (s *Server) publish(uri string, diagnostics []Diagnostic) error {
+	return s.write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
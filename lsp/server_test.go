@@ -0,0 +1,90 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// frame is a helper function that frames a message with its Content-Length header
+func frame(message string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(message), message)
+}
+
+// readMessages is a helper function that splits the output of a server into messages
+func readMessages(t *testing.T, out []byte) []map[string]interface{} {
+	t.Helper()
+
+	s := NewServer(bytes.NewReader(out), nil, "")
+	var messages []map[string]interface{}
+	for {
+		body, err := s.readMessage()
+		if err != nil {
+			return messages
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Fatalf("invalid message %q: %s", body, err)
+		}
+		messages = append(messages, message)
+	}
+}
+
+func TestServe(t *testing.T) {
+	text, _ := json.Marshal("let add = fn(a, b) { a + b };\nadd(1, c);")
+	input := strings.Join([]string{
+		frame(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`),
+		frame(`{"jsonrpc":"2.0","method":"initialized","params":{}}`),
+		frame(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.mky","text":` + string(text) + `}}}`),
+		frame(`{"jsonrpc":"2.0","id":2,"method":"textDocument/definition","params":{"textDocument":{"uri":"file:///a.mky"},"position":{"line":1,"character":1}}}`),
+		frame(`{"jsonrpc":"2.0","id":3,"method":"workspace/symbol","params":{}}`),
+		frame(`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`),
+		frame(`{"jsonrpc":"2.0","method":"exit"}`),
+	}, "")
+
+	var out bytes.Buffer
+	s := NewServer(strings.NewReader(input), &out, "test")
+	if err := s.Serve(); err != nil {
+		t.Fatalf("Serve failed: %s", err)
+	}
+
+	messages := readMessages(t, out.Bytes())
+	if len(messages) != 5 {
+		t.Fatalf("wrong number of messages. want=5, got=%d: %v", len(messages), messages)
+	}
+
+	capabilities := messages[0]["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	if capabilities["hoverProvider"] != true || capabilities["definitionProvider"] != true {
+		t.Errorf("wrong capabilities. got=%v", capabilities)
+	}
+
+	diagnostics := messages[1]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	if len(diagnostics) != 1 || diagnostics[0].(map[string]interface{})["message"] != "undefined variable c" {
+		t.Errorf("wrong diagnostics. got=%v", diagnostics)
+	}
+
+	location := messages[2]["result"].(map[string]interface{})
+	start := location["range"].(map[string]interface{})["start"].(map[string]interface{})
+	if location["uri"] != "file:///a.mky" || start["line"] != 0.0 || start["character"] != 4.0 {
+		t.Errorf("wrong definition. got=%v", location)
+	}
+
+	if code := messages[3]["error"].(map[string]interface{})["code"]; code != float64(codeMethodNotFound) {
+		t.Errorf("wrong error for an unknown method. got=%v", code)
+	}
+
+	if _, ok := messages[4]["result"]; !ok || messages[4]["id"] != 4.0 {
+		t.Errorf("wrong shutdown response. got=%v", messages[4])
+	}
+}
+
+func TestServeExitWithoutShutdown(t *testing.T) {
+	input := frame(`{"jsonrpc":"2.0","method":"exit"}`)
+
+	s := NewServer(strings.NewReader(input), &bytes.Buffer{}, "test")
+	if err := s.Serve(); err != ErrNoShutdown {
+		t.Errorf("wrong error. want=%v, got=%v", ErrNoShutdown, err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"monkey/compiler"
+	"monkey/imports"
+	"monkey/repl"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// payloadMagic ends an executable built by `monkey build`. It is preceded by
+// the serialized bytecode and its length as a big-endian uint64.
+const payloadMagic = "MONKEYBC"
+
+// trailerSize is the size of the length and magic following the bytecode
+const trailerSize = 8 + len(payloadMagic)
+
+// buildExecutable implements `monkey build <file> [-o output]`. The script is
+// compiled and its bytecode appended to a copy of the running interpreter,
+// which then runs the bytecode instead of behaving as monkey. An output
+// ending in .mkyc gets the bytecode alone, which `monkey run` runs.
+func buildExecutable(args []string, cfg *config) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := flags.String("o", "", "write the executable to `file` (default: the script name without extension)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey build <file> [-o output]")
+		flags.PrintDefaults()
+	}
+
+	// Flags may come before or after the script name
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+	script := flags.Arg(0)
+	cfg.resolver().SetScript(script)
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return repl.ExitUsage
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(script), filepath.Ext(script))
+	}
+
+	if imports.IsCompiled(*output) {
+		return exitCode(repl.WriteModule(script, *output, os.Stderr, cfg.options(os.Stderr)))
+	}
+
+	bytecode, err := repl.CompileScript(script, os.Stderr, cfg.options(os.Stderr))
+	if err != nil {
+		return exitCode(err)
+	}
+
+	if err := writeExecutable(*output, bytecode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error building %s: %s\n", *output, err)
+		return repl.ExitRuntimeError
+	}
+	return 0
+}
+
+// writeExecutable writes a copy of the running interpreter with the bytecode appended
+func writeExecutable(output string, bytecode *compiler.Bytecode) error {
+	payload, err := bytecode.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	interpreter, err := os.ReadFile(self)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	out.Write(interpreter)
+	out.Write(payload)
+	binary.Write(&out, binary.BigEndian, uint64(len(payload)))
+	out.WriteString(payloadMagic)
+
+	return os.WriteFile(output, out.Bytes(), 0755)
+}
+
+// embeddedProgram returns the bytecode appended to the running executable by
+// `monkey build`, if any
+func embeddedProgram() (*compiler.Bytecode, bool, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, false, nil
+	}
+
+	f, err := os.Open(self)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Size() < int64(trailerSize) {
+		return nil, false, nil
+	}
+
+	trailer := make([]byte, trailerSize)
+	if _, err := f.ReadAt(trailer, info.Size()-int64(trailerSize)); err != nil {
+		return nil, false, nil
+	}
+	if string(trailer[8:]) != payloadMagic {
+		return nil, false, nil
+	}
+
+	size := int64(binary.BigEndian.Uint64(trailer[:8]))
+	start := info.Size() - int64(trailerSize) - size
+	if size <= 0 || start < 0 {
+		return nil, true, fmt.Errorf("corrupt embedded program")
+	}
+
+	payload := make([]byte, size)
+	if _, err := f.ReadAt(payload, start); err != nil && err != io.EOF {
+		return nil, true, err
+	}
+
+	bytecode, err := compiler.UnmarshalBytecode(payload)
+	return bytecode, true, err
+}
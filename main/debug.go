@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"monkey/object"
+	"monkey/repl"
+	"os"
+)
+
+// debugScript implements `monkey debug [--engine eval|vm] <file> [args...]`,
+// running a script under the interactive debugger. Commands are read from
+// standard input; type help at the (debug) prompt for a list.
+func debugScript(args []string, cfg *config) int {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	engine := flags.String("engine", cfg.engine, "engine used to run the script: eval or vm")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey debug [--engine eval|vm] <file> [args...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+	if flags.NArg() < 1 || !validEngine(*engine) {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+
+	object.SetArgs(flags.Args()[1:])
+	cfg.resolver().SetScript(flags.Arg(0))
+	return exitCode(repl.DebugScript(flags.Arg(0), *engine, os.Stdin, os.Stdout, os.Stderr, cfg.options(os.Stderr)))
+}
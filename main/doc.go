@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"monkey/doc"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/repl"
+	"os"
+	"path/filepath"
+)
+
+// documentFiles implements `monkey doc [files...]`, printing Markdown
+// documentation for the top-level definitions of each file, or for the
+// builtins when no file is given
+func documentFiles(args []string) int {
+	flags := flag.NewFlagSet("doc", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey doc [files...]")
+	}
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Print(doc.Markdown("Builtins", doc.Builtins()))
+		return 0
+	}
+
+	for i, filename := range flags.Args() {
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return repl.ExitUsage
+		}
+
+		p := parser.New(lexer.New(string(source)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			for _, e := range p.Errors() {
+				fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", filename, e.Line, e.Column, e.Message)
+			}
+			return repl.ExitParseError
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(doc.Markdown(filepath.Base(filename), doc.FromProgram(program)))
+	}
+
+	return 0
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto/sha256"
+	"log"
+	"monkey/exthost"
+	"monkey/object"
+	"monkey/wasmext"
+	"os"
+	"path/filepath"
+	"plugin"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// extensionsEnvVar is the environment variable listing directories plugins are loaded from
+const extensionsEnvVar = "MONKEY_EXTENSIONS"
+
+// defaultExtensionsDir is the directory plugins are loaded from when none is given
+const defaultExtensionsDir = "extensions"
+
+// dirList is a flag that may be repeated, each value being a single
+// directory or a list of directories separated like MONKEY_PATH
+type dirList []string
+
+func (l *dirList) String() string {
+	return strings.Join(*l, string(filepath.ListSeparator))
+}
+
+func (l *dirList) Set(value string) error {
+	*l = append(*l, filepath.SplitList(value)...)
+	return nil
+}
+
+// extensionDirs returns the directories plugins are loaded from: those given
+// with --extensions, then those in MONKEY_EXTENSIONS. Without any, the
+// extensions directory of the current directory and of the directory of the
+// executable are used. It reports whether the directories were given
+// explicitly.
+func extensionDirs(cfg *config) ([]string, bool) {
+	dirs := append([]string{}, cfg.extensions...)
+	dirs = append(dirs, filepath.SplitList(os.Getenv(extensionsEnvVar))...)
+	if len(dirs) != 0 {
+		return dirs, true
+	}
+
+	dirs = []string{defaultExtensionsDir}
+	if executable, err := os.Executable(); err == nil {
+		dir := filepath.Join(filepath.Dir(executable), defaultExtensionsDir)
+		if abs, err := filepath.Abs(defaultExtensionsDir); err != nil || abs != dir {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, false
+}
+
+// extensionsInterval is how often the extension directories are checked for
+// changed plugins
+const extensionsInterval = time.Second
+
+// extensionLoader loads the plugins of the extension directories and reloads
+// those that change
+type extensionLoader struct {
+	dirs     []string
+	explicit bool                     // explicit is set when the directories were given rather than the defaults
+	sandbox  bool                     // sandbox refuses plugins that cannot declare their capabilities
+	loaded   map[string]pluginVersion // loaded holds the version of each plugin opened, by path
+}
+
+// pluginVersion identifies the contents of a plugin file
+type pluginVersion struct {
+	modTime time.Time
+	hash    [sha256.Size]byte
+}
+
+// newExtensionLoader returns a loader for the extension directories of cfg
+func newExtensionLoader(cfg *config) *extensionLoader {
+	dirs, explicit := extensionDirs(cfg)
+	return &extensionLoader{dirs: dirs, explicit: explicit, sandbox: cfg.sandbox, loaded: map[string]pluginVersion{}}
+}
+
+// loadAll loads the plugins of every extension directory. Missing
+// directories are only reported when they were given explicitly.
+func (l *extensionLoader) loadAll() {
+	for _, dir := range l.dirs {
+		files, err := pluginFiles(dir)
+		if os.IsNotExist(err) {
+			if l.explicit {
+				log.Printf("Extension directory %s does not exist", dir)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Error loading extensions from %s: %v", dir, err)
+			continue
+		}
+
+		for _, file := range files {
+			l.load(file)
+		}
+
+		executables, _ := executableFiles(dir)
+		for _, file := range executables {
+			if !object.Granted(object.CapExec) {
+				log.Printf("Not starting extension %s: the exec capability is not granted", file)
+				continue
+			}
+			startExtension(file)
+		}
+
+		modules, _ := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		for _, file := range modules {
+			loadModule(file)
+		}
+	}
+}
+
+// watch reloads the plugins that change, and loads those added, until the
+// process ends. Functions of a reloaded plugin are replaced at once, so
+// running sessions call their new version from then on.
+func (l *extensionLoader) watch() {
+	for {
+		time.Sleep(extensionsInterval)
+
+		for _, dir := range l.dirs {
+			files, _ := pluginFiles(dir)
+			for _, file := range files {
+				info, err := os.Stat(file)
+				if err != nil {
+					continue
+				}
+				if version, ok := l.loaded[file]; !ok || !info.ModTime().Equal(version.modTime) {
+					l.load(file)
+				}
+			}
+		}
+	}
+}
+
+// pluginFiles is a helper function that returns the plugin files of dir
+func pluginFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && filepath.Ext(entry.Name()) == ".so" {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// executableFiles is a helper function that returns the extensions of dir
+// run as processes, its executable files
+func executableFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); !entry.Type().IsRegular() || ext == ".so" || ext == ".wasm" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		executable := info.Mode()&0111 != 0
+		if runtime.GOOS == "windows" {
+			executable = strings.EqualFold(filepath.Ext(entry.Name()), ".exe")
+		}
+		if executable {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// startExtension starts the extension in file as a process and registers its
+// functions. The process runs until the interpreter exits.
+func startExtension(file string) {
+	p, err := exthost.Start(file)
+	if err != nil {
+		log.Printf("Error starting extension %s: %v", file, err)
+		return
+	}
+	fns, err := p.Functions()
+	if err != nil {
+		log.Printf("Error starting extension %s: %v", file, err)
+		p.Close()
+		return
+	}
+
+	names := make([]string, 0, len(fns))
+	for _, fn := range fns {
+		fn.Origin = file
+		if err := object.RegisterBuiltin(fn); err != nil {
+			log.Printf("Error registering %s from %s: %v", fn.Name, file, err)
+			continue
+		}
+		names = append(names, fn.Name)
+	}
+	log.Printf("Started extension %s: %s", file, strings.Join(names, ", "))
+}
+
+// loadModule loads the WebAssembly extension in file and registers its
+// functions
+func loadModule(file string) {
+	m, err := wasmext.Load(file)
+	if err != nil {
+		log.Printf("Error loading extension %s: %v", file, err)
+		return
+	}
+	fns, err := m.Functions()
+	if err != nil {
+		log.Printf("Error loading extension %s: %v", file, err)
+		m.Close()
+		return
+	}
+
+	names := make([]string, 0, len(fns))
+	for _, fn := range fns {
+		fn.Origin = file
+		if err := object.RegisterBuiltin(fn); err != nil {
+			log.Printf("Error registering %s from %s: %v", fn.Name, file, err)
+			continue
+		}
+		names = append(names, fn.Name)
+	}
+	log.Printf("Loaded extension %s: %s", file, strings.Join(names, ", "))
+}
+
+// load opens the plugin in file, or reopens it when it was loaded before
+// and its contents changed, and registers its functions. Errors are logged
+// and the plugin skipped.
+func (l *extensionLoader) load(file string) {
+	info, err := os.Stat(file)
+	if err != nil {
+		log.Printf("Error loading plugin %s: %v", file, err)
+		return
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		log.Printf("Error loading plugin %s: %v", file, err)
+		return
+	}
+
+	version := pluginVersion{modTime: info.ModTime(), hash: sha256.Sum256(content)}
+	previous, reloading := l.loaded[file]
+	l.loaded[file] = version
+	if reloading && previous.hash == version.hash {
+		return
+	}
+
+	// A path is only opened once by the plugin package, a new version is
+	// opened from a copy
+	path := file
+	if reloading {
+		if path, err = copyPlugin(content); err != nil {
+			log.Printf("Error reloading plugin %s: %v", file, err)
+			return
+		}
+		defer os.Remove(path)
+	}
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		log.Printf("Error loading plugin %s: %v", file, err)
+		return
+	}
+
+	symbol, err := p.Lookup("Register")
+	if err != nil {
+		log.Printf("Error looking up Register in %s: %v", file, err)
+		return
+	}
+
+	loaded := "Loaded"
+	if reloading {
+		loaded = "Reloaded"
+	}
+
+	// Plugins may declare their version as var Version string
+	declared := ""
+	if symbol, err := p.Lookup("Version"); err == nil {
+		if v, ok := symbol.(*string); ok {
+			declared = *v
+			loaded += " version " + declared + " of"
+		}
+	}
+
+	switch register := symbol.(type) {
+	case func() []object.Plugin:
+		fns := register()
+		for i := range fns {
+			fns[i].Origin = file
+			if fns[i].Version == "" {
+				fns[i].Version = declared
+			}
+		}
+		names := make([]string, 0, len(fns))
+		if reloading {
+			if err := object.ReplaceBuiltins(fns); err != nil {
+				log.Printf("Error reloading plugin %s: %v", file, err)
+				return
+			}
+			for _, fn := range fns {
+				names = append(names, fn.Name)
+			}
+		} else {
+			for _, fn := range fns {
+				if err := object.RegisterBuiltin(fn); err != nil {
+					log.Printf("Error registering %s from %s: %v", fn.Name, file, err)
+					continue
+				}
+				names = append(names, fn.Name)
+			}
+		}
+		log.Printf("%s plugin %s: %s", loaded, file, strings.Join(names, ", "))
+	case func():
+		// Plugins written against the first API register their
+		// functions themselves, which logs them
+		if l.sandbox {
+			log.Printf("Not loading plugin %s: it cannot declare its capabilities in sandbox mode", file)
+			return
+		}
+		object.RegisterFunctionsFrom(file, declared, register)
+		log.Printf("%s plugin %s", loaded, file)
+	default:
+		log.Printf("Register symbol in %s is not a function", file)
+	}
+}
+
+// copyPlugin is a helper function that writes the contents of a plugin to a
+// temporary file and returns its path
+func copyPlugin(content []byte) (string, error) {
+	f, err := os.CreateTemp("", "monkey-plugin-*.so")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExtensionDirs(t *testing.T) {
+	t.Setenv(extensionsEnvVar, "")
+	dirs, explicit := extensionDirs(&config{})
+	if explicit || len(dirs) == 0 || dirs[0] != defaultExtensionsDir {
+		t.Errorf("wrong default directories: %q %t", dirs, explicit)
+	}
+
+	list := strings.Join([]string{"c", "d"}, string(filepath.ListSeparator))
+	t.Setenv(extensionsEnvVar, list)
+	dirs, explicit = extensionDirs(&config{extensions: dirList{"a", "b"}})
+	if expected := []string{"a", "b", "c", "d"}; !explicit || !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("wrong directories. want=%q, got=%q %t", expected, dirs, explicit)
+	}
+}
+
+func TestLoadExtensions(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	empty := t.TempDir()
+	missing := filepath.Join(empty, "missing")
+
+	// Missing directories are only reported when they were given
+	for _, tt := range []struct {
+		dirs     []string
+		explicit bool
+		expected string
+	}{
+		{[]string{empty}, true, ""},
+		{[]string{missing}, true, "Extension directory " + missing + " does not exist\n"},
+		{[]string{missing}, false, ""},
+		{[]string{empty, missing, empty}, true, "Extension directory " + missing + " does not exist\n"},
+	} {
+		logged.Reset()
+		l := &extensionLoader{dirs: tt.dirs, explicit: tt.explicit, loaded: map[string]pluginVersion{}}
+		l.loadAll()
+		if logged.String() != tt.expected {
+			t.Errorf("wrong log loading %q (explicit %t). want=%q, got=%q", tt.dirs, tt.explicit, tt.expected, logged.String())
+		}
+		if len(l.loaded) != 0 {
+			t.Errorf("plugins loaded from %q: %v", tt.dirs, l.loaded)
+		}
+	}
+
+	// A directory given with a file that is no plugin reports it
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.so"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logged.Reset()
+	l := newExtensionLoader(&config{extensions: dirList{dir}})
+	l.loadAll()
+	if !strings.HasPrefix(logged.String(), "Error loading plugin "+filepath.Join(dir, "broken.so")) {
+		t.Errorf("wrong log loading a broken plugin: %q", logged.String())
+	}
+}
+
+func TestExtensionFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{
+		"a.so":     0644,
+		"b.so":     0755,
+		"m.wasm":   0755,
+		"tool":     0755,
+		"notes.md": 0644,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.so"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	plugins, err := pluginFiles(dir)
+	sort.Strings(plugins)
+	if expected := []string{filepath.Join(dir, "a.so"), filepath.Join(dir, "b.so")}; err != nil || !reflect.DeepEqual(plugins, expected) {
+		t.Errorf("wrong plugins. want=%q, got=%q (%v)", expected, plugins, err)
+	}
+
+	if runtime.GOOS != "windows" {
+		executables, err := executableFiles(dir)
+		if expected := []string{filepath.Join(dir, "tool")}; err != nil || !reflect.DeepEqual(executables, expected) {
+			t.Errorf("wrong executables. want=%q, got=%q (%v)", expected, executables, err)
+		}
+	}
+
+	if _, err := pluginFiles(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("wrong error listing a missing directory: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"monkey/format"
+	"monkey/repl"
+	"os"
+)
+
+// formatFiles implements `monkey fmt [--check] [files...]`. Files are
+// rewritten in place; without files the program on standard input is
+// formatted to standard output. With --check nothing is written and the
+// files that are not formatted are listed instead.
+func formatFiles(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	check := flags.Bool("check", false, "list files whose formatting differs and exit with status 1")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey fmt [--check] [files...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+
+	if flags.NArg() == 0 {
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading standard input: %s\n", err)
+			return repl.ExitRuntimeError
+		}
+
+		formatted, err := formatSource("<stdin>", string(source))
+		if err != nil {
+			return repl.ExitParseError
+		}
+		if *check {
+			if formatted != string(source) {
+				fmt.Println("<stdin>")
+				return repl.ExitRuntimeError
+			}
+			return 0
+		}
+		fmt.Print(formatted)
+		return 0
+	}
+
+	status := 0
+	for _, filename := range flags.Args() {
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			status = repl.ExitUsage
+			continue
+		}
+
+		formatted, err := formatSource(filename, string(source))
+		if err != nil {
+			status = repl.ExitParseError
+			continue
+		}
+		if formatted == string(source) {
+			continue
+		}
+
+		if *check {
+			fmt.Println(filename)
+			if status == 0 {
+				status = repl.ExitRuntimeError
+			}
+			continue
+		}
+
+		if err := os.WriteFile(filename, []byte(formatted), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			status = repl.ExitRuntimeError
+		}
+	}
+
+	return status
+}
+
+// formatSource formats a program, printing any parser errors with the name of its file
+func formatSource(filename, source string) (string, error) {
+	formatted, err := format.Source(source)
+	if formatErr, ok := err.(*format.Error); ok {
+		for _, e := range formatErr.Errors {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", filename, e.Line, e.Column, e.Message)
+		}
+	}
+	return formatted, err
+}
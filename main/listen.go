@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"monkey/repl"
+	"monkey/server"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// listenREPL implements `monkey repl --listen addr`, serving a REPL session
+// to editors and notebooks connecting to addr, see repl.Serve, until the
+// process is interrupted or terminated
+func listenREPL(args []string, cfg *config) int {
+	flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+	listen := flags.String("listen", "", "`address` to serve the session on: host:port, or unix:path for a unix socket")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey repl [--listen addr]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+	if *listen == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+
+	l, err := server.Listen(*listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return repl.ExitUsage
+	}
+	log.Printf("Serving a REPL session on %s with the %s engine", l.Addr(), cfg.engine)
+
+	// Closing the listener removes a unix socket
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		l.Close()
+	}()
+
+	if err := repl.Serve(l, cfg.engine, repl.Options{Version: version}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return repl.ExitRuntimeError
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"monkey/lsp"
+	"monkey/repl"
+	"os"
+)
+
+// serveLSP implements `monkey lsp`, running a language server for an editor
+// over standard input and output. The --stdio flag editors pass is accepted
+// since standard input and output are the only transport.
+func serveLSP(args []string) int {
+	for _, arg := range args {
+		if arg != "--stdio" {
+			fmt.Fprintln(os.Stderr, "usage: monkey lsp [--stdio]")
+			return repl.ExitUsage
+		}
+	}
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout, version).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return repl.ExitRuntimeError
+	}
+	return 0
+}
@@ -1,46 +1,316 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
+	"monkey/imports"
+	"monkey/object"
 	"monkey/repl"
 	"os"
 	"os/user"
-	"plugin"
+	"path/filepath"
 )
 
-func main() {
+// version is the interpreter version, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// config holds the global command line flags
+type config struct {
+	engine       string  // engine is the engine used to execute code, eval or vm
+	extensions   dirList // extensions lists the directories plugins are loaded from before MONKEY_EXTENSIONS
+	noExtensions bool
+	noColor      bool
+	quiet        bool // quiet suppresses the REPL greeting
+	version      bool
+	eval         string // eval is a program given on the command line with -e
+	record       string // record is the file the REPL session is recorded to
+	path         string // path lists directories searched for imports before MONKEY_PATH
+	sandbox      bool   // sandbox withholds the capabilities not allowed, refusing remote imports
+	allow        string // allow lists the capabilities granted in sandbox mode
+	workers      int    // workers is the number of goroutines large tensor operations are split across
+	ordered      bool   // ordered iterates over the pairs of hashes sorted by key
+
+	imports *imports.Resolver // imports finds the files imported by the programs run, made by resolver
+}
+
+const usage = `usage: monkey [flags] [command] [arguments]
+       monkey [flags] -e <program> [args...]
+
+Commands:
+  repl                   start an interactive session (default), or run
+                         the program piped to standard input
+  repl --listen <addr>   serve an interactive session to editors and
+                         notebooks connecting to addr, see below
+  run <file> [args]      run a script, passing it the arguments as args(),
+                         or a .mkyc file of bytecode on the VM
+  run --trace <out.json> <file> [args]
+                         run a script recording its calls and allocations
+                         as a Chrome trace, for flame graph viewers
+  test [--cover] [paths]
+                         run the scripts named *_test.mky in paths (.),
+                         reporting the lines of the files they run that
+                         they covered with --cover
+  profile <file> [args]  run a script on the VM, reporting the time spent in
+                         each function and writing a Go CPU profile
+  debug <file> [args]    run a script under the interactive debugger
+  build <file> [-o out]  compile a script into a standalone executable, or
+                         into bytecode for monkey run when out ends in .mkyc
+  compile <file>         compile and run a script line by line on the VM
+  fmt [--check] [files]  format scripts in place, or standard input
+  doc [files]            print Markdown documentation for scripts, or the builtins
+  replay <file>          re-execute a session recorded with --record
+  serve [--listen addr] <file>
+                         serve the top-level functions of a script over
+                         msgpack to clients connecting to addr (:7777)
+  lsp                    run a language server for editors on standard input/output
+  viz <file> [--format dot]
+                         print a Graphviz graph of the AST and bytecode
+
+Exit status is 0 on success, 1 for runtime errors, 2 for usage errors,
+3 for parser errors, 4 for compile errors and n for exit(n).
+
+Imports that do not start with ./ or ../ are looked up in the current
+directory, then in the directory of the script run, then in the directories
+given with --path, then in the directories listed in the MONKEY_PATH
+environment variable. Imports starting with std/ load the embedded standard
+library: std/arrays, std/math, std/strings and std/result. URLs import remote
+modules, which must be pinned with their checksum:
+import "https://example.com/lib.mky" sha256:<hex>;
+Imported files are compiled once and cached next to them as .mkyc files,
+which the vm engine can import on their own: import "lib.mkyc";
+
+Extension plugins (.so files) are loaded from the directories given with
+--extensions, then from those listed in the MONKEY_EXTENSIONS environment
+variable. Executable files in these directories are started as extensions
+speaking msgpack over their standard input and output, see package exthost,
+and WebAssembly modules (.wasm files) are loaded sandboxed, see package
+wasmext. Without any, the extensions directory of the current directory and
+of the directory of the monkey executable are used. Plugins rebuilt while
+the REPL or the language server runs are reloaded. Functions named like a
+builtin or like a function of another plugin are refused; plugins() and the
+:plugins REPL command list the plugins loaded.
+
+Extension functions declare the capabilities they need: fs, net, exec and
+env. With --sandbox, only those given with --allow are granted and calls to
+functions needing others fail. Extensions are then only started as processes
+with exec, and with the environment only with env; remote imports need net
+and tensor_save() and tensor_load() need fs. Plugins written against the
+first plugin API cannot declare capabilities and are not loaded in sandbox
+mode.
+
+Element-wise operations and matrix products on large tensors are split
+across goroutines, one per CPU unless --tensor-workers is given.
 
-	loadExtensions("extensions")
+The session served by repl --listen is shared by all the clients. They send
+JSON requests, one per line, naming an operation with "op" and carrying an
+"id" repeated by the responses, the last of which has a "status" listing
+"done". The operations are eval ("code"), interrupt ("interrupt-id"),
+complete ("prefix") and describe.
 
-	user, err := user.Current()
+Hashes are printed and iterated over in no particular order, which changes
+from run to run. With --ordered-hashes, their pairs are sorted by key:
+booleans, then integers, then strings.
+
+Flags:
+`
+
+func main() {
+	// An executable made by `monkey build` runs its program with all arguments
+	if bytecode, ok, err := embeddedProgram(); ok {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading embedded program: %s\n", err)
+			os.Exit(repl.ExitCrash)
+		}
+		object.SetArgs(os.Args[1:])
+		os.Exit(exitCode(repl.RunBytecode(filepath.Base(os.Args[0]), bytecode, os.Stderr, repl.Options{Color: repl.ColorSupported(os.Stderr)})))
+	}
+
+	cfg, args, err := parseFlags(os.Args[1:])
 	if err != nil {
-		panic(err)
+		os.Exit(repl.ExitUsage)
 	}
-	// Compile a filename
-	if len(os.Args) > 1 && os.Args[1] == "compile" {
-		if len(os.Args) < 3 {
-			fmt.Println("Please provide a filename to compile")
-			return
-		}
-		filename := os.Args[2]
-		repl.CompileFile(filename)
+
+	if cfg.version {
+		fmt.Printf("monkey %s\n", version)
 		return
 	}
 
-	// Read a command line argument for compiler or evaluator
-	if len(os.Args) > 1 && os.Args[1] == "compiler" {
-		fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
-		fmt.Printf("You are using the Monkey compiler\n")
-		repl.StartCompiler(os.Stdin, os.Stdout)
-		return
+	object.SetTensorParallelism(cfg.workers, object.DefaultTensorThreshold)
+	object.SetOrderedHashes(cfg.ordered)
+	if cfg.sandbox {
+		caps, _ := object.ParseCapabilities(cfg.allow)
+		object.SetCapabilities(caps)
+		imports.AllowRemote = object.Granted(object.CapNetwork)
 	}
 
-	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
-		user.Username)
-	fmt.Printf("You are using the Monkey evaluator\n")
-	fmt.Printf("Feel free to type in commands\n")
-	repl.StartEvaluator(os.Stdin, os.Stdout)
+	var extensions *extensionLoader
+	if !cfg.noExtensions {
+		extensions = newExtensionLoader(cfg)
+		extensions.loadAll()
+	}
+
+	if cfg.eval != "" {
+		os.Exit(evalProgram(args, cfg))
+	}
+
+	command := "repl"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "repl":
+		if len(args) > 0 {
+			os.Exit(listenREPL(args, cfg))
+		}
+		// A program piped in is run as a whole rather than line by line
+		if !repl.IsTerminal(os.Stdin) {
+			os.Exit(runStdin(cfg))
+		}
+		if extensions != nil {
+			go extensions.watch()
+		}
+		startREPL(cfg)
+
+	case "run":
+		os.Exit(runScript(args, cfg))
+
+	case "test":
+		os.Exit(testScripts(args, cfg))
+
+	case "build":
+		os.Exit(buildExecutable(args, cfg))
+
+	case "doc":
+		os.Exit(documentFiles(args))
+
+	case "replay":
+		os.Exit(replaySession(args, cfg))
+
+	case "fmt":
+		os.Exit(formatFiles(args))
+
+	case "viz":
+		os.Exit(vizFile(args, cfg))
+
+	case "profile":
+		os.Exit(profileScript(args, cfg))
+
+	case "debug":
+		os.Exit(debugScript(args, cfg))
+
+	case "serve":
+		os.Exit(serveScript(args, cfg))
+
+	case "lsp":
+		if extensions != nil {
+			go extensions.watch()
+		}
+		os.Exit(serveLSP(args))
+
+	case "compile":
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "Please provide a filename to compile")
+			os.Exit(repl.ExitUsage)
+		}
+		cfg.resolver().SetScript(args[0])
+		repl.CompileFile(args[0], cfg.options(os.Stderr))
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, run monkey --help for usage\n", command)
+		os.Exit(repl.ExitUsage)
+	}
+}
+
+// parseFlags parses the global flags, which come before the command, and
+// returns the remaining arguments
+func parseFlags(args []string) (*config, []string, error) {
+	cfg := &config{}
+
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.StringVar(&cfg.engine, "engine", "eval", "engine used to execute code: eval or vm")
+	flags.Var(&cfg.extensions, "extensions", "`dirs` to load extension plugins from, separated like MONKEY_PATH; may be repeated")
+	flags.Var(&cfg.extensions, "extensions-dir", "same as --extensions")
+	flags.BoolVar(&cfg.noExtensions, "no-extensions", false, "do not load extension plugins")
+	flags.BoolVar(&cfg.noColor, "no-color", false, "disable colored output")
+	flags.BoolVar(&cfg.quiet, "quiet", false, "do not print the REPL greeting")
+	flags.BoolVar(&cfg.version, "version", false, "print the version and exit")
+	flags.StringVar(&cfg.record, "record", "", "record the REPL session to `file` for monkey replay")
+	flags.BoolVar(&cfg.sandbox, "sandbox", false, "grant extensions and scripts only the capabilities given with --allow")
+	flags.StringVar(&cfg.allow, "allow", "", "`capabilities` granted in sandbox mode, separated by commas: fs, net, exec, env or all")
+	flags.IntVar(&cfg.workers, "tensor-workers", 0, "split large tensor operations across `n` goroutines, one per CPU when 0")
+	flags.BoolVar(&cfg.ordered, "ordered-hashes", false, "iterate over the pairs of hashes sorted by key, for output that is the same on every run")
+	flags.StringVar(&cfg.path, "path", "", "`dirs` searched for imports, separated like MONKEY_PATH")
+	flags.StringVar(&cfg.eval, "e", "", "run `program` and exit")
+	flags.StringVar(&cfg.eval, "eval", "", "run `program` and exit (same as -e)")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := object.ParseCapabilities(cfg.allow); err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		return nil, nil, err
+	}
+
+	if !validEngine(cfg.engine) {
+		err := fmt.Errorf("invalid engine %q, want eval or vm", cfg.engine)
+		fmt.Fprintln(flags.Output(), err)
+		return nil, nil, err
+	}
+
+	return cfg, flags.Args(), nil
+}
+
+// validEngine reports whether name is an engine the interpreter provides
+func validEngine(name string) bool {
+	return name == "eval" || name == "vm"
+}
+
+// options returns the REPL options for output written to f
+func (cfg *config) options(f *os.File) repl.Options {
+	return repl.Options{Color: !cfg.noColor && repl.ColorSupported(f), Record: cfg.record, Imports: cfg.resolver()}
+}
+
+// resolver returns the resolver finding the imports of the programs run,
+// searching the directories of --path before those of MONKEY_PATH
+func (cfg *config) resolver() *imports.Resolver {
+	if cfg.imports == nil {
+		cfg.imports = imports.NewResolver()
+		if cfg.path != "" {
+			cfg.imports.AddSearchPath(cfg.path)
+		}
+	}
+	return cfg.imports
+}
+
+// startREPL greets the user and starts an interactive session on the configured engine
+func startREPL(cfg *config) {
+	if !cfg.quiet {
+		name := "there"
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+
+		fmt.Printf("Hello %s! This is the Monkey programming language!\n", name)
+		if cfg.engine == "vm" {
+			fmt.Printf("You are using the Monkey compiler\n")
+		} else {
+			fmt.Printf("You are using the Monkey evaluator\n")
+		}
+		fmt.Printf("Feel free to type in commands\n")
+	}
+
+	if cfg.engine == "vm" {
+		repl.StartCompiler(os.Stdin, os.Stdout, cfg.options(os.Stdout))
+		return
+	}
+	repl.StartEvaluator(os.Stdin, os.Stdout, cfg.options(os.Stdout))
 }
 
 // Output:
@@ -51,37 +321,3 @@ func main() {
 // {Type:LET Literal:let}
 // {Type:IDENT Literal:add}
 // ..
-
-func loadExtensions(dir string) error {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return err
-	}
-
-	for _, file := range files {
-		if !file.IsDir() && file.Type().IsRegular() && file.Name()[len(file.Name())-3:] == ".so" {
-			extPath := dir + "/" + file.Name()
-			p, err := plugin.Open(extPath)
-			if err != nil {
-				log.Printf("Error loading plugin %s: %v", extPath, err)
-				continue
-			}
-
-			symbol, err := p.Lookup("Register")
-			if err != nil {
-				log.Printf("Error looking up Register in %s: %v", extPath, err)
-				continue
-			}
-
-			registerFunc, ok := symbol.(func())
-			if !ok {
-				log.Printf("Register symbol in %s is not a function", extPath)
-				continue
-			}
-
-			registerFunc()
-		}
-	}
-
-	return nil
-}
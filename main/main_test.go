@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected config
+		rest     []string
+	}{
+		{nil, config{engine: "eval"}, nil},
+		{[]string{"--engine", "vm", "run", "a.mky", "--engine", "eval"}, config{engine: "vm"}, []string{"run", "a.mky", "--engine", "eval"}},
+		{[]string{"--quiet", "--no-color", "--version"}, config{engine: "eval", quiet: true, noColor: true, version: true}, nil},
+		{[]string{"--no-extensions", "--extensions", "a" + string(filepath.ListSeparator) + "b", "--extensions-dir", "c"}, config{engine: "eval", noExtensions: true, extensions: dirList{"a", "b", "c"}}, nil},
+		{[]string{"-e", "puts(1)", "x"}, config{engine: "eval", eval: "puts(1)"}, []string{"x"}},
+		{[]string{"--eval=puts(2)"}, config{engine: "eval", eval: "puts(2)"}, nil},
+		{[]string{"--sandbox", "--allow", "fs,net", "--ordered-hashes", "--tensor-workers", "2"}, config{engine: "eval", sandbox: true, allow: "fs,net", ordered: true, workers: 2}, nil},
+	}
+
+	for _, tt := range tests {
+		cfg, rest, err := parseFlags(tt.args)
+		if err != nil {
+			t.Errorf("parseFlags(%q) failed: %s", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(*cfg, tt.expected) {
+			t.Errorf("wrong flags of %q. want=%+v, got=%+v", tt.args, tt.expected, *cfg)
+		}
+		if len(rest) != len(tt.rest) || len(rest) != 0 && !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("wrong arguments left by %q. want=%q, got=%q", tt.args, tt.rest, rest)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--engine", "jit"},
+		{"--allow", "disk"},
+		{"--unknown"},
+	} {
+		stderr := captureOutput(t, &os.Stderr, func() {
+			if _, _, err := parseFlags(args); err == nil {
+				t.Errorf("parseFlags(%q) succeeded", args)
+			}
+		})
+		if stderr == "" {
+			t.Errorf("parseFlags(%q) printed no error", args)
+		}
+	}
+}
+
+// captureOutput is a helper function that runs fn with *f writing to a
+// temporary file and returns what was written there
+func captureOutput(t *testing.T, f **os.File, fn func()) string {
+	t.Helper()
+	tmp, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+
+	saved := *f
+	*f = tmp
+	defer func() { *f = saved }()
+	fn()
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"monkey/object"
+	"monkey/repl"
+	"monkey/vm"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+)
+
+// profileScript implements `monkey profile [--pprof file] <file> [args...]`.
+// The script runs on the VM with the Monkey profiler installed and the Go
+// CPU profiler running. The time spent in each Monkey function is reported
+// on standard error and the CPU profile of the interpreter itself is written
+// for `go tool pprof`.
+func profileScript(args []string, cfg *config) int {
+	flags := flag.NewFlagSet("profile", flag.ContinueOnError)
+	output := flags.String("pprof", "", "write the Go CPU profile to `file` (default: the script name with a .pprof extension)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey profile [--pprof file] <file> [args...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+	script := flags.Arg(0)
+	cfg.resolver().SetScript(script)
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(script), filepath.Ext(script)) + ".pprof"
+	}
+
+	opts := cfg.options(os.Stderr)
+	bytecode, err := repl.CompileScript(script, os.Stderr, opts)
+	if err != nil {
+		return exitCode(err)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return repl.ExitUsage
+	}
+	defer file.Close()
+
+	if err := pprof.StartCPUProfile(file); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting the CPU profile: %s\n", err)
+		return repl.ExitRuntimeError
+	}
+
+	profiler := vm.NewProfiler()
+	opts.Hooks = profiler.Hooks()
+	object.SetArgs(flags.Args()[1:])
+	runErr := repl.RunBytecode(script, bytecode, os.Stderr, opts)
+
+	pprof.StopCPUProfile()
+
+	fmt.Fprintln(os.Stderr)
+	profiler.WriteReport(os.Stderr)
+	fmt.Fprintf(os.Stderr, "\nGo CPU profile written to %s, see go tool pprof %s\n", *output, *output)
+
+	return exitCode(runErr)
+}
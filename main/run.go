@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"monkey/object"
+	"monkey/repl"
+	"monkey/trace"
+	"os"
+)
+
+// runScript implements `monkey run [--engine eval|vm] [--watch] [--trace file]
+// <file> [args...]` and returns the process exit code. The engine defaults to
+// the global --engine flag. Arguments after the filename are passed to the
+// script as args(). With --watch the script is run again whenever it
+// changes. With --trace the run is recorded as a Chrome trace.
+func runScript(args []string, cfg *config) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	engine := flags.String("engine", cfg.engine, "engine used to run the script: eval or vm")
+	watch := flags.Bool("watch", false, "run the script again whenever it or a file it imports changes")
+	traceFile := flags.String("trace", "", "write the calls and allocations of the run to `file` in the Chrome trace format")
+	detail := flags.Bool("trace-detail", false, "also trace every statement the evaluator runs or every instruction the VM runs")
+	traceCompiler := flags.Bool("trace-compiler", false, "print every instruction the compiler emits to standard error, with --engine vm")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey run [--engine eval|vm] [--watch] [--trace file [--trace-detail]] [--trace-compiler] <file> [args...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+	if flags.NArg() < 1 || !validEngine(*engine) {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+
+	if *watch && *traceFile != "" {
+		fmt.Fprintln(os.Stderr, "--trace records a single run and cannot be used with --watch")
+		return repl.ExitUsage
+	}
+
+	object.SetArgs(flags.Args()[1:])
+	cfg.resolver().SetScript(flags.Arg(0))
+	if *watch {
+		return watchScript(flags.Arg(0), *engine, cfg)
+	}
+	if *traceFile != "" {
+		return traceScript(flags.Arg(0), *engine, *traceFile, trace.Options{Detail: *detail}, cfg)
+	}
+	opts := cfg.options(os.Stderr)
+	if *traceCompiler {
+		opts.Trace = os.Stderr
+	}
+	return exitCode(repl.RunFile(flags.Arg(0), *engine, os.Stderr, opts))
+}
+
+// traceScript runs a script on engine with a tracer installed, writing the
+// trace to the file out, and returns the process exit code
+func traceScript(script, engine, out string, opts trace.Options, cfg *config) int {
+	file, err := os.Create(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return repl.ExitUsage
+	}
+	defer file.Close()
+
+	tracer := trace.New(file, opts)
+	runOpts := cfg.options(os.Stderr)
+	if engine == "vm" {
+		runOpts.Hooks = tracer.VMHooks()
+	} else {
+		runOpts.EvalHooks = tracer.EvaluatorHooks()
+	}
+	runErr := repl.RunFile(script, engine, os.Stderr, runOpts)
+
+	if err := tracer.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the trace: %s\n", err)
+		return repl.ExitRuntimeError
+	}
+	if err := file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the trace: %s\n", err)
+		return repl.ExitRuntimeError
+	}
+	fmt.Fprintf(os.Stderr, "trace written to %s, open it in chrome://tracing, Perfetto or speedscope\n", out)
+	return exitCode(runErr)
+}
+
+// evalProgram runs the program given with -e, passing it args as args(), and
+// returns the process exit code
+func evalProgram(args []string, cfg *config) int {
+	object.SetArgs(args)
+	return exitCode(repl.RunSource("<eval>", cfg.eval, cfg.engine, os.Stderr, cfg.options(os.Stderr)))
+}
+
+// runStdin runs the program read from standard input and returns the process exit code
+func runStdin(cfg *config) int {
+	source, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading standard input: %s\n", err)
+		return repl.ExitRuntimeError
+	}
+
+	return exitCode(repl.RunSource("<stdin>", string(source), cfg.engine, os.Stderr, cfg.options(os.Stderr)))
+}
+
+// exitCode returns the process exit code for the outcome of running a script
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var scriptErr *repl.ScriptError
+	if errors.As(err, &scriptErr) {
+		return scriptErr.Code
+	}
+	return repl.ExitRuntimeError
+}
+
+// replaySession implements `monkey replay <file>`, re-executing a recorded
+// REPL session and reporting where its output differs from the recording
+func replaySession(args []string, cfg *config) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey replay <file>")
+		return repl.ExitUsage
+	}
+
+	if err := repl.Replay(args[0], os.Stdout, cfg.options(os.Stdout)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return repl.ExitRuntimeError
+	}
+	return 0
+}
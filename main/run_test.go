@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunScript(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, source string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	hello := write("hello.mky", `import "greet.mky" as greet; puts(greet.hello(first(args())));`)
+	write("greet.mky", `let hello = fn(name) { "hello " + name }; export hello;`)
+	broken := write("broken.mky", "let x = ;")
+	failing := write("failing.mky", "1 + true;")
+	exiting := write("exiting.mky", "exit(7);")
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+	}{
+		{[]string{hello, "world"}, 0, "hello world\n"},
+		{[]string{"--engine", "vm", hello, "vm"}, 0, "hello vm\n"},
+		{[]string{broken}, 3, ""},
+		{[]string{failing}, 1, ""},
+		{[]string{"--engine", "vm", failing}, 1, ""},
+		{[]string{exiting}, 7, ""},
+		{[]string{"--engine", "jit", hello}, 2, ""},
+		{[]string{"--watch", "--trace", filepath.Join(dir, "t.json"), hello}, 2, ""},
+		{nil, 2, ""},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{"eval", "vm"} {
+			var code int
+			stdout := captureOutput(t, &os.Stdout, func() {
+				captureOutput(t, &os.Stderr, func() {
+					code = runScript(tt.args, &config{engine: engine, noColor: true})
+				})
+			})
+			if code != tt.code {
+				t.Errorf("%s: wrong exit code of run %q. want=%d, got=%d", engine, tt.args, tt.code, code)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("%s: wrong output of run %q. want=%q, got=%q", engine, tt.args, tt.stdout, stdout)
+			}
+		}
+	}
+}
+
+func TestEvalProgram(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lib.mky"), []byte("let twice = fn(x) { x * 2 }; export twice;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		program string
+		args    []string
+		code    int
+		stdout  string
+	}{
+		{"puts(1 + 2)", nil, 0, "3\n"},
+		{"puts(args())", []string{"a", "b"}, 0, "[a, b]\n"},
+		{`import "lib.mky" as lib; puts(lib.twice(21))`, nil, 0, "42\n"},
+		{"let x = ;", nil, 3, ""},
+		{"1 + true", nil, 1, ""},
+		{"puts(1); exit(5)", nil, 5, "1\n"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{"eval", "vm"} {
+			var code int
+			stdout := captureOutput(t, &os.Stdout, func() {
+				captureOutput(t, &os.Stderr, func() {
+					code = evalProgram(tt.args, &config{engine: engine, eval: tt.program, noColor: true})
+				})
+			})
+			if code != tt.code || stdout != tt.stdout {
+				t.Errorf("%s: wrong outcome of -e %q. want=%d %q, got=%d %q", engine, tt.program, tt.code, tt.stdout, code, stdout)
+			}
+		}
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	tests := []struct {
+		program string
+		code    int
+		stdout  string
+	}{
+		// The program is run as a whole, statements spanning lines
+		{"let add = fn(a, b) {\n  a + b\n};\nputs(add(1,\n  2));\n", 0, "3\n"},
+		{"puts(1);\nlet x = ;\n", 3, ""},
+		{"puts(1);\nexit(6);\n", 6, "1\n"},
+		{"", 0, ""},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{"eval", "vm"} {
+			path := filepath.Join(t.TempDir(), "stdin")
+			if err := os.WriteFile(path, []byte(tt.program), 0644); err != nil {
+				t.Fatal(err)
+			}
+			stdin, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var code int
+			saved := os.Stdin
+			os.Stdin = stdin
+			stdout := captureOutput(t, &os.Stdout, func() {
+				captureOutput(t, &os.Stderr, func() {
+					code = runStdin(&config{engine: engine, noColor: true})
+				})
+			})
+			os.Stdin = saved
+			stdin.Close()
+
+			if code != tt.code || stdout != tt.stdout {
+				t.Errorf("%s: wrong outcome of %q piped in. want=%d %q, got=%d %q", engine, tt.program, tt.code, tt.stdout, code, stdout)
+			}
+		}
+	}
+	// A standard input that cannot be read fails the run
+	closed, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	saved := os.Stdin
+	os.Stdin = closed
+	defer func() { os.Stdin = saved }()
+	stderr := captureOutput(t, &os.Stderr, func() {
+		if code := runStdin(&config{engine: "eval"}); code != 1 {
+			t.Errorf("wrong exit code reading a closed standard input: %d", code)
+		}
+	})
+	if !strings.HasPrefix(stderr, "Error reading standard input: ") {
+		t.Errorf("wrong error reading a closed standard input: %q", stderr)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"monkey/object"
+	"monkey/repl"
+	"monkey/server"
+	"monkey/vm"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// serveScript implements `monkey serve [--listen addr] [--timeout d] <file>`.
+// The script runs once, then its top-level functions are served to clients
+// connecting to addr until the process is interrupted or terminated.
+func serveScript(args []string, cfg *config) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := flags.String("listen", ":7777", "`address` to listen on: host:port, or unix:path for a unix socket")
+	timeout := flags.Duration("timeout", 10*time.Second, "stop calls running longer than `duration`, 0 for no limit")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey serve [--listen addr] [--timeout duration] <file> [args...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+	script := flags.Arg(0)
+	cfg.resolver().SetScript(script)
+
+	program, err := repl.ParseScript(script, os.Stderr, cfg.options(os.Stderr))
+	if err != nil {
+		return exitCode(err)
+	}
+
+	object.SetArgs(flags.Args()[1:])
+	s, err := server.Load(program, cfg.resolver())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", script, err)
+		if _, ok := err.(*vm.RuntimeError); ok {
+			return repl.ExitRuntimeError
+		}
+		return repl.ExitCompileError
+	}
+	s.Timeout = *timeout
+
+	l, err := server.Listen(*listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return repl.ExitUsage
+	}
+	log.Printf("Serving %s on %s: %s", script, l.Addr(), strings.Join(s.Names(), ", "))
+
+	// Closing the listener removes a unix socket
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		l.Close()
+	}()
+
+	if err := s.Serve(l); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return repl.ExitRuntimeError
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"monkey/cover"
+	"monkey/object"
+	"monkey/repl"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// testSuffix ends the names of the scripts `monkey test` runs
+const testSuffix = "_test.mky"
+
+// testScripts implements `monkey test [--cover] [paths...]`. Every script
+// whose name ends in _test.mky found in the paths, files or directories
+// searched recursively, runs on its own and passes when it exits with
+// status 0. With --cover, the lines of the files the scripts import that
+// they run are reported, along with those of the scripts themselves when
+// they import none.
+func testScripts(args []string, cfg *config) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	engine := flags.String("engine", cfg.engine, "engine used to run the scripts: eval or vm")
+	cov := flags.Bool("cover", false, "report the lines of the files run that the scripts covered, measured on the evaluator")
+	profile := flags.String("coverprofile", "", "write the number of statements run on every line to `file`, implies --cover")
+	html := flags.String("html", "", "write the coverage as an HTML page to `file`, implies --cover")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey test [--engine eval|vm] [--cover] [--coverprofile file] [--html file] [paths...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+	if !validEngine(*engine) {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+	*cov = *cov || *profile != "" || *html != ""
+	if *cov && *engine != "eval" {
+		fmt.Fprintln(os.Stderr, "coverage is measured on the evaluator, run the scripts with --engine eval")
+		return repl.ExitUsage
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	scripts, err := findTests(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return repl.ExitUsage
+	}
+	if len(scripts) == 0 {
+		fmt.Println("no test files")
+		return 0
+	}
+
+	var coverage *cover.Profile
+	if *cov {
+		coverage = cover.New()
+	}
+
+	failed := 0
+	opts := cfg.options(os.Stderr)
+	if coverage != nil {
+		opts.EvalHooks = coverage.Hooks()
+	}
+	for _, script := range scripts {
+		if coverage != nil {
+			coverage.SetMain(script)
+		}
+		object.SetArgs(nil)
+		cfg.resolver().SetScript(script)
+
+		start := time.Now()
+		status := exitCode(repl.RunFile(script, *engine, os.Stderr, opts))
+		elapsed := time.Since(start).Seconds()
+		if status != 0 {
+			failed++
+			fmt.Printf("FAIL\t%s\t%.3fs (exit status %d)\n", script, elapsed, status)
+		} else {
+			fmt.Printf("ok\t%s\t%.3fs\n", script, elapsed)
+		}
+	}
+
+	if coverage != nil {
+		if err := reportCoverage(coverage, *profile, *html); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return repl.ExitRuntimeError
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL\t%d of %d test scripts failed\n", failed, len(scripts))
+		return repl.ExitRuntimeError
+	}
+	return 0
+}
+
+// findTests returns the test scripts found in paths: the files given and
+// those whose names end in _test.mky in the directories given
+func findTests(paths []string) ([]string, error) {
+	var scripts []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			scripts = append(scripts, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.HasSuffix(name, testSuffix) {
+				scripts = append(scripts, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scripts, nil
+}
+
+// reportCoverage prints the coverage of the files run and writes the
+// profile and the HTML page asked for
+func reportCoverage(coverage *cover.Profile, profile, html string) error {
+	files, err := coverage.Files(func(path string) bool { return !strings.HasSuffix(path, testSuffix) })
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		// The scripts import nothing and cover themselves
+		if files, err = coverage.Files(func(string) bool { return true }); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("\ncoverage:")
+	cover.WriteReport(os.Stdout, files)
+
+	if profile != "" {
+		if err := writeFile(profile, func(f *os.File) error { return cover.WriteProfile(f, files) }); err != nil {
+			return err
+		}
+	}
+	if html != "" {
+		if err := writeFile(html, func(f *os.File) error { return cover.WriteHTML(f, files) }); err != nil {
+			return err
+		}
+		fmt.Printf("coverage report written to %s\n", html)
+	}
+	return nil
+}
+
+// writeFile creates the file name and writes it with write
+func writeFile(name string, write func(f *os.File) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"monkey/compiler"
+	"monkey/repl"
+	"monkey/viz"
+	"os"
+)
+
+// vizFile implements `monkey viz <file> [--format dot] [--graph ast|bytecode|all]`,
+// printing a Graphviz graph of the script's syntax tree and of the control
+// flow of its compiled bytecode, e.g. for `monkey viz fib.mky | dot -Tsvg`
+func vizFile(args []string, cfg *config) int {
+	flags := flag.NewFlagSet("viz", flag.ContinueOnError)
+	format := flags.String("format", "dot", "output `format`, only dot is supported")
+	graph := flags.String("graph", "all", "graph to draw: ast, bytecode or all")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey viz <file> [--format dot] [--graph ast|bytecode|all]")
+		flags.PrintDefaults()
+	}
+
+	// Flags may come before or after the script name
+	if err := flags.Parse(args); err != nil {
+		return repl.ExitUsage
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+	script := flags.Arg(0)
+	cfg.resolver().SetScript(script)
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return repl.ExitUsage
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return repl.ExitUsage
+	}
+
+	if *format != "dot" {
+		fmt.Fprintf(os.Stderr, "unsupported format %q, only dot is supported\n", *format)
+		return repl.ExitUsage
+	}
+
+	graphs, ok := map[string]viz.Graphs{"ast": viz.AST, "bytecode": viz.Bytecode, "all": viz.All}[*graph]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown graph %q, expected ast, bytecode or all\n", *graph)
+		return repl.ExitUsage
+	}
+
+	opts := cfg.options(os.Stderr)
+	program, err := repl.ParseScript(script, os.Stderr, opts)
+	if err != nil {
+		return exitCode(err)
+	}
+
+	var bytecode *compiler.Bytecode
+	if graphs&viz.Bytecode != 0 {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: compilation failed: %s\n", script, err)
+			return repl.ExitCompileError
+		}
+		bytecode = comp.Bytecode()
+	}
+
+	fmt.Print(viz.Dot(program, bytecode, graphs))
+	return 0
+}
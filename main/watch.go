@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/imports"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/repl"
+	"os"
+	"time"
+)
+
+// watchInterval is how often watched files are checked for changes
+const watchInterval = 250 * time.Millisecond
+
+// watchScript implements `monkey run --watch`. The script runs every time it
+// or one of the files it imports changes, on a cleared screen when standard
+// output is a terminal. Errors are reported like any other output and do not
+// stop the watch, which only ends when the process is interrupted.
+func watchScript(script, engine string, cfg *config) int {
+	opts := cfg.options(os.Stderr)
+	clear := repl.IsTerminal(os.Stdout)
+
+	for {
+		if clear {
+			fmt.Print("\033[H\033[2J")
+		}
+		fmt.Fprintf(os.Stderr, "[watch] running %s at %s\n", script, time.Now().Format("15:04:05"))
+
+		status := exitCode(repl.RunFile(script, engine, os.Stderr, opts))
+		fmt.Fprintf(os.Stderr, "[watch] exit status %d, waiting for changes\n", status)
+
+		files := watchedFiles(script, cfg.resolver())
+		before := modTimes(files)
+		for {
+			time.Sleep(watchInterval)
+			if changed(before, modTimes(files)) {
+				break
+			}
+		}
+	}
+}
+
+// watchedFiles returns the script and every file it imports, directly or
+// through other imports, as found by resolver. Files that cannot be read or
+// parsed are still watched so fixing them triggers a run.
+func watchedFiles(script string, resolver *imports.Resolver) []string {
+	files := []string{}
+	seen := map[string]bool{}
+
+	var visit func(filename string)
+	visit = func(filename string) {
+		// The standard library is embedded and never changes
+		if seen[filename] || imports.IsStd(filename) {
+			return
+		}
+		seen[filename] = true
+		files = append(files, filename)
+
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			return
+		}
+		// Relative imports of the script are resolved from the current
+		// directory, those of imported files from their own directory
+		dir := ""
+		if filename != script {
+			dir = imports.Dir(filename)
+		}
+
+		program := parser.New(lexer.New(string(source))).ParseProgram()
+		ast.Inspect(program, func(node ast.Node) bool {
+			if imp, ok := node.(*ast.ImportLiteral); ok && !imports.IsRemote(imp.Path) {
+				if filename, err := resolver.Resolve(imp.Path, "", dir); err == nil {
+					visit(filename)
+				} else {
+					visit(imp.Path)
+				}
+			}
+			return true
+		})
+	}
+	visit(script)
+
+	return files
+}
+
+// modTimes returns the modification time of each file, the zero time for
+// files that do not exist
+func modTimes(files []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			times[file] = info.ModTime()
+		} else {
+			times[file] = time.Time{}
+		}
+	}
+	return times
+}
+
+// changed reports whether any modification time differs between two snapshots
+func changed(before, after map[string]time.Time) bool {
+	for file, t := range after {
+		if !before[file].Equal(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,388 @@
+// monkey.go
+
+// Package monkey embeds the Monkey interpreter in Go programs. An
+// Interpreter keeps its globals between calls to Eval, so a program can
+// define functions in Monkey and call them from Go, or give scripts values
+// and functions written in Go:
+//
+//	interp := monkey.New(monkey.Options{})
+//	interp.RegisterFunc("greet", func(args ...object.Object) object.Object {
+//		return &object.String{Value: "hello " + args[0].Inspect()}
+//	})
+//	interp.Eval(`let twice = fn(x) { greet(x) + "!" };`)
+//	result, err := interp.Call("twice", "world")
+//
+// A server running the same script for every request compiles it once into
+// a Program, which any number of goroutines run at once, each run on an
+// interpreter of its own:
+//
+//	program, err := monkey.Compile(`let handle = fn(path) { "you asked for " + path };`, monkey.Options{})
+//	// for every request
+//	interp, _, err := program.Run(w)
+//	result, err := interp.Call("handle", r.URL.Path)
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/diagnostic"
+	"monkey/evaluator"
+	"monkey/imports"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+	"time"
+)
+
+// Engines an interpreter can run programs with
+const (
+	EngineVM        = "vm"
+	EngineEvaluator = "eval"
+)
+
+// Options configures an interpreter. The builtins of interpreters of one
+// process do not share their state: each interpreter has its own random
+// numbers, seeded by seed(), and its own arguments and extension functions,
+// unless interpreters are given the same registry.
+type Options struct {
+	Engine    string              // Engine is EngineVM, the default, or EngineEvaluator
+	Output    io.Writer           // Output is where puts and the other builtins write, os.Stdout when nil
+	Sandbox   []object.Capability // Sandbox lists the capabilities the builtins may use of those granted, all of them when nil
+	Args      []string            // Args are the arguments of the program returned by args(), those of object.SetArgs when nil
+	Seed      int64               // Seed seeds the random numbers of the interpreter, seeded from the time when 0
+	Functions *object.Registry    // Functions holds extension functions of the interpreter, found before those of the process
+	Imports   *imports.Resolver   // Imports finds the files the interpreter imports, in the directories of MONKEY_PATH when nil
+}
+
+// Interpreter runs Monkey programs sharing their globals. It is not safe for
+// concurrent use.
+type Interpreter struct {
+	engine   string
+	builtins object.Context // builtins is the context builtins are called in by both engines
+
+	// Evaluator state
+	env *object.Environment
+
+	// Compiler and VM state
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+}
+
+// New returns an interpreter with no globals defined
+func New(opts Options) *Interpreter {
+	engine := opts.Engine
+	if engine == "" {
+		engine = EngineVM
+	}
+
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	symbolTable.Functions = opts.Functions
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	interp := &Interpreter{
+		engine: engine,
+		builtins: object.Context{
+			Out:       opts.Output,
+			Sandbox:   opts.Sandbox,
+			Args:      opts.Args,
+			Random:    object.NewRandom(seed),
+			Functions: opts.Functions,
+			Engine:    evaluator.Engine,
+			Modules:   evaluator.NewModules(),
+			Imports:   opts.Imports,
+		},
+		env:         object.NewEnvironment(),
+		symbolTable: symbolTable,
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalsSize),
+	}
+	interp.env.SetContext(&interp.builtins)
+	return interp
+}
+
+// Program is a compiled program. Its bytecode, constants and the modules it
+// imports are shared by the interpreters running it, which only read them,
+// so a Program can be run by any number of goroutines at once.
+type Program struct {
+	engine string
+	opts   Options // opts are the options of the interpreters running the program
+
+	// Evaluator state
+	program *ast.Program
+
+	// Compiler and VM state
+	symbols  compiler.SymbolTableSnapshot
+	bytecode *compiler.Bytecode
+}
+
+// Compile parses and compiles the program src for the engine of opts, to be
+// run by Program.Run, which sets the output of every run
+func Compile(src string, opts Options) (*Program, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(diagnostic.Messages(p.Errors()), "\n\t"))
+	}
+
+	interp := New(opts)
+	if interp.engine != EngineVM {
+		evaluator.Resolve(program)
+		return &Program{engine: interp.engine, opts: opts, program: program}, nil
+	}
+
+	comp := compiler.NewWithState(interp.symbolTable, interp.constants)
+	comp.SetResolver(opts.Imports)
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	return &Program{engine: interp.engine, opts: opts, symbols: interp.symbolTable.Snapshot(), bytecode: comp.Bytecode()}, nil
+}
+
+// Check lexes, parses and compiles the program src without running it, and
+// returns the problems found, none for a program that compiles. The errors
+// of the lexer and the parser are all returned; the program is compiled only
+// when there are none, and the compiler stops at its first error.
+func Check(src string) []diagnostic.Diagnostic {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return errors
+	}
+
+	err := compiler.New().Compile(program)
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *diagnostic.Diagnostic:
+		return []diagnostic.Diagnostic{*err}
+	case *compiler.ImportError:
+		return []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: err.Error(), Line: err.Line, Column: err.Column}}
+	default:
+		return []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: err.Error()}}
+	}
+}
+
+// Run runs the program on a new interpreter writing to out, os.Stdout when
+// nil, and returns the interpreter, holding the globals the program defined,
+// and the value of the last expression of the program. The globals, the
+// stack and the environments of every run are its own. Code the interpreter
+// evaluates later is compiled against the globals of the program without
+// changing the program.
+func (p *Program) Run(out io.Writer) (interp *Interpreter, result object.Object, err error) {
+	opts := p.opts
+	opts.Output = out
+	interp = New(opts)
+	defer recoverError(&err)
+
+	if p.engine != EngineVM {
+		result, err = objectResult(evaluator.Eval(p.program, interp.env))
+		return interp, result, err
+	}
+
+	interp.symbolTable.Restore(p.symbols, nil)
+	// Constants compiled later are appended to a copy of the shared ones
+	interp.constants = p.bytecode.Constants[:len(p.bytecode.Constants):len(p.bytecode.Constants)]
+
+	machine := vm.NewWithGlobalsStore(p.bytecode, interp.globals)
+	machine.SetContext(interp.builtins)
+	if err := machine.Run(); err != nil {
+		return interp, nil, err
+	}
+	result = machine.LastPoppedStackElem()
+	if result == nil {
+		result = vm.Null
+	}
+	result, err = objectResult(result)
+	return interp, result, err
+}
+
+// Eval runs the program src and returns the value of its last expression.
+// Errors of the program, from parsing to running it, are returned as errors.
+func (i *Interpreter) Eval(src string) (result object.Object, err error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(diagnostic.Messages(p.Errors()), "\n\t"))
+	}
+
+	defer recoverError(&err)
+	if i.engine == EngineVM {
+		return i.runVM(program)
+	}
+	return objectResult(evaluator.Eval(program, i.env))
+}
+
+// runVM is a helper function that compiles the program against the globals
+// of the interpreter and runs it. Globals the program defined are forgotten
+// when it fails.
+func (i *Interpreter) runVM(program *ast.Program) (object.Object, error) {
+	snapshot := i.symbolTable.Snapshot()
+
+	comp := compiler.NewWithState(i.symbolTable, i.constants)
+	comp.SetResolver(i.builtins.Imports)
+	if err := comp.Compile(program); err != nil {
+		i.symbolTable.Restore(snapshot, nil)
+		return nil, err
+	}
+	bytecode := comp.Bytecode()
+	i.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobalsStore(bytecode, i.globals)
+	machine.SetContext(i.builtins)
+	if err := machine.Run(); err != nil {
+		i.symbolTable.Restore(snapshot, func(symbol compiler.Symbol) bool {
+			return symbol.Scope == compiler.GlobalScope && i.globals[symbol.Index] != nil
+		})
+		return nil, err
+	}
+
+	result := machine.LastPoppedStackElem()
+	if result == nil {
+		result = vm.Null
+	}
+	return objectResult(result)
+}
+
+// Call calls the global function name with args, which are converted to
+// Monkey values, and returns its result
+func (i *Interpreter) Call(name string, args ...interface{}) (result object.Object, err error) {
+	fn, ok := i.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+
+	values := make([]object.Object, len(args))
+	for j, arg := range args {
+		value, err := i.toObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d to `%s`: %s", j+1, name, err)
+		}
+		values[j] = value
+	}
+
+	defer recoverError(&err)
+	if i.engine == EngineVM {
+		program := &object.Program{Constants: i.constants, Globals: i.globals, Builtins: i.builtins}
+		result, err := vm.Call(context.Background(), program, fn, values...)
+		if err != nil {
+			return nil, err
+		}
+		return objectResult(result)
+	}
+	return objectResult(evaluator.Engine.Call(i.env.Context(), fn, values...))
+}
+
+// RegisterFunc defines the global function name, implemented by fn
+func (i *Interpreter) RegisterFunc(name string, fn object.BuiltInFunction) {
+	i.define(name, &object.Builtin{Fn: fn})
+}
+
+// Set defines the global name, or changes its value when it is defined,
+// converting value to a Monkey value
+func (i *Interpreter) Set(name string, value interface{}) error {
+	obj, err := i.toObject(value)
+	if err != nil {
+		return err
+	}
+	i.define(name, obj)
+	return nil
+}
+
+// define is a helper function that sets the global name to value
+func (i *Interpreter) define(name string, value object.Object) {
+	if i.engine != EngineVM {
+		i.env.Set(name, value)
+		return
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		symbol = i.symbolTable.Define(name)
+	}
+	i.globals[symbol.Index] = value
+}
+
+// Get returns the value of the global name and whether it is defined
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	if i.engine != EngineVM {
+		return i.env.Get(name)
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope || i.globals[symbol.Index] == nil {
+		return nil, false
+	}
+	return i.globals[symbol.Index], true
+}
+
+// objectResult is a helper function that returns an error object either
+// engine returned as an error
+func objectResult(obj object.Object) (object.Object, error) {
+	if errObj, ok := obj.(*object.Error); ok {
+		return nil, errors.New(errObj.Message)
+	}
+	if obj == nil {
+		obj = evaluator.NULL
+	}
+	return obj, nil
+}
+
+// recoverError is a helper function that turns a panic of an engine, such as
+// the one of a call to exit(), into an error
+func recoverError(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if exit, ok := r.(*object.ExitRequest); ok {
+		*err = fmt.Errorf("exit(%d) called", exit.Code)
+		return
+	}
+	*err = fmt.Errorf("%v", r)
+}
+
+// toObject is a helper function that converts a Go value to a Monkey value
+// with object.FromGo. Booleans and null are the values of the engine, which
+// compares them by identity.
+func (i *Interpreter) toObject(value interface{}) (object.Object, error) {
+	switch value := value.(type) {
+	case nil:
+		if i.engine == EngineVM {
+			return vm.Null, nil
+		}
+		return evaluator.NULL, nil
+	case bool:
+		switch {
+		case i.engine == EngineVM && value:
+			return vm.True, nil
+		case i.engine == EngineVM:
+			return vm.False, nil
+		case value:
+			return evaluator.TRUE, nil
+		default:
+			return evaluator.FALSE, nil
+		}
+	case *object.Error:
+		return value, nil
+	}
+
+	obj := object.FromGo(value)
+	if errObj, ok := obj.(*object.Error); ok {
+		return nil, errors.New(errObj.Message)
+	}
+	return obj, nil
+}
@@ -0,0 +1,361 @@
+package monkey
+
+import (
+	"fmt"
+	"monkey/diagnostic"
+	"monkey/imports"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInterpreter(t *testing.T) {
+	for _, engine := range []string{EngineVM, EngineEvaluator} {
+		interp := New(Options{Engine: engine})
+
+		interp.RegisterFunc("double", func(args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+		})
+		if err := interp.Set("base", 10); err != nil {
+			t.Fatalf("%s: Set failed: %s", engine, err)
+		}
+
+		_, err := interp.Eval("let add = fn(a, b) { double(a) + b + base };")
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+
+		result, err := interp.Call("add", 1, 2)
+		if err != nil {
+			t.Fatalf("%s: Call failed: %s", engine, err)
+		}
+		if integer, ok := result.(*object.Integer); !ok || integer.Value != 14 {
+			t.Errorf("%s: wrong result of add. got=%v", engine, result)
+		}
+
+		if err := interp.Set("flag", true); err != nil {
+			t.Fatalf("%s: Set failed: %s", engine, err)
+		}
+		result, err = interp.Eval(`let answer = if (flag == true) { add(20, 0) } else { 0 }; answer;`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+		if answer, ok := interp.Get("answer"); !ok || answer != result || answer.Inspect() != "50" {
+			t.Errorf("%s: wrong answer. got=%v (%v)", engine, answer, result)
+		}
+
+		interp.RegisterFunc("apply", func(args ...object.Object) object.Object {
+			return object.Call(args[0], args[1:]...)
+		})
+		result, err = interp.Eval(`apply(fn(x) { add(x, x) }, 3);`)
+		if err != nil || result.Inspect() != "19" {
+			t.Errorf("%s: wrong result of a callback. got=%v (%v)", engine, result, err)
+		}
+
+		// Errors of Go functions are returned as errors on both engines
+		for src, expected := range map[string]string{
+			`apply(fn(x) { x }, 1, 2);`: "wrong number of arguments: want=1, got=2",
+			`apply(1);`:                 "not a function: INTEGER",
+			`len(1);`:                   "argument to `len` not supported",
+		} {
+			result, err := interp.Eval(src)
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: wrong error of a callback. want=%q, got=%v (%v)", engine, expected, err, result)
+			}
+		}
+
+		if _, ok := interp.Get("missing"); ok {
+			t.Errorf("%s: undefined global found", engine)
+		}
+
+		failures := []struct {
+			err      error
+			expected string
+		}{
+			{second(interp.Eval("let x = ;")), "parser errors"},
+			{second(interp.Eval("1 + true;")), "BOOLEAN"},
+			{second(interp.Eval("exit(3);")), "exit(3) called"},
+			{second(interp.Call("add", 1)), "wrong number of arguments: want=2, got=1"},
+			{second(interp.Call("missing")), "identifier not found: missing"},
+			{second(interp.Call("add", 1, struct{}{})), "argument 2 to `add`"},
+		}
+		for _, tt := range failures {
+			if tt.err == nil || !strings.Contains(tt.err.Error(), tt.expected) {
+				t.Errorf("%s: wrong error. want=%q, got=%v", engine, tt.expected, tt.err)
+			}
+		}
+	}
+}
+
+// second is a helper function that returns the error of a call returning a
+// value and an error
+func second(_ object.Object, err error) error {
+	return err
+}
+
+func TestProgramConcurrentRuns(t *testing.T) {
+	for _, engine := range []string{EngineVM, EngineEvaluator} {
+		program, err := Compile(`let scale = 3; let handle = fn(x) { let y = x * scale; [x, y, len([x, y])] };`, Options{Engine: engine})
+		if err != nil {
+			t.Fatalf("%s: Compile failed: %s", engine, err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 16)
+		for n := 0; n < 16; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				interp, _, err := program.Run(nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				// Every run changes its own globals
+				if err := interp.Set("scale", n); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := interp.Eval(fmt.Sprintf("let offset = fn(x) { handle(x)[1] + %d };", n)); err != nil {
+					errs <- err
+					return
+				}
+				result, err := interp.Call("offset", 2)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if expected := fmt.Sprint(2*n + n); result.Inspect() != expected {
+					errs <- fmt.Errorf("run %d: wrong result. want=%s, got=%s", n, expected, result.Inspect())
+				}
+			}(n)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("%s: %s", engine, err)
+		}
+
+		interp, _, err := program.Run(nil)
+		if err != nil {
+			t.Fatalf("%s: Run failed: %s", engine, err)
+		}
+		if scale, _ := interp.Get("scale"); scale.Inspect() != "3" {
+			t.Errorf("%s: runs shared their globals. scale=%s", engine, scale.Inspect())
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	for _, engine := range []string{EngineVM, EngineEvaluator} {
+		var out strings.Builder
+		interp := New(Options{Engine: engine, Output: &out})
+
+		_, err := interp.Eval(`puts("top"); let say = fn(x) { puts(x); x }; say(1); pmap([2], say, 1);`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+		if _, err := interp.Call("say", "called"); err != nil {
+			t.Fatalf("%s: Call failed: %s", engine, err)
+		}
+		if expected := "top\n1\n2\ncalled\n"; out.String() != expected {
+			t.Errorf("%s: wrong output. want=%q, got=%q", engine, expected, out.String())
+		}
+
+		program, err := Compile(`puts("run");`, Options{Engine: engine})
+		if err != nil {
+			t.Fatalf("%s: Compile failed: %s", engine, err)
+		}
+		var runOut strings.Builder
+		if _, _, err := program.Run(&runOut); err != nil {
+			t.Fatalf("%s: Run failed: %s", engine, err)
+		}
+		if runOut.String() != "run\n" {
+			t.Errorf("%s: wrong output of the run. got=%q", engine, runOut.String())
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t.npy")
+	for _, engine := range []string{EngineVM, EngineEvaluator} {
+		var out strings.Builder
+		interp := New(Options{Engine: engine, Output: &out, Sandbox: []object.Capability{object.CapNetwork}})
+
+		_, err := interp.Eval(`let save = fn(path) { tensor_save(@[1], [1.0], path) }; eval("puts(1)");`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+		if out.String() != "1\n" {
+			t.Errorf("%s: wrong output of eval. got=%q", engine, out.String())
+		}
+		_, err = interp.Call("save", path)
+		expected := "`tensor_save` needs the fs capability, which is not granted"
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: wrong error. want=%q, got=%v", engine, expected, err)
+		}
+	}
+}
+
+// TestCapturedAssignment tests that both engines reject the assignments to
+// a variable read by a closure, whose copy on the VM would not follow them
+func TestCapturedAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { let x = 1; let g = fn() { x }; x = 2; g() }; f();", "cannot assign to x, a variable captured by a closure"},
+		{"let f = fn() { let i = 0; let g = fn() { i }; while (i < 3) { i = i + 1; }; g() }; f();", "cannot assign to i, a variable captured by a closure"},
+		{"let f = fn(n) { n = n + 1; let g = fn() { fn() { n } }; g()() }; f(1);", "cannot assign to n, a variable captured by a closure"},
+		{"let f = fn() { let x = 1; let g = fn() { x }; let y = x; y = 2; g() + y }; f();", "3"},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } }; let r = g(3); g = 1; r + g }; f();", "1"},
+	}
+
+	for _, engine := range []string{EngineVM, EngineEvaluator} {
+		for _, tt := range tests {
+			result, err := New(Options{Engine: engine}).Eval(tt.input)
+			if err != nil {
+				if !strings.Contains(err.Error(), tt.expected) {
+					t.Errorf("%s: wrong error for %q. want=%q, got=%q", engine, tt.input, tt.expected, err)
+				}
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%s: wrong result for %q. want=%s, got=%s", engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+// TestNestedTensorErrors tests that both engines report nested tensor data
+// that is not rectangular with the same message, which the evaluator starts
+// with the line of the literal
+func TestNestedTensorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"@[[1.0, 2.0], [3.0]];", "nested tensor data is not rectangular: got an array of length 1 where an array of length 2 is expected"},
+		{"@[[1.0], [2.0, 3.0]];", "nested tensor data is not rectangular: got an array of length 2 where an array of length 1 is expected"},
+		{"@[[1.0, 2.0], 3.0];", "nested tensor data is not rectangular: got FLOAT where an array of length 2 is expected"},
+		{"@[1.0, [2.0]];", "nested tensor data is not rectangular: got an array of length 1 where a number is expected"},
+		{`@[[["a"]]];`, "tensor data must be numbers, got STRING"},
+	}
+
+	for _, engine := range []string{EngineVM, EngineEvaluator} {
+		for _, tt := range tests {
+			_, err := New(Options{Engine: engine}).Eval(tt.input)
+			if err == nil {
+				t.Errorf("%s: no error for %q", engine, tt.input)
+				continue
+			}
+			if message := strings.TrimPrefix(err.Error(), "On line 1, "); message != tt.expected {
+				t.Errorf("%s: wrong error for %q. want=%q, got=%q", engine, tt.input, tt.expected, message)
+			}
+		}
+	}
+}
+
+func TestIndependentInterpreters(t *testing.T) {
+	for _, engine := range []string{EngineVM, EngineEvaluator} {
+		interps := make([]*Interpreter, 2)
+		for n := range interps {
+			functions := object.NewRegistry()
+			name := fmt.Sprintf("interpreter %d", n)
+			functions.Register("whoami", func(args ...object.Object) object.Object {
+				return &object.String{Value: name}
+			})
+			interps[n] = New(Options{Engine: engine, Args: []string{name}, Functions: functions})
+		}
+
+		// Interpreters seeding and drawing numbers at once draw the same
+		var wg sync.WaitGroup
+		draws := make([]string, len(interps))
+		for n, interp := range interps {
+			wg.Add(1)
+			go func(n int, interp *Interpreter) {
+				defer wg.Done()
+				result, err := interp.Eval(`seed(42); [random(), random(), random()]`)
+				if err != nil {
+					t.Errorf("%s: Eval failed: %s", engine, err)
+					return
+				}
+				draws[n] = result.Inspect()
+			}(n, interp)
+		}
+		wg.Wait()
+		if draws[0] != draws[1] {
+			t.Errorf("%s: interpreters seeded alike drew different numbers: %s and %s", engine, draws[0], draws[1])
+		}
+
+		for n, interp := range interps {
+			result, err := interp.Eval(`[first(args()), whoami()]`)
+			if err != nil {
+				t.Fatalf("%s: Eval failed: %s", engine, err)
+			}
+			expected := fmt.Sprintf("[interpreter %d, interpreter %d]", n, n)
+			if result.Inspect() != expected {
+				t.Errorf("%s: wrong result. want=%s, got=%s", engine, expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for n, dir := range dirs {
+		source := fmt.Sprintf(`let name = "library %d";`, n)
+		if err := os.WriteFile(filepath.Join(dir, "library.mky"), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each interpreter searches the directories of its own resolver
+	for _, engine := range []string{EngineVM, EngineEvaluator} {
+		for n, dir := range dirs {
+			interp := New(Options{Engine: engine, Imports: &imports.Resolver{SearchPath: []string{dir}}})
+			result, err := interp.Eval(`import "library.mky"; name`)
+			if err != nil {
+				t.Fatalf("%s: Eval failed: %s", engine, err)
+			}
+			expected := fmt.Sprintf("library %d", n)
+			if result.Inspect() != expected {
+				t.Errorf("%s: wrong module imported. want=%s, got=%s", engine, expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if diagnostics := Check(`let add = fn(a, b) { a + b }; puts(add(1, 2));`); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics. got=%v", diagnostics)
+	}
+
+	tests := []struct {
+		input    string
+		expected diagnostic.Diagnostic
+	}{
+		{
+			"let a = 1;\nlet = 2;",
+			diagnostic.Diagnostic{Severity: diagnostic.Error, Message: "On line 2, expected next token to be IDENT, got = instead", Line: 2, Column: 5, End: diagnostic.Position{Line: 2, Column: 6}},
+		},
+		{
+			"let a = 1;\nputs(a + missing);",
+			diagnostic.Diagnostic{Severity: diagnostic.Error, Message: "undefined variable missing", Line: 2, Column: 10, End: diagnostic.Position{Line: 2, Column: 17}},
+		},
+	}
+
+	for _, tt := range tests {
+		diagnostics := Check(tt.input)
+		if len(diagnostics) == 0 {
+			t.Errorf("expected diagnostics for %q", tt.input)
+			continue
+		}
+		if got := diagnostics[0]; got != tt.expected {
+			t.Errorf("wrong diagnostic for %q. want=%#v, got=%#v", tt.input, tt.expected, got)
+		}
+	}
+}
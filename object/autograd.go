@@ -0,0 +1,307 @@
+// object/autograd.go
+
+package object
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Tensors created by requires_grad() record the operations they take part
+// in: every tensor computed from them keeps a gradNode pointing back at the
+// operands it was computed from. Backward walks these nodes from a loss back
+// to the tensors requiring gradients and sets their gradients.
+
+// gradNode records how a tensor was computed
+type gradNode struct {
+	inputs []*Tensor
+
+	// backward returns the gradients of the inputs given the gradient of
+	// the result, reduced to the shapes of the inputs
+	backward func(grad *Tensor) []*Tensor
+}
+
+// RequireGrad returns a copy of t whose gradient is computed by Backward.
+// The copy records no operation, so it also detaches a tensor computed from
+// others from them.
+func RequireGrad(t *Tensor) *Tensor {
+	return &Tensor{Shape: append([]int64{}, t.Shape...), Data: append([]float64{}, t.Data...), DType: t.DType, RequiresGrad: true}
+}
+
+// tracked is a helper function that reports whether operations on t must
+// be recorded
+func (t *Tensor) tracked() bool {
+	return t.RequiresGrad || t.node != nil
+}
+
+// record is a helper function that records that result was computed from
+// inputs, when one of them is tracked
+func record(result *Tensor, inputs []*Tensor, backward func(grad *Tensor) []*Tensor) *Tensor {
+	for _, input := range inputs {
+		if input.tracked() {
+			result.node = &gradNode{inputs: inputs, backward: backward}
+			break
+		}
+	}
+	return result
+}
+
+// Arithmetic applies the operator +, -, * or / element-wise to left and
+// right, broadcasting them to a common shape, and records the operation for
+// Backward
+func Arithmetic(operator string, left, right *Tensor) (*Tensor, error) {
+	fn, err := arithmetic(operator)
+	if err != nil {
+		return nil, err
+	}
+	if operator == "/" {
+		if err := checkDivisor(promote(left, right), right); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := Broadcast(left, right, fn)
+	if err != nil {
+		return nil, err
+	}
+	return record(result, []*Tensor{left, right}, func(grad *Tensor) []*Tensor {
+		var l, r *Tensor
+		switch operator {
+		case "+":
+			l, r = grad, grad
+		case "-":
+			l, r = grad, apply(grad, func(g float64) float64 { return -g })
+		case "*":
+			l, r = combine(grad, right, mul), combine(grad, left, mul)
+		case "/":
+			l = combine(grad, right, div)
+			r = combine(combine(grad, left, mul), combine(right, right, mul), func(x, y float64) float64 { return -x / y })
+		}
+		return []*Tensor{sumTo(l, left.Shape), sumTo(r, right.Shape)}
+	}), nil
+}
+
+// MatMul returns the product of the matrices a and b, tensors of shapes
+// [n, k] and [k, m], and records the operation for Backward
+func MatMul(a, b *Tensor) (*Tensor, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	if len(a.Shape) != 2 || len(b.Shape) != 2 || a.Shape[1] != b.Shape[0] {
+		return nil, fmt.Errorf("cannot multiply matrices of shapes %v and %v", a.Shape, b.Shape)
+	}
+
+	result := matmul(a, b)
+	if dtype := promote(a, b); dtype != Float64 {
+		result = result.AsType(dtype)
+	}
+	return record(result, []*Tensor{a, b}, func(grad *Tensor) []*Tensor {
+		aT, _ := (&Tensor{Shape: a.Shape, Data: a.Data}).Transpose(nil)
+		bT, _ := (&Tensor{Shape: b.Shape, Data: b.Data}).Transpose(nil)
+		return []*Tensor{matmul(grad, bT), matmul(aT, grad)}
+	}), nil
+}
+
+// matmul is a helper function that multiplies two matrices of compatible
+// shapes without recording the operation. Large products are split across
+// goroutines.
+func matmul(a, b *Tensor) *Tensor {
+	n, k, m := a.Shape[0], a.Shape[1], b.Shape[1]
+	result := &Tensor{Shape: []int64{n, m}, Data: make([]float64, n*m)}
+
+	// The rows of the result are split across goroutines, computing a row
+	// costs k*m multiplications
+	parallelFor(int(n), int(k*m), func(start, end int) {
+		for i := int64(start); i < int64(end); i++ {
+			row := result.Data[i*m : (i+1)*m]
+			for p := int64(0); p < k; p++ {
+				x := a.Data[i*k+p]
+				for j, y := range b.Data[p*m : (p+1)*m] {
+					row[j] += x * y
+				}
+			}
+		}
+	})
+	return result
+}
+
+// Sum returns the sum of the elements of t as a tensor of no dimension and
+// records the operation for Backward
+func Sum(t *Tensor) *Tensor {
+	total := 0.0
+	for _, v := range t.Data {
+		total += v
+	}
+	result := &Tensor{Shape: []int64{}, Data: []float64{total}}
+	return record(result, []*Tensor{t}, func(grad *Tensor) []*Tensor {
+		return []*Tensor{filled(t.Shape, grad.Data[0])}
+	})
+}
+
+// Mean returns the mean of the elements of t as a tensor of no dimension
+// and records the operation for Backward
+func Mean(t *Tensor) *Tensor {
+	n := float64(len(t.Data))
+	total := 0.0
+	for _, v := range t.Data {
+		total += v
+	}
+	result := &Tensor{Shape: []int64{}, Data: []float64{total / n}}
+	return record(result, []*Tensor{t}, func(grad *Tensor) []*Tensor {
+		return []*Tensor{filled(t.Shape, grad.Data[0]/n)}
+	})
+}
+
+// Backward computes the gradient of the elements of loss, summed, with
+// respect to the tensors requiring gradients it was computed from, and sets
+// their Grad, replacing the gradients of an earlier call
+func Backward(loss *Tensor) error {
+	if !loss.tracked() {
+		return errors.New("loss was not computed from a tensor requiring gradients")
+	}
+
+	// Order the tensors so that each comes after the ones computed from it
+	var order []*Tensor
+	visited := map[*Tensor]bool{}
+	var visit func(t *Tensor)
+	visit = func(t *Tensor) {
+		if visited[t] || !t.tracked() {
+			return
+		}
+		visited[t] = true
+		if t.node != nil {
+			for _, input := range t.node.inputs {
+				visit(input)
+			}
+		}
+		order = append(order, t)
+	}
+	visit(loss)
+
+	grads := map[*Tensor]*Tensor{loss: filled(loss.Shape, 1)}
+	for i := len(order) - 1; i >= 0; i-- {
+		t := order[i]
+		grad := grads[t]
+		if t.RequiresGrad {
+			t.Grad = grad
+		}
+		if t.node == nil {
+			continue
+		}
+
+		for j, inputGrad := range t.node.backward(grad) {
+			input := t.node.inputs[j]
+			if !input.tracked() {
+				continue
+			}
+			if previous, ok := grads[input]; ok {
+				inputGrad = combine(previous, inputGrad, add)
+			}
+			grads[input] = inputGrad
+		}
+	}
+	return nil
+}
+
+// arithmetic is a helper function that returns the function applying the
+// operator +, -, * or / to two elements
+func arithmetic(operator string) (func(x, y float64) float64, error) {
+	switch operator {
+	case "+":
+		return add, nil
+	case "-":
+		return sub, nil
+	case "*":
+		return mul, nil
+	case "/":
+		return div, nil
+	default:
+		return nil, fmt.Errorf("unknown tensor operator: %s", operator)
+	}
+}
+
+func add(x, y float64) float64 { return x + y }
+func sub(x, y float64) float64 { return x - y }
+func mul(x, y float64) float64 { return x * y }
+func div(x, y float64) float64 { return x / y }
+
+// combine is a helper function that applies fn to the elements of two
+// tensors broadcast to a common shape, without recording the operation.
+// Gradients are computed from tensors whose shapes broadcast.
+func combine(left, right *Tensor, fn func(x, y float64) float64) *Tensor {
+	result, err := broadcast([]*Tensor{left, right}, Float64, func(values []float64) float64 {
+		return fn(values[0], values[1])
+	})
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// apply is a helper function that returns the tensor of fn applied to the
+// elements of t
+func apply(t *Tensor, fn func(v float64) float64) *Tensor {
+	result := &Tensor{Shape: t.Shape, Data: make([]float64, len(t.Data))}
+	for i, v := range t.Data {
+		result.Data[i] = fn(v)
+	}
+	return result
+}
+
+// filled is a helper function that returns a tensor of the given shape
+// filled with v
+func filled(shape []int64, v float64) *Tensor {
+	size := int64(1)
+	for _, d := range shape {
+		size *= d
+	}
+	result := &Tensor{Shape: shape, Data: make([]float64, size)}
+	for i := range result.Data {
+		result.Data[i] = v
+	}
+	return result
+}
+
+// sumTo is a helper function that sums the gradient of a broadcast result
+// over the dimensions an operand of the given shape was stretched along, so
+// that it has the shape of the operand
+func sumTo(grad *Tensor, shape []int64) *Tensor {
+	if sameShape(grad.Shape, shape) {
+		return grad
+	}
+
+	result := filled(shape, 0)
+	strides := broadcastStrides(shape, grad.Shape)
+	index := make([]int64, len(grad.Shape))
+	var position int64
+	for _, v := range grad.Data {
+		result.Data[position] += v
+
+		for d := len(grad.Shape) - 1; d >= 0; d-- {
+			index[d]++
+			position += strides[d]
+			if index[d] < grad.Shape[d] {
+				break
+			}
+			position -= strides[d] * grad.Shape[d]
+			index[d] = 0
+		}
+	}
+	return result
+}
+
+// sameShape is a helper function that reports whether two shapes are equal
+func sameShape(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, d := range a {
+		if d != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package object
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBackward(t *testing.T) {
+	w := RequireGrad(&Tensor{Shape: []int64{2, 2}, Data: []float64{1, 2, 3, 4}})
+	b := RequireGrad(&Tensor{Shape: []int64{1, 2}, Data: []float64{0.5, -0.5}})
+	x := &Tensor{Shape: []int64{2, 2}, Data: []float64{1, 1, 2, 0}}
+
+	product, err := MatMul(x, w)
+	if err != nil {
+		t.Fatalf("MatMul failed: %s", err)
+	}
+	y, err := Arithmetic("+", product, b)
+	if err != nil {
+		t.Fatalf("Arithmetic failed: %s", err)
+	}
+	squares, _ := Arithmetic("*", y, y)
+	loss := Mean(squares)
+	if loss.Data[0] != 17.25 {
+		t.Fatalf("wrong loss. want=17.25, got=%f", loss.Data[0])
+	}
+
+	if err := Backward(loss); err != nil {
+		t.Fatalf("Backward failed: %s", err)
+	}
+	if want := []float64{4.75, 6.25, 2.25, 2.75}; !reflect.DeepEqual(w.Grad.Data, want) {
+		t.Errorf("wrong gradient of w. want=%v, got=%v", want, w.Grad.Data)
+	}
+	// b was broadcast over the rows of y, its gradient is summed over them
+	if want := []int64{1, 2}; !reflect.DeepEqual(b.Grad.Shape, want) {
+		t.Errorf("wrong shape of the gradient of b. want=%v, got=%v", want, b.Grad.Shape)
+	}
+	if want := []float64{3.5, 4.5}; !reflect.DeepEqual(b.Grad.Data, want) {
+		t.Errorf("wrong gradient of b. want=%v, got=%v", want, b.Grad.Data)
+	}
+	if x.Grad != nil {
+		t.Errorf("gradient set for a tensor not requiring it: %s", x.Grad.Inspect())
+	}
+}
+
+func TestBackwardOperators(t *testing.T) {
+	tests := []struct {
+		operator string
+		left     float64
+		right    float64
+		expected [2]float64
+	}{
+		{"+", 3, 2, [2]float64{1, 1}},
+		{"-", 3, 2, [2]float64{1, -1}},
+		{"*", 3, 2, [2]float64{2, 3}},
+		{"/", 3, 2, [2]float64{0.5, -0.75}},
+	}
+
+	for _, tt := range tests {
+		left := RequireGrad(&Tensor{Shape: []int64{1}, Data: []float64{tt.left}})
+		right := RequireGrad(&Tensor{Shape: []int64{1}, Data: []float64{tt.right}})
+		result, err := Arithmetic(tt.operator, left, right)
+		if err != nil {
+			t.Fatalf("Arithmetic(%q) failed: %s", tt.operator, err)
+		}
+		if err := Backward(result); err != nil {
+			t.Fatalf("Backward failed: %s", err)
+		}
+		got := [2]float64{left.Grad.Data[0], right.Grad.Data[0]}
+		if math.Abs(got[0]-tt.expected[0]) > 1e-12 || math.Abs(got[1]-tt.expected[1]) > 1e-12 {
+			t.Errorf("wrong gradients of %s. want=%v, got=%v", tt.operator, tt.expected, got)
+		}
+	}
+
+	// A tensor used twice accumulates the gradients of both uses
+	x := RequireGrad(&Tensor{Shape: []int64{2}, Data: []float64{1, 2}})
+	reshaped, _ := x.Reshape([]int64{2, 1})
+	transposed, _ := reshaped.Transpose(nil)
+	outer, _ := Arithmetic("*", reshaped, transposed)
+	if err := Backward(Sum(outer)); err != nil {
+		t.Fatalf("Backward failed: %s", err)
+	}
+	if want := []float64{6, 6}; !reflect.DeepEqual(x.Grad.Data, want) {
+		t.Errorf("wrong gradient of x. want=%v, got=%v", want, x.Grad.Data)
+	}
+
+	if err := Backward(Sum(&Tensor{Shape: []int64{1}, Data: []float64{1}})); err == nil {
+		t.Errorf("Backward succeeded on a loss not requiring gradients")
+	}
+}
@@ -5,14 +5,31 @@ package object
 import (
 	"fmt"
 	"math"
-	"math/rand"
+	"os"
+	"runtime"
+	"sort"
 	"strings"
-	"time"
+	"sync"
 )
 
-func random() float64 {
-	rand.Seed(time.Now().UnixNano()) // Initialize the global random number generator
-	return rand.Float64()
+// scriptArgs holds the arguments passed to the running script, returned by
+// args() called in no context or in a context without any
+var (
+	scriptArgs      []string
+	scriptArgsMutex = sync.RWMutex{}
+)
+
+// SetArgs sets the arguments returned by the args() builtin
+func SetArgs(args []string) {
+	scriptArgsMutex.Lock()
+	defer scriptArgsMutex.Unlock()
+	scriptArgs = args
+}
+
+// ExitRequest is raised as a panic by the exit() builtin to unwind the
+// running program. Whoever runs the program recovers it and exits with Code.
+type ExitRequest struct {
+	Code int
 }
 
 var Builtins = []struct {
@@ -21,7 +38,7 @@ var Builtins = []struct {
 }{
 	{
 		"len",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Usage: "len(value)", Doc: "Returns the number of characters in a string or elements in an array.", Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1",
 					len(args))
@@ -41,17 +58,18 @@ var Builtins = []struct {
 	},
 	{
 		"puts",
-		&Builtin{Fn: func(args ...Object) Object {
+		contextual(&Builtin{Usage: "puts(values...)", Doc: "Prints each value on its own line and returns null.", ContextFn: func(ctx *Context, args ...Object) Object {
+			out := ctx.Output()
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				fmt.Fprintln(out, arg.Inspect())
 			}
 			return nil
 		},
-		},
+		}),
 	},
 	{
 		"first",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Usage: "first(array)", Doc: "Returns the first element of an array, or null when it is empty.", Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
@@ -70,7 +88,7 @@ var Builtins = []struct {
 	},
 	{
 		"last",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Usage: "last(array)", Doc: "Returns the last element of an array, or null when it is empty.", Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
@@ -90,7 +108,7 @@ var Builtins = []struct {
 	},
 	{
 		"rest",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Usage: "rest(array)", Doc: "Returns a new array holding every element but the first, or null when the array is empty.", Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
@@ -112,7 +130,7 @@ var Builtins = []struct {
 	},
 	{
 		"push",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Usage: "push(array, value)", Doc: "Appends value to the array and returns it.", Fn: func(args ...Object) Object {
 			if len(args) != 2 {
 				return newError("wrong number of arguments. got=%d, want=2", len(args))
 			}
@@ -129,7 +147,7 @@ var Builtins = []struct {
 	},
 	{
 		"pop",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Usage: "pop(array)", Doc: "Removes the last element of the array and returns it, or null when the array is empty.", Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
@@ -151,7 +169,7 @@ var Builtins = []struct {
 	},
 	{
 		"join",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Usage: "join(array, separator)", Doc: "Returns the elements of the array as a string, separated by separator.", Fn: func(args ...Object) Object {
 			if len(args) != 2 {
 				return newError("wrong number of arguments. got=%d, want=2", len(args))
 			}
@@ -176,17 +194,17 @@ var Builtins = []struct {
 	},
 	{
 		"random",
-		&Builtin{Fn: func(args ...Object) Object {
+		contextual(&Builtin{Usage: "random()", Doc: "Returns a random float in [0.0, 1.0).", ContextFn: func(ctx *Context, args ...Object) Object {
 			if len(args) > 0 {
 				return newError("random() takes no arguments")
 			}
-			return &Float{Value: random()}
-		},
+			return &Float{Value: ctx.random().Float64()}
 		},
+		}),
 	},
 	{
 		"exp",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Usage: "exp(x)", Doc: "Returns e raised to the power of x as a float.", Fn: func(args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. exp() requires exactly one argument.")
 			}
@@ -204,6 +222,1054 @@ var Builtins = []struct {
 		},
 		},
 	},
+	{
+		"args",
+		contextual(&Builtin{Usage: "args()", Doc: "Returns the arguments passed to the script as an array of strings.", ContextFn: func(ctx *Context, args ...Object) Object {
+			if len(args) > 0 {
+				return newError("args() takes no arguments")
+			}
+
+			scriptArgs := ctx.args()
+			elements := make([]Object, len(scriptArgs))
+			for i, arg := range scriptArgs {
+				elements[i] = &String{Value: arg}
+			}
+			return &Array{Elements: elements}
+		},
+		}),
+	},
+	{
+		"exit",
+		&Builtin{Usage: "exit(status)", Doc: "Ends the program with the given exit status, 0 when omitted.", Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+			}
+
+			code := int64(0)
+			if len(args) == 1 {
+				arg, ok := args[0].(*Integer)
+				if !ok {
+					return newError("argument to `exit` must be INTEGER, got %s", args[0].Type())
+				}
+				if arg.Value < 0 || arg.Value > 255 {
+					return newError("exit status must be between 0 and 255, got %d", arg.Value)
+				}
+				code = arg.Value
+			}
+
+			panic(&ExitRequest{Code: int(code)})
+		},
+		},
+	},
+	{
+		"plugins",
+		&Builtin{Usage: "plugins()", Doc: "Returns the plugins loaded as an array of hashes with their file, version and functions.", Fn: func(args ...Object) Object {
+			if len(args) > 0 {
+				return newError("plugins() takes no arguments")
+			}
+
+			plugins := LoadedPlugins()
+			elements := make([]Object, len(plugins))
+			for i, plugin := range plugins {
+				functions := make([]Object, len(plugin.Functions))
+				for j, name := range plugin.Functions {
+					functions[j] = &String{Value: name}
+				}
+				elements[i] = newHash(map[string]Object{
+					"file":      &String{Value: plugin.File},
+					"version":   &String{Value: plugin.Version},
+					"functions": &Array{Elements: functions},
+				})
+			}
+			return &Array{Elements: elements}
+		},
+		},
+	},
+	{
+		"reshape",
+		&Builtin{Usage: "reshape(tensor, shape)", Doc: "Returns a tensor holding the elements of tensor with the shape given as an array of integers, one of which may be -1 to infer it.", Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			tensor, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `reshape` must be TENSOR, got %s", args[0].Type())
+			}
+			shape, ok := integers(args[1])
+			if !ok {
+				return newError("shape given to `reshape` must be an array of integers, got %s", args[1].Inspect())
+			}
+
+			result, err := tensor.Reshape(shape)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return result
+		},
+		},
+	},
+	{
+		"transpose",
+		&Builtin{Usage: "transpose(tensor, axes)", Doc: "Returns tensor with its axes reversed, or permuted so that its axis i is the axis axes[i] of tensor.", Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			tensor, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `transpose` must be TENSOR, got %s", args[0].Type())
+			}
+
+			var axes []int
+			if len(args) == 2 {
+				values, ok := integers(args[1])
+				if !ok {
+					return newError("axes given to `transpose` must be an array of integers, got %s", args[1].Inspect())
+				}
+				axes = make([]int, len(values))
+				for i, v := range values {
+					axes[i] = int(v)
+				}
+			}
+
+			result, err := tensor.Transpose(axes)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return result
+		},
+		},
+	},
+	{
+		"zeros",
+		&Builtin{Usage: "zeros(shape)", Doc: "Returns a tensor of the given shape, an integer or an array of integers, filled with zeros.", Fn: func(args ...Object) Object {
+			return newFilledTensor("zeros", args, func() float64 { return 0 })
+		},
+		},
+	},
+	{
+		"ones",
+		&Builtin{Usage: "ones(shape)", Doc: "Returns a tensor of the given shape, an integer or an array of integers, filled with ones.", Fn: func(args ...Object) Object {
+			return newFilledTensor("ones", args, func() float64 { return 1 })
+		},
+		},
+	},
+	{
+		"eye",
+		&Builtin{Usage: "eye(n)", Doc: "Returns the identity matrix of size n, a tensor of shape [n, n].", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			n, ok := args[0].(*Integer)
+			if !ok || n.Value < 0 {
+				return newError("argument to `eye` must be a non-negative INTEGER, got %s", args[0].Inspect())
+			}
+
+			tensor := &Tensor{Shape: []int64{n.Value, n.Value}, Data: make([]float64, n.Value*n.Value)}
+			for i := int64(0); i < n.Value; i++ {
+				tensor.Data[i*n.Value+i] = 1
+			}
+			return tensor
+		},
+		},
+	},
+	{
+		"rand",
+		contextual(&Builtin{Usage: "rand(shape)", Doc: "Returns a tensor of the given shape filled with random floats in [0.0, 1.0).", ContextFn: func(ctx *Context, args ...Object) Object {
+			return newFilledTensor("rand", args, ctx.random().Float64)
+		},
+		}),
+	},
+	{
+		"randn",
+		contextual(&Builtin{Usage: "randn(shape)", Doc: "Returns a tensor of the given shape filled with random floats following the standard normal distribution.", ContextFn: func(ctx *Context, args ...Object) Object {
+			return newFilledTensor("randn", args, ctx.random().NormFloat64)
+		},
+		}),
+	},
+	{
+		"seed",
+		contextual(&Builtin{Usage: "seed(n)", Doc: "Seeds the random numbers of random(), rand() and randn() with the integer n, so that they repeat from one run to the next.", ContextFn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			n, ok := args[0].(*Integer)
+			if !ok {
+				return newError("argument to `seed` must be INTEGER, got %s", args[0].Type())
+			}
+
+			ctx.random().Seed(n.Value)
+			return nil
+		},
+		}),
+	},
+	{
+		"arange",
+		&Builtin{Usage: "arange(start, stop, step)", Doc: "Returns a one-dimensional tensor of the numbers from start, 0 when only stop is given, up to stop excluded, spaced by step, 1 when omitted.", Fn: func(args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+			}
+			bounds := []float64{0, 0, 1}
+			for i, arg := range args {
+				scalar, ok := ScalarTensor(arg)
+				if !ok {
+					return newError("arguments to `arange` must be numbers, got %s", arg.Type())
+				}
+				bounds[i] = scalar.Data[0]
+			}
+			if len(args) == 1 {
+				bounds[0], bounds[1] = 0, bounds[0]
+			}
+			start, stop, step := bounds[0], bounds[1], bounds[2]
+			if step == 0 {
+				return newError("step of `arange` must not be 0")
+			}
+
+			count := int64(math.Ceil((stop - start) / step))
+			if count < 0 {
+				count = 0
+			}
+			tensor := &Tensor{Shape: []int64{count}, Data: make([]float64, count)}
+			for i := range tensor.Data {
+				tensor.Data[i] = start + float64(i)*step
+			}
+			return tensor
+		},
+		},
+	},
+	{
+		"dtype",
+		&Builtin{Usage: "dtype(tensor)", Doc: "Returns the type of the elements of tensor: float64, float32, int64 or bool.", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			tensor, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `dtype` must be TENSOR, got %s", args[0].Type())
+			}
+			return &String{Value: string(tensor.ElementType())}
+		},
+		},
+	},
+	{
+		"astype",
+		&Builtin{Usage: "astype(tensor, dtype)", Doc: "Returns a copy of tensor with its elements converted to dtype, float64, float32, int64 or bool. Converting to int64 truncates the elements, converting to bool turns those that are not zero into true.", Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			tensor, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `astype` must be TENSOR, got %s", args[0].Type())
+			}
+			name, ok := args[1].(*String)
+			if !ok {
+				return newError("dtype given to `astype` must be STRING, got %s", args[1].Type())
+			}
+
+			dtype, err := ParseDType(name.Value)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return tensor.AsType(dtype)
+		},
+		},
+	},
+	{
+		"where",
+		&Builtin{Usage: "where(mask, a, b)", Doc: "Returns the tensor holding the elements of a where mask is true, or not zero, and the elements of b elsewhere. The arguments are tensors or numbers broadcast to a common shape.", Fn: func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			operands := make([]*Tensor, len(args))
+			for i, arg := range args {
+				if operands[i] = asTensor(arg); operands[i] == nil {
+					return newError("arguments to `where` must be tensors or numbers, got %s", arg.Type())
+				}
+			}
+
+			result, err := Where(operands[0], operands[1], operands[2])
+			if err != nil {
+				return newError("%s", err)
+			}
+			return result
+		},
+		},
+	},
+	{
+		"count_nonzero",
+		&Builtin{Usage: "count_nonzero(tensor)", Doc: "Returns the number of elements of tensor that are not zero, such as the true elements of a mask.", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			tensor, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `count_nonzero` must be TENSOR, got %s", args[0].Type())
+			}
+
+			count := int64(0)
+			for _, v := range tensor.Data {
+				if v != 0 {
+					count++
+				}
+			}
+			return &Integer{Value: count}
+		},
+		},
+	},
+	{
+		"allclose",
+		&Builtin{Usage: "allclose(a, b, eps)", Doc: "Returns whether the elements of the tensors or numbers a and b, broadcast to a common shape, differ by eps at most, 1e-8 when omitted.", Fn: func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			a, b := asTensor(args[0]), asTensor(args[1])
+			if a == nil || b == nil {
+				return newError("arguments to `allclose` must be tensors or numbers, got %s and %s", args[0].Type(), args[1].Type())
+			}
+			eps := 1e-8
+			if len(args) == 3 {
+				scalar, ok := ScalarTensor(args[2])
+				if !ok {
+					return newError("eps given to `allclose` must be a number, got %s", args[2].Type())
+				}
+				eps = scalar.Data[0]
+			}
+
+			near, err := AllClose(a, b, eps)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return &Boolean{Value: near}
+		},
+		},
+	},
+	{
+		"tensor_save",
+		contextual(&Builtin{Usage: "tensor_save(tensor, path)", Doc: "Saves tensor to the file at path in the .npy format of NumPy and returns null.", ContextFn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			tensor, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `tensor_save` must be TENSOR, got %s", args[0].Type())
+			}
+			path, ok := args[1].(*String)
+			if !ok {
+				return newError("path given to `tensor_save` must be STRING, got %s", args[1].Type())
+			}
+			if !ctx.Granted(CapFilesystem) {
+				return newError("`tensor_save` needs the %s capability, which is not granted", CapFilesystem)
+			}
+
+			file, err := os.Create(path.Value)
+			if err != nil {
+				return newError("%s", err)
+			}
+			if err := WriteNPY(file, tensor); err != nil {
+				file.Close()
+				return newError("%s", err)
+			}
+			if err := file.Close(); err != nil {
+				return newError("%s", err)
+			}
+			return nil
+		},
+		}),
+	},
+	{
+		"tensor_load",
+		contextual(&Builtin{Usage: "tensor_load(path)", Doc: "Returns the tensor saved in the .npy file at path, by tensor_save() or NumPy.", ContextFn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			path, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `tensor_load` must be STRING, got %s", args[0].Type())
+			}
+			if !ctx.Granted(CapFilesystem) {
+				return newError("`tensor_load` needs the %s capability, which is not granted", CapFilesystem)
+			}
+
+			file, err := os.Open(path.Value)
+			if err != nil {
+				return newError("%s", err)
+			}
+			defer file.Close()
+
+			tensor, err := ReadNPY(file)
+			if err != nil {
+				return newError("%s: %s", path.Value, err)
+			}
+			return tensor
+		},
+		}),
+	},
+	{
+		"requires_grad",
+		&Builtin{Usage: "requires_grad(tensor)", Doc: "Returns a copy of tensor whose gradient is computed by backward(). The operations on it are recorded.", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			tensor, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `requires_grad` must be TENSOR, got %s", args[0].Type())
+			}
+			return RequireGrad(tensor)
+		},
+		},
+	},
+	{
+		"backward",
+		&Builtin{Usage: "backward(loss)", Doc: "Computes the gradient of the sum of the elements of the tensor loss with respect to the tensors created by requires_grad() it was computed from, read with grad(), and returns null.", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			loss, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `backward` must be TENSOR, got %s", args[0].Type())
+			}
+			if err := Backward(loss); err != nil {
+				return newError("%s", err)
+			}
+			return nil
+		},
+		},
+	},
+	{
+		"grad",
+		&Builtin{Usage: "grad(tensor)", Doc: "Returns the gradient of tensor set by the last call to backward(), or null.", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			tensor, ok := args[0].(*Tensor)
+			if !ok {
+				return newError("argument to `grad` must be TENSOR, got %s", args[0].Type())
+			}
+			if tensor.Grad == nil {
+				return nil
+			}
+			return tensor.Grad
+		},
+		},
+	},
+	{
+		"matmul",
+		&Builtin{Usage: "matmul(a, b)", Doc: "Returns the matrix product of the tensors a and b, of shapes [n, k] and [k, m].", Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			a, ok := args[0].(*Tensor)
+			b, ok2 := args[1].(*Tensor)
+			if !ok || !ok2 {
+				return newError("arguments to `matmul` must be TENSOR, got %s and %s", args[0].Type(), args[1].Type())
+			}
+			product, err := MatMul(a, b)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return product
+		},
+		},
+	},
+	{
+		"sum",
+		&Builtin{Usage: "sum(tensor)", Doc: "Returns the sum of the elements of tensor as a tensor of no dimension.", Fn: func(args ...Object) Object {
+			return reduceTensor("sum", args, Sum)
+		},
+		},
+	},
+	{
+		"mean",
+		&Builtin{Usage: "mean(tensor)", Doc: "Returns the mean of the elements of tensor as a tensor of no dimension.", Fn: func(args ...Object) Object {
+			return reduceTensor("mean", args, Mean)
+		},
+		},
+	},
+	{
+		"sgd_step",
+		&Builtin{Usage: "sgd_step(params, grads, lr)", Doc: "Returns the array of the tensors params moved against the tensors grads, scaled by the number lr. A null gradient leaves its parameter unchanged.", Fn: func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3", len(args))
+			}
+			params, errObj := tensors("sgd_step", "params", args[0])
+			if errObj != nil {
+				return errObj
+			}
+			grads, errObj := tensors("sgd_step", "grads", args[1])
+			if errObj != nil {
+				return errObj
+			}
+			lr, ok := ScalarTensor(args[2])
+			if !ok {
+				return newError("lr given to `sgd_step` must be a number, got %s", args[2].Type())
+			}
+
+			updated, err := SGDStep(params, grads, lr.Data[0])
+			if err != nil {
+				return newError("%s", err)
+			}
+			return tensorArray(updated)
+		},
+		},
+	},
+	{
+		"adam_step",
+		&Builtin{Usage: "adam_step(state, params, grads, opts)", Doc: "Moves the tensors params by a step of the Adam optimizer and returns a hash of the array of the new \"params\" and the \"state\" to pass to the next step, {} for the first. The hash opts may set \"lr\", \"beta1\", \"beta2\" and \"eps\".", Fn: func(args ...Object) Object {
+			if len(args) != 3 && len(args) != 4 {
+				return newError("wrong number of arguments. got=%d, want=3 or 4", len(args))
+			}
+			adam, errObj := adamState(args[0])
+			if errObj != nil {
+				return errObj
+			}
+			params, errObj := tensors("adam_step", "params", args[1])
+			if errObj != nil {
+				return errObj
+			}
+			grads, errObj := tensors("adam_step", "grads", args[2])
+			if errObj != nil {
+				return errObj
+			}
+			if len(args) == 4 {
+				if errObj := adamOptions(adam, args[3]); errObj != nil {
+					return errObj
+				}
+			}
+
+			updated, err := adam.Update(params, grads)
+			if err != nil {
+				return newError("%s", err)
+			}
+			state := newHash(map[string]Object{
+				"step":  &Integer{Value: adam.Step},
+				"m":     tensorArray(adam.M),
+				"v":     tensorArray(adam.V),
+				"lr":    &Float{Value: adam.LR},
+				"beta1": &Float{Value: adam.Beta1},
+				"beta2": &Float{Value: adam.Beta2},
+				"eps":   &Float{Value: adam.Eps},
+			})
+			return newHash(map[string]Object{"params": tensorArray(updated), "state": state})
+		},
+		},
+	},
+	{
+		"add_",
+		&Builtin{Usage: "add_(tensor, other)", Doc: "Adds the tensor or number other to tensor in place, writing into the data of tensor, and returns tensor.", Fn: func(args ...Object) Object {
+			return inPlace("add_", "+", args)
+		},
+		},
+	},
+	{
+		"sub_",
+		&Builtin{Usage: "sub_(tensor, other)", Doc: "Subtracts the tensor or number other from tensor in place, writing into the data of tensor, and returns tensor.", Fn: func(args ...Object) Object {
+			return inPlace("sub_", "-", args)
+		},
+		},
+	},
+	{
+		"mul_",
+		&Builtin{Usage: "mul_(tensor, other)", Doc: "Multiplies tensor by the tensor or number other in place, writing into the data of tensor, and returns tensor.", Fn: func(args ...Object) Object {
+			return inPlace("mul_", "*", args)
+		},
+		},
+	},
+	{
+		"div_",
+		&Builtin{Usage: "div_(tensor, other)", Doc: "Divides tensor by the tensor or number other in place, writing into the data of tensor, and returns tensor.", Fn: func(args ...Object) Object {
+			return inPlace("div_", "/", args)
+		},
+		},
+	},
+	{
+		"string_builder",
+		&Builtin{Usage: "string_builder(strings...)", Doc: "Returns a string builder holding the strings given. Appending to a builder is cheap where concatenating strings with + copies them every time.", Fn: func(args ...Object) Object {
+			builder := &StringBuilder{}
+			if errObj := appendStrings("string_builder", builder, args); errObj != nil {
+				return errObj
+			}
+			return builder
+		},
+		},
+	},
+	{
+		"append",
+		&Builtin{Usage: "append(builder, strings...)", Doc: "Appends the strings to the string builder and returns the builder.", Fn: func(args ...Object) Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. got=%d, want at least 1", len(args))
+			}
+			builder, ok := args[0].(*StringBuilder)
+			if !ok {
+				return newError("first argument to `append` must be STRING_BUILDER, got %s", args[0].Type())
+			}
+			if errObj := appendStrings("append", builder, args[1:]); errObj != nil {
+				return errObj
+			}
+			return builder
+		},
+		},
+	},
+	{
+		"build",
+		&Builtin{Usage: "build(builder)", Doc: "Returns the string of the strings appended to the string builder.", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			builder, ok := args[0].(*StringBuilder)
+			if !ok {
+				return newError("argument to `build` must be STRING_BUILDER, got %s", args[0].Type())
+			}
+			return &String{Value: builder.Builder.String()}
+		},
+		},
+	},
+	{
+		"pmap",
+		contextual(&Builtin{Usage: "pmap(array, fn, workers)", Doc: "Returns the array of the results of calling fn on each element of array, in order, calling it on as many goroutines as the integer workers, one per CPU when it is left out. The calls share no state but the values they are given: assigning a global variable in them is an error. The first error stops the remaining calls.", ContextFn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			array, ok := args[0].(*Array)
+			if !ok {
+				return newError("first argument to `pmap` must be ARRAY, got %s", args[0].Type())
+			}
+			if !IsCallable(args[1]) {
+				return newError("second argument to `pmap` must be a function, got %s", args[1].Type())
+			}
+			workers := runtime.GOMAXPROCS(0)
+			if len(args) == 3 {
+				integer, ok := args[2].(*Integer)
+				if !ok {
+					return newError("third argument to `pmap` must be INTEGER, got %s", args[2].Type())
+				}
+				if integer.Value < 1 {
+					return newError("number of workers given to `pmap` must be positive, got %d", integer.Value)
+				}
+				workers = int(integer.Value)
+			}
+			return parallelMap(ctx, array.Elements, args[1], workers)
+		},
+		}),
+	},
+	{
+		"map",
+		contextual(&Builtin{Usage: "map(array, fn)", Doc: "Returns the array of the results of calling fn on each element of array, in order. The first error stops the remaining calls.", ContextFn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			array, ok := args[0].(*Array)
+			if !ok {
+				return newError("first argument to `map` must be ARRAY, got %s", args[0].Type())
+			}
+			if !IsCallable(args[1]) {
+				return newError("second argument to `map` must be a function, got %s", args[1].Type())
+			}
+
+			results := make([]Object, len(array.Elements))
+			for i, element := range array.Elements {
+				if errObj := canceled(ctx, "map"); errObj != nil {
+					return errObj
+				}
+				results[i] = ctx.Call(args[1], element)
+				if errObj, ok := results[i].(*Error); ok {
+					return errObj
+				}
+			}
+			return &Array{Elements: results}
+		},
+		}),
+	},
+	{
+		"sort",
+		contextual(&Builtin{Usage: "sort(array, less)", Doc: "Returns the elements of array sorted in increasing order, numbers by value and strings by bytes, or by the function less, called with two elements and returning whether the first comes before the second, when it is given. Equal elements keep their order.", ContextFn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			array, ok := args[0].(*Array)
+			if !ok {
+				return newError("first argument to `sort` must be ARRAY, got %s", args[0].Type())
+			}
+			less := naturalLess
+			if len(args) == 2 {
+				if !IsCallable(args[1]) {
+					return newError("second argument to `sort` must be a function, got %s", args[1].Type())
+				}
+				less = func(a, b Object) (bool, *Error) {
+					if errObj := canceled(ctx, "sort"); errObj != nil {
+						return false, errObj
+					}
+					switch result := ctx.Call(args[1], a, b).(type) {
+					case *Boolean:
+						return result.Value, nil
+					case *Error:
+						return false, result
+					default:
+						return false, newError("function given to `sort` must return BOOLEAN, got %s", typeOf(result))
+					}
+				}
+			}
+			return sortElements(array.Elements, less)
+		},
+		}),
+	},
+	{
+		"eval",
+		contextual(&Builtin{Usage: "eval(source)", Doc: "Runs the string source as a program of its own, with globals of its own, and returns the value of its last expression.", ContextFn: func(ctx *Context, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			source, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `eval` must be STRING, got %s", args[0].Type())
+			}
+			return ctx.Eval(source.Value)
+		},
+		}),
+	},
+	{
+		"upper",
+		&Builtin{Usage: "upper(string)", Doc: "Returns the string with its letters in upper case.", Fn: func(args ...Object) Object {
+			return mapString("upper", args, strings.ToUpper)
+		},
+		},
+	},
+	{
+		"lower",
+		&Builtin{Usage: "lower(string)", Doc: "Returns the string with its letters in lower case.", Fn: func(args ...Object) Object {
+			return mapString("lower", args, strings.ToLower)
+		},
+		},
+	},
+	{
+		"keys",
+		&Builtin{Usage: "keys(hash)", Doc: "Returns the keys of the hash as an array, in the order for loops visit them.", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError("argument to `keys` must be HASH, got %s", args[0].Type())
+			}
+			keys, _ := Iteration(hash)
+			return &Array{Elements: keys}
+		},
+		},
+	},
+	{
+		"values",
+		&Builtin{Usage: "values(hash)", Doc: "Returns the values of the hash as an array, in the order of its keys.", Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError("argument to `values` must be HASH, got %s", args[0].Type())
+			}
+			pairs := hash.Iterate()
+			values := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				values[i] = pair.Value
+			}
+			return &Array{Elements: values}
+		},
+		},
+	},
+}
+
+// mapString is a helper function that returns the string argument of the
+// builtin name with f applied to it
+func mapString(name string, args []Object, f func(string) string) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return newError("argument to `%s` must be STRING, got %s", name, args[0].Type())
+	}
+	return &String{Value: f(str.Value)}
+}
+
+// naturalLess is a helper function that orders numbers by value and strings
+// by bytes, for sort
+func naturalLess(a, b Object) (bool, *Error) {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value < b.Value, nil
+		case *Float:
+			return float64(a.Value) < b.Value, nil
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value < float64(b.Value), nil
+		case *Float:
+			return a.Value < b.Value, nil
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value < b.Value, nil
+		}
+	}
+	return false, newError("cannot compare %s and %s in `sort` without a function", typeOf(a), typeOf(b))
+}
+
+// sortElements is a helper function that returns a stable sorted copy of
+// elements in the order of less, or the first error less returns
+func sortElements(elements []Object, less func(a, b Object) (bool, *Error)) Object {
+	sorted := make([]Object, len(elements))
+	copy(sorted, elements)
+
+	var failed *Error
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+		before, errObj := less(sorted[i], sorted[j])
+		if errObj != nil {
+			failed = errObj
+		}
+		return before
+	})
+	if failed != nil {
+		return failed
+	}
+	return &Array{Elements: sorted}
+}
+
+// appendStrings is a helper function that appends the strings given to the
+// builtin name to builder
+func appendStrings(name string, builder *StringBuilder, args []Object) *Error {
+	for _, arg := range args {
+		str, ok := arg.(*String)
+		if !ok {
+			return newError("arguments appended by `%s` must be STRING, got %s", name, arg.Type())
+		}
+		builder.Builder.WriteString(str.Value)
+	}
+	return nil
+}
+
+// inPlace is a helper function that implements the builtin name, applying
+// operator in place to the tensor and the tensor or number given in args
+func inPlace(name, operator string, args []Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	tensor, ok := args[0].(*Tensor)
+	if !ok {
+		return newError("first argument to `%s` must be TENSOR, got %s", name, args[0].Type())
+	}
+	other := asTensor(args[1])
+	if other == nil {
+		return newError("second argument to `%s` must be a tensor or a number, got %s", name, args[1].Type())
+	}
+	if err := tensor.ArithmeticInPlace(operator, other); err != nil {
+		return newError("%s", err)
+	}
+	return tensor
+}
+
+// tensors is a helper function that returns the elements of an array of
+// tensors given to the builtin name as its argument what, nil for nulls
+func tensors(name, what string, obj Object) ([]*Tensor, *Error) {
+	array, ok := obj.(*Array)
+	if !ok {
+		return nil, newError("%s given to `%s` must be an array of tensors, got %s", what, name, obj.Type())
+	}
+	values := make([]*Tensor, len(array.Elements))
+	for i, element := range array.Elements {
+		switch element := element.(type) {
+		case *Tensor:
+			values[i] = element
+		case *Null:
+		default:
+			return nil, newError("%s given to `%s` must be an array of tensors, got %s", what, name, element.Type())
+		}
+	}
+	return values, nil
+}
+
+// tensorArray is a helper function that returns an array of tensors, with
+// nulls for the nil ones
+func tensorArray(values []*Tensor) *Array {
+	elements := make([]Object, len(values))
+	for i, value := range values {
+		elements[i] = value
+		if value == nil {
+			elements[i] = &Null{}
+		}
+	}
+	return &Array{Elements: elements}
+}
+
+// adamState is a helper function that returns the Adam optimizer for the
+// state given to adam_step, a hash returned by a previous step or null
+func adamState(obj Object) (*Adam, *Error) {
+	adam := NewAdam()
+	if _, ok := obj.(*Null); ok {
+		return adam, nil
+	}
+	state, ok := obj.(*Hash)
+	if !ok {
+		return nil, newError("state given to `adam_step` must be HASH or NULL, got %s", obj.Type())
+	}
+	if len(state.Pairs) == 0 {
+		return adam, nil
+	}
+
+	for _, pair := range state.Iterate() {
+		key, _ := pair.Key.(*String)
+		if key == nil {
+			return nil, newError("invalid optimizer state: key %s", pair.Key.Inspect())
+		}
+		var errObj *Error
+		switch key.Value {
+		case "step":
+			step, ok := pair.Value.(*Integer)
+			if !ok {
+				return nil, newError("invalid optimizer state: step must be INTEGER, got %s", pair.Value.Type())
+			}
+			adam.Step = step.Value
+		case "m":
+			adam.M, errObj = tensors("adam_step", "state", pair.Value)
+		case "v":
+			adam.V, errObj = tensors("adam_step", "state", pair.Value)
+		default:
+			errObj = setAdamOption(adam, key.Value, pair.Value)
+		}
+		if errObj != nil {
+			return nil, errObj
+		}
+	}
+	return adam, nil
+}
+
+// reduceTensor is a helper function that implements the builtin name,
+// returning the tensor reduce computes from the tensor given in args
+func reduceTensor(name string, args []Object, reduce func(t *Tensor) *Tensor) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	tensor, ok := args[0].(*Tensor)
+	if !ok {
+		return newError("argument to `%s` must be TENSOR, got %s", name, args[0].Type())
+	}
+	if err := tensor.Validate(); err != nil {
+		return newError("%s", err)
+	}
+	return reduce(tensor)
+}
+
+// adamOptions is a helper function that sets the settings of adam given to
+// adam_step in the hash opts
+func adamOptions(adam *Adam, opts Object) *Error {
+	hash, ok := opts.(*Hash)
+	if !ok {
+		return newError("opts given to `adam_step` must be HASH, got %s", opts.Type())
+	}
+	for _, pair := range hash.Iterate() {
+		key, ok := pair.Key.(*String)
+		if !ok {
+			return newError("unknown option of `adam_step`: %s", pair.Key.Inspect())
+		}
+		if errObj := setAdamOption(adam, key.Value, pair.Value); errObj != nil {
+			return errObj
+		}
+	}
+	return nil
+}
+
+// setAdamOption is a helper function that sets the setting name of adam to
+// the number value
+func setAdamOption(adam *Adam, name string, value Object) *Error {
+	var setting *float64
+	switch name {
+	case "lr":
+		setting = &adam.LR
+	case "beta1":
+		setting = &adam.Beta1
+	case "beta2":
+		setting = &adam.Beta2
+	case "eps":
+		setting = &adam.Eps
+	default:
+		return newError("unknown option of `adam_step`: %s", name)
+	}
+	number, ok := ScalarTensor(value)
+	if !ok {
+		return newError("option %s of `adam_step` must be a number, got %s", name, value.Type())
+	}
+	*setting = number.Data[0]
+	return nil
+}
+
+// asTensor is a helper function that returns a tensor, or a number as a
+// tensor of no dimension, and nil for other objects
+func asTensor(obj Object) *Tensor {
+	if tensor, ok := obj.(*Tensor); ok {
+		return tensor
+	}
+	if scalar, ok := ScalarTensor(obj); ok {
+		return scalar
+	}
+	return nil
+}
+
+// newFilledTensor is a helper function that implements the builtin name,
+// returning a tensor of the shape given in args filled by fill
+func newFilledTensor(name string, args []Object, fill func() float64) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	var shape []int64
+	if n, ok := args[0].(*Integer); ok {
+		shape = []int64{n.Value}
+	} else if shape, ok = integers(args[0]); !ok {
+		return newError("shape given to `%s` must be an integer or an array of integers, got %s", name, args[0].Inspect())
+	}
+
+	size := int64(1)
+	for _, d := range shape {
+		if d < 0 {
+			return newError("shape given to `%s` has a negative dimension: %v", name, shape)
+		}
+		size *= d
+	}
+	tensor := &Tensor{Shape: shape, Data: make([]float64, size)}
+	for i := range tensor.Data {
+		tensor.Data[i] = fill()
+	}
+	return tensor
+}
+
+// integers is a helper function that returns the values of an array of
+// integers and whether obj is one
+func integers(obj Object) ([]int64, bool) {
+	array, ok := obj.(*Array)
+	if !ok {
+		return nil, false
+	}
+	values := make([]int64, len(array.Elements))
+	for i, element := range array.Elements {
+		integer, ok := element.(*Integer)
+		if !ok {
+			return nil, false
+		}
+		values[i] = integer.Value
+	}
+	return values, true
+}
+
+// newHash is a helper function that returns a hash with string keys
+func newHash(pairs map[string]Object) *Hash {
+	hash := &Hash{Pairs: make(map[HashKey]HashPair, len(pairs))}
+	for key, value := range pairs {
+		k := &String{Value: key}
+		hash.Pairs[k.HashKey()] = HashPair{Key: k, Value: value}
+	}
+	return hash
 }
 
 // newError returns a new error object with the given format and arguments.
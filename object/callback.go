@@ -0,0 +1,46 @@
+// object/callback.go
+
+package object
+
+// callers holds how the engines call their functions, by type
+var callers = map[ObjectType]func(fn Object, args ...Object) Object{}
+
+// RegisterCaller registers how the functions of type t of an engine are
+// called. The engines register theirs when they are initialized.
+func RegisterCaller(t ObjectType, call func(fn Object, args ...Object) Object) {
+	callers[t] = call
+}
+
+// Call calls fn, a function of either engine or a builtin, with args and
+// returns its result. Extensions use it to call back the functions passed
+// to them. Failures are returned as an *Error.
+func Call(fn Object, args ...Object) Object {
+	var result Object
+	switch fn := fn.(type) {
+	case *Builtin:
+		result = fn.Fn(args...)
+	case *Extended:
+		result = fn.Fn(args...)
+	default:
+		call, ok := callers[typeOf(fn)]
+		if !ok {
+			return newError("not a function: %s", typeOf(fn))
+		}
+		result = call(fn, args...)
+	}
+
+	if result == nil {
+		return &Null{}
+	}
+	return result
+}
+
+// IsCallable reports whether obj can be called with Call
+func IsCallable(obj Object) bool {
+	switch obj.(type) {
+	case *Builtin, *Extended:
+		return true
+	}
+	_, ok := callers[typeOf(obj)]
+	return ok
+}
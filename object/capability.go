@@ -0,0 +1,91 @@
+// object/capability.go
+
+package object
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Capability is a kind of access to the system outside the interpreter that
+// extension functions declare they need
+type Capability string
+
+const (
+	CapFilesystem Capability = "fs"   // CapFilesystem reads and writes files
+	CapNetwork    Capability = "net"  // CapNetwork opens connections
+	CapExec       Capability = "exec" // CapExec runs programs
+	CapEnv        Capability = "env"  // CapEnv reads the environment variables
+)
+
+// Capabilities lists every capability
+var Capabilities = []Capability{CapFilesystem, CapNetwork, CapExec, CapEnv}
+
+var (
+	granted      = map[Capability]bool{CapFilesystem: true, CapNetwork: true, CapExec: true, CapEnv: true}
+	grantedMutex = sync.RWMutex{}
+)
+
+// SetCapabilities grants the capabilities caps and withholds the others.
+// Every capability is granted until it is called. Functions needing a
+// capability withheld fail when called.
+func SetCapabilities(caps []Capability) {
+	grantedMutex.Lock()
+	defer grantedMutex.Unlock()
+
+	granted = map[Capability]bool{}
+	for _, c := range caps {
+		granted[c] = true
+	}
+}
+
+// Granted reports whether the capability c is granted
+func Granted(c Capability) bool {
+	grantedMutex.RLock()
+	defer grantedMutex.RUnlock()
+	return granted[c]
+}
+
+// ParseCapabilities parses a list of capabilities separated by commas, such
+// as fs,env. The list all stands for every capability.
+func ParseCapabilities(list string) ([]Capability, error) {
+	if strings.TrimSpace(list) == "all" {
+		return append([]Capability{}, Capabilities...), nil
+	}
+
+	var caps []Capability
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !knownCapability(Capability(name)) {
+			return nil, fmt.Errorf("unknown capability %q, want fs, net, exec or env", name)
+		}
+		caps = append(caps, Capability(name))
+	}
+	return caps, nil
+}
+
+// knownCapability is a helper function that reports whether c is one of the
+// capabilities
+func knownCapability(c Capability) bool {
+	for _, known := range Capabilities {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// withheld is a helper function that returns the first capability of needs
+// that is not granted
+func withheld(needs []Capability) (Capability, bool) {
+	for _, c := range needs {
+		if !Granted(c) {
+			return c, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,160 @@
+// object/context.go
+
+package object
+
+import (
+	"context"
+	"io"
+	"monkey/imports"
+	"os"
+)
+
+// Context is what the engines call a builtin with besides its arguments:
+// the state of the interpreter running the call that the builtin uses, such
+// as where it writes. Each interpreter calls builtins with its own, so
+// builtins do not reach for the state of the process.
+type Context struct {
+	Out       io.Writer         // Out is where output is written, os.Stdout when nil
+	Context   context.Context   // Context cancels the builtins running long, such as those calling functions back, never when nil
+	Sandbox   []Capability      // Sandbox lists the capabilities builtins may use of those granted, all of them when nil
+	Args      []string          // Args are the arguments of the script returned by args(), those given to SetArgs when nil
+	Random    *Random           // Random generates the random numbers of the builtins, a generator of the process when nil
+	Functions *Registry         // Functions holds extension functions found before those registered with RegisterFunction
+	Engine    Engine            // Engine is the engine running the call, set by the engines
+	Hooks     any               // Hooks observe the evaluator running in the context, an *evaluator.Hooks, none when nil
+	Depth     int               // Depth is the number of calls the evaluator has in progress in the context
+	Modules   any               // Modules caches the modules the evaluator imported in the context, an *evaluator.Modules, those of the process when nil
+	Parallel  bool              // Parallel is set for the calls pmap makes on goroutines of their own, which may not assign global variables
+	Imports   *imports.Resolver // Imports finds the files imported in the context, in the directories of MONKEY_PATH when nil
+}
+
+// Engine is an engine calling builtins, which builtins use to call the
+// functions they are given and to evaluate source
+type Engine interface {
+	// Call calls fn, a function of the engine or a builtin, with args in
+	// ctx and returns its result. Failures are returned as an *Error.
+	Call(ctx *Context, fn Object, args ...Object) Object
+	// Eval runs the program src in ctx, in an environment of its own, and
+	// returns the value of its last expression. Failures are returned as an
+	// *Error.
+	Eval(ctx *Context, src string) Object
+}
+
+// ContextFunction is the function of a builtin called with a context
+type ContextFunction func(ctx *Context, args ...Object) Object
+
+// Output returns where output is written in the context, os.Stdout for a
+// nil context
+func (c *Context) Output() io.Writer {
+	if c == nil || c.Out == nil {
+		return os.Stdout
+	}
+	return c.Out
+}
+
+// Resolver returns what finds the files imported in the context, nil for a
+// nil context, which finds them in the directories of MONKEY_PATH
+func (c *Context) Resolver() *imports.Resolver {
+	if c == nil {
+		return nil
+	}
+	return c.Imports
+}
+
+// Err returns the error of the cancellation context once it is done, nil
+// until then
+func (c *Context) Err() error {
+	if c == nil || c.Context == nil {
+		return nil
+	}
+	return c.Context.Err()
+}
+
+// random is a helper function that returns the generator of the context
+func (c *Context) random() *Random {
+	if c == nil || c.Random == nil {
+		return generator
+	}
+	return c.Random
+}
+
+// args is a helper function that returns the arguments of the script in
+// the context
+func (c *Context) args() []string {
+	if c == nil || c.Args == nil {
+		scriptArgsMutex.RLock()
+		defer scriptArgsMutex.RUnlock()
+		return scriptArgs
+	}
+	return c.Args
+}
+
+// Function returns the extension function name of the registry of the
+// context, or else the one registered with RegisterFunction
+func (c *Context) Function(name string) (Extended, bool) {
+	if c == nil {
+		return GetExtendedFunction(name)
+	}
+	return c.Functions.Lookup(name)
+}
+
+// Granted reports whether the builtins called in the context may use the
+// capability cap: it is granted and the sandbox, if any, lists it
+func (c *Context) Granted(cap Capability) bool {
+	if !Granted(cap) {
+		return false
+	}
+	if c == nil || c.Sandbox == nil {
+		return true
+	}
+	for _, allowed := range c.Sandbox {
+		if allowed == cap {
+			return true
+		}
+	}
+	return false
+}
+
+// Call calls fn with args on the engine of the context, or with the package
+// function Call when there is none
+func (c *Context) Call(fn Object, args ...Object) Object {
+	if c == nil || c.Engine == nil {
+		return Call(fn, args...)
+	}
+	return c.Engine.Call(c, fn, args...)
+}
+
+// Eval runs the program src on the engine of the context
+func (c *Context) Eval(src string) Object {
+	if c == nil || c.Engine == nil {
+		return newError("no engine to evaluate source with")
+	}
+	return c.Engine.Eval(c, src)
+}
+
+// canceled is a helper function that returns the error of the builtin name
+// stopped by the cancellation of ctx, nil until ctx is canceled
+func canceled(ctx *Context, name string) *Error {
+	if err := ctx.Err(); err != nil {
+		return newError("`%s` stopped: %s", name, err)
+	}
+	return nil
+}
+
+// Call calls the builtin with args in ctx, which may be nil for the
+// default context
+func (b *Builtin) Call(ctx *Context, args ...Object) Object {
+	if b.ContextFn != nil {
+		return b.ContextFn(ctx, args...)
+	}
+	return b.Fn(args...)
+}
+
+// contextual is a helper function that returns b with Fn calling its
+// ContextFn in the default context, for the callers of Fn
+func contextual(b *Builtin) *Builtin {
+	b.Fn = func(args ...Object) Object {
+		return b.ContextFn(nil, args...)
+	}
+	return b
+}
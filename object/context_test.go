@@ -0,0 +1,55 @@
+package object
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextGranted(t *testing.T) {
+	tests := []struct {
+		ctx      *Context
+		expected bool
+	}{
+		{nil, true},
+		{&Context{}, true},
+		{&Context{Sandbox: []Capability{CapFilesystem}}, true},
+		{&Context{Sandbox: []Capability{CapNetwork}}, false},
+		{&Context{Sandbox: []Capability{}}, false},
+	}
+
+	for i, tt := range tests {
+		if granted := tt.ctx.Granted(CapFilesystem); granted != tt.expected {
+			t.Errorf("tests[%d]: wrong grant. want=%t, got=%t", i, tt.expected, granted)
+		}
+	}
+
+	SetCapabilities(nil)
+	defer SetCapabilities(Capabilities)
+	if (&Context{Sandbox: []Capability{CapFilesystem}}).Granted(CapFilesystem) {
+		t.Errorf("a sandbox granted a capability withheld from the process")
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := &Context{Context: ctx}
+
+	array := &Array{Elements: []Object{&String{Value: "a"}, &String{Value: "b"}}}
+	length := GetBuiltInByName("len")
+	for _, name := range []string{"map", "pmap"} {
+		result := GetBuiltInByName(name).Call(canceled, array, length)
+		errObj, ok := result.(*Error)
+		if !ok {
+			t.Fatalf("%s: result is not an error. got=%s", name, result.Inspect())
+		}
+		if expected := "`" + name + "` stopped: context canceled"; errObj.Message != expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", name, expected, errObj.Message)
+		}
+	}
+
+	result := GetBuiltInByName("map").Call(&Context{}, array, length)
+	if result.Inspect() != "[1, 1]" {
+		t.Errorf("wrong result of map without an engine. got=%s", result.Inspect())
+	}
+}
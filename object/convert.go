@@ -0,0 +1,150 @@
+// object/convert.go
+
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// FromGo returns the object for a Go value. Integers, floats, strings,
+// booleans and nil become the matching objects, byte slices strings,
+// [][]float64 a tensor with a row per slice, other slices and arrays arrays
+// and maps hashes. Objects are returned as they are. A value that cannot be
+// converted, such as a struct, is returned as an *Error.
+func FromGo(value interface{}) Object {
+	switch value := value.(type) {
+	case Object:
+		return value
+	case nil:
+		return &Null{}
+	case string:
+		return &String{Value: value}
+	case []byte:
+		return &String{Value: string(value)}
+	case bool:
+		return &Boolean{Value: value}
+	case [][]float64:
+		return tensorFromRows(value)
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Integer{Value: int64(v.Uint())}
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}
+	case reflect.String:
+		return &String{Value: v.String()}
+	case reflect.Bool:
+		return &Boolean{Value: v.Bool()}
+	case reflect.Slice, reflect.Array:
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element := FromGo(v.Index(i).Interface())
+			if isConversionError(element, v.Index(i).Interface()) {
+				return element
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}
+	case reflect.Map:
+		hash := &Hash{Pairs: make(map[HashKey]HashPair, v.Len())}
+		iter := v.MapRange()
+		for iter.Next() {
+			key := FromGo(iter.Key().Interface())
+			if isConversionError(key, iter.Key().Interface()) {
+				return key
+			}
+			hashable, ok := key.(Hashable)
+			if !ok {
+				return &Error{Message: fmt.Sprintf("unusable as hash key: %s", key.Type())}
+			}
+			element := FromGo(iter.Value().Interface())
+			if isConversionError(element, iter.Value().Interface()) {
+				return element
+			}
+			hash.Pairs[hashable.HashKey()] = HashPair{Key: key, Value: element}
+		}
+		return hash
+	}
+	return &Error{Message: fmt.Sprintf("cannot convert %T to a Monkey value", value)}
+}
+
+// isConversionError is a helper function that reports whether FromGo failed
+// to convert value, rather than value being an error object itself
+func isConversionError(obj Object, value interface{}) bool {
+	_, isError := obj.(*Error)
+	_, wasError := value.(*Error)
+	return isError && !wasError
+}
+
+// tensorFromRows is a helper function that returns the tensor holding rows
+func tensorFromRows(rows [][]float64) Object {
+	tensor := &Tensor{Shape: []int64{int64(len(rows)), 0}, Data: []float64{}}
+	for i, row := range rows {
+		if i == 0 {
+			tensor.Shape[1] = int64(len(row))
+		} else if int64(len(row)) != tensor.Shape[1] {
+			return &Error{Message: fmt.Sprintf("rows of a tensor must have the same length, got %d and %d", tensor.Shape[1], len(row))}
+		}
+		tensor.Data = append(tensor.Data, row...)
+	}
+	return tensor
+}
+
+// ToGo returns the Go value for an object, the reverse of FromGo. Integers
+// become int64, floats float64, strings string, booleans bool and null nil.
+// Arrays become []interface{}, hashes map[interface{}]interface{} and
+// tensors [][]float64 with a row per element of their last dimension. Other
+// objects, such as functions, are returned as they are.
+func ToGo(obj Object) interface{} {
+	switch obj := obj.(type) {
+	case nil, *Null:
+		return nil
+	case *Integer:
+		return obj.Value
+	case *Float:
+		return obj.Value
+	case *String:
+		return obj.Value
+	case *Boolean:
+		return obj.Value
+	case *Array:
+		values := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			values[i] = ToGo(element)
+		}
+		return values
+	case *Hash:
+		values := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			values[ToGo(pair.Key)] = ToGo(pair.Value)
+		}
+		return values
+	case *Tensor:
+		return tensorRows(obj)
+	default:
+		return obj
+	}
+}
+
+// tensorRows is a helper function that splits the data of a tensor into rows
+// as long as its last dimension
+func tensorRows(t *Tensor) [][]float64 {
+	width := len(t.Data)
+	if len(t.Shape) > 0 {
+		width = int(t.Shape[len(t.Shape)-1])
+	}
+	if width == 0 {
+		return [][]float64{}
+	}
+
+	rows := make([][]float64, 0, len(t.Data)/width)
+	for start := 0; start+width <= len(t.Data); start += width {
+		rows = append(rows, append([]float64{}, t.Data[start:start+width]...))
+	}
+	return rows
+}
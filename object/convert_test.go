@@ -0,0 +1,84 @@
+package object
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{int8(-3), "-3"},
+		{uint32(7), "7"},
+		{float32(0.5), "0.500000"},
+		{"hi", "hi"},
+		{[]byte("raw"), "raw"},
+		{true, "true"},
+		{nil, "null"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{[]interface{}{1, []interface{}{"x"}, nil}, "[1, [x], null]"},
+		{[][]float64{{1, 2}, {3, 4}}, "@[2, 2], [1.000000, 2.000000, 3.000000, 4.000000]"},
+		{&Integer{Value: 5}, "5"},
+	}
+
+	for _, tt := range tests {
+		if obj := FromGo(tt.input); obj.Inspect() != tt.expected {
+			t.Errorf("wrong object for %#v. want=%s, got=%s", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	errors := []struct {
+		input    interface{}
+		expected string
+	}{
+		{struct{}{}, "cannot convert struct {} to a Monkey value"},
+		{[]interface{}{1, struct{}{}}, "cannot convert struct {}"},
+		{map[interface{}]int{1.5: 1}, "unusable as hash key: FLOAT"},
+		{[][]float64{{1, 2}, {3}}, "rows of a tensor must have the same length"},
+	}
+
+	for _, tt := range errors {
+		errObj, ok := FromGo(tt.input).(*Error)
+		if !ok || !strings.Contains(errObj.Message, tt.expected) {
+			t.Errorf("wrong error for %#v. want=%q, got=%v", tt.input, tt.expected, errObj)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	key := &String{Value: "k"}
+	hash := &Hash{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key, Value: &Boolean{Value: true}}}}
+	fn := &Builtin{}
+
+	tests := []struct {
+		input    Object
+		expected interface{}
+	}{
+		{&Integer{Value: 3}, int64(3)},
+		{&Float{Value: 1.5}, 1.5},
+		{&String{Value: "s"}, "s"},
+		{&Null{}, nil},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &Null{}}}, []interface{}{int64(1), nil}},
+		{hash, map[interface{}]interface{}{"k": true}},
+		{&Tensor{Shape: []int64{2, 3}, Data: []float64{1, 2, 3, 4, 5, 6}}, [][]float64{{1, 2, 3}, {4, 5, 6}}},
+		{&Tensor{Shape: []int64{2}, Data: []float64{1, 2}}, [][]float64{{1, 2}}},
+		{fn, fn},
+	}
+
+	for _, tt := range tests {
+		if value := ToGo(tt.input); !reflect.DeepEqual(value, tt.expected) {
+			t.Errorf("wrong value for %s. want=%#v, got=%#v", tt.input.Inspect(), tt.expected, value)
+		}
+	}
+
+	// Values make the round trip
+	value := []interface{}{int64(1), "two", map[interface{}]interface{}{"three": 3.0}}
+	if back := ToGo(FromGo(value)); !reflect.DeepEqual(back, value) {
+		t.Errorf("value changed by the round trip. want=%#v, got=%#v", value, back)
+	}
+}
@@ -1,5 +1,7 @@
 package object
 
+import "sort"
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
@@ -11,13 +13,38 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s}
 }
 
+// NewFunctionEnvironment returns an environment enclosed by outer holding the
+// variables named by locals in slots, read and set by index with Slot and
+// SetSlot. Other variables are bound by name in a map made when the first is.
+func NewFunctionEnvironment(outer *Environment, locals []string) *Environment {
+	return &Environment{outer: outer, locals: locals, slots: make([]Object, len(locals))}
+}
+
+// NewModuleEnvironment returns the environment of the top level of the file
+// at path, imported by a program
+func NewModuleEnvironment(path string) *Environment {
+	env := NewEnvironment()
+	env.file = path
+	return env
+}
+
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	file  string   // file is the path of the imported file of a module environment
+	ctx   *Context // ctx is the context of the builtins called in the environment, set by SetContext
+
+	locals []string // locals names the slots
+	slots  []Object // slots holds the values of the locals, nil until they are set
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
+	if !ok {
+		if i := e.local(name); i != -1 && e.slots[i] != nil {
+			obj, ok = e.slots[i], true
+		}
+	}
 	if !ok && e.outer != nil {
 		obj, ok = e.outer.Get(name)
 	}
@@ -25,6 +52,119 @@ func (e *Environment) Get(name string) (Object, bool) {
 }
 
 func (e *Environment) Set(name string, val Object) Object {
+	if i := e.local(name); i != -1 {
+		e.slots[i] = val
+		return val
+	}
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
 	return val
 }
+
+// Assign sets the variable name in the environment binding it, this one or
+// the nearest one enclosing it, and reports whether any binds it
+func (e *Environment) Assign(name string, val Object) bool {
+	for ; e != nil; e = e.outer {
+		if _, ok := e.store[name]; ok {
+			e.store[name] = val
+			return true
+		}
+		if i := e.local(name); i != -1 && e.slots[i] != nil {
+			e.slots[i] = val
+			return true
+		}
+	}
+	return false
+}
+
+// Binder returns the environment binding the variable name, this one or the
+// nearest one enclosing it, nil when none does
+func (e *Environment) Binder(name string) *Environment {
+	for ; e != nil; e = e.outer {
+		if _, ok := e.store[name]; ok {
+			return e
+		}
+		if i := e.local(name); i != -1 && e.slots[i] != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Slot returns the value of the local at index of the environment depth
+// levels out, and whether it is set
+func (e *Environment) Slot(depth, index int) (Object, bool) {
+	if e = e.Outer(depth); e == nil || index >= len(e.slots) {
+		return nil, false
+	}
+	obj := e.slots[index]
+	return obj, obj != nil
+}
+
+// SetSlot sets the local at index
+func (e *Environment) SetSlot(index int, val Object) Object {
+	e.slots[index] = val
+	return val
+}
+
+// Outer returns the environment depth levels out, nil when there is none
+func (e *Environment) Outer(depth int) *Environment {
+	for ; depth > 0 && e != nil; depth-- {
+		e = e.outer
+	}
+	return e
+}
+
+// File returns the path of the imported file whose top level encloses the
+// environment, empty for the main program
+func (e *Environment) File() string {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e.file
+}
+
+// SetContext sets the context of the builtins called in the environment,
+// and in the environments it encloses
+func (e *Environment) SetContext(ctx *Context) {
+	e.ctx = ctx
+}
+
+// Context returns the context of the builtins called in the environment,
+// set on it or on the nearest environment enclosing it, nil when none is set
+func (e *Environment) Context() *Context {
+	for ; e != nil; e = e.outer {
+		if e.ctx != nil {
+			return e.ctx
+		}
+	}
+	return nil
+}
+
+// local is a helper function that returns the index of the slot of name,
+// or -1 when it has none
+func (e *Environment) local(name string) int {
+	for i, local := range e.locals {
+		if local == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Names returns the names bound directly in this environment, sorted
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store)+len(e.locals))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	for i, name := range e.locals {
+		if e.slots[i] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,35 @@
+// object/methods.go
+
+package object
+
+// methods holds the names of the builtins callable as methods of the values
+// of each type: value.name(args...) calls name(value, args...)
+var methods = map[ObjectType][]string{
+	STRING_OBJ:         {"len", "upper", "lower"},
+	ARRAY_OBJ:          {"len", "first", "last", "rest", "push", "pop", "join", "map", "pmap", "sort"},
+	HASH_OBJ:           {"keys", "values"},
+	TENSOR_OBJ:         {"reshape", "transpose", "dtype", "astype", "count_nonzero", "requires_grad", "backward", "grad", "matmul", "sum", "mean"},
+	STRING_BUILDER_OBJ: {"append", "build"},
+}
+
+// Field returns the value of the field name of a hash, as read by
+// hash.name, and whether receiver is a hash with that field
+func Field(receiver Object, name string) (Object, bool) {
+	hash, ok := receiver.(*Hash)
+	if !ok {
+		return nil, false
+	}
+	pair, ok := hash.Pairs[(&String{Value: name}).HashKey()]
+	return pair.Value, ok
+}
+
+// LookupMethod returns the builtin called by the method name of receiver.
+// Fields of hashes named name come first, see Field.
+func LookupMethod(receiver Object, name string) (*Builtin, bool) {
+	for _, method := range methods[receiver.Type()] {
+		if method == name {
+			return GetBuiltInByName(name), true
+		}
+	}
+	return nil, false
+}
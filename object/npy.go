@@ -0,0 +1,222 @@
+// object/npy.go
+
+package object
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Tensors are saved in the .npy format of NumPy, version 1.0: the magic
+// string "\x93NUMPY", the version bytes 1 and 0, the length of the header as
+// a little-endian uint16 and the header, a Python dict literal such as
+//
+//	{'descr': '<f8', 'fortran_order': False, 'shape': (2, 3), }
+//
+// padded with spaces and ended by a newline so that the data starts at a
+// multiple of 64 bytes. The data follows in row-major order, little-endian.
+// Files of versions 2.0 and 3.0, whose header length is a uint32, are read
+// as well, and so are Fortran-ordered files.
+
+// npyMagic starts every .npy file
+const npyMagic = "\x93NUMPY"
+
+// npyDescrs maps the element types to the type descriptions they are saved
+// with
+var npyDescrs = map[DType]string{Float64: "<f8", Float32: "<f4", Int64: "<i8", Bool: "|b1"}
+
+// npyHeader matches the fields of the header of a .npy file
+var npyHeader = regexp.MustCompile(`'(descr|fortran_order|shape)'\s*:\s*('[^']*'|True|False|\([^)]*\))`)
+
+// WriteNPY writes t to w in the .npy format
+func WriteNPY(w io.Writer, t *Tensor) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	dims := make([]string, len(t.Shape))
+	for i, d := range t.Shape {
+		dims[i] = strconv.FormatInt(d, 10)
+	}
+	shape := "(" + strings.Join(dims, ", ") + ")"
+	if len(dims) == 1 {
+		shape = "(" + dims[0] + ",)"
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': %s, }", npyDescrs[t.ElementType()], shape)
+
+	// The magic string, the version and the length take 10 bytes
+	padding := 64 - (10+len(header)+1)%64
+	if padding == 64 {
+		padding = 0
+	}
+	header += strings.Repeat(" ", padding) + "\n"
+
+	out := bufio.NewWriter(w)
+	out.WriteString(npyMagic)
+	out.Write([]byte{1, 0})
+	binary.Write(out, binary.LittleEndian, uint16(len(header)))
+	out.WriteString(header)
+
+	buf := make([]byte, 8)
+	for _, v := range t.Data {
+		switch t.ElementType() {
+		case Float32:
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(v)))
+			out.Write(buf[:4])
+		case Int64:
+			binary.LittleEndian.PutUint64(buf, uint64(int64(v)))
+			out.Write(buf)
+		case Bool:
+			out.WriteByte(byte(v))
+		default:
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(v))
+			out.Write(buf)
+		}
+	}
+	return out.Flush()
+}
+
+// ReadNPY reads a tensor in the .npy format from r. Elements of type
+// float64, float32, int64, int32, uint8 and bool are read, as float64,
+// float32 and int64 tensors and masks.
+func ReadNPY(r io.Reader) (*Tensor, error) {
+	in := bufio.NewReader(r)
+
+	prefix := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(in, prefix); err != nil || string(prefix[:len(npyMagic)]) != npyMagic {
+		return nil, errors.New("not a .npy file")
+	}
+	var headerLen uint32
+	switch major := prefix[len(npyMagic)]; major {
+	case 1:
+		var length uint16
+		if err := binary.Read(in, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		headerLen = uint32(length)
+	case 2, 3:
+		if err := binary.Read(in, binary.LittleEndian, &headerLen); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported .npy version %d", major)
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for _, match := range npyHeader.FindAllStringSubmatch(string(header), -1) {
+		fields[match[1]] = match[2]
+	}
+	shape, err := parseNPYShape(fields["shape"])
+	if err != nil {
+		return nil, err
+	}
+	descr := strings.Trim(fields["descr"], "'")
+
+	// Fortran-ordered data holds the transpose of the tensor in row-major
+	// order
+	fortran := fields["fortran_order"] == "True"
+	if fortran {
+		for i, j := 0, len(shape)-1; i < j; i, j = i+1, j-1 {
+			shape[i], shape[j] = shape[j], shape[i]
+		}
+	}
+
+	size := int64(1)
+	for _, d := range shape {
+		size *= d
+	}
+	tensor := &Tensor{Shape: shape, Data: make([]float64, size)}
+	if err := readNPYData(in, descr, tensor); err != nil {
+		return nil, err
+	}
+
+	if fortran {
+		return tensor.Transpose(nil)
+	}
+	return tensor, nil
+}
+
+// parseNPYShape is a helper function that parses the shape of a .npy header,
+// a Python tuple of integers
+func parseNPYShape(tuple string) ([]int64, error) {
+	if !strings.HasPrefix(tuple, "(") {
+		return nil, errors.New("invalid .npy header: no shape")
+	}
+	shape := []int64{}
+	for _, dim := range strings.Split(strings.Trim(tuple, "()"), ",") {
+		dim = strings.TrimSpace(dim)
+		if dim == "" {
+			continue
+		}
+		d, err := strconv.ParseInt(dim, 10, 64)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid .npy header: bad shape %s", tuple)
+		}
+		shape = append(shape, d)
+	}
+	return shape, nil
+}
+
+// readNPYData is a helper function that reads the elements of tensor, of the
+// type described by descr, and sets the element type of tensor
+func readNPYData(in io.Reader, descr string, tensor *Tensor) error {
+	var width int
+	var order binary.ByteOrder = binary.LittleEndian
+	if strings.HasPrefix(descr, ">") {
+		order = binary.BigEndian
+	}
+	kind := strings.TrimLeft(descr, "<>|=")
+	switch kind {
+	case "f8", "i8":
+		width = 8
+	case "f4", "i4":
+		width = 4
+	case "u1", "b1":
+		width = 1
+	default:
+		return fmt.Errorf("unsupported .npy element type %s", descr)
+	}
+
+	switch kind {
+	case "f4":
+		tensor.DType = Float32
+	case "i8", "i4", "u1":
+		tensor.DType = Int64
+	case "b1":
+		tensor.DType = Bool
+	}
+
+	data := make([]byte, width*len(tensor.Data))
+	if _, err := io.ReadFull(in, data); err != nil {
+		return fmt.Errorf("truncated .npy data: %s", err)
+	}
+	for i := range tensor.Data {
+		element := data[i*width : (i+1)*width]
+		switch kind {
+		case "f8":
+			tensor.Data[i] = math.Float64frombits(order.Uint64(element))
+		case "f4":
+			tensor.Data[i] = float64(math.Float32frombits(order.Uint32(element)))
+		case "i8":
+			tensor.Data[i] = float64(int64(order.Uint64(element)))
+		case "i4":
+			tensor.Data[i] = float64(int32(order.Uint32(element)))
+		case "u1":
+			tensor.Data[i] = float64(element[0])
+		case "b1":
+			tensor.Data[i] = Bool.Convert(float64(element[0]))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package object
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNPYRoundTrip(t *testing.T) {
+	tests := []*Tensor{
+		{Shape: []int64{2, 3}, Data: []float64{1, 2, 3, 4, 5, 6.5}},
+		{Shape: []int64{3}, Data: []float64{0.5, -1, 2}, DType: Float32},
+		{Shape: []int64{2}, Data: []float64{-7, 9}, DType: Int64},
+		{Shape: []int64{2, 1}, Data: []float64{1, 0}, DType: Bool},
+		{Shape: []int64{}, Data: []float64{42}},
+	}
+
+	for _, tensor := range tests {
+		var buf bytes.Buffer
+		if err := WriteNPY(&buf, tensor); err != nil {
+			t.Fatalf("WriteNPY failed: %s", err)
+		}
+		headerLen := binary.LittleEndian.Uint16(buf.Bytes()[8:10])
+		if (10+int(headerLen))%64 != 0 || buf.Bytes()[9+headerLen] != '\n' {
+			t.Errorf("data of %s not aligned on 64 bytes", tensor.Inspect())
+		}
+
+		read, err := ReadNPY(&buf)
+		if err != nil {
+			t.Fatalf("ReadNPY failed: %s", err)
+		}
+		if !reflect.DeepEqual(read, tensor) {
+			t.Errorf("tensor changed by the round trip. want=%s, got=%s", tensor.Inspect(), read.Inspect())
+		}
+	}
+}
+
+func TestReadNPY(t *testing.T) {
+	// npy is a helper function that returns a version 1.0 file with the given
+	// header and data
+	npy := func(header string, data ...interface{}) []byte {
+		var buf bytes.Buffer
+		buf.WriteString("\x93NUMPY\x01\x00")
+		binary.Write(&buf, binary.LittleEndian, uint16(len(header)))
+		buf.WriteString(header)
+		for _, d := range data {
+			binary.Write(&buf, binary.LittleEndian, d)
+		}
+		return buf.Bytes()
+	}
+
+	fortran := npy("{'descr': '<f8', 'fortran_order': True, 'shape': (2, 3), }\n", []float64{1, 4, 2, 5, 3, 6})
+	tensor, err := ReadNPY(bytes.NewReader(fortran))
+	if err != nil {
+		t.Fatalf("ReadNPY failed: %s", err)
+	}
+	if !reflect.DeepEqual(tensor.Shape, []int64{2, 3}) || !reflect.DeepEqual(tensor.Data, []float64{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("wrong Fortran-ordered tensor. got=%s", tensor.Inspect())
+	}
+
+	ints := npy("{'descr': '<i4', 'fortran_order': False, 'shape': (3,), }\n", []int32{-1, 0, 7})
+	if tensor, err := ReadNPY(bytes.NewReader(ints)); err != nil || tensor.Inspect() != "@[3], [-1, 0, 7], int64" {
+		t.Errorf("wrong int32 tensor. got=%v (%v)", tensor, err)
+	}
+
+	big := []byte("\x93NUMPY\x01\x00")
+	big = append(big, 0, 0)
+	header := "{'descr': '>f8', 'fortran_order': False, 'shape': (1,), }\n"
+	binary.LittleEndian.PutUint16(big[8:], uint16(len(header)))
+	big = append(big, header...)
+	big = binary.BigEndian.AppendUint64(big, math.Float64bits(2.5))
+	if tensor, err := ReadNPY(bytes.NewReader(big)); err != nil || tensor.Data[0] != 2.5 {
+		t.Errorf("wrong big-endian tensor. got=%v (%v)", tensor, err)
+	}
+
+	errors := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte("PK\x03\x04"), "not a .npy file"},
+		{npy("{'descr': '<c16', 'fortran_order': False, 'shape': (1,), }\n"), "unsupported .npy element type <c16"},
+		{npy("{'descr': '<f8', 'fortran_order': False, 'shape': (2,), }\n", 1.0), "truncated .npy data"},
+		{npy("{'descr': '<f8', 'fortran_order': False, }\n"), "invalid .npy header: no shape"},
+	}
+
+	for _, tt := range errors {
+		if _, err := ReadNPY(bytes.NewReader(tt.input)); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// object/numbers.go
+
+package object
+
+// Numbers are immutable, so the engines share one Integer for each of the
+// small integers and take the others from arenas: allocating numbers one
+// at a time costs an allocation for every intermediate result of a
+// computation, where an arena allocates them by blocks.
+
+const (
+	minSmallInteger = -128
+	maxSmallInteger = 1023
+)
+
+// smallIntegers holds the Integer of every small integer
+var smallIntegers = func() []Integer {
+	integers := make([]Integer, maxSmallInteger-minSmallInteger+1)
+	for i := range integers {
+		integers[i].Value = int64(i + minSmallInteger)
+	}
+	return integers
+}()
+
+// NewInteger returns an Integer of value, shared for small integers
+func NewInteger(value int64) *Integer {
+	if value >= minSmallInteger && value <= maxSmallInteger {
+		return &smallIntegers[value-minSmallInteger]
+	}
+	return &Integer{Value: value}
+}
+
+// FloorDivide returns the quotient of a and b rounded toward negative
+// infinity, where a / b rounds it toward zero. b must not be zero.
+func FloorDivide(a, b int64) int64 {
+	quotient := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		quotient--
+	}
+	return quotient
+}
+
+// Power returns a raised to the power of b by repeated squaring, wrapping
+// around on overflow like multiplication. b must not be negative.
+func Power(a, b int64) int64 {
+	result := int64(1)
+	for ; b > 0; b >>= 1 {
+		if b&1 == 1 {
+			result *= a
+		}
+		a *= a
+	}
+	return result
+}
+
+// arenaBlock is the number of numbers an arena allocates at once
+const arenaBlock = 256
+
+// Arena allocates numbers by blocks. The numbers it returns stay valid once
+// it is released, but a number kept alive keeps its whole block alive. The
+// zero Arena is ready to use. An Arena is not safe for concurrent use.
+type Arena struct {
+	integers []Integer
+	floats   []Float
+}
+
+// Integer returns an Integer of value, shared for small integers
+func (a *Arena) Integer(value int64) *Integer {
+	if value >= minSmallInteger && value <= maxSmallInteger {
+		return &smallIntegers[value-minSmallInteger]
+	}
+	if len(a.integers) == 0 {
+		a.integers = make([]Integer, arenaBlock)
+	}
+	integer := &a.integers[0]
+	integer.Value = value
+	a.integers = a.integers[1:]
+	return integer
+}
+
+// Float returns a Float of value
+func (a *Arena) Float(value float64) *Float {
+	if len(a.floats) == 0 {
+		a.floats = make([]Float, arenaBlock)
+	}
+	float := &a.floats[0]
+	float.Value = value
+	a.floats = a.floats[1:]
+	return float
+}
+
+// Release drops the blocks of the arena, so the numbers left in them are
+// freed with the numbers it returned
+func (a *Arena) Release() {
+	a.integers, a.floats = nil, nil
+}
@@ -0,0 +1,52 @@
+package object
+
+import "testing"
+
+func TestNumberAllocation(t *testing.T) {
+	if NewInteger(7) != NewInteger(7) || NewInteger(-128) != NewInteger(-128) {
+		t.Errorf("small integers are not shared")
+	}
+	if NewInteger(5000) == NewInteger(5000) {
+		t.Errorf("large integers are shared")
+	}
+
+	var arena Arena
+	var integers []*Integer
+	var floats []*Float
+	for i := 0; i < 2*arenaBlock+3; i++ {
+		integers = append(integers, arena.Integer(int64(i*1000)))
+		floats = append(floats, arena.Float(float64(i)/2))
+		if i == arenaBlock {
+			arena.Release()
+		}
+	}
+	for i := range integers {
+		if integers[i].Value != int64(i*1000) || floats[i].Value != float64(i)/2 {
+			t.Fatalf("number %d overwritten: %d, %f", i, integers[i].Value, floats[i].Value)
+		}
+	}
+	if arena.Integer(3) != NewInteger(3) {
+		t.Errorf("arena does not share small integers")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { arena.Integer(1 << 40) }); allocs > 0.1 {
+		t.Errorf("arena allocates %v times per integer", allocs)
+	}
+}
+
+func TestPower(t *testing.T) {
+	tests := []struct {
+		a, b, expected int64
+	}{
+		{2, 0, 1},
+		{2, 10, 1024},
+		{-3, 3, -27},
+		{0, 0, 1},
+		{10, 18, 1000000000000000000},
+	}
+
+	for _, tt := range tests {
+		if got := Power(tt.a, tt.b); got != tt.expected {
+			t.Errorf("Power(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
@@ -28,11 +28,22 @@ const (
 	HASH_OBJ              = "HASH"
 	CLOSURE_OBJ           = "CLOSURE"
 	TENSOR_OBJ            = "TENSOR"
+	STRING_BUILDER_OBJ    = "STRING_BUILDER"
+	TUPLE_OBJ             = "TUPLE"
 )
 
 type Closure struct {
-	Fn   *CompiledFunction
-	Free []Object
+	Fn      *CompiledFunction
+	Free    []Object
+	Program *Program // Program is the program the closure was created by, set by the VM
+}
+
+// Program holds the constants and globals of the bytecode a closure was
+// created by, which it needs to be called back from outside the VM
+type Program struct {
+	Constants []Object
+	Globals   []Object
+	Builtins  Context // Builtins is the context of the builtins called by the program, but for the engine
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
@@ -91,11 +102,62 @@ func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 // Error
 type Error struct {
 	Message string
+	Stack   []string     // Stack lists the calls the error propagated out of, innermost first
+	Line    int          // Line is the line of the statement that raised the error, 0 when unknown
+	Imports []ImportSite // Imports lists where an error raised in an imported file comes from, innermost first
 }
 
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
+// ImportSite is a line of a file an error propagated out of, the file being
+// empty for the main program
+type ImportSite struct {
+	File string
+	Line int
+}
+
+// ImportChain describes where an error raised in an imported file comes
+// from, as in "error in helper.mky:12, imported from main.mky:3". The first
+// site is where the error was raised and main names the main program. Sites
+// with an unknown line, 0, only name their file.
+func ImportChain(sites []ImportSite, main string) string {
+	parts := make([]string, len(sites))
+	for i, site := range sites {
+		location := site.File
+		if location == "" {
+			location = main
+		}
+		if site.Line != 0 {
+			location = fmt.Sprintf("%s:%d", location, site.Line)
+		}
+		if i == 0 {
+			parts[i] = "error in " + location
+		} else {
+			parts[i] = "imported from " + location
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// CompactStack returns a stack trace with runs of the same call, as left by
+// deep recursion, shortened to the call and a line counting the others
+func CompactStack(stack []string) []string {
+	var compact []string
+	for i := 0; i < len(stack); {
+		j := i + 1
+		for j < len(stack) && stack[j] == stack[i] {
+			j++
+		}
+		compact = append(compact, stack[i])
+		if repeated := j - i - 1; repeated > 0 {
+			compact = append(compact, fmt.Sprintf("... %d more", repeated))
+		}
+		i = j
+	}
+	return compact
+}
+
 type Import struct {
 	Path string
 }
@@ -107,6 +169,8 @@ type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Name       string
+	Locals     []string // Locals names the slots of the environments of calls, see NewFunctionEnvironment
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -138,7 +202,10 @@ func (e *Extended) Inspect() string  { return "extended function" }
 type BuiltInFunction func(args ...Object) Object
 
 type Builtin struct {
-	Fn BuiltInFunction
+	Fn        BuiltInFunction
+	ContextFn ContextFunction // ContextFn is called by the engines instead of Fn when set, with the context of the call
+	Usage     string          // Usage shows how the builtin is called, e.g. len(value)
+	Doc       string          // Doc describes what the builtin does
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -148,6 +215,13 @@ func (b *Builtin) Inspect() string  { return "builtin function" }
 type Tensor struct {
 	Shape []int64
 	Data  []float64
+	DType DType // DType is the type of the elements, float64 when empty
+
+	RequiresGrad bool    // RequiresGrad is set for tensors Backward computes the gradient of
+	Grad         *Tensor // Grad is the gradient set by Backward
+
+	scalar bool      // scalar is set for numbers turned into tensors, see ScalarTensor
+	node   *gradNode // node records how the tensor was computed, see autograd.go
 }
 
 func (t *Tensor) Type() ObjectType { return TENSOR_OBJ }
@@ -163,7 +237,7 @@ func (t *Tensor) Inspect() string {
 	// Print out the data
 	data := []string{}
 	for _, d := range t.Data {
-		data = append(data, fmt.Sprintf("%f", d))
+		data = append(data, t.ElementType().Format(d))
 	}
 
 	out.WriteString("@[")
@@ -171,10 +245,24 @@ func (t *Tensor) Inspect() string {
 	out.WriteString("], [")
 	out.WriteString(strings.Join(data, ", "))
 	out.WriteString("]")
+	if t.ElementType() != Float64 {
+		out.WriteString(", " + string(t.ElementType()))
+	}
 
 	return out.String()
 }
 
+// StringBuilder accumulates strings appended one by one and builds them into
+// a string once, without copying the accumulated strings on every append
+type StringBuilder struct {
+	Builder strings.Builder
+}
+
+func (sb *StringBuilder) Type() ObjectType { return STRING_BUILDER_OBJ }
+func (sb *StringBuilder) Inspect() string {
+	return fmt.Sprintf("string_builder(%d bytes)", sb.Builder.Len())
+}
+
 type Array struct {
 	Elements []Object
 }
@@ -195,6 +283,34 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
+// Tuple holds the values returned together by return a, b;, which a let
+// statement binds to names of their own, let x, y = f();
+type Tuple struct {
+	Elements []Object
+}
+
+func (t *Tuple) Type() ObjectType { return TUPLE_OBJ }
+func (t *Tuple) Inspect() string {
+	elements := make([]string, len(t.Elements))
+	for i, el := range t.Elements {
+		elements[i] = el.Inspect()
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+// Unpack returns the values of a tuple bound to n names by a let statement.
+// It is an error for value not to be a tuple of n values.
+func Unpack(value Object, n int) ([]Object, error) {
+	tuple, ok := value.(*Tuple)
+	if !ok {
+		return nil, fmt.Errorf("cannot unpack %s into %d names", value.Type(), n)
+	}
+	if len(tuple.Elements) != n {
+		return nil, fmt.Errorf("cannot unpack %d values into %d names", len(tuple.Elements), n)
+	}
+	return tuple.Elements, nil
+}
+
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
@@ -235,7 +351,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.Iterate() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -256,6 +372,11 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	Name          string
+	Lines         code.LineTable
+	Calls         code.CallTable // Calls holds the source text of the callees of the calls, for errors
+	LocalNames    []string       // LocalNames names the locals by index, for debuggers
+	FreeNames     []string       // FreeNames names the free variables by index
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -20,3 +20,18 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestCompactStack(t *testing.T) {
+	stack := []string{"at inner (line 2)", "at inner (line 2)", "at inner (line 2)", "at outer (line 5)", "at main (line 9)", "at main (line 9)"}
+	expected := []string{"at inner (line 2)", "... 2 more", "at outer (line 5)", "at main (line 9)", "... 1 more"}
+
+	compact := CompactStack(stack)
+	if len(compact) != len(expected) {
+		t.Fatalf("wrong stack. want=%q, got=%q", expected, compact)
+	}
+	for i := range expected {
+		if compact[i] != expected[i] {
+			t.Errorf("wrong frame %d. want=%q, got=%q", i, expected[i], compact[i])
+		}
+	}
+}
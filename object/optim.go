@@ -0,0 +1,127 @@
+// object/optim.go
+
+package object
+
+import (
+	"fmt"
+	"math"
+)
+
+// The optimizers return new parameters rather than changing the ones they
+// are given: tensors are values. The parameters returned require gradients
+// when the ones given did, so a training loop computes the next loss from
+// them directly.
+
+// SGDStep returns the parameters moved against their gradients by lr times
+// the gradients. A nil gradient leaves its parameter unchanged.
+func SGDStep(params, grads []*Tensor, lr float64) ([]*Tensor, error) {
+	if err := checkGradients(params, grads); err != nil {
+		return nil, err
+	}
+
+	updated := make([]*Tensor, len(params))
+	for i, param := range params {
+		if grads[i] == nil {
+			updated[i] = param
+			continue
+		}
+		updated[i] = step(param, func(j int, v float64) float64 {
+			return v - lr*grads[i].Data[j]
+		})
+	}
+	return updated, nil
+}
+
+// Adam holds the settings and the state of the Adam optimizer, the moving
+// averages of the gradients of every parameter and of their squares
+type Adam struct {
+	LR, Beta1, Beta2, Eps float64
+
+	Step int64     // Step counts the steps taken
+	M, V []*Tensor // M and V are the averages of the gradients and their squares
+}
+
+// NewAdam returns an Adam optimizer with the default settings of the paper
+// that introduced it
+func NewAdam() *Adam {
+	return &Adam{LR: 0.001, Beta1: 0.9, Beta2: 0.999, Eps: 1e-8}
+}
+
+// Update returns the parameters moved by a step of Adam and updates the
+// state of a. A nil gradient leaves its parameter and its averages
+// unchanged.
+func (a *Adam) Update(params, grads []*Tensor) ([]*Tensor, error) {
+	if err := checkGradients(params, grads); err != nil {
+		return nil, err
+	}
+	if a.M == nil {
+		a.M, a.V = make([]*Tensor, len(params)), make([]*Tensor, len(params))
+	}
+	if len(a.M) != len(params) || len(a.V) != len(params) {
+		return nil, fmt.Errorf("optimizer state holds %d parameters, got %d", len(a.M), len(params))
+	}
+
+	a.Step++
+	correction1 := 1 - math.Pow(a.Beta1, float64(a.Step))
+	correction2 := 1 - math.Pow(a.Beta2, float64(a.Step))
+
+	updated := make([]*Tensor, len(params))
+	for i, param := range params {
+		grad := grads[i]
+		if grad == nil {
+			updated[i] = param
+			continue
+		}
+		if a.M[i] == nil {
+			a.M[i], a.V[i] = filled(param.Shape, 0), filled(param.Shape, 0)
+		}
+		if !sameShape(a.M[i].Shape, param.Shape) || !sameShape(a.V[i].Shape, param.Shape) {
+			return nil, fmt.Errorf("optimizer state of shape %v does not match parameter of shape %v", a.M[i].Shape, param.Shape)
+		}
+
+		m, v := filled(param.Shape, 0), filled(param.Shape, 0)
+		for j, g := range grad.Data {
+			m.Data[j] = a.Beta1*a.M[i].Data[j] + (1-a.Beta1)*g
+			v.Data[j] = a.Beta2*a.V[i].Data[j] + (1-a.Beta2)*g*g
+		}
+		a.M[i], a.V[i] = m, v
+
+		updated[i] = step(param, func(j int, value float64) float64 {
+			return value - a.LR*(m.Data[j]/correction1)/(math.Sqrt(v.Data[j]/correction2)+a.Eps)
+		})
+	}
+	return updated, nil
+}
+
+// checkGradients is a helper function that checks that there is a gradient
+// of the shape of every parameter, or nil
+func checkGradients(params, grads []*Tensor) error {
+	if len(params) != len(grads) {
+		return fmt.Errorf("got %d parameters and %d gradients", len(params), len(grads))
+	}
+	for i, param := range params {
+		if err := param.Validate(); err != nil {
+			return err
+		}
+		if grads[i] == nil {
+			continue
+		}
+		if err := grads[i].Validate(); err != nil {
+			return err
+		}
+		if !sameShape(grads[i].Shape, param.Shape) {
+			return fmt.Errorf("gradient of shape %v does not match parameter of shape %v", grads[i].Shape, param.Shape)
+		}
+	}
+	return nil
+}
+
+// step is a helper function that returns a parameter with fn applied to
+// each of its elements, requiring gradients when the parameter does
+func step(param *Tensor, fn func(i int, v float64) float64) *Tensor {
+	updated := &Tensor{Shape: param.Shape, Data: make([]float64, len(param.Data)), DType: param.DType, RequiresGrad: param.RequiresGrad}
+	for i, v := range param.Data {
+		updated.Data[i] = param.ElementType().Convert(fn(i, v))
+	}
+	return updated
+}
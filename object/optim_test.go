@@ -0,0 +1,59 @@
+package object
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSGDStep(t *testing.T) {
+	w := RequireGrad(&Tensor{Shape: []int64{2}, Data: []float64{1, -2}})
+	b := &Tensor{Shape: []int64{1}, Data: []float64{3}}
+	updated, err := SGDStep([]*Tensor{w, b}, []*Tensor{{Shape: []int64{2}, Data: []float64{2, -4}}, nil}, 0.25)
+	if err != nil {
+		t.Fatalf("SGDStep failed: %s", err)
+	}
+	if want := []float64{0.5, -1}; !reflect.DeepEqual(updated[0].Data, want) {
+		t.Errorf("wrong parameter. want=%v, got=%v", want, updated[0].Data)
+	}
+	if !updated[0].RequiresGrad {
+		t.Errorf("updated parameter does not require gradients")
+	}
+	if updated[1] != b {
+		t.Errorf("parameter without gradient changed: %s", updated[1].Inspect())
+	}
+
+	_, err = SGDStep([]*Tensor{w}, []*Tensor{{Shape: []int64{2, 1}, Data: []float64{1, 1}}}, 1)
+	if err == nil || err.Error() != "gradient of shape [2 1] does not match parameter of shape [2]" {
+		t.Errorf("wrong error for a gradient of another shape. got=%v", err)
+	}
+	if _, err = SGDStep([]*Tensor{w}, nil, 1); err == nil {
+		t.Errorf("SGDStep succeeded without gradients")
+	}
+}
+
+func TestAdamUpdate(t *testing.T) {
+	adam := NewAdam()
+	adam.LR = 0.1
+	params := []*Tensor{{Shape: []int64{2}, Data: []float64{1, -2}}}
+	grads := []*Tensor{{Shape: []int64{2}, Data: []float64{2, -4}}}
+
+	// With a constant gradient the corrected averages make every step lr
+	// long, against the sign of the gradient
+	for _, want := range [][]float64{{0.9, -1.9}, {0.8, -1.8}, {0.7, -1.7}} {
+		var err error
+		if params, err = adam.Update(params, grads); err != nil {
+			t.Fatalf("Update failed: %s", err)
+		}
+		for i, v := range params[0].Data {
+			if math.Abs(v-want[i]) > 1e-6 {
+				t.Errorf("wrong parameters after step %d. want=%v, got=%v", adam.Step, want, params[0].Data)
+				break
+			}
+		}
+	}
+
+	if _, err := adam.Update(append(params, params[0]), append(grads, grads[0])); err == nil {
+		t.Errorf("Update succeeded with more parameters than its state holds")
+	}
+}
@@ -0,0 +1,107 @@
+// object/order.go
+
+package object
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Hashes are iterated in the order of their map, which Go randomizes from
+// run to run. With ordered iteration on, every iteration over the pairs of a
+// hash, such as printing it, sorts them by key instead: booleans first, false
+// before true, then integers by value, then strings by bytes. Tests and
+// golden files then see the same output on every run. For loops always go
+// over the keys of a hash sorted, so that a loop runs alike on both engines
+// and from run to run.
+
+// orderedHashes is set while ordered iteration is on
+var orderedHashes atomic.Bool
+
+// SetOrderedHashes turns ordered iteration of hashes on or off for the
+// whole process
+func SetOrderedHashes(ordered bool) {
+	orderedHashes.Store(ordered)
+}
+
+// OrderedHashes reports whether ordered iteration of hashes is on
+func OrderedHashes() bool {
+	return orderedHashes.Load()
+}
+
+// Iterate returns the pairs of the hash in the order they are iterated: by
+// key when ordered iteration is on, in the order of the map otherwise
+func (h *Hash) Iterate() []HashPair {
+	if OrderedHashes() {
+		return h.SortedPairs()
+	}
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// Iteration returns the values a for loop over obj binds in turn: the
+// elements of an array, or the keys of a hash sorted. It returns false for
+// values that cannot be iterated over.
+func Iteration(obj Object) ([]Object, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.Elements, true
+	case *Hash:
+		pairs := obj.SortedPairs()
+		keys := make([]Object, len(pairs))
+		for i, pair := range pairs {
+			keys[i] = pair.Key
+		}
+		return keys, true
+	default:
+		return nil, false
+	}
+}
+
+// SortedPairs returns the pairs of the hash sorted by key, whether ordered
+// iteration is on or not
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return keyLess(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+// keyLess is a helper function that orders the keys of hashes
+func keyLess(a, b Object) bool {
+	if rankA, rankB := keyRank(a), keyRank(b); rankA != rankB {
+		return rankA < rankB
+	}
+	switch a := a.(type) {
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	case *Integer:
+		return a.Value < b.(*Integer).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	default:
+		return a.Inspect() < b.Inspect()
+	}
+}
+
+// keyRank is a helper function that returns the rank of the type of a key,
+// types of a lower rank coming first
+func keyRank(key Object) int {
+	switch key.(type) {
+	case *Boolean:
+		return 0
+	case *Integer:
+		return 1
+	case *String:
+		return 2
+	default:
+		return 3
+	}
+}
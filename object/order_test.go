@@ -0,0 +1,29 @@
+package object
+
+import "testing"
+
+func TestOrderedHashes(t *testing.T) {
+	defer SetOrderedHashes(OrderedHashes())
+	SetOrderedHashes(true)
+
+	keys := []Object{
+		&String{Value: "b"}, &Integer{Value: 10}, &Boolean{Value: true},
+		&String{Value: "a"}, &Integer{Value: -2}, &Boolean{Value: false}, &Integer{Value: 9},
+	}
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for i, key := range keys {
+		hash.Pairs[key.(Hashable).HashKey()] = HashPair{Key: key, Value: &Integer{Value: int64(i)}}
+	}
+
+	expected := "{false: 5, true: 2, -2: 4, 9: 6, 10: 1, a: 3, b: 0}"
+	for i := 0; i < 10; i++ {
+		if inspected := hash.Inspect(); inspected != expected {
+			t.Fatalf("wrong order. want=%s, got=%s", expected, inspected)
+		}
+	}
+
+	SetOrderedHashes(false)
+	if pairs := hash.Iterate(); len(pairs) != len(keys) {
+		t.Errorf("wrong number of pairs. want=%d, got=%d", len(keys), len(pairs))
+	}
+}
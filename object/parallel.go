@@ -0,0 +1,160 @@
+// object/parallel.go
+
+package object
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Tensor operations costing more than a threshold are split across
+// goroutines. The cost of an operation is the number of elements it computes
+// times the cost of computing one, such as the number of operands or the
+// number of multiplications of a row of a matrix product.
+
+// DefaultTensorThreshold is the cost from which tensor operations are split
+// across goroutines
+const DefaultTensorThreshold = 1 << 16
+
+var (
+	tensorWorkers   = runtime.GOMAXPROCS(0)
+	tensorThreshold = DefaultTensorThreshold
+	parallelMutex   = sync.RWMutex{}
+)
+
+// SetTensorParallelism sets the number of goroutines tensor operations are
+// split across, one per CPU when workers is less than 1, and the cost from
+// which they are split, DefaultTensorThreshold when threshold is less than 1.
+// One worker computes every operation on the calling goroutine.
+func SetTensorParallelism(workers, threshold int) {
+	parallelMutex.Lock()
+	defer parallelMutex.Unlock()
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if threshold < 1 {
+		threshold = DefaultTensorThreshold
+	}
+	tensorWorkers, tensorThreshold = workers, threshold
+}
+
+// TensorParallelism returns the number of goroutines tensor operations are
+// split across and the cost from which they are split
+func TensorParallelism() (workers, threshold int) {
+	parallelMutex.RLock()
+	defer parallelMutex.RUnlock()
+	return tensorWorkers, tensorThreshold
+}
+
+// parallelFor is a helper function that calls fn on consecutive ranges
+// [start, end) covering [0, n), on as many goroutines as there are workers
+// when the n items, each of the given cost, cost more than the threshold,
+// and returns when every call returned
+func parallelFor(n, cost int, fn func(start, end int)) {
+	if n == 0 {
+		return
+	}
+	workers, threshold := TensorParallelism()
+	if cost < 1 {
+		cost = 1
+	}
+	// Every worker gets a share costing the threshold at least
+	if share := n * cost / threshold; share < workers {
+		workers = share
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		fn(0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	chunk := (n + workers - 1) / workers
+	for start := 0; start < n; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// parallelMap is a helper function that returns the array of the results of
+// calling fn on each of the elements, on as many goroutines as there are
+// workers, each taking the next element when it is done with the last. The
+// functions of the evaluator are called in environments of their own and
+// the closures of the VM on VMs of their own, in Parallel contexts where
+// the engines refuse to assign global variables, so calls share no state
+// but the values they are given. The first error, by order of the elements,
+// is returned, and no element is taken once a call failed or ctx is
+// canceled. A panic of a call, such as that of exit(), stops the others
+// and is raised again on the caller once they are done.
+func parallelMap(ctx *Context, elements []Object, fn Object, workers int) Object {
+	if workers > len(elements) {
+		workers = len(elements)
+	}
+
+	results := make([]Object, len(elements))
+	var (
+		wg       sync.WaitGroup
+		next     atomic.Int64
+		failed   atomic.Bool
+		panicked sync.Once
+		raised   interface{} // raised is the value of the first panic of a call
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panicked.Do(func() { raised = r })
+					failed.Store(true)
+				}
+			}()
+			// Each worker calls fn in a context of its own, which the
+			// engines keep the state of its calls in
+			worker := &Context{}
+			if ctx != nil {
+				*worker = *ctx
+			}
+			worker.Parallel = true
+			// Elements are taken in order, so the ones before a failed
+			// call were all taken and their errors come first
+			for !failed.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= len(elements) {
+					return
+				}
+				if errObj := canceled(ctx, "pmap"); errObj != nil {
+					results[i] = errObj
+				} else {
+					results[i] = worker.Call(fn, elements[i])
+				}
+				if _, ok := results[i].(*Error); ok {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if raised != nil {
+		panic(raised)
+	}
+
+	for _, result := range results {
+		if errObj, ok := result.(*Error); ok {
+			return errObj
+		}
+	}
+	return &Array{Elements: results}
+}
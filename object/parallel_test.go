@@ -0,0 +1,61 @@
+package object
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParallelTensorOperations(t *testing.T) {
+	workers, threshold := TensorParallelism()
+	defer SetTensorParallelism(workers, threshold)
+
+	left := &Tensor{Shape: []int64{7, 1, 5}, Data: make([]float64, 35)}
+	right := &Tensor{Shape: []int64{3, 1}, Data: []float64{1, 2, 3}}
+	a := &Tensor{Shape: []int64{9, 4}, Data: make([]float64, 36)}
+	b := &Tensor{Shape: []int64{4, 6}, Data: make([]float64, 24)}
+	for _, tensor := range []*Tensor{left, a, b} {
+		for i := range tensor.Data {
+			tensor.Data[i] = float64(i*7%11) - 5
+		}
+	}
+
+	compute := func() (*Tensor, *Tensor) {
+		sum, err := Broadcast(left, right, func(x, y float64) float64 { return x*10 + y })
+		if err != nil {
+			t.Fatalf("Broadcast failed: %s", err)
+		}
+		product, err := MatMul(a, b)
+		if err != nil {
+			t.Fatalf("MatMul failed: %s", err)
+		}
+		return sum, product
+	}
+
+	SetTensorParallelism(1, 0)
+	serialSum, serialProduct := compute()
+
+	// A threshold of 1 splits even these small operations, across more
+	// workers than some of them have rows
+	for _, workers := range []int{2, 3, 16} {
+		SetTensorParallelism(workers, 1)
+		sum, product := compute()
+		if !reflect.DeepEqual(sum, serialSum) {
+			t.Errorf("broadcast on %d workers differs. want=%s, got=%s", workers, serialSum.Inspect(), sum.Inspect())
+		}
+		if !reflect.DeepEqual(product, serialProduct) {
+			t.Errorf("matmul on %d workers differs. want=%s, got=%s", workers, serialProduct.Inspect(), product.Inspect())
+		}
+	}
+
+	calls := 0
+	SetTensorParallelism(4, DefaultTensorThreshold)
+	parallelFor(100, 1, func(start, end int) {
+		calls++
+		if start != 0 || end != 100 {
+			t.Errorf("cheap loop split: [%d, %d)", start, end)
+		}
+	})
+	if calls != 1 {
+		t.Errorf("cheap loop called %d times", calls)
+	}
+}
@@ -1,14 +1,70 @@
 // protocol.go
 package object
 
+import "github.com/vmihailenco/msgpack"
+
+// FunctionCall is a message sent to an extension running as a process. Its
+// Type is "describe" to ask for the functions of the extension, or "call"
+// to call the function Name with Args. A message of Type "result" answers a
+// callback request, Args holding the value returned unless Error is set.
 type FunctionCall struct {
-	Type string        `msgpack:"type"`
-	Name string        `msgpack:"name"`
-	Args []interface{} `msgpack:"args"`
+	Type  string        `msgpack:"type"`
+	Name  string        `msgpack:"name"`
+	Args  []interface{} `msgpack:"args"`
+	Error *string       `msgpack:"error"`
 }
 
+// FunctionResponse is the answer of an extension to a FunctionCall, of the
+// same Type. Result holds a list of FunctionSpec for describe and the value
+// returned for call, unless Error is set. While it handles a call, an
+// extension may send responses of Type "callback" to call back a function
+// passed to it, Result holding the Callback followed by the arguments. Each
+// is answered by a FunctionCall of Type "result".
 type FunctionResponse struct {
 	Type   string      `msgpack:"type"`
 	Result interface{} `msgpack:"result"`
 	Error  *string     `msgpack:"error"`
 }
+
+// FunctionSpec describes a function of an extension running as a process
+type FunctionSpec struct {
+	Name     string      `msgpack:"name"`
+	Params   []ParamSpec `msgpack:"params"`
+	Variadic bool        `msgpack:"variadic"`
+	Doc      string      `msgpack:"doc"`
+	Needs    []string    `msgpack:"needs"`   // Needs lists the capabilities the function uses
+	Version  string      `msgpack:"version"` // Version is the version of the extension
+}
+
+// ParamSpec describes a parameter of a FunctionSpec, Type being empty when
+// it accepts any type
+type ParamSpec struct {
+	Name string `msgpack:"name"`
+	Type string `msgpack:"type"`
+}
+
+// callbackExtType is the msgpack extension type of a Callback
+const callbackExtType = 1
+
+func init() {
+	msgpack.RegisterExt(callbackExtType, (*Callback)(nil))
+}
+
+// Callback stands for a function passed to an extension. It is encoded as
+// the msgpack extension type 1 holding its number as an unsigned integer,
+// numbers being valid for the call the function was passed to.
+type Callback struct {
+	ID uint32
+}
+
+// EncodeMsgpack encodes the number of the callback
+func (c Callback) EncodeMsgpack(e *msgpack.Encoder) error {
+	return e.EncodeUint32(c.ID)
+}
+
+// DecodeMsgpack decodes the number of the callback
+func (c *Callback) DecodeMsgpack(d *msgpack.Decoder) error {
+	id, err := d.DecodeUint32()
+	c.ID = id
+	return err
+}
@@ -0,0 +1,49 @@
+// object/random.go
+
+package object
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Random generates the random numbers of random() and of the tensor
+// constructors, and is seeded by seed(). It is safe for concurrent use, by
+// the calls of pmap() among others.
+type Random struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// NewRandom returns a generator seeded with seed
+func NewRandom(seed int64) *Random {
+	return &Random{rand: rand.New(rand.NewSource(seed))}
+}
+
+// generator is the generator of the builtins called in no context or in a
+// context without one
+var generator = NewRandom(time.Now().UnixNano())
+
+// Float64 returns a random float in [0.0, 1.0)
+func (r *Random) Float64() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rand.Float64()
+}
+
+// NormFloat64 returns a random float following the standard normal
+// distribution
+func (r *Random) NormFloat64() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rand.NormFloat64()
+}
+
+// Seed seeds the generator with seed, so that the numbers it returns next
+// repeat from one run to the next
+func (r *Random) Seed(seed int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rand.Seed(seed)
+}
@@ -2,21 +2,128 @@
 package object
 
 import (
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 )
 
 var (
 	functionRegistry = make(map[string]Extended)
+	functionOrigins  = make(map[string]origin) // functionOrigins holds where the functions of the registry come from
 	registryMutex    = sync.RWMutex{}
 )
 
-// RegisterFunction registers a function in the global registry
+// origin is the plugin file a function was loaded from and its version,
+// both empty for functions the host registered
+type origin struct {
+	file    string
+	version string
+}
+
+// String returns the file of the origin, or the host
+func (o origin) String() string {
+	if o.file == "" {
+		return "the host"
+	}
+	return o.file
+}
+
+var (
+	// registering is the origin of the functions RegisterFunction
+	// registers, set by RegisterFunctionsFrom
+	registering  origin
+	originsMutex = sync.Mutex{}
+)
+
+// RegisterFunction registers a function in the global registry. Functions
+// registered this way are only known to the evaluator, plugins should return
+// their functions from Register to have them registered with RegisterBuiltin.
+// A function named like one registered by another plugin, or like a
+// builtin, is not registered.
 func RegisterFunction(name string, fn Extended) {
 	registryMutex.Lock()
 	defer registryMutex.Unlock()
+
+	if err := conflict(name, registering); err != nil {
+		log.Printf("Not registering function %s: %s", name, err)
+		return
+	}
 	log.Printf("Registering function: %s", name)
 	functionRegistry[name] = fn
+	functionOrigins[name] = registering
+}
+
+// RegisterFunctionsFrom calls register, which registers functions with
+// RegisterFunction, recording them as those of version of the plugin file
+func RegisterFunctionsFrom(file, version string, register func()) {
+	originsMutex.Lock()
+	defer originsMutex.Unlock()
+
+	registryMutex.Lock()
+	registering = origin{file: file, version: version}
+	registryMutex.Unlock()
+	defer func() {
+		registryMutex.Lock()
+		registering = origin{}
+		registryMutex.Unlock()
+	}()
+
+	register()
+}
+
+// conflict is a helper function that returns an error when name is taken by
+// a builtin or by a function of another file than o
+func conflict(name string, o origin) error {
+	if p, ok := pluginRegistry[name]; ok {
+		if p.Origin != o.file {
+			return fmt.Errorf("%s of %s is already registered by %s", name, o, p.origin())
+		}
+		return nil
+	}
+	if registered, ok := functionOrigins[name]; ok && registered.file != o.file {
+		return fmt.Errorf("%s of %s is already registered by %s", name, o, registered)
+	}
+	if GetBuiltInByName(name) != nil {
+		return fmt.Errorf("%s of %s is already a builtin", name, o)
+	}
+	return nil
+}
+
+// Registry holds extension functions of their own for the interpreters it
+// is given to, so that interpreters of one process do not see the functions
+// of one another. A Registry is safe for concurrent use.
+type Registry struct {
+	mutex     sync.RWMutex
+	functions map[string]Extended
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{functions: map[string]Extended{}}
+}
+
+// Register registers the function name, replacing the one registered
+// before under that name. A builtin named name is found before it.
+func (r *Registry) Register(name string, fn BuiltInFunction) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.functions[name] = Extended{Fn: fn}
+}
+
+// Lookup returns the function name of the registry, or else the one
+// registered with RegisterFunction. A nil registry holds no function.
+func (r *Registry) Lookup(name string) (Extended, bool) {
+	if r != nil {
+		r.mutex.RLock()
+		fn, ok := r.functions[name]
+		r.mutex.RUnlock()
+		if ok {
+			return fn, true
+		}
+	}
+	return GetExtendedFunction(name)
 }
 
 // GetFunction retrieves a function from the global registry and casts to Extended
@@ -31,3 +138,230 @@ func GetFunction(name string) (Extended, bool) {
 	fn, exists := functionRegistry[name]
 	return fn, exists
 }
+
+// Plugin is the metadata of a function registered by an extension plugin.
+// Plugins return their functions from their Register function:
+//
+//	func Register() []object.Plugin {
+//		return []object.Plugin{{Name: "hello", Params: []object.Param{{Name: "name", Type: object.STRING_OBJ}}, Doc: "Greets name.", Fn: Hello}}
+//	}
+type Plugin struct {
+	Name     string
+	Params   []Param      // Params declares the parameters, the arity being their number
+	Variadic bool         // Variadic lets the last parameter take any number of arguments
+	Doc      string       // Doc describes what the function does
+	Needs    []Capability // Needs declares the capabilities the function uses
+	Origin   string       // Origin is the file the function was loaded from, set by the loader
+	Version  string       // Version is the version of the plugin the function belongs to
+	Fn       BuiltInFunction
+}
+
+// origin is a helper function that returns where the function comes from
+func (p Plugin) origin() origin {
+	return origin{file: p.Origin, version: p.Version}
+}
+
+// Param is a parameter of a plugin function
+type Param struct {
+	Name string
+	Type ObjectType // Type is the type of the arguments accepted, any type when empty
+}
+
+// Usage returns how the function is called, e.g. hello(name)
+func (p Plugin) Usage() string {
+	params := make([]string, len(p.Params))
+	for i, param := range p.Params {
+		params[i] = param.Name
+	}
+	if p.Variadic && len(params) > 0 {
+		params[len(params)-1] += "..."
+	}
+	return fmt.Sprintf("%s(%s)", p.Name, strings.Join(params, ", "))
+}
+
+// check is a helper function that returns an error when args do not match
+// the declared parameters, nil otherwise
+func (p Plugin) check(args []Object) *Error {
+	fixed := len(p.Params)
+	if p.Variadic {
+		fixed--
+	}
+	if len(args) < fixed || (!p.Variadic && len(args) > fixed) {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), len(p.Params))
+	}
+
+	for i, arg := range args {
+		param := p.Params[len(p.Params)-1]
+		if i < len(p.Params) {
+			param = p.Params[i]
+		}
+		if param.Type != "" && (arg == nil || arg.Type() != param.Type) {
+			return newError("argument `%s` to `%s` must be %s, got %s", param.Name, p.Name, param.Type, typeOf(arg))
+		}
+	}
+	return nil
+}
+
+// typeOf is a helper function that returns the type of an argument, which
+// may be a nil null
+func typeOf(arg Object) ObjectType {
+	if arg == nil {
+		return NULL_OBJ
+	}
+	return arg.Type()
+}
+
+// RegisterBuiltin adds a plugin function to the builtins, where the compiler,
+// the evaluator and the documentation find it. The arguments are checked
+// against its parameters before it is called. Builtins are numbered in the
+// order they are registered, so plugins must be registered before any
+// program is compiled. A function named like a builtin or a function
+// registered before is refused.
+func RegisterBuiltin(p Plugin) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if err := validatePlugin(p); err != nil {
+		return err
+	}
+	if previous, ok := pluginRegistry[p.Name]; ok {
+		return fmt.Errorf("plugin function %s of %s is already registered by %s", p.Name, p.origin(), previous.origin())
+	}
+	if err := conflict(p.Name, p.origin()); err != nil {
+		return fmt.Errorf("plugin function %s", err)
+	}
+	return addPluginBuiltin(p)
+}
+
+// ReplaceBuiltins registers the functions of a new version of a plugin.
+// Functions registered before by the same plugin file are replaced, keeping
+// their builtin so that compiled programs and values holding them call the
+// new version. All of them are replaced at once, or none when one of them is
+// invalid or taken by another plugin.
+func ReplaceBuiltins(ps []Plugin) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	added := 0
+	for _, p := range ps {
+		if err := validatePlugin(p); err != nil {
+			return err
+		}
+		if previous, ok := pluginRegistry[p.Name]; ok {
+			if previous.Origin != p.Origin {
+				return fmt.Errorf("plugin function %s of %s is already registered by %s", p.Name, p.origin(), previous.origin())
+			}
+			continue
+		}
+		if err := conflict(p.Name, p.origin()); err != nil {
+			return fmt.Errorf("plugin function %s", err)
+		}
+		added++
+	}
+	if len(Builtins)+added > 256 {
+		return fmt.Errorf("cannot register %d functions, too many builtins", added)
+	}
+
+	for _, p := range ps {
+		if _, ok := pluginRegistry[p.Name]; !ok {
+			_ = addPluginBuiltin(p)
+			continue
+		}
+		builtin := GetBuiltInByName(p.Name)
+		builtin.Usage, builtin.Doc = p.Usage(), p.Doc
+		pluginRegistry[p.Name] = p
+	}
+	return nil
+}
+
+// pluginRegistry holds the current version of the functions registered by plugins
+var pluginRegistry = map[string]Plugin{}
+
+// validatePlugin is a helper function that returns an error when the
+// metadata of a plugin function is incomplete
+func validatePlugin(p Plugin) error {
+	if p.Name == "" || p.Fn == nil {
+		return fmt.Errorf("plugin function %q has no name or no implementation", p.Name)
+	}
+	if p.Variadic && len(p.Params) == 0 {
+		return fmt.Errorf("variadic plugin function %s has no parameters", p.Name)
+	}
+	for _, c := range p.Needs {
+		if !knownCapability(c) {
+			return fmt.Errorf("plugin function %s needs unknown capability %q", p.Name, c)
+		}
+	}
+	return nil
+}
+
+// addPluginBuiltin is a helper function that appends the builtin of a plugin
+// function. The builtin calls the version of the function registered last,
+// unless it needs a capability that is not granted.
+func addPluginBuiltin(p Plugin) error {
+	// Builtins are referred to by a single byte operand
+	if len(Builtins) >= 256 {
+		return fmt.Errorf("cannot register %s, too many builtins", p.Name)
+	}
+
+	name := p.Name
+	pluginRegistry[name] = p
+	Builtins = append(Builtins, struct {
+		Name    string
+		Builtin *Builtin
+	}{name, &Builtin{Usage: p.Usage(), Doc: p.Doc, Fn: func(args ...Object) Object {
+		registryMutex.RLock()
+		current := pluginRegistry[name]
+		registryMutex.RUnlock()
+
+		if c, ok := withheld(current.Needs); ok {
+			return newError("`%s` needs the %s capability, which is not granted", name, c)
+		}
+		if err := current.check(args); err != nil {
+			return err
+		}
+		return current.Fn(args...)
+	}}})
+	return nil
+}
+
+// PluginInfo describes a plugin file functions were loaded from
+type PluginInfo struct {
+	File      string
+	Version   string
+	Functions []string
+}
+
+// LoadedPlugins lists the plugin files functions were loaded from, ordered
+// by file, with their functions ordered by name. Functions the host
+// registered are left out.
+func LoadedPlugins() []PluginInfo {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	byFile := map[string]*PluginInfo{}
+	add := func(name string, o origin) {
+		if o.file == "" {
+			return
+		}
+		info, ok := byFile[o.file]
+		if !ok {
+			info = &PluginInfo{File: o.file, Version: o.version}
+			byFile[o.file] = info
+		}
+		info.Functions = append(info.Functions, name)
+	}
+	for name, p := range pluginRegistry {
+		add(name, p.origin())
+	}
+	for name, o := range functionOrigins {
+		add(name, o)
+	}
+
+	plugins := make([]PluginInfo, 0, len(byFile))
+	for _, info := range byFile {
+		sort.Strings(info.Functions)
+		plugins = append(plugins, *info)
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].File < plugins[j].File })
+	return plugins
+}
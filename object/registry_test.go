@@ -0,0 +1,222 @@
+package object
+
+import "testing"
+
+func TestRegisterBuiltin(t *testing.T) {
+	greet := Plugin{
+		Name:     "greet",
+		Params:   []Param{{Name: "name", Type: STRING_OBJ}, {Name: "times"}},
+		Variadic: true,
+		Doc:      "Greets name.",
+		Fn: func(args ...Object) Object {
+			return &String{Value: "hi " + args[0].(*String).Value}
+		},
+	}
+	if err := RegisterBuiltin(greet); err != nil {
+		t.Fatalf("RegisterBuiltin failed: %s", err)
+	}
+	if err := RegisterBuiltin(greet); err == nil {
+		t.Errorf("registering greet twice did not fail")
+	}
+	if err := RegisterBuiltin(Plugin{Name: "len", Fn: greet.Fn}); err == nil {
+		t.Errorf("replacing len did not fail")
+	}
+
+	builtin := GetBuiltInByName("greet")
+	if builtin == nil {
+		t.Fatalf("greet is not a builtin")
+	}
+	if builtin.Usage != "greet(name, times...)" || builtin.Doc != "Greets name." {
+		t.Errorf("wrong metadata. got usage=%q doc=%q", builtin.Usage, builtin.Doc)
+	}
+
+	tests := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{&String{Value: "bob"}}, "hi bob"},
+		{[]Object{&String{Value: "bob"}, &Integer{Value: 1}, &Integer{Value: 2}}, "hi bob"},
+		{[]Object{}, "wrong number of arguments. got=0, want=2"},
+		{[]Object{&Integer{Value: 1}}, "argument `name` to `greet` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		switch result := builtin.Fn(tt.args...).(type) {
+		case *String:
+			if result.Value != tt.expected {
+				t.Errorf("wrong result. want=%q, got=%q", tt.expected, result.Value)
+			}
+		case *Error:
+			if result.Message != tt.expected {
+				t.Errorf("wrong error. want=%q, got=%q", tt.expected, result.Message)
+			}
+		default:
+			t.Errorf("unexpected result %T", result)
+		}
+	}
+}
+
+func TestReplaceBuiltins(t *testing.T) {
+	version := func(v int64) BuiltInFunction {
+		return func(args ...Object) Object { return &Integer{Value: v} }
+	}
+	if err := RegisterBuiltin(Plugin{Name: "version", Fn: version(1)}); err != nil {
+		t.Fatalf("RegisterBuiltin failed: %s", err)
+	}
+	builtin := GetBuiltInByName("version")
+
+	if err := ReplaceBuiltins([]Plugin{{Name: "version", Fn: version(2)}, {Name: "len", Fn: version(2)}}); err == nil {
+		t.Errorf("replacing len did not fail")
+	}
+	if result := builtin.Fn().(*Integer); result.Value != 1 {
+		t.Errorf("failed replacement was partly applied. got=%d", result.Value)
+	}
+
+	err := ReplaceBuiltins([]Plugin{
+		{Name: "version", Params: []Param{{Name: "x"}}, Doc: "Returns the version.", Fn: version(2)},
+		{Name: "added", Fn: version(3)},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceBuiltins failed: %s", err)
+	}
+
+	if GetBuiltInByName("version") != builtin {
+		t.Errorf("replaced function got a new builtin")
+	}
+	if result := builtin.Fn(&Integer{Value: 0}).(*Integer); result.Value != 2 {
+		t.Errorf("old version called after replacement. got=%d", result.Value)
+	}
+	if builtin.Usage != "version(x)" || builtin.Doc != "Returns the version." {
+		t.Errorf("metadata not replaced. got usage=%q doc=%q", builtin.Usage, builtin.Doc)
+	}
+	if added := GetBuiltInByName("added"); added == nil || added.Fn().(*Integer).Value != 3 {
+		t.Errorf("new function not registered")
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	defer SetCapabilities(Capabilities)
+
+	if err := RegisterBuiltin(Plugin{Name: "fetch", Needs: []Capability{"disk"}, Fn: func(args ...Object) Object { return nil }}); err == nil {
+		t.Errorf("registering a function needing an unknown capability did not fail")
+	}
+	err := RegisterBuiltin(Plugin{Name: "fetch", Needs: []Capability{CapNetwork}, Fn: func(args ...Object) Object {
+		return &String{Value: "fetched"}
+	}})
+	if err != nil {
+		t.Fatalf("RegisterBuiltin failed: %s", err)
+	}
+	fetch := GetBuiltInByName("fetch")
+
+	if result, ok := fetch.Fn().(*String); !ok || result.Value != "fetched" {
+		t.Errorf("function not called with every capability granted. got=%v", result)
+	}
+
+	caps, err := ParseCapabilities("fs, env")
+	if err != nil || len(caps) != 2 || caps[0] != CapFilesystem || caps[1] != CapEnv {
+		t.Fatalf("wrong capabilities parsed. got=%v (%v)", caps, err)
+	}
+	SetCapabilities(caps)
+	expected := "`fetch` needs the net capability, which is not granted"
+	if errObj, ok := fetch.Fn().(*Error); !ok || errObj.Message != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, errObj)
+	}
+
+	if _, err := ParseCapabilities("fs,disk"); err == nil {
+		t.Errorf("parsing an unknown capability did not fail")
+	}
+	if caps, _ := ParseCapabilities("all"); len(caps) != len(Capabilities) {
+		t.Errorf("all does not grant every capability. got=%v", caps)
+	}
+}
+
+func TestPluginConflicts(t *testing.T) {
+	tool := func(args ...Object) Object { return &String{Value: "tool"} }
+	if err := RegisterBuiltin(Plugin{Name: "tool", Origin: "a.so", Version: "1.0", Fn: tool}); err != nil {
+		t.Fatalf("RegisterBuiltin failed: %s", err)
+	}
+
+	expected := "plugin function tool of b.so is already registered by a.so"
+	if err := RegisterBuiltin(Plugin{Name: "tool", Origin: "b.so", Fn: tool}); err == nil || err.Error() != expected {
+		t.Errorf("wrong conflict error. want=%q, got=%v", expected, err)
+	}
+	if err := ReplaceBuiltins([]Plugin{{Name: "tool", Origin: "b.so", Fn: tool}}); err == nil {
+		t.Errorf("replacing the function of another plugin did not fail")
+	}
+	if err := ReplaceBuiltins([]Plugin{{Name: "tool", Origin: "a.so", Version: "1.1", Fn: tool}}); err != nil {
+		t.Errorf("reloading a plugin failed: %s", err)
+	}
+
+	RegisterFunctionsFrom("c.so", "2.0", func() {
+		RegisterFunction("helper", Extended{Fn: tool})
+	})
+	RegisterFunction("tool", Extended{Fn: tool})
+	RegisterFunction("len", Extended{Fn: tool})
+	for _, name := range []string{"tool", "len"} {
+		if _, ok := GetFunction(name); ok {
+			t.Errorf("conflicting function %s registered", name)
+		}
+	}
+
+	plugins := map[string]PluginInfo{}
+	for _, plugin := range LoadedPlugins() {
+		plugins[plugin.File] = plugin
+	}
+	if a := plugins["a.so"]; a.Version != "1.1" || len(a.Functions) != 1 || a.Functions[0] != "tool" {
+		t.Errorf("wrong plugin a.so. got=%+v", a)
+	}
+	if c := plugins["c.so"]; c.Version != "2.0" || len(c.Functions) != 1 || c.Functions[0] != "helper" {
+		t.Errorf("wrong plugin c.so. got=%+v", c)
+	}
+	if _, ok := plugins[""]; ok {
+		t.Errorf("functions registered by the host listed as a plugin")
+	}
+
+	listed, ok := GetBuiltInByName("plugins").Fn().(*Array)
+	if !ok || len(listed.Elements) != len(plugins) {
+		t.Errorf("wrong result of plugins(). got=%v", listed)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	RegisterFunction("registry_shared", Extended{Fn: func(args ...Object) Object {
+		return &String{Value: "global"}
+	}})
+
+	r := NewRegistry()
+	r.Register("registry_shared", func(args ...Object) Object {
+		return &String{Value: "own"}
+	})
+	r.Register("registry_own", func(args ...Object) Object {
+		return &String{Value: "own"}
+	})
+
+	tests := []struct {
+		registry *Registry
+		name     string
+		expected string
+	}{
+		{r, "registry_shared", "own"},
+		{r, "registry_own", "own"},
+		{nil, "registry_shared", "global"},
+		{NewRegistry(), "registry_shared", "global"},
+		{NewRegistry(), "registry_own", ""},
+	}
+
+	for _, tt := range tests {
+		fn, ok := tt.registry.Lookup(tt.name)
+		if tt.expected == "" {
+			if ok {
+				t.Errorf("%s was found", tt.name)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s was not found", tt.name)
+			continue
+		}
+		if result := fn.Fn().Inspect(); result != tt.expected {
+			t.Errorf("wrong function for %s. want=%s, got=%s", tt.name, tt.expected, result)
+		}
+	}
+}
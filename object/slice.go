@@ -0,0 +1,66 @@
+// object/slice.go
+
+package object
+
+import "fmt"
+
+// Slice returns the elements of an array, or the bytes of a string, from low
+// up to but not including high, as read by value[low:high]. A null bound
+// stands for the start or the end, and a negative one counts from the end,
+// like array indices. Bounds are clamped to the value, so slicing past its
+// end is not an error, and a low bound past the high one gives an empty
+// slice.
+func Slice(value, low, high Object) (Object, error) {
+	var length int
+	switch value := value.(type) {
+	case *Array:
+		length = len(value.Elements)
+	case *String:
+		length = len(value.Value)
+	default:
+		return nil, fmt.Errorf("slice operator not supported: %s", value.Type())
+	}
+
+	start, err := sliceBound(low, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	end, err := sliceBound(high, length, length)
+	if err != nil {
+		return nil, err
+	}
+	if start > end {
+		start = end
+	}
+
+	if str, ok := value.(*String); ok {
+		return &String{Value: str.Value[start:end]}, nil
+	}
+	elements := make([]Object, end-start)
+	copy(elements, value.(*Array).Elements[start:end])
+	return &Array{Elements: elements}, nil
+}
+
+// sliceBound is a helper function that returns a bound of a slice of a value
+// of length elements clamped to it, or def when the bound is null
+func sliceBound(bound Object, def, length int) (int, error) {
+	switch bound := bound.(type) {
+	case *Null:
+		return def, nil
+	case *Integer:
+		value := bound.Value
+		if value < 0 {
+			value += int64(length)
+		}
+		switch {
+		case value < 0:
+			return 0, nil
+		case value > int64(length):
+			return length, nil
+		default:
+			return int(value), nil
+		}
+	default:
+		return 0, fmt.Errorf("slice bound must be INTEGER, got %s", bound.Type())
+	}
+}
@@ -0,0 +1,41 @@
+package object
+
+import "testing"
+
+func TestSlice(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}, &Integer{Value: 3}}}
+	null := &Null{}
+	tests := []struct {
+		value     Object
+		low, high Object
+		expected  string
+	}{
+		{array, &Integer{Value: 1}, &Integer{Value: 3}, "[2, 3]"},
+		{array, null, &Integer{Value: 2}, "[1, 2]"},
+		{array, &Integer{Value: 2}, null, "[3]"},
+		{array, null, null, "[1, 2, 3]"},
+		{array, &Integer{Value: -5}, &Integer{Value: 10}, "[1, 2, 3]"},
+		{array, &Integer{Value: 2}, &Integer{Value: 1}, "[]"},
+		{array, &Integer{Value: -2}, null, "[2, 3]"},
+		{array, null, &Integer{Value: -1}, "[1, 2]"},
+		{&String{Value: "hello"}, &Integer{Value: 1}, &Integer{Value: 3}, "el"},
+		{&String{Value: "hello"}, &Integer{Value: 4}, &Integer{Value: 9}, "o"},
+	}
+
+	for _, tt := range tests {
+		result, err := Slice(tt.value, tt.low, tt.high)
+		if err != nil {
+			t.Fatalf("Slice(%s) returned error: %s", tt.value.Inspect(), err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong slice of %s. want=%q, got=%q", tt.value.Inspect(), tt.expected, result.Inspect())
+		}
+	}
+
+	if _, err := Slice(&Integer{Value: 1}, null, null); err == nil || err.Error() != "slice operator not supported: INTEGER" {
+		t.Errorf("wrong error for slicing an integer. got=%v", err)
+	}
+	if _, err := Slice(array, &String{Value: "a"}, null); err == nil || err.Error() != "slice bound must be INTEGER, got STRING" {
+		t.Errorf("wrong error for a string bound. got=%v", err)
+	}
+}
@@ -0,0 +1,555 @@
+// object/tensor.go
+
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DType is the type of the elements of a tensor. The elements are held as
+// float64 whatever their type, rounded to the values the type can hold.
+type DType string
+
+const (
+	Float64 DType = "float64" // Float64 is the type of tensors by default
+	Float32 DType = "float32" // Float32 rounds the elements to single precision
+	Int64   DType = "int64"   // Int64 truncates the elements to integers
+	Bool    DType = "bool"    // Bool holds 1 for true and 0 for false, as masks do
+)
+
+// ParseDType returns the element type called name
+func ParseDType(name string) (DType, error) {
+	switch d := DType(name); d {
+	case Float64, Float32, Int64, Bool:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown dtype %q, want float64, float32, int64 or bool", name)
+	}
+}
+
+// Convert returns v rounded to a value of type d
+func (d DType) Convert(v float64) float64 {
+	switch d {
+	case Float32:
+		return float64(float32(v))
+	case Int64:
+		return math.Trunc(v)
+	case Bool:
+		if v != 0 {
+			return 1
+		}
+		return 0
+	default:
+		return v
+	}
+}
+
+// Format returns the text of an element of type d
+func (d DType) Format(v float64) string {
+	if d == Bool {
+		return fmt.Sprintf("%t", v != 0)
+	}
+	if d == Int64 && !math.IsInf(v, 0) && !math.IsNaN(v) {
+		return fmt.Sprintf("%d", int64(v))
+	}
+	return fmt.Sprintf("%f", v)
+}
+
+// ElementType returns the type of the elements of t
+func (t *Tensor) ElementType() DType {
+	if t.DType == "" {
+		return Float64
+	}
+	return t.DType
+}
+
+// AsType returns a copy of t with its elements converted to the type d
+func (t *Tensor) AsType(d DType) *Tensor {
+	data := make([]float64, len(t.Data))
+	for i, v := range t.Data {
+		data[i] = d.Convert(v)
+	}
+	return &Tensor{Shape: append([]int64{}, t.Shape...), Data: data, DType: d}
+}
+
+// promote is a helper function that returns the type of the elements of the
+// result of an operation on left and right. Numbers take the type of the
+// tensor they are applied to, unless a float is applied to integers. Masks
+// count as integers.
+func promote(left, right *Tensor) DType {
+	l, r := left.ElementType(), right.ElementType()
+	if l == Bool {
+		l = Int64
+	}
+	if r == Bool {
+		r = Int64
+	}
+	switch {
+	case left.scalar == right.scalar && l == r:
+		return l
+	case left.scalar == right.scalar:
+		return Float64
+	case left.scalar && l == Float64 && r == Int64, right.scalar && r == Float64 && l == Int64:
+		return Float64
+	case left.scalar:
+		return r
+	default:
+		return l
+	}
+}
+
+// BroadcastShapes returns the shape of the result of an element-wise
+// operation on tensors of shapes a and b. Like in NumPy, the shapes are
+// aligned on their last dimension and dimensions of size 1, as well as the
+// ones missing from the shorter shape, are stretched to match the other.
+func BroadcastShapes(a, b []int64) ([]int64, error) {
+	rank := len(a)
+	if len(b) > rank {
+		rank = len(b)
+	}
+
+	shape := make([]int64, rank)
+	for i := range shape {
+		x, y := dimension(a, rank, i), dimension(b, rank, i)
+		switch {
+		case x == y || y == 1:
+			shape[i] = x
+		case x == 1:
+			shape[i] = y
+		default:
+			return nil, fmt.Errorf("shapes %v and %v cannot be broadcast together", a, b)
+		}
+	}
+	return shape, nil
+}
+
+// dimension is a helper function that returns the size of the dimension i of
+// shape aligned to the right with a shape of rank dimensions
+func dimension(shape []int64, rank, i int) int64 {
+	i -= rank - len(shape)
+	if i < 0 {
+		return 1
+	}
+	return shape[i]
+}
+
+// Broadcast applies fn to the elements of left and right, broadcasting them
+// to a common shape, and returns the tensor of the results
+func Broadcast(left, right *Tensor, fn func(x, y float64) float64) (*Tensor, error) {
+	return broadcast([]*Tensor{left, right}, promote(left, right), func(values []float64) float64 {
+		return fn(values[0], values[1])
+	})
+}
+
+// Compare applies the comparison fn to the elements of left and right,
+// broadcasting them to a common shape, and returns the mask of the results
+func Compare(left, right *Tensor, fn func(x, y float64) bool) (*Tensor, error) {
+	return broadcast([]*Tensor{left, right}, Bool, func(values []float64) float64 {
+		if fn(values[0], values[1]) {
+			return 1
+		}
+		return 0
+	})
+}
+
+// Where returns the tensor holding the elements of a where the elements of
+// mask are not zero and the ones of b elsewhere, the three tensors being
+// broadcast to a common shape
+func Where(mask, a, b *Tensor) (*Tensor, error) {
+	return broadcast([]*Tensor{mask, a, b}, promote(a, b), func(values []float64) float64 {
+		if values[0] != 0 {
+			return values[1]
+		}
+		return values[2]
+	})
+}
+
+// ArithmeticInPlace applies the operator +, -, * or / element-wise to t
+// and other, broadcast to the shape of t, and writes the results into the
+// data of t, converted to its element type. Unlike Arithmetic it allocates
+// no tensor, but the operation is not recorded for Backward, so it refuses
+// tensors whose gradients are computed.
+func (t *Tensor) ArithmeticInPlace(operator string, other *Tensor) error {
+	fn, err := arithmetic(operator)
+	if err != nil {
+		return err
+	}
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	if err := other.Validate(); err != nil {
+		return err
+	}
+	if t.tracked() || other.tracked() {
+		return errors.New("in-place operations cannot be used on tensors requiring gradients")
+	}
+	if shape, err := BroadcastShapes(t.Shape, other.Shape); err != nil || !sameShape(shape, t.Shape) {
+		return fmt.Errorf("shape %v cannot be broadcast in place into shape %v", other.Shape, t.Shape)
+	}
+	if operator == "/" {
+		if err := checkDivisor(t.ElementType(), other); err != nil {
+			return err
+		}
+	}
+
+	broadcastInto(t.Data, t.Shape, []*Tensor{t, other}, t.ElementType(), func(values []float64) float64 {
+		return fn(values[0], values[1])
+	})
+	return nil
+}
+
+// checkDivisor is a helper function that returns an error when elements of
+// type dtype are divided by the elements of divisor and one of them is zero.
+// Integers have no infinity, so that like integer numbers they fail.
+func checkDivisor(dtype DType, divisor *Tensor) error {
+	if dtype != Int64 {
+		return nil
+	}
+	for _, v := range divisor.Data {
+		if v == 0 {
+			return errors.New("division by zero: int64 tensor / 0")
+		}
+	}
+	return nil
+}
+
+// Equal reports whether t and other have the same shape and elements
+func (t *Tensor) Equal(other *Tensor) bool {
+	if len(t.Shape) != len(other.Shape) || len(t.Data) != len(other.Data) {
+		return false
+	}
+	for i, d := range t.Shape {
+		if d != other.Shape[i] {
+			return false
+		}
+	}
+	for i, v := range t.Data {
+		if v != other.Data[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// AllClose reports whether the elements of a and b, broadcast to a common
+// shape, differ by eps at most
+func AllClose(a, b *Tensor, eps float64) (bool, error) {
+	near, err := Compare(a, b, func(x, y float64) bool { return math.Abs(x-y) <= eps })
+	if err != nil {
+		return false, err
+	}
+	for _, v := range near.Data {
+		if v == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// broadcast is a helper function that applies fn to the elements of the
+// operands broadcast to a common shape and returns the tensor of the results
+// converted to dtype
+func broadcast(operands []*Tensor, dtype DType, fn func(values []float64) float64) (*Tensor, error) {
+	shape := []int64{}
+	for _, operand := range operands {
+		if err := operand.Validate(); err != nil {
+			return nil, err
+		}
+		var err error
+		if shape, err = BroadcastShapes(shape, operand.Shape); err != nil {
+			return nil, err
+		}
+	}
+
+	size := int64(1)
+	for _, d := range shape {
+		size *= d
+	}
+	data := make([]float64, size)
+	broadcastInto(data, shape, operands, dtype, fn)
+
+	result := &Tensor{Shape: shape, Data: data}
+	if dtype != Float64 {
+		result.DType = dtype
+	}
+	return result, nil
+}
+
+// broadcastInto is a helper function that applies fn to the elements of the
+// operands broadcast to shape and writes the results, converted to dtype,
+// into data. data may be the data of an operand, each element is read before
+// it is written. Large results are split across goroutines.
+func broadcastInto(data []float64, shape []int64, operands []*Tensor, dtype DType, fn func(values []float64) float64) {
+	// Operands holding as many elements as the result are not stretched,
+	// their elements are at the index of the result
+	stretched := false
+	for _, operand := range operands {
+		stretched = stretched || len(operand.Data) != len(data)
+	}
+	if !stretched {
+		parallelFor(len(data), len(operands), func(start, end int) {
+			values := make([]float64, len(operands))
+			for i := start; i < end; i++ {
+				for j, operand := range operands {
+					values[j] = operand.Data[i]
+				}
+				data[i] = dtype.Convert(fn(values))
+			}
+		})
+		return
+	}
+
+	strides := make([][]int64, len(operands))
+	for j, operand := range operands {
+		strides[j] = broadcastStrides(operand.Shape, shape)
+	}
+	parallelFor(len(data), len(operands), func(start, end int) {
+		values := make([]float64, len(operands))
+
+		// Start the index at the element start
+		positions := make([]int64, len(operands))
+		index := make([]int64, len(shape))
+		rest := int64(start)
+		for d := len(shape) - 1; d >= 0; d-- {
+			index[d] = rest % shape[d]
+			rest /= shape[d]
+			for j := range positions {
+				positions[j] += index[d] * strides[j][d]
+			}
+		}
+
+		for i := start; i < end; i++ {
+			for j, operand := range operands {
+				values[j] = operand.Data[positions[j]]
+			}
+			data[i] = dtype.Convert(fn(values))
+
+			// Move to the next element like an odometer, the positions in
+			// the operands following the index
+			for d := len(shape) - 1; d >= 0; d-- {
+				index[d]++
+				for j := range positions {
+					positions[j] += strides[j][d]
+				}
+				if index[d] < shape[d] {
+					break
+				}
+				for j := range positions {
+					positions[j] -= strides[j][d] * shape[d]
+				}
+				index[d] = 0
+			}
+		}
+	})
+}
+
+// broadcastStrides is a helper function that returns the strides of the
+// data of a tensor of shape broadcast to the shape to. Stretched dimensions
+// have a stride of 0, so that their single element is repeated.
+func broadcastStrides(shape, to []int64) []int64 {
+	strides := make([]int64, len(to))
+	stride := int64(1)
+	for i := len(to) - 1; i >= 0; i-- {
+		if d := dimension(shape, len(to), i); d != 1 {
+			strides[i] = stride
+			stride *= d
+		}
+	}
+	return strides
+}
+
+// Validate checks the dimensions of a tensor are not negative and its data
+// holds as many elements as its shape
+func (t *Tensor) Validate() error {
+	size := int64(1)
+	for _, d := range t.Shape {
+		if d < 0 {
+			return fmt.Errorf("tensor of shape %v has a negative dimension", t.Shape)
+		}
+		size *= d
+	}
+	if size != int64(len(t.Data)) {
+		return fmt.Errorf("tensor of shape %v holds %d elements, want %d", t.Shape, len(t.Data), size)
+	}
+	return nil
+}
+
+// NestedTensor returns the tensor holding the numbers of nested arrays, its
+// shape being the lengths of the arrays at each depth. Arrays at the same
+// depth must have the same length.
+func NestedTensor(data *Array) (*Tensor, error) {
+	// The shape is the one of the first elements, which the others are
+	// checked against
+	shape := []int64{}
+	var element Object = data
+	for {
+		array, ok := element.(*Array)
+		if !ok {
+			break
+		}
+		shape = append(shape, int64(len(array.Elements)))
+		if len(array.Elements) == 0 {
+			break
+		}
+		element = array.Elements[0]
+	}
+
+	tensor := &Tensor{Shape: shape, Data: []float64{}}
+	if err := tensor.flatten(data, 0); err != nil {
+		return nil, err
+	}
+	return tensor, nil
+}
+
+// flatten is a helper function that appends the numbers of obj, found at the
+// given depth of nested data, to the data of a tensor
+func (t *Tensor) flatten(obj Object, depth int) error {
+	array, isArray := obj.(*Array)
+	if depth == len(t.Shape) {
+		if isArray {
+			return notRectangular(obj, "a number")
+		}
+		scalar, ok := ScalarTensor(obj)
+		if !ok {
+			return fmt.Errorf("tensor data must be numbers, got %s", obj.Type())
+		}
+		t.Data = append(t.Data, scalar.Data[0])
+		return nil
+	}
+
+	expected := fmt.Sprintf("an array of length %d", t.Shape[depth])
+	if !isArray || int64(len(array.Elements)) != t.Shape[depth] {
+		return notRectangular(obj, expected)
+	}
+	for _, element := range array.Elements {
+		if err := t.flatten(element, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notRectangular is a helper function that returns the error of nested
+// tensor data holding obj where the first elements make expected the shape,
+// which both engines report for tensor literals
+func notRectangular(obj Object, expected string) error {
+	got := string(obj.Type())
+	if array, ok := obj.(*Array); ok {
+		got = fmt.Sprintf("an array of length %d", len(array.Elements))
+	}
+	return fmt.Errorf("nested tensor data is not rectangular: got %s where %s is expected", got, expected)
+}
+
+// ScalarTensor returns a number as a tensor of no dimension, which
+// broadcasts to any shape, and whether obj is a number
+func ScalarTensor(obj Object) (*Tensor, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return &Tensor{Shape: []int64{}, Data: []float64{float64(obj.Value)}, DType: Int64, scalar: true}, true
+	case *Float:
+		return &Tensor{Shape: []int64{}, Data: []float64{obj.Value}, scalar: true}, true
+	default:
+		return nil, false
+	}
+}
+
+// Reshape returns a tensor holding the data of t with the given shape, which
+// must hold as many elements. One dimension may be -1, its size is then
+// inferred from the others.
+func (t *Tensor) Reshape(shape []int64) (*Tensor, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	shape = append([]int64{}, shape...)
+	inferred := -1
+	size := int64(1)
+	for i, d := range shape {
+		switch {
+		case d == -1 && inferred == -1:
+			inferred = i
+		case d < 0:
+			return nil, fmt.Errorf("cannot reshape tensor of shape %v into %v", t.Shape, shape)
+		default:
+			size *= d
+		}
+	}
+	if inferred != -1 && size != 0 && int64(len(t.Data))%size == 0 {
+		shape[inferred] = int64(len(t.Data)) / size
+		size *= shape[inferred]
+	}
+	if size != int64(len(t.Data)) {
+		return nil, fmt.Errorf("cannot reshape tensor of shape %v into %v", t.Shape, shape)
+	}
+	result := &Tensor{Shape: shape, Data: append([]float64{}, t.Data...), DType: t.DType}
+	return record(result, []*Tensor{t}, func(grad *Tensor) []*Tensor {
+		return []*Tensor{{Shape: t.Shape, Data: grad.Data}}
+	}), nil
+}
+
+// Transpose returns t with its axes permuted, the axis i of the result being
+// the axis axes[i] of t. Without axes, the axes are reversed.
+func (t *Tensor) Transpose(axes []int) (*Tensor, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	rank := len(t.Shape)
+	if axes == nil {
+		axes = make([]int, rank)
+		for i := range axes {
+			axes[i] = rank - 1 - i
+		}
+	}
+	if len(axes) != rank {
+		return nil, fmt.Errorf("axes %v do not permute the %d axes of the tensor", axes, rank)
+	}
+	seen := make([]bool, rank)
+	for _, axis := range axes {
+		if axis < 0 || axis >= rank || seen[axis] {
+			return nil, fmt.Errorf("axes %v do not permute the %d axes of the tensor", axes, rank)
+		}
+		seen[axis] = true
+	}
+
+	strides := make([]int64, rank)
+	stride := int64(1)
+	for i := rank - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= t.Shape[i]
+	}
+
+	shape := make([]int64, rank)
+	sourceStrides := make([]int64, rank)
+	for i, axis := range axes {
+		shape[i], sourceStrides[i] = t.Shape[axis], strides[axis]
+	}
+
+	data := make([]float64, len(t.Data))
+	index := make([]int64, rank)
+	var source int64
+	for i := range data {
+		data[i] = t.Data[source]
+
+		for d := rank - 1; d >= 0; d-- {
+			index[d]++
+			source += sourceStrides[d]
+			if index[d] < shape[d] {
+				break
+			}
+			source -= sourceStrides[d] * shape[d]
+			index[d] = 0
+		}
+	}
+	result := &Tensor{Shape: shape, Data: data, DType: t.DType}
+	return record(result, []*Tensor{t}, func(grad *Tensor) []*Tensor {
+		inverse := make([]int, rank)
+		for i, axis := range axes {
+			inverse[axis] = i
+		}
+		transposed, _ := grad.Transpose(inverse)
+		return []*Tensor{transposed}
+	}), nil
+}
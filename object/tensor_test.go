@@ -0,0 +1,295 @@
+package object
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBroadcast(t *testing.T) {
+	add := func(x, y float64) float64 { return x + y }
+
+	tests := []struct {
+		left, right *Tensor
+		expected    *Tensor
+	}{
+		{
+			&Tensor{Shape: []int64{2}, Data: []float64{1, 2}},
+			&Tensor{Shape: []int64{2}, Data: []float64{10, 20}},
+			&Tensor{Shape: []int64{2}, Data: []float64{11, 22}},
+		},
+		{
+			&Tensor{Shape: []int64{3, 1}, Data: []float64{1, 2, 3}},
+			&Tensor{Shape: []int64{3, 4}, Data: []float64{0, 0, 0, 0, 10, 10, 10, 10, 20, 20, 20, 20}},
+			&Tensor{Shape: []int64{3, 4}, Data: []float64{1, 1, 1, 1, 12, 12, 12, 12, 23, 23, 23, 23}},
+		},
+		{
+			&Tensor{Shape: []int64{2, 1}, Data: []float64{1, 2}},
+			&Tensor{Shape: []int64{3}, Data: []float64{10, 20, 30}},
+			&Tensor{Shape: []int64{2, 3}, Data: []float64{11, 21, 31, 12, 22, 32}},
+		},
+		{
+			&Tensor{Shape: []int64{2, 2}, Data: []float64{1, 2, 3, 4}},
+			&Tensor{Shape: []int64{}, Data: []float64{1}},
+			&Tensor{Shape: []int64{2, 2}, Data: []float64{2, 3, 4, 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		result, err := Broadcast(tt.left, tt.right, add)
+		if err != nil {
+			t.Errorf("Broadcast(%v, %v) failed: %s", tt.left.Shape, tt.right.Shape, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("wrong result of %v + %v. want=%s, got=%s", tt.left.Shape, tt.right.Shape, tt.expected.Inspect(), result.Inspect())
+		}
+	}
+
+	errors := []struct {
+		left, right *Tensor
+		expected    string
+	}{
+		{
+			&Tensor{Shape: []int64{2}, Data: []float64{1, 2}},
+			&Tensor{Shape: []int64{3}, Data: []float64{1, 2, 3}},
+			"shapes [2] and [3] cannot be broadcast together",
+		},
+		{
+			&Tensor{Shape: []int64{2, 2}, Data: []float64{1, 2, 3}},
+			&Tensor{Shape: []int64{2, 2}, Data: []float64{1, 2, 3, 4}},
+			"tensor of shape [2 2] holds 3 elements, want 4",
+		},
+		{
+			&Tensor{Shape: []int64{2}, Data: []float64{1, 2}},
+			&Tensor{Shape: []int64{-1}, Data: []float64{}},
+			"tensor of shape [-1] has a negative dimension",
+		},
+	}
+
+	for _, tt := range errors {
+		if _, err := Broadcast(tt.left, tt.right, add); err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestReshape(t *testing.T) {
+	tensor := &Tensor{Shape: []int64{2, 3}, Data: []float64{1, 2, 3, 4, 5, 6}}
+
+	tests := []struct {
+		shape    []int64
+		expected []int64
+	}{
+		{[]int64{3, 2}, []int64{3, 2}},
+		{[]int64{6}, []int64{6}},
+		{[]int64{-1, 3}, []int64{2, 3}},
+		{[]int64{1, -1, 2}, []int64{1, 3, 2}},
+	}
+
+	for _, tt := range tests {
+		result, err := tensor.Reshape(tt.shape)
+		if err != nil {
+			t.Errorf("Reshape(%v) failed: %s", tt.shape, err)
+			continue
+		}
+		if !reflect.DeepEqual(result.Shape, tt.expected) || !reflect.DeepEqual(result.Data, tensor.Data) {
+			t.Errorf("wrong result of Reshape(%v). got=%s", tt.shape, result.Inspect())
+		}
+	}
+
+	for _, shape := range [][]int64{{4}, {-1, 4}, {-1, -1}, {-2, -3}} {
+		if _, err := tensor.Reshape(shape); err == nil || !strings.Contains(err.Error(), "cannot reshape tensor of shape [2 3]") {
+			t.Errorf("wrong error for Reshape(%v). got=%v", shape, err)
+		}
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	matrix := &Tensor{Shape: []int64{2, 3}, Data: []float64{1, 2, 3, 4, 5, 6}}
+	cube := &Tensor{Shape: []int64{2, 1, 3}, Data: []float64{1, 2, 3, 4, 5, 6}}
+
+	tests := []struct {
+		tensor   *Tensor
+		axes     []int
+		expected *Tensor
+	}{
+		{matrix, nil, &Tensor{Shape: []int64{3, 2}, Data: []float64{1, 4, 2, 5, 3, 6}}},
+		{matrix, []int{0, 1}, matrix},
+		{cube, []int{1, 2, 0}, &Tensor{Shape: []int64{1, 3, 2}, Data: []float64{1, 4, 2, 5, 3, 6}}},
+		{cube, nil, &Tensor{Shape: []int64{3, 1, 2}, Data: []float64{1, 4, 2, 5, 3, 6}}},
+	}
+
+	for _, tt := range tests {
+		result, err := tt.tensor.Transpose(tt.axes)
+		if err != nil {
+			t.Errorf("Transpose(%v) failed: %s", tt.axes, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("wrong result of Transpose(%v). want=%s, got=%s", tt.axes, tt.expected.Inspect(), result.Inspect())
+		}
+	}
+
+	for _, axes := range [][]int{{0}, {0, 0}, {1, 2}} {
+		if _, err := matrix.Transpose(axes); err == nil || !strings.Contains(err.Error(), "do not permute the 2 axes") {
+			t.Errorf("wrong error for Transpose(%v). got=%v", axes, err)
+		}
+	}
+}
+
+func TestDType(t *testing.T) {
+	floats := &Tensor{Shape: []int64{3}, Data: []float64{1.5, -2.7, 0.1}}
+	ints := floats.AsType(Int64)
+	if ints.Inspect() != "@[3], [1, -2, 0], int64" {
+		t.Errorf("wrong int64 tensor. got=%s", ints.Inspect())
+	}
+	if singles := floats.AsType(Float32); singles.Data[2] != float64(float32(0.1)) || singles.ElementType() != Float32 {
+		t.Errorf("wrong float32 tensor. got=%v", singles.Data)
+	}
+	if floats.ElementType() != Float64 || floats.Data[0] != 1.5 {
+		t.Errorf("AsType changed the tensor. got=%s", floats.Inspect())
+	}
+
+	two, _ := ScalarTensor(&Integer{Value: 2})
+	half, _ := ScalarTensor(&Float{Value: 0.5})
+	div := func(x, y float64) float64 { return x / y }
+
+	tests := []struct {
+		left, right *Tensor
+		expected    string
+	}{
+		{ints, ints, "@[3], [1, 1, NaN], int64"},
+		{ints, two, "@[3], [0, -1, 0], int64"},
+		{ints, half, "@[3], [2.000000, -4.000000, 0.000000]"},
+		{floats.AsType(Float32), two, "@[3], [0.750000, -1.350000, 0.050000], float32"},
+		{floats.AsType(Float32), ints, "@[3], [1.500000, 1.350000, +Inf]"},
+	}
+
+	for _, tt := range tests {
+		result, err := Broadcast(tt.left, tt.right, div)
+		if err != nil {
+			t.Fatalf("Broadcast failed: %s", err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result of %s / %s. want=%s, got=%s", tt.left.ElementType(), tt.right.ElementType(), tt.expected, result.Inspect())
+		}
+	}
+
+	if _, err := ParseDType("int8"); err == nil || err.Error() != `unknown dtype "int8", want float64, float32, int64 or bool` {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestMasks(t *testing.T) {
+	values := &Tensor{Shape: []int64{2, 2}, Data: []float64{0.2, 0.7, 0.9, 0.4}}
+	half, _ := ScalarTensor(&Float{Value: 0.5})
+
+	mask, err := Compare(values, half, func(x, y float64) bool { return x > y })
+	if err != nil {
+		t.Fatalf("Compare failed: %s", err)
+	}
+	if mask.Inspect() != "@[2, 2], [false, true, true, false], bool" {
+		t.Errorf("wrong mask. got=%s", mask.Inspect())
+	}
+
+	zero, _ := ScalarTensor(&Integer{Value: 0})
+	result, err := Where(mask, values, zero)
+	if err != nil {
+		t.Fatalf("Where failed: %s", err)
+	}
+	if !reflect.DeepEqual(result.Data, []float64{0, 0.7, 0.9, 0}) || result.ElementType() != Float64 {
+		t.Errorf("wrong result of Where. got=%s", result.Inspect())
+	}
+
+	rows := &Tensor{Shape: []int64{2, 1}, Data: []float64{1, 0}}
+	result, err = Where(rows, &Tensor{Shape: []int64{2}, Data: []float64{1, 2}}, zero)
+	if err != nil {
+		t.Fatalf("Where failed: %s", err)
+	}
+	if !reflect.DeepEqual(result.Shape, []int64{2, 2}) || !reflect.DeepEqual(result.Data, []float64{1, 2, 0, 0}) {
+		t.Errorf("wrong result of a broadcast Where. got=%s", result.Inspect())
+	}
+
+	if sum, _ := Broadcast(mask, mask, func(x, y float64) float64 { return x + y }); sum.Inspect() != "@[2, 2], [0, 2, 2, 0], int64" {
+		t.Errorf("masks not added as integers. got=%s", sum.Inspect())
+	}
+}
+
+func TestTensorEquality(t *testing.T) {
+	a := &Tensor{Shape: []int64{2}, Data: []float64{1, 2}}
+
+	tests := []struct {
+		other    *Tensor
+		expected bool
+	}{
+		{&Tensor{Shape: []int64{2}, Data: []float64{1, 2}}, true},
+		{&Tensor{Shape: []int64{2}, Data: []float64{1, 3}}, false},
+		{&Tensor{Shape: []int64{1, 2}, Data: []float64{1, 2}}, false},
+		{&Tensor{Shape: []int64{2}, Data: []float64{1, 2}, DType: Int64}, true},
+	}
+
+	for _, tt := range tests {
+		if a.Equal(tt.other) != tt.expected {
+			t.Errorf("wrong equality of %s and %s. want=%t", a.Inspect(), tt.other.Inspect(), tt.expected)
+		}
+	}
+
+	near := &Tensor{Shape: []int64{2}, Data: []float64{1.0001, 1.9999}}
+	if close, err := AllClose(a, near, 1e-3); err != nil || !close {
+		t.Errorf("tensors not close. got=%t (%v)", close, err)
+	}
+	if close, err := AllClose(a, near, 1e-5); err != nil || close {
+		t.Errorf("tensors close. got=%t (%v)", close, err)
+	}
+	if _, err := AllClose(a, &Tensor{Shape: []int64{3}, Data: []float64{1, 2, 3}}, 1); err == nil {
+		t.Errorf("tensors of different shapes compared")
+	}
+}
+
+func TestArithmeticInPlace(t *testing.T) {
+	a := &Tensor{Shape: []int64{2, 2}, Data: []float64{1, 2, 3, 4}}
+	data := a.Data
+	if err := a.ArithmeticInPlace("+", &Tensor{Shape: []int64{2}, Data: []float64{10, 20}}); err != nil {
+		t.Fatalf("ArithmeticInPlace failed: %s", err)
+	}
+	if err := a.ArithmeticInPlace("*", &Tensor{Shape: []int64{}, Data: []float64{2}}); err != nil {
+		t.Fatalf("ArithmeticInPlace failed: %s", err)
+	}
+	if want := []float64{22, 44, 26, 48}; !reflect.DeepEqual(a.Data, want) {
+		t.Errorf("wrong data. want=%v, got=%v", want, a.Data)
+	}
+	if &data[0] != &a.Data[0] {
+		t.Errorf("ArithmeticInPlace replaced the data of the tensor")
+	}
+
+	i := &Tensor{Shape: []int64{2}, Data: []float64{7, 8}, DType: Int64}
+	if err := i.ArithmeticInPlace("/", &Tensor{Shape: []int64{}, Data: []float64{2}}); err != nil {
+		t.Fatalf("ArithmeticInPlace failed: %s", err)
+	}
+	if want := []float64{3, 4}; !reflect.DeepEqual(i.Data, want) {
+		t.Errorf("wrong int64 data. want=%v, got=%v", want, i.Data)
+	}
+
+	errors := []struct {
+		tensor, other *Tensor
+		expected      string
+	}{
+		{
+			&Tensor{Shape: []int64{1}, Data: []float64{1}},
+			&Tensor{Shape: []int64{2}, Data: []float64{1, 2}},
+			"shape [2] cannot be broadcast in place into shape [1]",
+		},
+		{
+			RequireGrad(&Tensor{Shape: []int64{1}, Data: []float64{1}}),
+			&Tensor{Shape: []int64{1}, Data: []float64{1}},
+			"in-place operations cannot be used on tensors requiring gradients",
+		},
+	}
+	for _, tt := range errors {
+		err := tt.tensor.ArithmeticInPlace("+", tt.other)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
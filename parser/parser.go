@@ -3,9 +3,11 @@ package parser
 import (
 	"fmt"
 	"monkey/ast"
+	"monkey/diagnostic"
 	"monkey/lexer"
 	"monkey/token"
 	"strconv"
+	"strings"
 )
 
 type (
@@ -17,6 +19,10 @@ const (
 	_ int = iota
 	// LOWEST is the lowest precedence
 	LOWEST
+	// OR is the precedence of the logical or
+	OR
+	// AND is the precedence of the logical and
+	AND
 	// EQUALS is the precedence of the equals sign
 	EQUALS
 	// LESSGREATER is the precedence of the less than and greater than signs
@@ -27,6 +33,9 @@ const (
 	PRODUCT
 	// PREFIX is the precedence of the prefix sign
 	PREFIX
+	// POWER is the precedence of the exponent sign, binding tighter than a
+	// prefix operator on its left, -2 ** 2 being -(2 ** 2)
+	POWER
 	// CALL is the precedence of the call sign
 	CALL
 	// INDEX is the precedence of the index sign
@@ -34,25 +43,34 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.OR:        OR,
+	token.AND:       AND,
+	token.EQ:        EQUALS,
+	token.NOT_EQ:    EQUALS,
+	token.LT:        LESSGREATER,
+	token.GT:        LESSGREATER,
+	token.LT_EQ:     LESSGREATER,
+	token.GT_EQ:     LESSGREATER,
+	token.PLUS:      SUM,
+	token.MINUS:     SUM,
+	token.SLASH:     PRODUCT,
+	token.ASTERISK:  PRODUCT,
+	token.FLOOR_DIV: PRODUCT,
+	token.POWER:     POWER,
+	token.LPAREN:    CALL,
+	token.LBRACKET:  INDEX,
+	token.DOT:       INDEX,
 }
 
 // Parser is a struct that holds the lexer and the currentToken
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l           *lexer.Lexer
+	errors      []diagnostic.Diagnostic
+	lexerErrors int // lexerErrors is the number of errors of the lexer added to errors
 
-	currentToken token.Token
-	peekToken    token.Token
+	previousToken token.Token // previousToken is the token before currentToken
+	currentToken  token.Token
+	peekToken     token.Token
 
 	prefixParseFns map[token.TokenType]prefixParseFn // prefixParseFns is a map of prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn  // infixParseFns is a map of infixParseFn
@@ -62,7 +80,7 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []diagnostic.Diagnostic{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn) // Initialize the prefixParseFns
@@ -73,6 +91,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)     // Register the parsePrefixExpression function
 	p.registerPrefix(token.TRUE, p.parseBoolean)               // Register the parseBoolean function
 	p.registerPrefix(token.FALSE, p.parseBoolean)              // Register the parseBoolean function
+	p.registerPrefix(token.NULL, p.parseNullLiteral)           // Register the parseNullLiteral function
+	p.registerPrefix(token.TRY, p.parseTryExpression)          // Register the parseTryExpression function
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)   // Register the parseGroupedExpression function
 	p.registerPrefix(token.IF, p.parseIfExpression)            // Register the parseIfExpression function
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)   // Register the parseFunctionLiteral function
@@ -87,12 +107,19 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)     // Register the parseInfixExpression function
 	p.registerInfix(token.SLASH, p.parseInfixExpression)     // Register the parseInfixExpression function
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)  // Register the parseInfixExpression function
+	p.registerInfix(token.FLOOR_DIV, p.parseInfixExpression) // Register the parseInfixExpression function
+	p.registerInfix(token.POWER, p.parseInfixExpression)     // Register the parseInfixExpression function
+	p.registerInfix(token.AND, p.parseInfixExpression)       // Register the parseInfixExpression function
+	p.registerInfix(token.OR, p.parseInfixExpression)        // Register the parseInfixExpression function
 	p.registerInfix(token.EQ, p.parseInfixExpression)        // Register the parseInfixExpression function
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)    // Register the parseInfixExpression function
 	p.registerInfix(token.LT, p.parseInfixExpression)        // Register the parseInfixExpression function
 	p.registerInfix(token.GT, p.parseInfixExpression)        // Register the parseInfixExpression function
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)     // Register the parseInfixExpression function
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)     // Register the parseInfixExpression function
 	p.registerInfix(token.LPAREN, p.parseCallExpression)     // Register the parseCallExpression function
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)  // Register the parseIndexExpression function
+	p.registerInfix(token.DOT, p.parseMemberExpression)      // Register the parseMemberExpression function
 
 	// Read two tokens so currentToken and peekToken are both set
 	p.nextToken()
@@ -106,25 +133,54 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	value, err := strconv.ParseFloat(p.currentToken.Literal, 64) // Convert the literal to an float
 	if err != nil {
 		msg := fmt.Sprintf("Syntax error on line %d: could not parse %q as float", p.currentToken.Line, p.currentToken.Literal)
-		p.errors = append(p.errors, msg) // Add an error to the errors slice
+		p.addError(p.currentToken, msg) // Add an error to the errors slice
 		return nil
 	}
 
 	return &ast.FloatLiteral{Token: p.currentToken, Value: value}
 }
 
-// parseImportLiteral is a helper function that parses an import literal
+// parseImportLiteral is a helper function that parses an import literal and
+// the checksum pinning a remote import, if any, and the name of the module,
+// import "math.mky" as math
 func (p *Parser) parseImportLiteral() ast.Expression {
 	exp := &ast.ImportLiteral{Token: p.currentToken} // Create a new import literal
 
-	for !p.peekTokenIs(token.SEMICOLON) {
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	exp.Path = p.currentToken.Literal
+
+	if p.peekTokenIs(token.CHECKSUM) {
+		exp.Checksum = p.parseChecksum() // parseChecksum is a helper function
+	}
+
+	// as is only a keyword after the path, so it can still name variables
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "as" {
 		p.nextToken()
-		exp.Path = p.currentToken.Literal
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		exp.Alias = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
 	}
 
 	return exp
 }
 
+// parseChecksum is a helper function that parses a sha256:<hex> checksum,
+// which the lexer reads as a single token
+func (p *Parser) parseChecksum() string {
+	p.nextToken()
+	text := p.currentToken.Literal
+
+	checksum := strings.TrimPrefix(text, "sha256:")
+	if !strings.HasPrefix(text, "sha256:") || len(checksum) != 64 || strings.Trim(checksum, "0123456789abcdef") != "" {
+		p.addError(p.currentToken, fmt.Sprintf("On line %d, invalid checksum %s, expected sha256: and 64 lowercase hex digits", p.currentToken.Line, text))
+		return ""
+	}
+	return checksum
+}
+
 // parseHashLiteral is a helper function that parses a hash literal
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.currentToken}      // Create a new hash literal
@@ -144,6 +200,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST) // Parse the value
 
 		hash.Pairs[key] = value // Set the key-value pair
+		hash.Keys = append(hash.Keys, key)
 
 		// Check if the next token is a comma
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
@@ -155,16 +212,45 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.End = p.currentToken
 
 	return hash
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	expression := &ast.IndexExpression{Token: p.currentToken, Left: left}
+	tok := p.currentToken
+
+	// arr[:2] leaves out the low bound of a slice
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(tok, left, nil)
+	}
 
 	p.nextToken()
 
-	expression.Index = p.parseExpression(LOWEST)
+	index := p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(tok, left, index)
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+}
+
+// parseSliceExpression is a helper function that parses the rest of a slice,
+// arr[low:high], from the colon on. The high bound can be left out, arr[2:].
+func (p *Parser) parseSliceExpression(tok token.Token, left, low ast.Expression) ast.Expression {
+	expression := &ast.SliceExpression{Token: tok, Left: left, Low: low}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		expression.High = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -173,11 +259,25 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseMemberExpression is a helper function that parses the access of a
+// hash field by name, config.host
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	expression := &ast.MemberExpression{Token: p.currentToken, Left: left}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expression.Field = p.currentToken.Literal
+
+	return expression
+}
+
 // parseArrayLiteral is a helper function that parses an array literal
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.currentToken} // Create a new array literal
 
 	array.Elements = p.parseExpressionList(token.RBRACKET) // Parse the expression list
+	array.End = p.currentToken
 
 	return array
 }
@@ -218,6 +318,13 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 
 // parseCallExpression is a helper function that parses a call expression
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	// A call of a field is a method call, value.name(args)
+	if member, ok := function.(*ast.MemberExpression); ok {
+		call := &ast.MemberCallExpression{Token: p.currentToken, Receiver: member.Left, Method: member.Field}
+		call.Arguments = p.parseCallArguments() // Parse the call arguments
+		return call
+	}
+
 	exp := &ast.CallExpression{Token: p.currentToken, Function: function} // Create a new call expression
 
 	exp.Arguments = p.parseCallArguments() // Parse the call arguments
@@ -254,26 +361,29 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	return args
 }
 
-// parseTensorLiteral is a helper function that parses a tensor functionish literal.
+// parseTensorLiteral is a helper function that parses a tensor literal, either
+// a shape followed by the flat data, @[2, 2], [1.0, 2.0, 3.0, 4.0], or nested
+// data whose shape is inferred, @[[1.0, 2.0], [3.0, 4.0]]. An array of
+// integer literals followed by a comma is a shape.
 func (p *Parser) parseTensorLiteral() ast.Expression {
 	lit := &ast.TensorLiteral{Token: p.currentToken} // create a new Tensor literal
 
 	if !p.expectPeek(token.LBRACKET) {
 		return nil
 	}
-	// Move to the shape list/array
-	//p.nextToken()
 
-	// Parse the shape - assuming parseArrayLiteral can handle general list/array parsing
-	shape := p.parseExpression(LOWEST)
-	if shape == nil {
+	// Parse the array alone, an operator after nested data applies to the
+	// tensor
+	first := p.parseExpression(INDEX)
+	if first == nil {
 		return nil
 	}
-	lit.Shape = shape
-
-	if !p.expectPeek(token.COMMA) {
-		return nil
+	if !isShapeLiteral(first) || !p.peekTokenIs(token.COMMA) {
+		lit.Data = first
+		return lit
 	}
+	lit.Shape = first
+	p.nextToken()
 
 	// Move to the data list/array
 	p.nextToken()
@@ -288,6 +398,21 @@ func (p *Parser) parseTensorLiteral() ast.Expression {
 	return lit
 }
 
+// isShapeLiteral is a helper function that reports whether exp is an array
+// of integer literals, the shape of a tensor literal
+func isShapeLiteral(exp ast.Expression) bool {
+	array, ok := exp.(*ast.ArrayLiteral)
+	if !ok {
+		return false
+	}
+	for _, element := range array.Elements {
+		if _, ok := element.(*ast.IntegerLiteral); !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // parseFunctionLiteral is a helper function that parses a function literal
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.currentToken} // Create a new function literal
@@ -379,6 +504,29 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// parseTryExpression is a helper function that parses a try expression,
+// try { block } catch (e) { handler }
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.currentToken} // Create a new try expression
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement() // Parse the block
+
+	if !p.expectPeek(token.CATCH) || !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expression.Variable = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Handler = p.parseBlockStatement() // Parse the handler
+
+	return expression
+}
+
 // parseBlockStatement is a helper function that parses a block statement
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.currentToken} // Create a new block statement
@@ -395,6 +543,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	block.End = p.currentToken
 
 	return block
 }
@@ -433,6 +582,9 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.currentPrecedence() // Get the precedence of the current token
+	if p.currentTokenIs(token.POWER) {
+		precedence-- // ** is right associative, a ** b ** c being a ** (b ** c)
+	}
 
 	p.nextToken() // Advance the current token
 
@@ -455,8 +607,22 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 
 // nextToken is a helper function that advances both currentToken and peekToken
 func (p *Parser) nextToken() {
+	p.previousToken = p.currentToken
 	p.currentToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	if p.peekToken.Type == token.ILLEGAL {
+		p.addLexerErrors()
+	}
+}
+
+// addLexerErrors is a helper function that adds the errors of the lexer
+// not added yet. The ILLEGAL tokens they are about raise no other error.
+func (p *Parser) addLexerErrors() {
+	errors := p.l.Errors()
+	for _, err := range errors[p.lexerErrors:] {
+		p.errors = append(p.errors, diagnostic.Diagnostic{Severity: diagnostic.Error, Message: err.Message, Line: err.Line, Column: err.Column})
+	}
+	p.lexerErrors = len(errors)
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -480,17 +646,33 @@ func (p *Parser) ParseProgram() *ast.Program {
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.currentToken.Type {
 	case token.LET:
+		if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+			return p.parseDestructureStatement() // parseDestructureStatement is a helper function
+		}
 		return p.parseLetStatement() // parseLetStatement is a helper function
+	case token.EXPORT:
+		return p.parseExportStatement() // parseExportStatement is a helper function
 	case token.RETURN:
 		return p.parseReturnStatement() // parseReturnStatement is a helper function
+	case token.WHILE:
+		return p.parseWhileStatement() // parseWhileStatement is a helper function
+	case token.FOR:
+		return p.parseForStatement() // parseForStatement is a helper function
+	case token.IDENT:
+		if p.peekTokenIs(token.ASSIGN) {
+			return p.parseAssignStatement() // parseAssignStatement is a helper function
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement() // parseExpressionStatement is a helper function
 	}
 }
 
-// parseLetStatement is a helper function that parses a let statement
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+// parseLetStatement is a helper function that parses a let statement, or a
+// let statement binding the values of a tuple, let x, y = f();
+func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.currentToken} // Create a new let statement
+	stmt.Doc = p.docComment(p.currentToken.Line)     // Attach the comments above the statement
 
 	// Check if the next token is an identifier
 	if !p.expectPeek(token.IDENT) {
@@ -499,6 +681,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Name = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal} // Set the identifier
 
+	if p.peekTokenIs(token.COMMA) {
+		return p.parseTupleDestructure(stmt.Token, stmt.Name)
+	}
+
 	// Check if the next token is an equal sign
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -512,13 +698,159 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		fl.Name = stmt.Name.Value
 	}
 
-	for !p.currentTokenIs(token.SEMICOLON) {
+	for !p.currentTokenIs(token.SEMICOLON) && !p.currentTokenIs(token.EOF) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseDestructureStatement is a helper function that parses a let statement
+// binding the elements of an array, let [a, b] = pair;, or the fields of a
+// hash, let {x, y} = point;
+func (p *Parser) parseDestructureStatement() ast.Statement {
+	stmt := &ast.DestructureStatement{Token: p.currentToken} // Create a new destructure statement
+
+	p.nextToken() // Advance to the opening bracket or brace
+	end := token.TokenType(token.RBRACKET)
+	if p.currentTokenIs(token.LBRACE) {
+		stmt.Hash = true
+		end = token.RBRACE
+	}
+
+	// Parse the names, separated by commas
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal})
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(end) {
+		return nil
+	}
+
+	return p.parseDestructureValue(stmt)
+}
+
+// parseTupleDestructure is a helper function that parses the rest of a let
+// statement binding the values of a tuple, let x, y = f();, from the comma
+// after the first name on
+func (p *Parser) parseTupleDestructure(tok token.Token, first *ast.Identifier) ast.Statement {
+	stmt := &ast.DestructureStatement{Token: tok, Tuple: true, Names: []*ast.Identifier{first}}
+
+	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal})
+	}
+
+	return p.parseDestructureValue(stmt)
+}
+
+// parseDestructureValue is a helper function that parses the value of a
+// destructuring let statement, from the equal sign on
+func (p *Parser) parseDestructureValue(stmt *ast.DestructureStatement) ast.Statement {
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+
+	p.nextToken() // Advance the current token
+
+	stmt.Value = p.parseExpression(LOWEST) // Parse the expression
+
+	// Check if the next token is a semicolon
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken() // Advance the current token
 	}
 
 	return stmt
 }
 
+// parseExportStatement is a helper function that parses an exported let
+// statement, or a list of names exported, export a, b;
+func (p *Parser) parseExportStatement() ast.Statement {
+	doc := p.docComment(p.currentToken.Line) // The comments above belong to the let statement
+
+	if p.peekTokenIs(token.IDENT) {
+		return p.parseExportList()
+	}
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+
+	let := p.parseLetStatement()
+	if let == nil {
+		return nil
+	}
+	stmt, ok := let.(*ast.LetStatement)
+	if !ok {
+		p.addError(let.(*ast.DestructureStatement).Token, fmt.Sprintf("On line %d, only a let statement binding a single name can be exported", p.currentToken.Line))
+		return nil
+	}
+	stmt.Exported = true
+	stmt.Doc = doc
+
+	return stmt
+}
+
+// parseExportList is a helper function that parses the names of an export
+// statement
+func (p *Parser) parseExportList() ast.Statement {
+	stmt := &ast.ExportStatement{Token: p.currentToken}
+
+	p.nextToken()
+	stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal})
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal})
+	}
+
+	// Check if the next token is a semicolon
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken() // Advance the current token
+	}
+
+	return stmt
+}
+
+// docComment is a helper function that returns the text of the comment lines
+// directly above the given line, without the leading # and one space.
+// Comments trailing the code of an earlier line are not part of it.
+func (p *Parser) docComment(line int) string {
+	comments := p.l.Comments()
+
+	// Find the last comment that ends right above the line
+	end := len(comments) - 1
+	for end >= 0 && comments[end].Line >= line {
+		end--
+	}
+	if end < 0 || comments[end].Line != line-1 || comments[end].Line <= p.previousToken.Line {
+		return ""
+	}
+
+	start := end
+	for start > 0 && comments[start-1].Line == comments[start].Line-1 && comments[start-1].Line > p.previousToken.Line {
+		start--
+	}
+
+	lines := make([]string, 0, end-start+1)
+	for _, comment := range comments[start : end+1] {
+		text := strings.TrimPrefix(comment.Literal, "#")
+		lines = append(lines, strings.TrimPrefix(text, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // parseReturnStatement is a helper function that parses a return statement
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.currentToken} // Create a new return statement
@@ -527,8 +859,125 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	stmt.ReturnValue = p.parseExpression(LOWEST) // Parse the expression
 
-	for !p.currentTokenIs(token.SEMICOLON) {
+	// return a, b; returns a tuple
+	if p.peekTokenIs(token.COMMA) {
+		stmt.ReturnValue = p.parseTupleLiteral(stmt.ReturnValue)
+	}
+
+	for !p.currentTokenIs(token.SEMICOLON) && !p.currentTokenIs(token.EOF) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseTupleLiteral is a helper function that parses the values of a tuple
+// after the first one, from the comma on
+func (p *Parser) parseTupleLiteral(first ast.Expression) *ast.TupleLiteral {
+	tuple := &ast.TupleLiteral{Token: p.peekToken, Elements: []ast.Expression{first}}
+
+	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
+		p.nextToken()
+		tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+	}
+
+	return tuple
+}
+
+// parseAssignStatement is a helper function that parses an assignment to a
+// variable, x = x + 1
+func (p *Parser) parseAssignStatement() *ast.AssignStatement {
+	stmt := &ast.AssignStatement{Token: p.currentToken} // Create a new assign statement
+
+	stmt.Name = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal} // Set the identifier
+
+	p.nextToken() // Advance to the equal sign
+	p.nextToken() // Advance the current token
+
+	stmt.Value = p.parseExpression(LOWEST) // Parse the expression
+
+	// Check if the next token is a semicolon
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken() // Advance the current token
+	}
+
+	return stmt
+}
+
+// parseWhileStatement is a helper function that parses a while statement
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.currentToken} // Create a new while statement
+
+	// Check if the next token is a left parenthesis
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken() // Advance the current token
+
+	stmt.Condition = p.parseExpression(LOWEST) // Parse the condition
+
+	// Check if the next token is a right parenthesis
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	// Check if the next token is a left brace
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement() // Parse the body
+
+	// Check if the next token is a semicolon
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken() // Advance the current token
+	}
+
+	return stmt
+}
+
+// parseForStatement is a helper function that parses a for statement,
+// for (x in iterable) { ... }
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.currentToken} // Create a new for statement
+
+	// Check if the next token is a left parenthesis
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	// Check if the next token is the identifier of the variable
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	// Check if the next token is in
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken() // Advance the current token
+
+	stmt.Iterable = p.parseExpression(LOWEST) // Parse the iterable
+
+	// Check if the next token is a right parenthesis
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	// Check if the next token is a left brace
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement() // Parse the body
+
+	// Check if the next token is a semicolon
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken() // Advance the current token
 	}
 
 	return stmt
@@ -573,8 +1022,11 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
+	if t == token.ILLEGAL {
+		return
+	}
 	msg := fmt.Sprintf("On line %d, no prefix parse function for %s found", p.currentToken.Line, t)
-	p.errors = append(p.errors, msg) // Add an error to the errors slice
+	p.addError(p.currentToken, msg) // Add an error to the errors slice
 }
 
 // parseIntegerLiteral is a helper function that parses an integer literal
@@ -584,7 +1036,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.currentToken.Literal, 0, 64) // Convert the literal to an integer
 	if err != nil {
 		msg := fmt.Sprintf("Syntax error on line %d: could not parse %q as integer", p.currentToken.Line, p.currentToken.Literal)
-		p.errors = append(p.errors, msg) // Add an error to the errors slice
+		p.addError(p.currentToken, msg) // Add an error to the errors slice
 		return nil
 	}
 
@@ -598,6 +1050,11 @@ func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.currentToken, Value: p.currentTokenIs(token.TRUE)} // Create a new boolean
 }
 
+// parseNullLiteral is a helper function that parses the null keyword
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.currentToken} // Create a new null literal
+}
+
 // currentTokenIs is a helper function that checks if the current token is of a certain type
 func (p *Parser) currentTokenIs(t token.TokenType) bool {
 	return p.currentToken.Type == t
@@ -621,15 +1078,24 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
-// Errors is a function that returns the errors
-func (p *Parser) Errors() []string {
+// Errors returns the errors of the lexer and of the parser, with the
+// positions they were raised at
+func (p *Parser) Errors() []diagnostic.Diagnostic {
 	return p.errors
 }
 
 // peekError is a helper function that adds an error to the errors slice
 func (p *Parser) peekError(t token.TokenType) {
+	if p.peekToken.Type == token.ILLEGAL {
+		return
+	}
 	msg := fmt.Sprintf("On line %d, expected next token to be %s, got %s instead", p.currentToken.Line, t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(p.peekToken, msg)
+}
+
+// addError is a helper function that records an error raised at the given token
+func (p *Parser) addError(tok token.Token, msg string) {
+	p.errors = append(p.errors, *diagnostic.At(tok, "%s", msg))
 }
 
 // peekPrecedence is a helper function that returns the precedence of the peek token
@@ -3,7 +3,9 @@ package parser
 import (
 	"fmt"
 	"monkey/ast"
+	"monkey/diagnostic"
 	"monkey/lexer"
+	"reflect"
 	"testing"
 )
 
@@ -17,8 +19,8 @@ func checkParserErrors(t *testing.T, p *Parser) {
 
 	// Print the errors
 	t.Errorf("parser has %d errors", len(errors))
-	for _, msg := range errors {
-		t.Errorf("parser error: %q", msg)
+	for _, err := range errors {
+		t.Errorf("parser error: %q", err.Message)
 	}
 	t.FailNow()
 }
@@ -57,6 +59,85 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedIdentifier string
+		expectedValue      interface{}
+	}{
+		{"x = 5;", "x", 5},
+		{"y = true", "y", true},
+		{"foobar = y;", "foobar", "y"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p) // Check if there are any parser errors
+
+		// Check if the program contains 1 statement
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. Got %d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.AssignStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not *ast.AssignStatement. Got %T", program.Statements[0])
+		}
+
+		if stmt.Name.Value != tt.expectedIdentifier {
+			t.Errorf("stmt.Name.Value not '%s'. Got %s", tt.expectedIdentifier, stmt.Name.Value)
+		}
+
+		if !testLiteralExpression(t, stmt.Value, tt.expectedValue) { // Check if the value is correct
+			return
+		}
+	}
+}
+
+func TestDestructureStatements(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedHash  bool
+		expectedNames []string
+		expected      string
+	}{
+		{"let [a, b] = pair;", false, []string{"a", "b"}, "let [a, b] = pair;"},
+		{"let {x, y} = point", true, []string{"x", "y"}, "let {x, y} = point;"},
+		{"let [first] = f(1);", false, []string{"first"}, "let [first] = f(1);"},
+		{"let q, r = divmod(7, 2);", false, []string{"q", "r"}, "let q, r = divmod(7, 2);"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p) // Check if there are any parser errors
+
+		stmt, ok := program.Statements[0].(*ast.DestructureStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not *ast.DestructureStatement. Got %T", program.Statements[0])
+		}
+		if stmt.Hash != tt.expectedHash || len(stmt.Names) != len(tt.expectedNames) {
+			t.Fatalf("wrong pattern. Got %s", stmt.Pattern())
+		}
+		for i, name := range tt.expectedNames {
+			testIdentifier(t, stmt.Names[i], name)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("stmt.String() wrong. want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+
+	for _, input := range []string{"let [] = a;", "let [a, 1] = b;", "let {a] = b;", "let [a, b];", "let a, = b;", "export let a, b = c;"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
 // testLetStatement is a helper function that checks if the statement is a let statement
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	// Check if the statement is a let statement
@@ -203,6 +284,35 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestPrefixedIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xFF", 255},
+		{"0o755", 493},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0xdead_beef", 0xdeadbeef},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p) // Check if there are any parser errors
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.IntegerLiteral. Got %T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value for %q not %d. Got %d", tt.input, tt.expected, literal.Value)
+		}
+	}
+}
+
 func testIntegerLiteral(t *testing.T, il ast.Expression, value int64) bool {
 	// Type assertion to get the *ast.IntegerLiteral
 	integ, ok := il.(*ast.IntegerLiteral)
@@ -273,8 +383,14 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 - 5;", 5, "-", 5},                  // 5 - 5
 		{"5 * 5;", 5, "*", 5},                  // 5 * 5
 		{"5 / 5;", 5, "/", 5},                  // 5 / 5
+		{"5 // 5;", 5, "//", 5},                // 5 // 5
+		{"5 ** 5;", 5, "**", 5},                // 5 ** 5
 		{"5 > 5;", 5, ">", 5},                  // 5 > 5
 		{"5 < 5;", 5, "<", 5},                  // 5 < 5
+		{"5 >= 5;", 5, ">=", 5},                // 5 >= 5
+		{"5 <= 5;", 5, "<=", 5},                // 5 <= 5
+		{"true && false;", true, "&&", false},  // true && false
+		{"true || false;", true, "||", false},  // true || false
 		{"5 == 5;", 5, "==", 5},                // 5 == 5
 		{"5 != 5;", 5, "!=", 5},                // 5 != 5
 		{"true == true", true, "==", true},     // true == true
@@ -315,6 +431,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"a + b - c", "((a + b) - c)"},                                           // a + b - c
 		{"a * b * c", "((a * b) * c)"},                                           // a * b * c
 		{"a * b / c", "((a * b) / c)"},                                           // a * b / c
+		{"a + b // c * d", "(a + ((b // c) * d))"},                               // a + b // c * d
+		{"a + 1 <= b == c >= d", "(((a + 1) <= b) == (c >= d))"},                 // a + 1 <= b == c >= d
+		{"a || b && c == d", "(a || (b && (c == d)))"},                           // a || b && c == d
+		{"a && b || !c", "((a && b) || (!c))"},                                   // a && b || !c
 		{"a + b / c", "(a + (b / c))"},                                           // a + b / c
 		{"a + b * c + d / e - f", "(((a + (b * c)) + (d / e)) - f)"},             // a + b * c + d / e - f
 		{"3 + 4; -5 * 5", "(3 + 4)((-5) * 5)"},                                   // 3 + 4; -5 * 5
@@ -335,6 +455,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"add(a + b + c * d / f + g)", "add((((a + b) + ((c * d) / f)) + g))"},                           // add(a + b + c * d / f + g)
 		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},                           // a * [1, 2, 3, 4][b * c] * d
 		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},           // add(a * b[2], b[1], 2 * [1, 2][1])
+		{"-a.b.c * d", "((-((a.b).c)) * d)"},                                                             // -a.b.c * d
+		{"a.b[c].d(e)", "((a.b)[c]).d(e)"},                                                               // a.b[c].d(e)
+		{"-a.b(c).d", "(-(a.b(c).d))"},                                                                   // -a.b(c).d
+		{"s[i + 1:len(s)][0]", "((s[(i + 1):len(s)])[0])"},                                               // s[i + 1:len(s)][0]
+		{"a ** b ** c", "(a ** (b ** c))"},                                                               // a ** b ** c
+		{"-a ** b * c", "((-(a ** b)) * c)"},                                                             // -a ** b * c
+		{"a ** -b", "(a ** (-b))"},                                                                       // a ** -b
+		{"a.b ** c[0]", "((a.b) ** (c[0]))"},                                                             // a.b ** c[0]
 	}
 
 	// Loop through the tests and check if the infix expression is correct
@@ -462,6 +590,67 @@ func TestBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestNullLiteral(t *testing.T) {
+	p := New(lexer.New("let x = null;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	if _, ok := stmt.Value.(*ast.NullLiteral); !ok {
+		t.Fatalf("stmt.Value is not *ast.NullLiteral. Got %T", stmt.Value)
+	}
+	if stmt.String() != "let x = null;" {
+		t.Errorf("stmt.String() wrong. Got %q", stmt.String())
+	}
+}
+
+func TestReturnTuple(t *testing.T) {
+	p := New(lexer.New("return a, b + 1, f(c, d);"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	stmt := program.Statements[0].(*ast.ReturnStatement)
+	tuple, ok := stmt.ReturnValue.(*ast.TupleLiteral)
+	if !ok {
+		t.Fatalf("stmt.ReturnValue is not *ast.TupleLiteral. Got %T", stmt.ReturnValue)
+	}
+	if len(tuple.Elements) != 3 {
+		t.Fatalf("wrong number of elements. Got %d", len(tuple.Elements))
+	}
+	if stmt.String() != "return a, (b + 1), f(c, d);" {
+		t.Errorf("stmt.String() wrong. Got %q", stmt.String())
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	p := New(lexer.New("let x = try { f(1) } catch (err) { err };"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	exp, ok := stmt.Value.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Value is not *ast.TryExpression. Got %T", stmt.Value)
+	}
+	if exp.Variable.Value != "err" {
+		t.Errorf("exp.Variable is not 'err'. Got %s", exp.Variable.Value)
+	}
+	if len(exp.Block.Statements) != 1 || len(exp.Handler.Statements) != 1 {
+		t.Fatalf("wrong number of statements. Got block=%d, handler=%d", len(exp.Block.Statements), len(exp.Handler.Statements))
+	}
+	if stmt.String() != "let x = try f(1) catch (err) err;" {
+		t.Errorf("stmt.String() wrong. Got %q", stmt.String())
+	}
+
+	for _, input := range []string{"try { 1 }", "try { 1 } catch { 2 }", "try { 1 } catch (1) { 2 }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
 func TestIfExpression(t *testing.T) {
 	input := `if (x < y) { x }`
 
@@ -513,6 +702,75 @@ func TestIfExpression(t *testing.T) {
 
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x }; y`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	// Check if the program contains 2 statements
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. Got %d", len(program.Statements))
+	}
+
+	// Type assertion to get the *ast.WhileStatement
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.WhileStatement. Got %T", program.Statements[0])
+	}
+
+	// Check if the condition is correct
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	// Check if the body is correct
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statement. Got %d", len(stmt.Body.Statements))
+	}
+
+	body, ok := stmt.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.ExpressionStatement. Got %T", stmt.Body.Statements[0])
+	}
+
+	if !testIdentifier(t, body.Expression, "x") { // Check if the identifier is correct
+		return
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { puts(x); }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	// Check if the program contains 1 statement
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. Got %d", len(program.Statements))
+	}
+
+	// Type assertion to get the *ast.ForStatement
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.ForStatement. Got %T", program.Statements[0])
+	}
+
+	if stmt.Variable.Value != "x" {
+		t.Errorf("stmt.Variable is not x. Got %s", stmt.Variable.Value)
+	}
+	if stmt.Iterable.String() != "[1, 2]" {
+		t.Errorf("stmt.Iterable is not [1, 2]. Got %s", stmt.Iterable.String())
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Errorf("body is not 1 statement. Got %d", len(stmt.Body.Statements))
+	}
+}
+
 func TestIfElseExpression(t *testing.T) {
 	input := `if (x < y) { x } else { y }`
 
@@ -824,6 +1082,82 @@ func TestIndexExpressionParsing(t *testing.T) {
 	}
 }
 
+func TestSliceExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"arr[1:3]", "(arr[1:3])"},
+		{"arr[:2]", "(arr[:2])"},
+		{"arr[2:]", "(arr[2:])"},
+		{"arr[:]", "(arr[:])"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p) // Check if there are any parser errors
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+		if _, ok := stmt.Expression.(*ast.SliceExpression); !ok {
+			t.Errorf("stmt.Expression is not *ast.SliceExpression. Got %T", stmt.Expression)
+		}
+	}
+}
+
+func TestMemberExpressionParsing(t *testing.T) {
+	p := New(lexer.New("config.host;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	member, ok := stmt.Expression.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not *ast.MemberExpression. Got %T", stmt.Expression)
+	}
+	if !testIdentifier(t, member.Left, "config") {
+		return
+	}
+	if member.Field != "host" {
+		t.Errorf("member.Field not %q. Got %q", "host", member.Field)
+	}
+
+	// The field must be a name
+	for _, input := range []string{"config.", "config.1", "config.if"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestMemberCallExpressionParsing(t *testing.T) {
+	p := New(lexer.New("xs.push(1, 2 * 3);"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	call, ok := stmt.Expression.(*ast.MemberCallExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not *ast.MemberCallExpression. Got %T", stmt.Expression)
+	}
+	if !testIdentifier(t, call.Receiver, "xs") {
+		return
+	}
+	if call.Method != "push" {
+		t.Errorf("call.Method not %q. Got %q", "push", call.Method)
+	}
+	if len(call.Arguments) != 2 {
+		t.Fatalf("wrong length of arguments. Got %d", len(call.Arguments))
+	}
+	testLiteralExpression(t, call.Arguments[0], 1)
+	testInfixExpression(t, call.Arguments[1], 2, "*", 3)
+}
+
 // Test Hash Literal Parsing
 func TestHashLiteralParsing(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
@@ -950,6 +1284,37 @@ func TestFunctionLiteralWithName(t *testing.T) {
 	}
 }
 
+// TestLetStatementDoc tests that comments directly above a let statement become its doc
+func TestLetStatementDoc(t *testing.T) {
+	input := `# Adds two numbers.
+#
+# Works on floats too.
+let add = fn(a, b) { a + b };
+let x = 1; # trailing
+
+let y = 2;
+# detached
+
+let z = 3;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	expected := []string{"Adds two numbers.\n\nWorks on floats too.", "", "", ""}
+	if len(program.Statements) != len(expected) {
+		t.Fatalf("program.Statements does not contain %d statements. Got=%d", len(expected), len(program.Statements))
+	}
+
+	for i, doc := range expected {
+		stmt := program.Statements[i].(*ast.LetStatement)
+		if stmt.Doc != doc {
+			t.Errorf("statement %d doc wrong. Want %q, Got=%q", i, doc, stmt.Doc)
+		}
+	}
+}
+
 // TestImportLiteral which is a test case to import "file.mky" as a module
 func TestImportLiteral(t *testing.T) {
 	input := `import "file.mky";`
@@ -982,6 +1347,121 @@ func TestImportLiteral(t *testing.T) {
 	}
 }
 
+func TestExportStatement(t *testing.T) {
+	input := "# Doubles x.\nexport let double = fn(x) { x * 2 };\nlet y = 1;"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. Got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.LetStatement. Got=%T", program.Statements[0])
+	}
+	if !stmt.Exported || stmt.Name.Value != "double" || stmt.Doc != "Doubles x." {
+		t.Errorf("wrong export statement. got=%+v", stmt)
+	}
+	if program.Statements[1].(*ast.LetStatement).Exported {
+		t.Errorf("let statement without export is exported")
+	}
+
+	exports := program.Exports()
+	if len(exports) != 1 || !exports["double"] {
+		t.Errorf("wrong exports. got=%v", exports)
+	}
+
+	p = New(lexer.New("export 5;"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for export without let")
+	}
+
+	p = New(lexer.New("let a = 1; let [b, c] = [2, 3];\nexport a, c;"))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+
+	list, ok := program.Statements[2].(*ast.ExportStatement)
+	if !ok || list.String() != "export a, c;" {
+		t.Fatalf("program.Statements[2] is not an export list. Got=%+v", program.Statements[2])
+	}
+	exports = program.Exports()
+	if len(exports) != 2 || !exports["a"] || !exports["c"] {
+		t.Errorf("wrong exports. got=%v", exports)
+	}
+
+	p = New(lexer.New("export a,;"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for an export list ending in a comma")
+	}
+}
+
+func TestImportAlias(t *testing.T) {
+	p := New(lexer.New(`import "math.mky" as math; let as = 1;`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p) // Check if there are any parser errors
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	imp, ok := stmt.Expression.(*ast.ImportLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not *ast.ImportLiteral. Got=%T", stmt.Expression)
+	}
+	if imp.Path != "math.mky" || imp.Alias == nil || imp.Alias.Value != "math" {
+		t.Errorf("wrong import. Got path=%q alias=%v", imp.Path, imp.Alias)
+	}
+	if len(program.Statements) != 2 {
+		t.Errorf("as is not usable as a name. Got %d statements", len(program.Statements))
+	}
+
+	p = New(lexer.New(`import "math.mky" as 1;`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for an import aliased to a number")
+	}
+}
+
+func TestImportChecksum(t *testing.T) {
+	checksum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	input := `import "https://example.com/lib.mky" sha256:` + checksum + `;`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	imp := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ImportLiteral)
+	if imp.Path != "https://example.com/lib.mky" || imp.Checksum != checksum {
+		t.Errorf("wrong import. got=%+v", imp)
+	}
+
+	// Checksums starting like a number literal are not malformed numbers
+	p = New(lexer.New(`import "lib.mky" sha256:0b8` + checksum[3:] + `;`))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	p = New(lexer.New(`import "lib.mky" sha256:0b8;`))
+	p.ParseProgram()
+	expected := "On line 1, invalid checksum sha256:0b8, expected sha256: and 64 lowercase hex digits"
+	if errors := p.Errors(); len(errors) != 1 || errors[0].Message != expected {
+		t.Errorf("wrong errors. want=%q, got=%v", expected, errors)
+	}
+
+	for _, input := range []string{
+		`import "lib.mky" sha256:abc;`,
+		`import "lib.mky" sha256: ` + checksum + `;`,
+		`import "lib.mky" sha1:` + checksum + `;`,
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
 func TestFloatLiteralExpression(t *testing.T) {
 	input := "5.12;"
 
@@ -1037,3 +1517,81 @@ func TestTensorLiteralExpression(t *testing.T) {
 	// need more things to check
 
 }
+
+func TestNestedTensorLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		shape    string
+		expected string
+	}{
+		{"@[[1.0, 2.0], [3.0, 4.0]]", "", "[[1.0, 2.0], [3.0, 4.0]]"},
+		{"@[1, 2]", "", "[1, 2]"},
+		{"@[[1.0, 2.0]] + x", "", "[[1.0, 2.0]]"},
+		{"@[2], [1.0, 2.0]", "[2]", "[1.0, 2.0]"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+		if infix, ok := exp.(*ast.InfixExpression); ok {
+			exp = infix.Left
+		}
+		lit, ok := exp.(*ast.TensorLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.TensorLiteral. Got %T", exp)
+		}
+		shape := ""
+		if lit.Shape != nil {
+			shape = lit.Shape.String()
+		}
+		if shape != tt.shape || lit.Data.String() != tt.expected {
+			t.Errorf("wrong literal for %q. want shape %q and data %q, got %q and %q", tt.input, tt.shape, tt.expected, shape, lit.Data.String())
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []diagnostic.Diagnostic
+	}{
+		{"let a = 1;\nlet b = é + 2;", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 2, illegal character 'é' at 2:9", Line: 2, Column: 9}}},
+		{"let a = 1;\nlet b = a $ 2;", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 2, illegal character '$' at 2:11", Line: 2, Column: 11}}},
+		{"puts(1);\n\nlet s = \"abc", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 3, unterminated string literal starting at 3:9", Line: 3, Column: 9}}},
+		{"return \"abc", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 1, unterminated string literal starting at 1:8", Line: 1, Column: 8}}},
+		{"let v = 1.2.3;", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 1, malformed number literal 1.2.3 at 1:9: more than one decimal point", Line: 1, Column: 9}}},
+		{"let v = [1, 2.];", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 1, malformed number literal 2. at 1:13: expected a digit after the decimal point", Line: 1, Column: 13}}},
+		{"let v = .5 + 1;", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 1, malformed number literal .5 at 1:9: expected a digit before the decimal point", Line: 1, Column: 9}}},
+		{"let v = 09;", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 1, malformed number literal 09 at 1:9: leading zero in a decimal integer, write 0o for an octal one", Line: 1, Column: 9}}},
+		{"let v = 1 + 0xFFFFFFFFFFFFFFFFFF;", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 1, malformed number literal 0xFFFFFFFFFFFFFFFFFF at 1:13: value out of range for int64", Line: 1, Column: 13}}},
+		{"let v = 9223372036854775808;", []diagnostic.Diagnostic{{Severity: diagnostic.Error, Message: "On line 1, malformed number literal 9223372036854775808 at 1:9: value out of range for int64", Line: 1, Column: 9}}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if !reflect.DeepEqual(p.Errors(), tt.expected) {
+			t.Errorf("wrong errors for %q. want=%v, got=%v", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestErrorSpans(t *testing.T) {
+	p := New(lexer.New("let x = 5;\nlet = 10;"))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected an error")
+	}
+	err := errors[0]
+	if err.Severity != diagnostic.Error || err.Line != 2 || err.Column != 5 {
+		t.Errorf("wrong error. got=%s", err)
+	}
+	if err.End != (diagnostic.Position{Line: 2, Column: 6}) {
+		t.Errorf("wrong end. want=2:6, got=%+v", err.End)
+	}
+}
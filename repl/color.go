@@ -0,0 +1,159 @@
+package repl
+
+import (
+	"io"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/token"
+	"os"
+	"strings"
+)
+
+// ANSI escape sequences used by the REPL
+const (
+	colorReset   = "\x1b[0m"
+	colorBold    = "\x1b[1m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorBlue    = "\x1b[34m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+	colorGray    = "\x1b[90m"
+)
+
+// IsTerminal reports whether the given file is attached to a terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ColorSupported reports whether colored output should be used when writing to f.
+// The NO_COLOR environment variable (https://no-color.org) always disables it.
+func ColorSupported(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return IsTerminal(f)
+}
+
+// colorizer wraps text in ANSI colors when enabled and leaves it untouched otherwise
+type colorizer struct {
+	enabled bool
+}
+
+// paint wraps s in the given color
+func (c colorizer) paint(color, s string) string {
+	if !c.enabled || color == "" || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// prompt returns the REPL prompt
+func (c colorizer) prompt() string {
+	return c.paint(colorBold+colorBlue, PROMPT)
+}
+
+// error returns an error message painted red
+func (c colorizer) error(s string) string {
+	return c.paint(colorRed, s)
+}
+
+// value returns the inspected object painted according to its type
+func (c colorizer) value(obj object.Object) string {
+	return c.paint(typeColor(obj.Type()), obj.Inspect())
+}
+
+// typeColor returns the color used to print values of the given type
+func typeColor(t object.ObjectType) string {
+	switch t {
+	case object.INTEGER_OBJ, object.FLOAT_OBJ:
+		return colorCyan
+	case object.STRING_OBJ:
+		return colorGreen
+	case object.BOOLEAN_OBJ:
+		return colorYellow
+	case object.NULL_OBJ:
+		return colorGray
+	case object.ERROR_OBJ:
+		return colorRed
+	case object.FUNCTION_OBJ, object.CLOSURE_OBJ, object.COMPILED_FUNCTION_OBJ,
+		object.BUILTIN_OBJ, object.EXTENDED_OBJ:
+		return colorMagenta
+	case object.ARRAY_OBJ, object.HASH_OBJ, object.TENSOR_OBJ, object.TUPLE_OBJ:
+		return colorBlue
+	default:
+		return ""
+	}
+}
+
+// highlight returns a single line of source with its tokens colored
+func (c colorizer) highlight(line string) string {
+	if !c.enabled {
+		return line
+	}
+
+	var out strings.Builder
+	offset := 0
+
+	l := lexer.New(line)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		start := tok.Column - 1
+		end := start + len(tok.Literal)
+		if tok.Type == token.STRING {
+			end += 2 // the surrounding quotes are not part of the literal
+		}
+		if start < offset || end > len(line) {
+			break
+		}
+
+		out.WriteString(line[offset:start])
+		out.WriteString(c.paint(tokenColor(tok), line[start:end]))
+		offset = end
+	}
+	out.WriteString(line[offset:])
+
+	return out.String()
+}
+
+// tokenColor returns the color used to highlight the given token
+func tokenColor(tok token.Token) string {
+	switch tok.Type {
+	case token.INT, token.FLOAT:
+		return colorCyan
+	case token.STRING:
+		return colorGreen
+	case token.TRUE, token.FALSE:
+		return colorYellow
+	case token.ILLEGAL:
+		return colorRed
+	case token.IDENT:
+		return ""
+	}
+	if token.LookupIdent(tok.Literal) != token.IDENT {
+		return colorMagenta
+	}
+	return ""
+}
+
+// writeCaret writes the offending source line followed by a caret under the given column
+func (c colorizer) writeCaret(out io.Writer, line string, column int) {
+	if column < 1 || column > len(line)+1 {
+		return
+	}
+
+	// Keep tabs so the caret lines up with the source above it
+	padding := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, line[:column-1])
+
+	io.WriteString(out, "\t"+c.highlight(line)+"\n")
+	io.WriteString(out, "\t"+padding+c.error("^")+"\n")
+}
@@ -0,0 +1,124 @@
+package repl
+
+import (
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		enabled  bool
+		line     string
+		expected string
+	}{
+		{false, `let x = "a" + 1;`, `let x = "a" + 1;`},
+		{true, `let x = "a" + 1;`, colorMagenta + "let" + colorReset + " x = " + colorGreen + `"a"` + colorReset + " + " + colorCyan + "1" + colorReset + ";"},
+		{true, "if (true) { 2.5 }", colorMagenta + "if" + colorReset + " (" + colorYellow + "true" + colorReset + ") { " + colorCyan + "2.5" + colorReset + " }"},
+		{true, "x $ y", "x " + colorRed + "$" + colorReset + " y"},
+		// A string running past the line is left as it is
+		{true, `puts("abc`, `puts(` + colorRed + `"abc` + colorReset},
+	}
+
+	for _, tt := range tests {
+		if got := (colorizer{enabled: tt.enabled}).highlight(tt.line); got != tt.expected {
+			t.Errorf("wrong highlight of %q. want=%q, got=%q", tt.line, tt.expected, got)
+		}
+	}
+}
+
+func TestWriteCaret(t *testing.T) {
+	tests := []struct {
+		enabled  bool
+		line     string
+		column   int
+		expected string
+	}{
+		{false, "let x = ;", 9, "\tlet x = ;\n\t        ^\n"},
+		{false, "let x = 1", 10, "\tlet x = 1\n\t         ^\n"},
+		{false, "\tlet = 1;", 6, "\t\tlet = 1;\n\t\t    ^\n"},
+		{true, "x", 1, "\tx\n\t" + colorRed + "^" + colorReset + "\n"},
+		// Columns outside of the line have no caret
+		{false, "let x = 1;", 0, ""},
+		{false, "let x = 1;", 12, ""},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		(colorizer{enabled: tt.enabled}).writeCaret(&out, tt.line, tt.column)
+		if out.String() != tt.expected {
+			t.Errorf("wrong caret of %q at %d. want=%q, got=%q", tt.line, tt.column, tt.expected, out.String())
+		}
+	}
+}
+
+func TestColorizer(t *testing.T) {
+	tests := []struct {
+		enabled  bool
+		obj      object.Object
+		expected string
+	}{
+		{false, &object.Integer{Value: 1}, "1"},
+		{true, &object.Integer{Value: 1}, colorCyan + "1" + colorReset},
+		{true, &object.String{Value: "a"}, colorGreen + "a" + colorReset},
+		{true, &object.Null{}, colorGray + "null" + colorReset},
+		{true, &object.Error{Message: "boom"}, colorRed + "ERROR: boom" + colorReset},
+	}
+
+	for _, tt := range tests {
+		if got := (colorizer{enabled: tt.enabled}).value(tt.obj); got != tt.expected {
+			t.Errorf("wrong value of %s. want=%q, got=%q", tt.obj.Inspect(), tt.expected, got)
+		}
+	}
+
+	if prompt := (colorizer{}).prompt(); prompt != PROMPT {
+		t.Errorf("wrong plain prompt %q", prompt)
+	}
+	if prompt := (colorizer{enabled: true}).prompt(); prompt != colorBold+colorBlue+PROMPT+colorReset {
+		t.Errorf("wrong colored prompt %q", prompt)
+	}
+}
+
+func TestColorSupported(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if IsTerminal(f) || ColorSupported(f) {
+		t.Errorf("a file is not a terminal")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if IsTerminal(w) {
+		t.Errorf("a pipe is not a terminal")
+	}
+
+	// Files that cannot be examined are not terminals
+	closed, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	if IsTerminal(closed) {
+		t.Errorf("a closed file is not a terminal")
+	}
+
+	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+		defer tty.Close()
+		if !IsTerminal(tty) {
+			t.Errorf("/dev/tty is a terminal")
+		}
+		t.Setenv("NO_COLOR", "")
+		if ColorSupported(tty) {
+			t.Errorf("NO_COLOR set, even empty, disables colors")
+		}
+	}
+}
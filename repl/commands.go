@@ -0,0 +1,254 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"monkey/doc"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/vm"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command is a REPL command invoked as :name args
+type command struct {
+	usage       string
+	description string
+	run         func(s *session, args string)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"doc": {
+			usage:       ":doc <name>",
+			description: "show the documentation of a builtin or of a definition",
+			run:         (*session).docCommand,
+		},
+		"engine": {
+			usage:       ":engine [eval|vm]",
+			description: "switch the engine, migrating the global bindings",
+			run:         (*session).engineCommand,
+		},
+		"help": {
+			usage:       ":help",
+			description: "list the available commands",
+			run:         (*session).helpCommand,
+		},
+		"plugins": {
+			usage:       ":plugins",
+			description: "list the plugins loaded with their versions and functions",
+			run:         (*session).pluginsCommand,
+		},
+		"trace": {
+			usage:       ":trace on|off",
+			description: "print every opcode the VM executes along with the stack depth",
+			run:         (*session).traceCommand,
+		},
+		"time": {
+			usage:       ":time [-n N] <expr>",
+			description: "run <expr> N times in both engines and report time and allocations",
+			run:         (*session).timeCommand,
+		},
+	}
+}
+
+// runCommand dispatches a :command line to its handler
+func (s *session) runCommand(line string) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), ":")
+	name, args, _ := strings.Cut(line, " ")
+
+	cmd, ok := commands[name]
+	if !ok {
+		s.printError(fmt.Sprintf("unknown command :%s, type :help for a list of commands", name))
+		return
+	}
+	cmd.run(s, strings.TrimSpace(args))
+}
+
+// helpCommand lists the available commands
+func (s *session) helpCommand(args string) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(s.out, "  %-24s %s\n", commands[name].usage, commands[name].description)
+	}
+}
+
+// pluginsCommand lists the plugins loaded
+func (s *session) pluginsCommand(args string) {
+	plugins := object.LoadedPlugins()
+	if len(plugins) == 0 {
+		fmt.Fprintln(s.out, "no plugins loaded")
+		return
+	}
+
+	for _, plugin := range plugins {
+		name := plugin.File
+		if plugin.Version != "" {
+			name += " " + plugin.Version
+		}
+		fmt.Fprintf(s.out, "  %s: %s\n", name, strings.Join(plugin.Functions, ", "))
+	}
+}
+
+// timing is the outcome of running a piece of code a number of times
+type timing struct {
+	runs    int
+	elapsed time.Duration
+	allocs  uint64
+	bytes   uint64
+	err     error
+}
+
+// measure calls fn runs times and records the wall time and heap allocations
+func measure(runs int, fn func() error) timing {
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	for i := 0; i < runs; i++ {
+		if err := fn(); err != nil {
+			return timing{err: err}
+		}
+	}
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return timing{
+		runs:    runs,
+		elapsed: elapsed,
+		allocs:  after.Mallocs - before.Mallocs,
+		bytes:   after.TotalAlloc - before.TotalAlloc,
+	}
+}
+
+// String formats the timing as a single report line
+func (t timing) String() string {
+	if t.err != nil {
+		return "error: " + t.err.Error()
+	}
+
+	n := uint64(t.runs)
+	return fmt.Sprintf("%d runs in %s (%s/run), %d allocs/run, %d B/run",
+		t.runs, t.elapsed, t.elapsed/time.Duration(t.runs), t.allocs/n, t.bytes/n)
+}
+
+// timeCommand runs an expression in both engines and reports wall time and
+// allocations. Bindings are synced between the engines first, and parsing and
+// compiling happen once and are not measured.
+func (s *session) timeCommand(args string) {
+	runs := 1
+	if strings.HasPrefix(args, "-n ") {
+		count, expr, _ := strings.Cut(strings.TrimSpace(args[3:]), " ")
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			s.printError(fmt.Sprintf("invalid run count %q", count))
+			return
+		}
+		runs, args = n, strings.TrimSpace(expr)
+	}
+
+	if args == "" {
+		s.printError("usage: " + commands["time"].usage)
+		return
+	}
+
+	program, ok := s.parse(args)
+	if !ok {
+		return
+	}
+
+	var evaluated, compiled timing
+
+	if err := s.sync(engineEvaluator); err != nil {
+		evaluated = timing{err: err}
+	} else {
+		evaluated = measure(runs, func() error {
+			result := evaluator.Eval(program, s.env)
+			if errObj, ok := result.(*object.Error); ok {
+				return fmt.Errorf("%s", errObj.Message)
+			}
+			return nil
+		})
+	}
+
+	if err := s.sync(engineVM); err != nil {
+		compiled = timing{err: err}
+	} else if code, err := s.compile(program); err != nil {
+		compiled = timing{err: err}
+	} else {
+		compiled = measure(runs, func() error {
+			machine := vm.NewWithGlobalsStore(code, s.globals)
+			machine.SetOutput(s.out)
+			return machine.Run()
+		})
+	}
+
+	s.recordDefinitions(program)
+
+	io.WriteString(s.out, fmt.Sprintf("evaluator: %s\n", evaluated))
+	io.WriteString(s.out, fmt.Sprintf("compiler:  %s\n", compiled))
+}
+
+// traceCommand toggles opcode tracing for input run on the VM
+func (s *session) traceCommand(args string) {
+	switch args {
+	case "on":
+		s.trace = true
+	case "off":
+		s.trace = false
+	case "":
+	default:
+		s.printError("usage: " + commands["trace"].usage)
+		return
+	}
+
+	state := "off"
+	if s.trace {
+		state = "on"
+	}
+	fmt.Fprintf(s.out, "tracing is %s\n", state)
+	if s.trace && s.engine != engineVM {
+		io.WriteString(s.out, "note: tracing only applies to the vm engine\n")
+	}
+}
+
+// traceInstruction prints an instruction before the VM executes it,
+// indented by the depth of the call stack
+func (s *session) traceInstruction(ev vm.InstructionEvent) {
+	indent := strings.Repeat("  ", ev.FrameDepth-1)
+	line := fmt.Sprintf("%s%04d %-24s stack=%d", indent, ev.IP, ev.Instructions.Disassemble(ev.IP), ev.StackDepth)
+	io.WriteString(s.out, s.color.paint(colorGray, line)+"\n")
+}
+
+// docCommand shows the documentation of a builtin or a top-level definition
+func (s *session) docCommand(args string) {
+	if args == "" {
+		s.printError("usage: " + commands["doc"].usage)
+		return
+	}
+
+	if let := s.lastDefinition(args); let != nil {
+		io.WriteString(s.out, doc.Text(doc.FromLet(let))+"\n")
+		return
+	}
+	if builtin := object.GetBuiltInByName(args); builtin != nil {
+		io.WriteString(s.out, doc.Text(doc.FromBuiltin(args, builtin))+"\n")
+		return
+	}
+
+	s.printError(fmt.Sprintf("no documentation for %s", args))
+}
@@ -0,0 +1,65 @@
+package repl
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTimeCommand(t *testing.T) {
+	report := `(\d+) runs in [0-9.]+[µnm]?s \([0-9.]+[µnm]?s/run\), \d+ allocs/run, \d+ B/run`
+	timed := regexp.MustCompile(`^evaluator: ` + report + `\ncompiler:  ` + report + `\n$`)
+
+	tests := []struct {
+		args string
+		runs string
+	}{
+		{"1 + 2", "1"},
+		{"-n 3 1 + 2", "3"},
+		{"-n 2 let x = 1; x * 2", "2"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		newSession(strings.NewReader(""), &out, engineEvaluator, Options{}).runCommand(":time " + tt.args)
+
+		m := timed.FindStringSubmatch(out.String())
+		if m == nil {
+			t.Errorf("wrong report of :time %s: %q", tt.args, out.String())
+			continue
+		}
+		if m[1] != tt.runs || m[2] != tt.runs {
+			t.Errorf("wrong run count of :time %s. want=%s, got=%s and %s", tt.args, tt.runs, m[1], m[2])
+		}
+	}
+
+	// Errors are reported in place of the timing of the engine
+	var out bytes.Buffer
+	newSession(strings.NewReader(""), &out, engineEvaluator, Options{}).runCommand(":time -n 2 missing")
+	if !strings.HasPrefix(out.String(), "evaluator: error: identifier not found: missing\ncompiler:  error: ") {
+		t.Errorf("wrong report of an error: %q", out.String())
+	}
+
+	for args, expected := range map[string]string{
+		"":         "usage: :time [-n N] <expr>\n",
+		"-n 2":     "usage: :time [-n N] <expr>\n",
+		"-n 0 1":   "invalid run count \"0\"\n",
+		"-n -1 1":  "invalid run count \"-1\"\n",
+		"-n two 1": "invalid run count \"two\"\n",
+		"-n 3 fn(": "",
+	} {
+		var out bytes.Buffer
+		newSession(strings.NewReader(""), &out, engineEvaluator, Options{}).runCommand(":time " + args)
+		if expected == "" {
+			// Parse errors are reported without running anything
+			if out.Len() == 0 || strings.Contains(out.String(), "runs in") {
+				t.Errorf(":time %s ran: %q", args, out.String())
+			}
+			continue
+		}
+		if out.String() != expected {
+			t.Errorf("wrong output of :time %s. want=%q, got=%q", args, expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"monkey/compiler"
+	"monkey/debugger"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/vm"
+	"os"
+)
+
+// DebugScript runs a script under the interactive debugger with the given
+// engine, reading debugger commands from in and writing the debugger's output
+// to out. Errors are reported like RunFile does; quitting the debugger is
+// not an error.
+func DebugScript(filename, engine string, in io.Reader, out, errOut io.Writer, opts Options) (err error) {
+	color := colorizer{enabled: opts.Color}
+
+	program, err := ParseScript(filename, errOut, opts)
+	if err != nil {
+		return err
+	}
+	source, _ := os.ReadFile(filename)
+	d := debugger.New(filename, string(source), in, out)
+
+	defer recoverScript(&err, filename, engine, errOut, color)
+
+	switch engine {
+	case engineVM:
+		symbolTable := compiler.NewSymbolTable()
+		for i, v := range object.Builtins {
+			symbolTable.DefineBuiltin(i, v.Name)
+		}
+		comp := compiler.NewWithState(symbolTable, []object.Object{})
+		comp.SetResolver(opts.Imports)
+		if err := comp.Compile(program); err != nil {
+			return reportError(errOut, color, ExitCompileError, filename, compileErrorLine(err), "compilation failed: "+compileErrorMessage(err, filename), nil)
+		}
+
+		err := d.RunVM(comp.Bytecode(), symbolTable)
+		if rtErr, ok := err.(*vm.RuntimeError); ok {
+			return reportError(errOut, color, ExitRuntimeError, filename, rtErr.Line, rtErr.Message, rtErr.Stack)
+		}
+		if err != nil && err != debugger.ErrQuit {
+			return reportError(errOut, color, ExitRuntimeError, filename, 0, err.Error(), nil)
+		}
+		return nil
+
+	case engineEvaluator:
+		env := object.NewEnvironment()
+		env.SetContext(&object.Context{Engine: evaluator.Engine, Imports: opts.Imports})
+		result, err := d.RunEvaluator(program, env)
+		if err == debugger.ErrQuit {
+			return nil
+		}
+		if errObj, ok := result.(*object.Error); ok {
+			return reportEvalError(errOut, color, filename, errObj)
+		}
+		return nil
+
+	default:
+		return &ScriptError{Code: ExitUsage, Message: fmt.Sprintf("unknown engine %q", engine)}
+	}
+}
@@ -0,0 +1,194 @@
+package repl
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/token"
+	"sort"
+)
+
+// engineCommand switches the engine used to execute input, carrying the
+// global bindings over to the new engine
+func (s *session) engineCommand(args string) {
+	switch args {
+	case "":
+		fmt.Fprintf(s.out, "using the %s engine\n", s.engine)
+		return
+	case engineEvaluator, engineVM:
+	default:
+		s.printError("usage: " + commands["engine"].usage)
+		return
+	}
+
+	if args == s.engine {
+		fmt.Fprintf(s.out, "already using the %s engine\n", s.engine)
+		return
+	}
+
+	migrated, err := s.migrate(args)
+	if err != nil {
+		s.printError(fmt.Sprintf("%s, still using the %s engine", err, s.engine))
+		return
+	}
+	s.engine = args
+	fmt.Fprintf(s.out, "switched to the %s engine, %d bindings migrated\n", s.engine, migrated)
+}
+
+// sync brings the global bindings of target up to date with the active engine
+func (s *session) sync(target string) error {
+	if target == s.engine {
+		return nil
+	}
+	_, err := s.migrate(target)
+	return err
+}
+
+// migrate copies every global binding of the active engine into target and
+// returns how many bindings were changed. Plain values are shared as is since
+// both engines use the same objects; functions are recreated in the target
+// engine from their definition.
+func (s *session) migrate(target string) (int, error) {
+	count := 0
+
+	for _, name := range s.globalNames(s.engine) {
+		value, ok := s.lookup(s.engine, name)
+		if !ok {
+			continue
+		}
+
+		current, _ := s.lookup(target, name)
+		if current == value || (current != nil && s.twins[value] == current) {
+			continue
+		}
+
+		if !isFunction(value) {
+			s.bind(target, name, value)
+			count++
+			continue
+		}
+
+		created, err := s.recreateFunction(target, name, value)
+		if err != nil {
+			return count, fmt.Errorf("could not migrate %s: %s", name, err)
+		}
+		s.twins[value] = created
+		s.twins[created] = value
+		count++
+	}
+
+	return count, nil
+}
+
+// globalNames returns the names of the engine's global bindings in the order
+// they were last defined, so definitions depending on earlier ones replay cleanly
+func (s *session) globalNames(engine string) []string {
+	var names []string
+	if engine == engineVM {
+		for _, symbol := range s.symbolTable.Symbols() {
+			if symbol.Scope == compiler.GlobalScope {
+				names = append(names, symbol.Name)
+			}
+		}
+	} else {
+		names = s.env.Names()
+	}
+
+	order := map[string]int{}
+	for i, let := range s.definitions {
+		order[let.Name.Value] = i
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		oi, iok := order[names[i]]
+		oj, jok := order[names[j]]
+		if iok != jok {
+			return iok
+		}
+		return oi < oj
+	})
+
+	return names
+}
+
+// lookup returns the value bound to a global name in the engine
+func (s *session) lookup(engine, name string) (object.Object, bool) {
+	if engine == engineVM {
+		symbol, ok := s.symbolTable.Resolve(name)
+		if !ok || symbol.Scope != compiler.GlobalScope || s.globals[symbol.Index] == nil {
+			return nil, false
+		}
+		return s.globals[symbol.Index], true
+	}
+	return s.env.Get(name)
+}
+
+// bind sets a global name in the engine
+func (s *session) bind(engine, name string, value object.Object) {
+	if engine == engineVM {
+		symbol, ok := s.symbolTable.Resolve(name)
+		if !ok || symbol.Scope != compiler.GlobalScope {
+			symbol = s.symbolTable.Define(name)
+		}
+		s.globals[symbol.Index] = value
+		return
+	}
+	s.env.Set(name, value)
+}
+
+// recreateFunction defines a function in the target engine, either by
+// compiling the literal of an evaluator function that closes over nothing
+// but the globals, or by replaying the let statement that defined it
+func (s *session) recreateFunction(target, name string, fn object.Object) (object.Object, error) {
+	let := s.lastDefinition(name)
+
+	if function, ok := fn.(*object.Function); ok && function.Env == s.env {
+		let = &ast.LetStatement{
+			Token: token.Token{Type: token.LET, Literal: "let"},
+			Name:  &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name},
+			Value: &ast.FunctionLiteral{
+				Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+				Parameters: function.Parameters,
+				Body:       function.Body,
+				Name:       name,
+			},
+		}
+	}
+
+	if let == nil {
+		return nil, fmt.Errorf("its definition is unknown")
+	}
+
+	program := &ast.Program{Statements: []ast.Statement{let}}
+	if target == engineVM {
+		if _, err := s.runVM(program); err != nil {
+			return nil, err
+		}
+	} else if result := evaluator.Eval(program, s.env); result != nil && result.Type() == object.ERROR_OBJ {
+		return nil, fmt.Errorf("%s", result.(*object.Error).Message)
+	}
+
+	created, _ := s.lookup(target, name)
+	return created, nil
+}
+
+// lastDefinition returns the most recent top-level let statement binding name
+func (s *session) lastDefinition(name string) *ast.LetStatement {
+	for i := len(s.definitions) - 1; i >= 0; i-- {
+		if s.definitions[i].Name.Value == name {
+			return s.definitions[i]
+		}
+	}
+	return nil
+}
+
+// isFunction reports whether the value is a user defined function of either engine
+func isFunction(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Function, *object.Closure:
+		return true
+	default:
+		return false
+	}
+}
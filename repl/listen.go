@@ -0,0 +1,355 @@
+// repl/listen.go
+
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A session can be driven over the network by editors and notebooks, with a
+// protocol modelled on nREPL. Clients send requests, one JSON object per
+// line, naming an operation with op and carrying an id the responses to the
+// request repeat. Every request is answered by one or more responses, the
+// last of which has a status listing done:
+//
+//	{"op": "eval", "id": "1", "code": "let x = 2; puts(x); x * 21"}
+//	{"id": "1", "out": "2\n"}
+//	{"id": "1", "value": "42"}
+//	{"id": "1", "status": ["done"]}
+//
+// All the clients share one session: a binding made by one is seen by the
+// others. Evaluations run one at a time, those of a client in the order it
+// sent them.
+
+// Operations served to clients, with their descriptions returned by describe
+var operations = map[string]string{
+	"eval":      "evaluate code, or run a REPL command such as :engine vm, streaming its output as out",
+	"interrupt": "stop the evaluation interrupt-id, or all those of the connection without one",
+	"complete":  "list the globals, builtins, keywords and commands starting with prefix",
+	"describe":  "list the operations, the versions and the engine of the session",
+}
+
+// Statuses of the responses
+const (
+	statusDone        = "done"
+	statusError       = "error"
+	statusInterrupted = "interrupted"
+	statusIdle        = "session-idle"
+	statusUnknownOp   = "unknown-op"
+)
+
+// request is a request of a client
+type request struct {
+	Op          string `json:"op"`
+	ID          string `json:"id,omitempty"`
+	Code        string `json:"code,omitempty"`         // Code is the source evaluated by eval
+	Prefix      string `json:"prefix,omitempty"`       // Prefix is what complete completes
+	InterruptID string `json:"interrupt-id,omitempty"` // InterruptID is the id of the eval interrupt stops
+}
+
+// response is a response to a request
+type response struct {
+	ID          string            `json:"id,omitempty"`
+	Out         string            `json:"out,omitempty"`
+	Value       string            `json:"value,omitempty"`
+	Err         string            `json:"err,omitempty"`
+	Completions []completion      `json:"completions,omitempty"`
+	Ops         map[string]string `json:"ops,omitempty"`
+	Versions    map[string]string `json:"versions,omitempty"`
+	Engine      string            `json:"engine,omitempty"`
+	Status      []string          `json:"status,omitempty"`
+}
+
+// completion is a candidate returned by complete, with its type: function,
+// var, keyword or command
+type completion struct {
+	Candidate string `json:"candidate"`
+	Type      string `json:"type"`
+}
+
+// listener serves a session to the clients connecting to it
+type listener struct {
+	session *session
+	output  *redirect
+	version string
+
+	mutex sync.Mutex // mutex is held by the evaluation running and by complete
+}
+
+// redirect is the output of a session served to clients, written to the
+// client whose evaluation runs. Output written between evaluations, such as
+// by goroutines they started, is dropped.
+type redirect struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (r *redirect) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.w == nil {
+		return len(p), nil
+	}
+	return r.w.Write(p)
+}
+
+// set sets where the output is written, nil to drop it
+func (r *redirect) set(w io.Writer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.w = w
+}
+
+// Serve serves a session on engine, eval or vm, to the clients connecting
+// to l until l is closed. Output is never colored.
+func Serve(l net.Listener, engine string, opts Options) error {
+	output := &redirect{}
+	srv := &listener{
+		session: newSession(strings.NewReader(""), output, engine, Options{Hooks: opts.Hooks}),
+		output:  output,
+		version: opts.Version,
+	}
+
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go srv.serveConn(conn)
+	}
+}
+
+// client is a connection to a client
+type client struct {
+	srv     *listener
+	encoder *json.Encoder
+	mutex   sync.Mutex // mutex is held while a response is written
+
+	evals sync.Map      // evals maps the ids of the evaluations waiting or running to their cancel functions
+	last  chan struct{} // last is closed once the last evaluation received is done, nil before the first
+}
+
+// serveConn answers the requests of a client until it disconnects, then
+// interrupts its evaluations
+func (srv *listener) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	c := &client{srv: srv, encoder: json.NewEncoder(conn)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		if c.last != nil {
+			<-c.last
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			c.send(response{Err: "invalid request: " + err.Error(), Status: []string{statusError, statusDone}})
+			continue
+		}
+		c.handle(ctx, req)
+	}
+}
+
+// handle answers a request. Evaluations run in goroutines of their own so
+// that interrupts are read while they run, each waiting for the one received
+// before it.
+func (c *client) handle(ctx context.Context, req request) {
+	switch req.Op {
+	case "eval":
+		evalCtx, cancel := context.WithCancel(ctx)
+		c.evals.Store(req.ID, cancel)
+		previous, done := c.last, make(chan struct{})
+		c.last = done
+		go func() {
+			defer close(done)
+			defer c.evals.Delete(req.ID)
+			defer cancel()
+			if previous != nil {
+				<-previous
+			}
+			c.eval(evalCtx, req)
+		}()
+
+	case "interrupt":
+		interrupted := false
+		c.evals.Range(func(id, cancel interface{}) bool {
+			if req.InterruptID == "" || id == req.InterruptID {
+				cancel.(context.CancelFunc)()
+				interrupted = true
+			}
+			return true
+		})
+		if !interrupted {
+			c.send(response{ID: req.ID, Status: []string{statusIdle, statusDone}})
+			return
+		}
+		c.send(response{ID: req.ID, Status: []string{statusDone}})
+
+	case "complete":
+		c.send(response{ID: req.ID, Completions: c.srv.complete(req.Prefix), Status: []string{statusDone}})
+
+	case "describe":
+		c.srv.mutex.Lock()
+		engine := c.srv.session.engine
+		c.srv.mutex.Unlock()
+		c.send(response{
+			ID:       req.ID,
+			Ops:      operations,
+			Versions: map[string]string{"monkey": c.srv.version},
+			Engine:   engine,
+			Status:   []string{statusDone},
+		})
+
+	default:
+		c.send(response{ID: req.ID, Err: "unknown operation " + req.Op, Status: []string{statusError, statusUnknownOp, statusDone}})
+	}
+}
+
+// eval evaluates the code of req in the session, streaming its output to
+// the client
+func (c *client) eval(ctx context.Context, req request) {
+	srv := c.srv
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+
+	if ctx.Err() != nil {
+		c.send(response{ID: req.ID, Status: []string{statusInterrupted, statusDone}})
+		return
+	}
+
+	srv.output.set(writerFunc(func(p []byte) (int, error) {
+		c.send(response{ID: req.ID, Out: string(p)})
+		return len(p), nil
+	}))
+	srv.session.builtins.Context = ctx
+	defer func() {
+		srv.session.builtins.Context = nil
+		srv.output.set(nil)
+	}()
+
+	value, err := srv.session.evaluate(req.Code)
+	switch {
+	case ctx.Err() != nil:
+		c.send(response{ID: req.ID, Err: err, Status: []string{statusInterrupted, statusDone}})
+	case err != "":
+		c.send(response{ID: req.ID, Err: err, Status: []string{statusError, statusDone}})
+	default:
+		c.send(response{ID: req.ID, Value: value, Status: []string{statusDone}})
+	}
+}
+
+// send writes a response to the client
+func (c *client) send(resp response) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_ = c.encoder.Encode(resp)
+}
+
+// writerFunc is a function writing output
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// evaluate runs code, a command or a program, and returns the value of the
+// program as the REPL prints it, or its error. The output of commands is written like that of
+// programs.
+func (s *session) evaluate(code string) (value, err string) {
+	if isCommand(code) {
+		s.runCommand(code)
+		return "", ""
+	}
+
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		var msg bytes.Buffer
+		printParserErrors(&msg, colorizer{}, code, p.Errors())
+		return "", strings.TrimSuffix(msg.String(), "\n")
+	}
+
+	result, execErr := s.execute(s.engine, program)
+	s.exited = false // exit() ends the evaluation, not the session of the other clients
+	if execErr != nil {
+		return "", execErr.Error()
+	}
+	if errObj, ok := result.(*object.Error); ok {
+		return "", errObj.Message
+	}
+	s.recordDefinitions(program)
+
+	if result == nil {
+		return "", ""
+	}
+	return s.color.pretty(result, ""), ""
+}
+
+// complete returns the candidates starting with prefix: the globals of the
+// session, the builtins and the keywords, or the commands for a prefix
+// starting with a colon
+func (srv *listener) complete(prefix string) []completion {
+	var candidates []completion
+	if strings.HasPrefix(prefix, ":") {
+		for name := range commands {
+			if strings.HasPrefix(":"+name, prefix) {
+				candidates = append(candidates, completion{Candidate: ":" + name, Type: "command"})
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Candidate < candidates[j].Candidate })
+		return candidates
+	}
+
+	seen := map[string]bool{}
+	add := func(name, kind string) {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, completion{Candidate: name, Type: kind})
+		}
+	}
+
+	srv.mutex.Lock()
+	s := srv.session
+	for _, name := range s.globalNames(s.engine) {
+		kind := "var"
+		if value, ok := s.lookup(s.engine, name); ok && isFunction(value) {
+			kind = "function"
+		}
+		add(name, kind)
+	}
+	srv.mutex.Unlock()
+
+	for _, builtin := range object.Builtins {
+		add(builtin.Name, "function")
+	}
+	for _, keyword := range token.Keywords() {
+		add(keyword, "keyword")
+	}
+	return candidates
+}
@@ -0,0 +1,124 @@
+package repl
+
+import (
+	"fmt"
+	"monkey/object"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxPrintedElements is the number of elements printed per array, hash
+	// or tensor dimension before the rest is summarized
+	maxPrintedElements = 20
+	// inlineWidth is the widest a container may be to stay on a single line
+	inlineWidth = 60
+)
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// result renders an evaluation result as "=> value : TYPE"
+func (c colorizer) result(obj object.Object) string {
+	if obj.Type() == object.ERROR_OBJ {
+		return c.value(obj)
+	}
+	return "=> " + c.pretty(obj, "") + c.paint(colorGray, " : "+string(obj.Type()))
+}
+
+// pretty renders obj for display, breaking large containers over several
+// indented lines and truncating very long ones
+func (c colorizer) pretty(obj object.Object, indent string) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return c.paint(colorGreen, strconv.Quote(obj.Value))
+
+	case *object.Array:
+		return c.container("[", "]", len(obj.Elements), indent, func(i int, indent string) string {
+			return c.pretty(obj.Elements[i], indent)
+		})
+
+	case *object.Hash:
+		pairs := obj.SortedPairs()
+
+		return c.container("{", "}", len(pairs), indent, func(i int, indent string) string {
+			return c.pretty(pairs[i].Key, indent) + ": " + c.pretty(pairs[i].Value, indent)
+		})
+
+	case *object.Tensor:
+		shape := make([]string, len(obj.Shape))
+		for i, dim := range obj.Shape {
+			shape[i] = strconv.FormatInt(dim, 10)
+		}
+
+		header := "@[" + strings.Join(shape, ", ") + "] "
+		if obj.ElementType() != object.Float64 {
+			header += string(obj.ElementType()) + " "
+		}
+		if tensorSize(obj.Shape) != len(obj.Data) {
+			return header + c.paint(colorCyan, fmt.Sprintf("%v", obj.Data))
+		}
+		return header + c.tensor(obj.Shape, obj.Data, obj.ElementType(), indent)
+
+	default:
+		return c.value(obj)
+	}
+}
+
+// tensor renders the data of a tensor as nested rows following its shape
+func (c colorizer) tensor(shape []int64, data []float64, dtype object.DType, indent string) string {
+	if len(shape) <= 1 {
+		return c.container("[", "]", len(data), indent, func(i int, indent string) string {
+			return c.paint(colorCyan, dtype.Format(data[i]))
+		})
+	}
+
+	stride := tensorSize(shape[1:])
+	return c.container("[", "]", int(shape[0]), indent, func(i int, indent string) string {
+		return c.tensor(shape[1:], data[i*stride:(i+1)*stride], dtype, indent)
+	})
+}
+
+// container renders n elements between open and close, on a single line when
+// they are short and one per line otherwise
+func (c colorizer) container(open, close string, n int, indent string, element func(i int, indent string) string) string {
+	inner := indent + "  "
+	shown := n
+	if shown > maxPrintedElements {
+		shown = maxPrintedElements
+	}
+
+	items := make([]string, shown)
+	width := 0
+	multiline := false
+	for i := range items {
+		items[i] = element(i, inner)
+		width += len(ansiEscape.ReplaceAllString(items[i], "")) + 2
+		multiline = multiline || strings.Contains(items[i], "\n")
+	}
+
+	if n == shown && !multiline && width <= inlineWidth {
+		return open + strings.Join(items, ", ") + close
+	}
+
+	var out strings.Builder
+	out.WriteString(open + "\n")
+	for _, item := range items {
+		out.WriteString(inner + item + ",\n")
+	}
+	if n > shown {
+		out.WriteString(inner + c.paint(colorGray, fmt.Sprintf("… (%d more elements)", n-shown)) + "\n")
+	}
+	out.WriteString(indent + close)
+
+	return out.String()
+}
+
+// tensorSize returns the number of elements a tensor of the given shape holds
+func tensorSize(shape []int64) int {
+	size := 1
+	for _, dim := range shape {
+		size *= int(dim)
+	}
+	return size
+}
@@ -0,0 +1,43 @@
+package repl
+
+import (
+	"monkey/object"
+	"strings"
+	"testing"
+)
+
+func TestResult(t *testing.T) {
+	elements := make([]object.Object, 25)
+	for i := range elements {
+		elements[i] = &object.Integer{Value: int64(i)}
+	}
+	hash := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	for _, key := range []string{"b", "a"} {
+		k := &object.String{Value: key}
+		hash.Pairs[k.HashKey()] = object.HashPair{Key: k, Value: &object.Integer{Value: 1}}
+	}
+
+	tests := []struct {
+		obj      object.Object
+		expected string
+	}{
+		{&object.Integer{Value: 15}, "=> 15 : INTEGER"},
+		{&object.String{Value: "hi"}, `=> "hi" : STRING`},
+		{&object.Array{Elements: elements[:3]}, "=> [0, 1, 2] : ARRAY"},
+		{hash, `=> {"a": 1, "b": 1} : HASH`},
+		{&object.Array{Elements: []object.Object{&object.Array{Elements: elements[:2]}, hash}}, `=> [[0, 1], {"a": 1, "b": 1}] : ARRAY`},
+		{&object.Array{Elements: elements}, "=> [\n  0,\n  1,\n  2,\n  3,\n  4,\n  5,\n  6,\n  7,\n  8,\n  9,\n  10,\n  11,\n  12,\n  13,\n  14,\n  15,\n  16,\n  17,\n  18,\n  19,\n  … (5 more elements)\n] : ARRAY"},
+	}
+
+	for _, tt := range tests {
+		if got := (colorizer{}).result(tt.obj); got != tt.expected {
+			t.Errorf("wrong result. want=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	// Colors are left out of the width of the elements
+	colored := colorizer{enabled: true}.result(&object.Array{Elements: elements[:3]})
+	if plain := ansiEscape.ReplaceAllString(colored, ""); plain != "=> [0, 1, 2] : ARRAY" || !strings.Contains(colored, "\x1b[") {
+		t.Errorf("wrong colored result %q", colored)
+	}
+}
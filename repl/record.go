@@ -0,0 +1,100 @@
+package repl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Recording is a REPL session saved with --record: the engine the session
+// started with and every line of input along with the output it produced
+type Recording struct {
+	Engine  string           `json:"engine"`
+	Entries []RecordingEntry `json:"entries"`
+}
+
+// RecordingEntry is a single line of input and its output, without colors
+type RecordingEntry struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+}
+
+// recorder appends the lines of a session to a recording, rewriting the
+// file after each line so nothing is lost when the session is interrupted
+type recorder struct {
+	path      string
+	recording Recording
+}
+
+// add records a line of input and its output and saves the recording
+func (r *recorder) add(input, output string) error {
+	r.recording.Entries = append(r.recording.Entries, RecordingEntry{
+		Input:  input,
+		Output: ansiEscape.ReplaceAllString(output, ""),
+	})
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r.recording); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data.Bytes(), 0644)
+}
+
+// handleRecorded handles a line of input, capturing its output for the recording
+func (s *session) handleRecorded(line string) string {
+	var captured bytes.Buffer
+
+	out := s.out
+	s.out = io.MultiWriter(out, &captured)
+	s.handle(line)
+	s.out = out
+
+	return captured.String()
+}
+
+// Replay re-executes a recorded session, echoing each input and its output
+// to out. Entries whose output differs from the recording are reported and
+// make Replay return an error.
+func Replay(filename string, out io.Writer, opts Options) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var recording Recording
+	if err := json.Unmarshal(data, &recording); err != nil {
+		return fmt.Errorf("%s: invalid recording: %s", filename, err)
+	}
+	if recording.Engine != engineEvaluator && recording.Engine != engineVM {
+		return fmt.Errorf("%s: unknown engine %q", filename, recording.Engine)
+	}
+
+	s := newSession(strings.NewReader(""), out, recording.Engine, Options{Color: opts.Color})
+
+	differences := 0
+	for i, entry := range recording.Entries {
+		io.WriteString(out, s.color.prompt()+entry.Input+"\n")
+
+		output := ansiEscape.ReplaceAllString(s.handleRecorded(entry.Input), "")
+		if output != entry.Output {
+			differences++
+			s.printError(fmt.Sprintf("entry %d differs from the recording, which printed:", i+1))
+			io.WriteString(out, entry.Output)
+		}
+
+		if s.exited {
+			break
+		}
+	}
+
+	if differences > 0 {
+		return fmt.Errorf("%d of %d entries differ from the recording", differences, len(recording.Entries))
+	}
+	return nil
+}
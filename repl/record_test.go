@@ -0,0 +1,52 @@
+package repl
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	for _, engine := range []string{engineEvaluator, engineVM} {
+		path := filepath.Join(t.TempDir(), "session.json")
+		input := "puts(\"hello\")\nlet x = 2\nx * 3\n"
+		var out bytes.Buffer
+		newSession(strings.NewReader(input), &out, engine, Options{Record: path}).run()
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recording Recording
+		if err := json.Unmarshal(data, &recording); err != nil {
+			t.Fatalf("invalid recording: %s", err)
+		}
+		if recording.Engine != engine || len(recording.Entries) != 3 {
+			t.Fatalf("wrong recording on %s: %+v", engine, recording)
+		}
+		// What programs print is recorded along with the results
+		if output := recording.Entries[0].Output; !strings.HasPrefix(output, "hello\n") {
+			t.Errorf("wrong output of puts on %s: %q", engine, output)
+		}
+		if output := recording.Entries[2].Output; output != "=> 6 : INTEGER\n" {
+			t.Errorf("wrong output of x * 3 on %s: %q", engine, output)
+		}
+
+		var replayed bytes.Buffer
+		if err := Replay(path, &replayed, Options{}); err != nil {
+			t.Errorf("replay on %s failed: %s\n%s", engine, err, replayed.String())
+		}
+
+		recording.Entries[0].Output = "bye\n"
+		data, _ = json.Marshal(recording)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := Replay(path, &replayed, Options{}); err == nil || err.Error() != "1 of 3 entries differ from the recording" {
+			t.Errorf("wrong error replaying a changed recording on %s: %v", engine, err)
+		}
+	}
+}
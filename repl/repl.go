@@ -5,18 +5,34 @@ import (
 	"fmt"
 	"io"
 	"monkey/compiler"
+	"monkey/diagnostic"
 	"monkey/evaluator"
+	"monkey/imports"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
 	"monkey/vm"
 	"os"
+	"strings"
 )
 
 const PROMPT = ">> "
 
+// Options configures a REPL session
+type Options struct {
+	Color   bool   // Color enables ANSI colored prompts, errors and results
+	Record  string // Record is the path of a file every input and result is saved to
+	Version string // Version is the version of the interpreter told to the clients of Serve
+
+	Hooks     *vm.Hooks        // Hooks observe the VM running a script, e.g. to profile it
+	EvalHooks *evaluator.Hooks // EvalHooks observe the evaluator running a script, e.g. to trace it
+	Trace     io.Writer        // Trace receives the instructions compiled for a script run on the VM
+
+	Imports *imports.Resolver // Imports finds the files programs import, in the directories of MONKEY_PATH when nil
+}
+
 // Compile a text file
-func CompileFile(filename string) {
+func CompileFile(filename string, opts Options) {
 	// Read the file
 	file, err := os.Open(filename)
 	if err != nil {
@@ -42,11 +58,12 @@ func CompileFile(filename string) {
 
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParserErrors(os.Stderr, p.Errors())
+			printParserErrors(os.Stderr, colorizer{}, line, p.Errors())
 			continue
 		}
 
 		comp := compiler.NewWithState(symbolTable, constants)
+		comp.SetResolver(opts.Imports)
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Woops! Compilation failed:\n %s\n", err)
@@ -73,94 +90,26 @@ func CompileFile(filename string) {
 }
 
 // Start is a function that starts the REPL
-func StartEvaluator(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
-
-	for {
-		fmt.Print(PROMPT)
-		scanned := scanner.Scan() // scanned is a boolean
-		if !scanned {
-			return
-		}
-
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
-		}
-
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
-		}
-	}
+func StartEvaluator(in io.Reader, out io.Writer, opts Options) {
+	newSession(in, out, engineEvaluator, opts).run()
 }
 
 // Start is a function that starts the REPL as a compiler
-func StartCompiler(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-
-	// Define a global environment
-	constants := make([]object.Object, 0)
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
-
-	for {
-		fmt.Print(PROMPT)
-		scanned := scanner.Scan() // scanned is a boolean
-		if !scanned {
-			return
-		}
-
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
-		}
-
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
-		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
-			continue
-		}
-
-		code := comp.Bytecode()
-		constants = code.Constants
-
-		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
-			continue
-		}
-
-		stackTop := machine.LastPoppedStackElem()
-		if stackTop == nil {
-			stackTop = vm.Null
-		}
-		io.WriteString(out, stackTop.Inspect())
-		io.WriteString(out, "\n")
-	}
+func StartCompiler(in io.Reader, out io.Writer, opts Options) {
+	newSession(in, out, engineVM, opts).run()
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
-	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+// printParserErrors writes the parser errors, pointing a caret at the
+// offending column of the source when it is known
+func printParserErrors(out io.Writer, color colorizer, source string, errors []diagnostic.Diagnostic) {
+	io.WriteString(out, color.error("Woops! We ran into some monkey business here!")+"\n")
+	io.WriteString(out, color.error(" parser errors:")+"\n")
+
+	lines := strings.Split(source, "\n")
+	for _, e := range errors {
+		io.WriteString(out, "\t"+color.error(e.Message)+"\n")
+		if e.Line >= 1 && e.Line <= len(lines) {
+			color.writeCaret(out, lines[e.Line-1], e.Column)
+		}
 	}
 }
@@ -0,0 +1,248 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/diagnostic"
+	"monkey/evaluator"
+	"monkey/imports"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"os"
+)
+
+// Exit codes reported by a ScriptError
+const (
+	ExitRuntimeError = 1  // ExitRuntimeError is an uncaught error raised while running
+	ExitUsage        = 2  // ExitUsage is a bad invocation, such as a missing script
+	ExitParseError   = 3  // ExitParseError is a program that does not parse
+	ExitCompileError = 4  // ExitCompileError is a program the compiler rejects
+	ExitCrash        = 70 // ExitCrash is a panic inside the interpreter
+)
+
+// ScriptError is returned when a script fails or calls exit(n) and carries
+// the exit code the process should end with
+type ScriptError struct {
+	Code    int
+	Message string
+}
+
+func (e *ScriptError) Error() string {
+	return e.Message
+}
+
+// RunFile parses a whole script and executes it with the given engine, "eval"
+// or "vm". Parser errors and uncaught runtime errors, together with their
+// stack trace, are written to errOut and reported by the returned *ScriptError.
+// A .mkyc file holds a program already compiled, which runs on the VM.
+func RunFile(filename, engine string, errOut io.Writer, opts Options) error {
+	if imports.IsCompiled(filename) {
+		return runCompiled(filename, errOut, opts)
+	}
+
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", colorizer{enabled: opts.Color}.error(err.Error()))
+		return &ScriptError{Code: ExitUsage, Message: err.Error()}
+	}
+
+	return RunSource(filename, string(source), engine, errOut, opts)
+}
+
+// RunSource is like RunFile but executes source directly. The name is used
+// in error messages in place of a filename.
+func RunSource(filename, source, engine string, errOut io.Writer, opts Options) (err error) {
+	color := colorizer{enabled: opts.Color}
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(errOut, color, source, p.Errors())
+		return &ScriptError{Code: ExitParseError, Message: fmt.Sprintf("%s: %d parser errors", filename, len(p.Errors()))}
+	}
+
+	defer recoverScript(&err, filename, engine, errOut, color)
+
+	switch engine {
+	case engineVM:
+		comp := compiler.New()
+		comp.SetTrace(opts.Trace)
+		comp.SetResolver(opts.Imports)
+		if err := comp.Compile(program); err != nil {
+			return reportError(errOut, color, ExitCompileError, filename, compileErrorLine(err), "compilation failed: "+compileErrorMessage(err, filename), nil)
+		}
+		return runBytecode(filename, comp.Bytecode(), errOut, color, opts)
+
+	case engineEvaluator:
+		// Each run imports the files it needs afresh, so scripts run one
+		// after the other do not see the modules of those before
+		ctx := evaluator.WithHooks(nil, opts.EvalHooks)
+		ctx.Modules = evaluator.NewModules()
+		ctx.Imports = opts.Imports
+		env := object.NewEnvironment()
+		env.SetContext(ctx)
+		result := evaluator.Eval(program, env)
+		if errObj, ok := result.(*object.Error); ok {
+			return reportEvalError(errOut, color, filename, errObj)
+		}
+		return nil
+
+	default:
+		return &ScriptError{Code: ExitUsage, Message: fmt.Sprintf("unknown engine %q", engine)}
+	}
+}
+
+// runCompiled is a helper function that runs a program compiled to the file
+// filename, linking it to the modules it imports first
+func runCompiled(filename string, errOut io.Writer, opts Options) error {
+	if _, err := os.Stat(filename); err != nil {
+		fmt.Fprintf(errOut, "%s\n", colorizer{enabled: opts.Color}.error(err.Error()))
+		return &ScriptError{Code: ExitUsage, Message: err.Error()}
+	}
+
+	bytecode, err := compiler.LoadProgram(filename, opts.Imports)
+	if err != nil {
+		return reportError(errOut, colorizer{enabled: opts.Color}, ExitCompileError, filename, compileErrorLine(err), "loading failed: "+compileErrorMessage(err, filename), nil)
+	}
+	return RunBytecode(filename, bytecode, errOut, opts)
+}
+
+// WriteModule compiles a whole script into a .mkyc file, which RunFile runs
+// and the VM imports without the source. Compiler errors are written to
+// errOut and reported as a *ScriptError.
+func WriteModule(filename, output string, errOut io.Writer, opts Options) error {
+	if err := compiler.WriteModule(filename, output, opts.Imports); err != nil {
+		return reportError(errOut, colorizer{enabled: opts.Color}, ExitCompileError, filename, compileErrorLine(err), "compilation failed: "+compileErrorMessage(err, filename), nil)
+	}
+	return nil
+}
+
+// CompileScript parses and compiles a whole script for the VM. Parser and
+// compiler errors are written to errOut and reported as a *ScriptError.
+func CompileScript(filename string, errOut io.Writer, opts Options) (*compiler.Bytecode, error) {
+	program, err := ParseScript(filename, errOut, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	comp := compiler.New()
+	comp.SetTrace(opts.Trace)
+	comp.SetResolver(opts.Imports)
+	if err := comp.Compile(program); err != nil {
+		return nil, reportError(errOut, colorizer{enabled: opts.Color}, ExitCompileError, filename, compileErrorLine(err), "compilation failed: "+compileErrorMessage(err, filename), nil)
+	}
+	return comp.Bytecode(), nil
+}
+
+// ParseScript reads and parses a whole script. Read and parser errors are
+// written to errOut and reported as a *ScriptError.
+func ParseScript(filename string, errOut io.Writer, opts Options) (*ast.Program, error) {
+	color := colorizer{enabled: opts.Color}
+
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", color.error(err.Error()))
+		return nil, &ScriptError{Code: ExitUsage, Message: err.Error()}
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(errOut, color, string(source), p.Errors())
+		return nil, &ScriptError{Code: ExitParseError, Message: fmt.Sprintf("%s: %d parser errors", filename, len(p.Errors()))}
+	}
+	return program, nil
+}
+
+// RunBytecode runs compiled bytecode on the VM, reporting errors like RunFile
+func RunBytecode(name string, bytecode *compiler.Bytecode, errOut io.Writer, opts Options) (err error) {
+	color := colorizer{enabled: opts.Color}
+	defer recoverScript(&err, name, engineVM, errOut, color)
+
+	return runBytecode(name, bytecode, errOut, color, opts)
+}
+
+// compileErrorLine is a helper function that returns the line of a compiler error, 0 if unknown
+func compileErrorLine(err error) int {
+	switch err := err.(type) {
+	case *diagnostic.Diagnostic:
+		return err.Line
+	case *compiler.ImportError:
+		return err.Line
+	}
+	return 0
+}
+
+// compileErrorMessage is a helper function that returns the message of a
+// compiler error, with the import chain starting from the program in name
+func compileErrorMessage(err error, name string) string {
+	if importErr, ok := err.(*compiler.ImportError); ok {
+		return importErr.Chain(name)
+	}
+	return err.Error()
+}
+
+// runBytecode is a helper function that runs bytecode on a fresh VM with the
+// hooks and the imports of opts
+func runBytecode(name string, bytecode *compiler.Bytecode, errOut io.Writer, color colorizer, opts Options) error {
+	machine := vm.New(bytecode)
+	machine.SetContext(object.Context{Imports: opts.Imports})
+	machine.SetHooks(opts.Hooks)
+	if err := machine.Run(); err != nil {
+		if rtErr, ok := err.(*vm.RuntimeError); ok {
+			return reportError(errOut, color, ExitRuntimeError, name, rtErr.Line, rtErr.Message, rtErr.Stack)
+		}
+		return reportError(errOut, color, ExitRuntimeError, name, 0, err.Error(), nil)
+	}
+	return nil
+}
+
+// recoverScript is deferred while a script runs. It turns a call to exit()
+// into the matching *ScriptError and reports any other panic as a crash.
+func recoverScript(err *error, name, engine string, errOut io.Writer, color colorizer) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if exit, ok := r.(*object.ExitRequest); ok {
+		*err = nil
+		if exit.Code != 0 {
+			*err = &ScriptError{Code: exit.Code, Message: fmt.Sprintf("%s: exit status %d", name, exit.Code)}
+		}
+		return
+	}
+
+	*err = &ScriptError{Code: ExitCrash, Message: fmt.Sprintf("%s: the %s engine crashed: %v", name, engine, r)}
+	fmt.Fprintf(errOut, "%s\n", color.error((*err).Error()))
+}
+
+// reportEvalError is a helper function that reports an uncaught evaluator
+// error, prefixed with the chain of imports it was raised in
+func reportEvalError(errOut io.Writer, color colorizer, name string, errObj *object.Error) error {
+	message := errObj.Message
+	if len(errObj.Imports) != 0 {
+		message = object.ImportChain(errObj.Imports, name) + ": " + message
+	}
+	return reportError(errOut, color, ExitRuntimeError, name, errObj.Line, message, object.CompactStack(errObj.Stack))
+}
+
+// reportError writes an uncaught error and its stack trace to errOut and
+// returns it as a *ScriptError with the given exit code
+func reportError(errOut io.Writer, color colorizer, code int, name string, line int, message string, stack []string) error {
+	location := name
+	if line > 0 {
+		location = fmt.Sprintf("%s:%d", name, line)
+	}
+
+	fmt.Fprintf(errOut, "%s\n", color.error(fmt.Sprintf("%s: error: %s", location, message)))
+	for _, frame := range stack {
+		fmt.Fprintf(errOut, "\t%s\n", color.paint(colorGray, frame))
+	}
+
+	return &ScriptError{Code: code, Message: fmt.Sprintf("%s: %s", location, message)}
+}
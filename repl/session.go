@@ -0,0 +1,265 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+)
+
+// Engines a session can execute input with
+const (
+	engineEvaluator = "eval"
+	engineVM        = "vm"
+)
+
+// session holds the state of an interactive REPL for both engines so
+// commands can run input through either of them
+type session struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+	color   colorizer
+	engine  string
+	trace   bool // trace prints every opcode the VM executes
+	exited  bool // exited is set once the program calls exit()
+
+	recorder *recorder // recorder saves every line of input when recording
+
+	// builtins is the context builtins are called in by both engines. Its
+	// cancellation stops the input executing.
+	builtins *object.Context
+
+	// Evaluator state
+	env *object.Environment
+
+	// Compiler and VM state
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+
+	// definitions holds the top-level let statements executed so far so
+	// functions can be recreated when bindings move between engines
+	definitions []*ast.LetStatement
+	// twins maps a migrated function to its counterpart in the other engine
+	twins map[object.Object]object.Object
+}
+
+// newSession creates a session reading from in and writing to out
+func newSession(in io.Reader, out io.Writer, engine string, opts Options) *session {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	var rec *recorder
+	if opts.Record != "" {
+		rec = &recorder{path: opts.Record, recording: Recording{Engine: engine}}
+	}
+
+	s := &session{
+		recorder:    rec,
+		scanner:     bufio.NewScanner(in),
+		out:         out,
+		color:       colorizer{enabled: opts.Color},
+		engine:      engine,
+		env:         object.NewEnvironment(),
+		symbolTable: symbolTable,
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalsSize),
+		twins:       map[object.Object]object.Object{},
+	}
+	// Programs write where the session does, even while it is recorded
+	s.builtins = &object.Context{Out: sessionOutput{s}, Engine: evaluator.Engine, Modules: evaluator.NewModules(), Imports: opts.Imports}
+	s.env.SetContext(s.builtins)
+	return s
+}
+
+// sessionOutput is the writer programs run by a session write to, which
+// writes to the output the session has at the time
+type sessionOutput struct {
+	s *session
+}
+
+func (o sessionOutput) Write(p []byte) (int, error) {
+	return o.s.out.Write(p)
+}
+
+// run reads lines until the input is exhausted, executing commands and programs
+func (s *session) run() {
+	for {
+		io.WriteString(s.out, s.color.prompt())
+		scanned := s.scanner.Scan() // scanned is a boolean
+		if !scanned {
+			return
+		}
+
+		line := s.scanner.Text()
+		if s.recorder != nil {
+			output := s.handleRecorded(line)
+			if err := s.recorder.add(line, output); err != nil {
+				s.printError(fmt.Sprintf("could not record the session: %s", err))
+				s.recorder = nil
+			}
+		} else {
+			s.handle(line)
+		}
+
+		if s.exited {
+			return
+		}
+	}
+}
+
+// handle executes a line of input, either a command or a program
+func (s *session) handle(line string) {
+	if isCommand(line) {
+		s.runCommand(line)
+		return
+	}
+	s.evalLine(line)
+}
+
+// evalLine parses and executes a line of input and prints the result
+func (s *session) evalLine(line string) {
+	program, ok := s.parse(line)
+	if !ok {
+		return
+	}
+
+	result, err := s.execute(s.engine, program)
+	if err != nil {
+		s.printError(err.Error())
+		return
+	}
+	s.recordDefinitions(program)
+
+	if result != nil {
+		io.WriteString(s.out, s.color.result(result))
+		io.WriteString(s.out, "\n")
+	}
+}
+
+// parse parses the input, printing any parser errors
+func (s *session) parse(input string) (*ast.Program, bool) {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, s.color, input, p.Errors())
+		return nil, false
+	}
+
+	return program, true
+}
+
+// execute runs the program with the given engine. A panic inside an engine
+// is reported as an error so a bad line cannot end the session.
+func (s *session) execute(engine string, program *ast.Program) (result object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*object.ExitRequest); ok {
+				s.exited = true
+				result, err = nil, nil
+				return
+			}
+			result, err = nil, fmt.Errorf("Woops! The %s engine crashed:\n %v", engine, r)
+		}
+	}()
+
+	if engine == engineVM {
+		return s.runVM(program)
+	}
+	return evaluator.Eval(program, s.env), nil
+}
+
+// recordDefinitions remembers the top-level let statements of an executed program
+func (s *session) recordDefinitions(program *ast.Program) {
+	for _, stmt := range program.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok {
+			s.definitions = append(s.definitions, let)
+		}
+	}
+}
+
+// compile compiles the program against the session's symbol table and
+// constants. Definitions made by a failed compilation are rolled back.
+func (s *session) compile(program *ast.Program) (*compiler.Bytecode, error) {
+	snapshot := s.symbolTable.Snapshot()
+
+	comp := compiler.NewWithState(s.symbolTable, s.constants)
+	comp.SetResolver(s.builtins.Imports)
+	err := comp.Compile(program)
+	if err != nil {
+		s.symbolTable.Restore(snapshot, nil)
+		return nil, fmt.Errorf("Woops! Compilation failed:\n %s", err)
+	}
+
+	code := comp.Bytecode()
+	s.constants = code.Constants
+	return code, nil
+}
+
+// runVM compiles the program and runs it on a VM sharing the session's
+// globals. When the program fails at runtime, globals it defined but never
+// assigned are forgotten so later lines cannot read them.
+func (s *session) runVM(program *ast.Program) (object.Object, error) {
+	snapshot := s.symbolTable.Snapshot()
+
+	code, err := s.compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := vm.NewWithGlobalsStore(code, s.globals)
+	machine.SetContext(*s.builtins)
+	if s.trace {
+		machine.SetHooks(&vm.Hooks{OnInstruction: s.traceInstruction})
+	}
+	err = s.runMachine(machine)
+	if err != nil {
+		s.symbolTable.Restore(snapshot, func(symbol compiler.Symbol) bool {
+			return symbol.Scope == compiler.GlobalScope && s.globals[symbol.Index] != nil
+		})
+		return nil, fmt.Errorf("Woops! Executing bytecode failed:\n %s", err)
+	}
+
+	stackTop := machine.LastPoppedStackElem()
+	if stackTop == nil {
+		stackTop = vm.Null
+	}
+	return stackTop, nil
+}
+
+// runMachine runs the VM, turning a panic other than a call to exit() into an error
+func (s *session) runMachine(machine *vm.VM) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*object.ExitRequest); ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if s.builtins.Context == nil {
+		return machine.Run()
+	}
+	return machine.RunContext(s.builtins.Context)
+}
+
+// printError writes an error message
+func (s *session) printError(msg string) {
+	io.WriteString(s.out, s.color.error(msg)+"\n")
+}
+
+// isCommand reports whether the line is a REPL command such as :help
+func isCommand(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), ":")
+}
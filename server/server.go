@@ -0,0 +1,219 @@
+// server/server.go
+
+// Package server serves the top-level functions of a Monkey script to other
+// programs. Clients connect over TCP or a unix socket and speak the protocol
+// extensions run as processes speak, described in package exthost: they ask
+// for the functions with a describe message and call them with call
+// messages.
+//
+// The script runs once on the VM when it is loaded. Every call then runs on
+// its own VM, with its own copy of the globals and the values they hold, so
+// calls cannot see each other's effects and run concurrently.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/doc"
+	"monkey/exthost"
+	"monkey/imports"
+	"monkey/object"
+	"monkey/vm"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Server holds a loaded script and serves its functions
+type Server struct {
+	Timeout time.Duration // Timeout bounds the time a call runs for, no bound when zero
+	Output  io.Writer     // Output is where the builtins called by the functions write, os.Stdout when nil
+
+	imports   *imports.Resolver // imports finds the files the script and its calls import
+	constants []object.Object
+	globals   []object.Object // globals holds the globals of the script once it ran
+	functions []function
+}
+
+// function is a top-level function of the script
+type function struct {
+	entry doc.Entry
+	index int // index is the global holding the function
+}
+
+// Load compiles and runs a script, finding its imports with resolver, and
+// returns a server for its top-level functions, only the exported ones when
+// it exports any
+func Load(program *ast.Program, resolver *imports.Resolver) (*Server, error) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	comp.SetResolver(resolver)
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+
+	bytecode := comp.Bytecode()
+	globals := make([]object.Object, vm.GlobalsSize)
+	machine := vm.NewWithGlobalsStore(bytecode, globals)
+	machine.SetContext(object.Context{Imports: resolver})
+	if err := machine.Run(); err != nil {
+		return nil, err
+	}
+
+	s := &Server{imports: resolver, constants: bytecode.Constants, globals: globals}
+	for _, entry := range doc.FromProgram(program) {
+		symbol, ok := symbolTable.Resolve(entry.Name)
+		if !ok || symbol.Scope != compiler.GlobalScope {
+			continue
+		}
+		if _, ok := globals[symbol.Index].(*object.Closure); ok {
+			s.functions = append(s.functions, function{entry: entry, index: symbol.Index})
+		}
+	}
+	return s, nil
+}
+
+// Names returns the names of the functions served
+func (s *Server) Names() []string {
+	names := make([]string, len(s.functions))
+	for i, fn := range s.functions {
+		names[i] = fn.entry.Name
+	}
+	return names
+}
+
+// Functions returns the functions served as plugin functions
+func (s *Server) Functions() []object.Plugin {
+	fns := make([]object.Plugin, len(s.functions))
+	for i, fn := range s.functions {
+		fn := fn
+		fns[i] = object.Plugin{Name: fn.entry.Name, Params: params(fn.entry.Signature), Doc: fn.entry.Doc, Fn: func(args ...object.Object) object.Object {
+			result, err := s.call(fn.index, args)
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			return result
+		}}
+	}
+	return fns
+}
+
+// params is a helper function that returns the parameters of a signature
+// such as add(a, b)
+func params(signature string) []object.Param {
+	_, list, _ := strings.Cut(strings.TrimSuffix(signature, ")"), "(")
+	if list == "" {
+		return nil
+	}
+
+	var params []object.Param
+	for _, name := range strings.Split(list, ", ") {
+		params = append(params, object.Param{Name: name})
+	}
+	return params
+}
+
+// call runs the function held by the global at index with args on a new VM
+func (s *Server) call(index int, args []object.Object) (object.Object, error) {
+	globals := make([]object.Object, len(s.globals))
+	program := &object.Program{Constants: s.constants, Globals: globals, Builtins: object.Context{Out: s.Output, Imports: s.imports}}
+	copies := map[object.Object]object.Object{}
+	for i, global := range s.globals {
+		if global != nil {
+			globals[i] = copyValue(global, program, copies)
+		}
+	}
+
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	return vm.Call(ctx, program, globals[index], args...)
+}
+
+// copyValue returns a copy of a value that calls can change without changing
+// it, such as an array a builtin appends to. Values reachable more than once
+// are copied once, through copies. Closures are copied into program, so those
+// called back by extensions see the copied globals.
+func copyValue(value object.Object, program *object.Program, copies map[object.Object]object.Object) object.Object {
+	if copied, ok := copies[value]; ok {
+		return copied
+	}
+
+	switch value := value.(type) {
+	case *object.Array:
+		copied := &object.Array{Elements: make([]object.Object, len(value.Elements))}
+		copies[value] = copied
+		for i, element := range value.Elements {
+			copied.Elements[i] = copyValue(element, program, copies)
+		}
+		return copied
+	case *object.Hash:
+		copied := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair, len(value.Pairs))}
+		copies[value] = copied
+		for key, pair := range value.Pairs {
+			copied.Pairs[key] = object.HashPair{Key: pair.Key, Value: copyValue(pair.Value, program, copies)}
+		}
+		return copied
+	case *object.Closure:
+		copied := &object.Closure{Fn: value.Fn, Free: make([]object.Object, len(value.Free)), Program: program}
+		copies[value] = copied
+		for i, free := range value.Free {
+			copied.Free[i] = copyValue(free, program, copies)
+		}
+		return copied
+	default:
+		// Other values cannot be changed
+		return value
+	}
+}
+
+// Serve accepts connections on l and serves each of them until l is closed
+func (s *Server) Serve(l net.Listener) error {
+	fns := s.Functions()
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			_ = exthost.Serve(conn, conn, fns)
+		}()
+	}
+}
+
+// Listen listens on addr, a unix socket when it starts with unix: and a TCP
+// address otherwise. A socket left by a server that did not close it is
+// replaced.
+func Listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("listen unix %s: a server is already listening", path)
+			}
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("no address to listen on")
+	}
+	return net.Listen("tcp", addr)
+}
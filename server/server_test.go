@@ -0,0 +1,122 @@
+package server
+
+import (
+	"monkey/exthost"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+const script = `# Adds two numbers.
+let add = fn(a, b) { a + b };
+let items = [1];
+let append = fn(x) { push(items, x); len(items) };
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+let answer = 42;`
+
+// load is a helper function that loads a script
+func load(t *testing.T, input string) *Server {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	s, err := Load(program, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	return s
+}
+
+func TestFunctions(t *testing.T) {
+	s := load(t, script)
+	s.Timeout = 50 * time.Millisecond
+
+	if names := strings.Join(s.Names(), " "); names != "add append fib" {
+		t.Fatalf("wrong functions. got=%s", names)
+	}
+
+	fns := map[string]object.Plugin{}
+	for _, fn := range s.Functions() {
+		fns[fn.Name] = fn
+	}
+	if fns["add"].Usage() != "add(a, b)" || fns["add"].Doc != "Adds two numbers." {
+		t.Errorf("wrong metadata. got usage=%q doc=%q", fns["add"].Usage(), fns["add"].Doc)
+	}
+
+	if result, ok := fns["add"].Fn(&object.Integer{Value: 2}, &object.Integer{Value: 3}).(*object.Integer); !ok || result.Value != 5 {
+		t.Errorf("wrong result of add. got=%v", result)
+	}
+
+	// Every call starts from the globals the script left
+	for i := 0; i < 2; i++ {
+		if result, ok := fns["append"].Fn(&object.Integer{Value: 2}).(*object.Integer); !ok || result.Value != 2 {
+			t.Errorf("call %d saw the changes of an earlier call. got=%v", i, result)
+		}
+	}
+
+	errObj, ok := fns["fib"].Fn(&object.Integer{Value: 40}).(*object.Error)
+	if !ok || !strings.Contains(errObj.Message, "context deadline exceeded") {
+		t.Errorf("call not stopped by its timeout. got=%v", errObj)
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := load(t, "export let double = fn(x) { x * 2 };\nlet hidden = fn() { 1 };")
+
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	defer conn.Close()
+
+	roundTrip := func(call object.FunctionCall) object.FunctionResponse {
+		data, err := object.SerializeFunctionCall(call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exthost.WriteMessage(conn, data); err != nil {
+			t.Fatal(err)
+		}
+		data, err = exthost.ReadMessage(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := object.DeserializeFunctionResponse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	data, _ := msgpack.Marshal(roundTrip(object.FunctionCall{Type: "describe"}).Result)
+	var specs []object.FunctionSpec
+	if err := msgpack.Unmarshal(data, &specs); err != nil || len(specs) != 1 || specs[0].Name != "double" {
+		t.Errorf("wrong description. got=%+v (%v)", specs, err)
+	}
+
+	resp := roundTrip(object.FunctionCall{Type: "call", Name: "double", Args: []interface{}{21}})
+	if resp.Error != nil || resp.Result != int64(42) {
+		t.Errorf("wrong response. got=%+v", resp)
+	}
+
+	resp = roundTrip(object.FunctionCall{Type: "call", Name: "hidden"})
+	if resp.Error == nil || *resp.Error != "unknown function hidden" {
+		t.Errorf("unexported function served. got=%+v", resp)
+	}
+}
@@ -0,0 +1,12 @@
+// std/std.go
+
+// Package std holds the standard library of Monkey modules, embedded in the
+// interpreter so `import "std/strings";` works without any files on disk.
+package std
+
+import "embed"
+
+// FS holds the source of the standard library modules
+//
+//go:embed *.mky
+var FS embed.FS
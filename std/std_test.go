@@ -0,0 +1,34 @@
+package std
+
+import (
+	"io/fs"
+	"monkey/lexer"
+	"monkey/parser"
+	"testing"
+)
+
+// TestModules tests that every module of the standard library parses and
+// exports its functions
+func TestModules(t *testing.T) {
+	files, err := fs.Glob(FS, "*.mky")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no modules found: %v", err)
+	}
+
+	for _, file := range files {
+		source, err := fs.ReadFile(FS, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := parser.New(lexer.New(string(source)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("%s: parser errors: %v", file, p.Errors())
+			continue
+		}
+		if len(program.Exports()) == 0 {
+			t.Errorf("%s exports nothing", file)
+		}
+	}
+}
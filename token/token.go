@@ -1,35 +1,45 @@
 package token
 
+import "sort"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
 	Line    int
+	Column  int // 1-based column of the first character of the token
 }
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // # to the end of the line, skipped by the lexer
 
 	// Identifiers + literals
-	IDENT  = "IDENT"  // add, foobar, x, y, ...
-	INT    = "INT"    // 1234567890
-	FLOAT  = "FLOAT"  // 1.2345679
-	STRING = "STRING" // "foobar"
+	IDENT    = "IDENT"    // add, foobar, x, y, ...
+	INT      = "INT"      // 1234567890
+	FLOAT    = "FLOAT"    // 1.2345679
+	STRING   = "STRING"   // "foobar"
+	CHECKSUM = "CHECKSUM" // sha256:<hex>, after the path of an import
 
 	// Operators
-	ASSIGN   = "="
-	PLUS     = "+"
-	MINUS    = "-"
-	BANG     = "!"
-	ASTERISK = "*"
-	SLASH    = "/"
+	ASSIGN    = "="
+	PLUS      = "+"
+	MINUS     = "-"
+	BANG      = "!"
+	ASTERISK  = "*"
+	SLASH     = "/"
+	FLOOR_DIV = "//"
+	POWER     = "**"
+	AND       = "&&"
+	OR        = "||"
 
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	DOT       = "."
 
 	LPAREN = "("
 	RPAREN = ")"
@@ -42,30 +52,46 @@ const (
 
 	// Keywords
 	FUNCTION = "FUNCTION"
+	EXPORT   = "EXPORT"
 	IMPORT   = "IMPORT"
 	LET      = "LET"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	NULL     = "NULL"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
 
 	// Comparison operators
 	LT     = "<"
 	GT     = ">"
+	LT_EQ  = "<="
+	GT_EQ  = ">="
 	EQ     = "=="
 	NOT_EQ = "!="
 )
 
 var keywords = map[string]TokenType{
 	"fn":     FUNCTION,
+	"export": EXPORT,
 	"import": IMPORT,
 	"let":    LET,
 	"true":   TRUE,
 	"false":  FALSE,
+	"null":   NULL,
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"while":  WHILE,
+	"for":    FOR,
+	"in":     IN,
+	"try":    TRY,
+	"catch":  CATCH,
 }
 
 // LookupIdent checks the keywords table to see whether the given identifier is
@@ -77,3 +103,13 @@ func LookupIdent(ident string) TokenType {
 	}
 	return IDENT
 }
+
+// Keywords returns the reserved words of the language in alphabetical order
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
+}
@@ -0,0 +1,347 @@
+// trace/trace.go
+
+// Package trace records where a Monkey program spends its time as a trace
+// in the Chrome trace event format, for `monkey run --trace`. Traces open in
+// chrome://tracing, Perfetto and speedscope, which show them as flame
+// graphs.
+//
+// The calls of Monkey functions are recorded as spans, and with Detail so is
+// every statement the evaluator runs or every instruction the VM runs. The
+// memory the interpreter allocates is sampled as counters. Events are
+// written as they happen, so that the traces of long-running programs are
+// not held in memory.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/evaluator"
+	"monkey/vm"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures what a tracer records
+type Options struct {
+	Detail bool // Detail also records every statement the evaluator runs or every instruction the VM runs
+}
+
+// sampleInterval is the time between two samples of the memory allocated
+const sampleInterval = time.Millisecond
+
+// maxName is the length statements are cut to in the names of their spans
+const maxName = 60
+
+// Tracer writes the events of a program running with its hooks installed.
+// Close must be called once the program is done.
+type Tracer struct {
+	mutex  sync.Mutex
+	w      *bufio.Writer
+	err    error // err is the first error writing the trace
+	events int   // events is the number of events written
+	detail bool
+
+	start time.Time
+	now   func() time.Time
+	open  []string // open holds the names of the spans begun and not ended yet, innermost last
+
+	allocs     []metrics.Sample // allocs holds the memory allocated when the trace started
+	lastSample time.Time
+
+	frames      int          // frames is the number of VM frames seen active
+	instruction *instruction // instruction is the VM instruction running, recorded once the next one starts
+}
+
+// instruction is a VM instruction that started running
+type instruction struct {
+	name  string
+	line  int
+	start time.Time
+}
+
+// event is an event of the Chrome trace event format. Times are in
+// microseconds since the trace started.
+type event struct {
+	Name     string                 `json:"name"`
+	Category string                 `json:"cat,omitempty"`
+	Phase    string                 `json:"ph"`
+	Time     float64                `json:"ts"`
+	Duration float64                `json:"dur,omitempty"`
+	Process  int                    `json:"pid"`
+	Thread   int                    `json:"tid"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+}
+
+// New returns a tracer writing the trace to w
+func New(w io.Writer, opts Options) *Tracer {
+	t := &Tracer{
+		w:      bufio.NewWriter(w),
+		detail: opts.Detail,
+		now:    time.Now,
+		allocs: allocSamples(),
+	}
+	t.start = t.now()
+	t.lastSample = t.start
+	metrics.Read(t.allocs)
+
+	t.write(event{Name: "process_name", Phase: "M", Process: 1, Thread: 1, Args: map[string]interface{}{"name": "monkey"}})
+	t.write(event{Name: "thread_name", Phase: "M", Process: 1, Thread: 1, Args: map[string]interface{}{"name": "main"}})
+	return t
+}
+
+// allocSamples is a helper function that returns the metrics of the memory
+// allocated sampled by tracers
+func allocSamples() []metrics.Sample {
+	return []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}, {Name: "/gc/heap/allocs:objects"}}
+}
+
+// EvaluatorHooks returns the evaluator hooks feeding the tracer
+func (t *Tracer) EvaluatorHooks() *evaluator.Hooks {
+	hooks := &evaluator.Hooks{OnCall: t.onCall, OnReturn: t.onReturn}
+	if t.detail {
+		hooks.OnStatement = t.onStatement
+		hooks.OnStatementDone = t.onStatementDone
+	}
+	return hooks
+}
+
+// VMHooks returns the VM hooks feeding the tracer
+func (t *Tracer) VMHooks() *vm.Hooks {
+	return &vm.Hooks{OnInstruction: t.onInstruction}
+}
+
+// onCall begins the span of a function called by the evaluator
+func (t *Tracer) onCall(ev evaluator.CallEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	t.begin(functionName(ev.Name), "function", now, location(ev.File, ev.Line))
+	t.sample(now)
+}
+
+// onReturn ends the span of the function returning
+func (t *Tracer) onReturn(ev evaluator.CallEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	t.end(now)
+	t.sample(now)
+}
+
+// onStatement begins the span of a statement run by the evaluator
+func (t *Tracer) onStatement(ev evaluator.StatementEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	t.begin(statementName(ev.Statement), "statement", now, location(ev.File, ev.Line))
+	t.sample(now)
+}
+
+// onStatementDone ends the span of the statement evaluated
+func (t *Tracer) onStatementDone(ev evaluator.StatementEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.end(t.now())
+}
+
+// onInstruction records the instruction run by the VM before, and the calls
+// and returns since. Calls and returns are noticed from the frame depth,
+// which changes by one between two instructions.
+func (t *Tracer) onInstruction(ev vm.InstructionEvent) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	t.endInstruction(now)
+
+	for t.frames > ev.FrameDepth {
+		t.frames--
+		t.end(now)
+	}
+	for t.frames < ev.FrameDepth {
+		t.frames++
+		name := functionName(ev.Function.Name)
+		if t.frames == 1 {
+			name = "<main>"
+		}
+		t.begin(name, "function", now, location("", ev.Function.Lines.LineFor(0)))
+	}
+
+	if t.detail {
+		name := "unknown"
+		if def, err := code.Lookup(byte(ev.Op)); err == nil {
+			name = def.Name
+		}
+		t.instruction = &instruction{name: name, line: ev.Function.Lines.LineFor(ev.IP), start: now}
+	}
+	t.sample(now)
+}
+
+// endInstruction records the instruction running, if any, as done at now
+func (t *Tracer) endInstruction(now time.Time) {
+	if t.instruction == nil {
+		return
+	}
+	ins := t.instruction
+	t.instruction = nil
+	t.write(event{
+		Name:     ins.name,
+		Category: "instruction",
+		Phase:    "X",
+		Time:     t.micros(ins.start),
+		Duration: float64(now.Sub(ins.start).Nanoseconds()) / 1000,
+		Process:  1,
+		Thread:   1,
+		Args:     location("", ins.line),
+	})
+}
+
+// begin writes the beginning of a span
+func (t *Tracer) begin(name, category string, now time.Time, args map[string]interface{}) {
+	t.open = append(t.open, name)
+	t.write(event{Name: name, Category: category, Phase: "B", Time: t.micros(now), Process: 1, Thread: 1, Args: args})
+}
+
+// end writes the end of the innermost span open, if any
+func (t *Tracer) end(now time.Time) {
+	if len(t.open) == 0 {
+		return
+	}
+	name := t.open[len(t.open)-1]
+	t.open = t.open[:len(t.open)-1]
+	t.write(event{Name: name, Phase: "E", Time: t.micros(now), Process: 1, Thread: 1})
+}
+
+// sample writes the memory allocated since the trace started, at most once
+// per sampleInterval
+func (t *Tracer) sample(now time.Time) {
+	if now.Sub(t.lastSample) < sampleInterval {
+		return
+	}
+	t.writeSample(now)
+}
+
+// writeSample writes counters of the memory allocated since the trace started
+func (t *Tracer) writeSample(now time.Time) {
+	t.lastSample = now
+	samples := allocSamples()
+	metrics.Read(samples)
+
+	for i, counter := range []string{"allocated bytes", "allocated objects"} {
+		if samples[i].Value.Kind() != metrics.KindUint64 || t.allocs[i].Value.Kind() != metrics.KindUint64 {
+			continue
+		}
+		allocated := samples[i].Value.Uint64() - t.allocs[i].Value.Uint64()
+		t.write(event{Name: counter, Category: "memory", Phase: "C", Time: t.micros(now), Process: 1, Thread: 1, Args: map[string]interface{}{"value": allocated}})
+	}
+}
+
+// Close ends the spans still open, such as those of a program stopped by an
+// error, and finishes the trace. It returns the first error writing it.
+func (t *Tracer) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	t.endInstruction(now)
+	for len(t.open) > 0 {
+		t.end(now)
+	}
+	t.frames = 0
+	t.writeSample(now)
+
+	if t.err == nil {
+		_, t.err = t.w.WriteString("\n]\n")
+	}
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	return t.err
+}
+
+// write writes an event of the JSON array of the trace
+func (t *Tracer) write(ev event) {
+	if t.err != nil {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	separator := ",\n"
+	if t.events == 0 {
+		separator = "[\n"
+	}
+	if _, err := t.w.WriteString(separator); err != nil {
+		t.err = err
+		return
+	}
+	if _, err := t.w.Write(data); err != nil {
+		t.err = err
+		return
+	}
+	t.events++
+}
+
+// micros returns the time since the trace started in microseconds
+func (t *Tracer) micros(now time.Time) float64 {
+	return float64(now.Sub(t.start).Nanoseconds()) / 1000
+}
+
+// functionName is a helper function that returns the name of the span of a
+// function
+func functionName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
+}
+
+// statementName is a helper function that returns the name of the span of
+// a statement: the name a let statement binds, or else its source cut to its
+// first line and maxName bytes
+func statementName(stmt ast.Statement) string {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return "let " + stmt.Name.Value
+	case *ast.ReturnStatement:
+		return "return"
+	}
+
+	source := stmt.String()
+	if i := strings.IndexByte(source, '\n'); i >= 0 {
+		source = source[:i] + " ..."
+	}
+	if len(source) > maxName {
+		source = source[:maxName] + "..."
+	}
+	return source
+}
+
+// location is a helper function that returns the arguments of an event
+// with its file, left out for the main program, and its line, left out
+// when it is unknown
+func location(file string, line int) map[string]interface{} {
+	args := map[string]interface{}{}
+	if file != "" {
+		args["file"] = file
+	}
+	if line > 0 {
+		args["line"] = line
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
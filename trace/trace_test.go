@@ -0,0 +1,139 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"testing"
+)
+
+const program = `let double = fn(x) { x * 2 };
+let twice = fn(f, x) { f(f(x)) };
+twice(double, 5);`
+
+// run is a helper function that runs program on engine with a tracer and
+// returns the events of the trace
+func run(t *testing.T, engine string, opts Options) []event {
+	var out bytes.Buffer
+	tracer := New(&out, opts)
+	parsed := parser.New(lexer.New(program)).ParseProgram()
+
+	if engine == "vm" {
+		comp := compiler.New()
+		if err := comp.Compile(parsed); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		machine := vm.New(comp.Bytecode())
+		machine.SetHooks(tracer.VMHooks())
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	} else {
+		env := object.NewEnvironment()
+		env.SetContext(evaluator.WithHooks(nil, tracer.EvaluatorHooks()))
+		result := evaluator.Eval(parsed, env)
+		if errObj, ok := result.(*object.Error); ok {
+			t.Fatalf("evaluation failed: %s", errObj.Message)
+		}
+	}
+
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	var events []event
+	if err := json.Unmarshal(out.Bytes(), &events); err != nil {
+		t.Fatalf("invalid trace: %s\n%s", err, out.String())
+	}
+	return events
+}
+
+// spans is a helper function that returns the names of the spans of a
+// category in the order they begin, checking that the spans are nested
+func spans(t *testing.T, events []event, category string) []string {
+	var names, open []string
+	for _, ev := range events {
+		switch ev.Phase {
+		case "B":
+			open = append(open, ev.Name)
+			if ev.Category == category {
+				names = append(names, ev.Name)
+			}
+		case "E":
+			if len(open) == 0 || open[len(open)-1] != ev.Name {
+				t.Fatalf("span %s ends while %v are open", ev.Name, open)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) != 0 {
+		t.Fatalf("spans left open: %v", open)
+	}
+	return names
+}
+
+func TestCalls(t *testing.T) {
+	tests := []struct {
+		engine   string
+		expected []string
+	}{
+		{"vm", []string{"<main>", "twice", "double", "double"}},
+		{"eval", []string{"twice", "double", "double"}},
+	}
+
+	for _, tt := range tests {
+		events := run(t, tt.engine, Options{})
+		names := spans(t, events, "function")
+		if len(names) != len(tt.expected) {
+			t.Fatalf("%s: wrong calls. want=%v, got=%v", tt.engine, tt.expected, names)
+		}
+		for i, name := range tt.expected {
+			if names[i] != name {
+				t.Errorf("%s: wrong call %d. want=%s, got=%s", tt.engine, i, name, names[i])
+			}
+		}
+
+		counters := 0
+		for _, ev := range events {
+			if ev.Phase == "C" {
+				counters++
+			}
+			if ev.Category == "instruction" || ev.Category == "statement" {
+				t.Errorf("%s: unexpected detail event %+v", tt.engine, ev)
+			}
+		}
+		if counters == 0 {
+			t.Errorf("%s: expected allocation counters", tt.engine)
+		}
+	}
+}
+
+func TestDetail(t *testing.T) {
+	statements := spans(t, run(t, "eval", Options{Detail: true}), "statement")
+	expected := []string{"let double", "let twice", "twice(double, 5)", "f(f(x))", "(x * 2)", "(x * 2)"}
+	if len(statements) != len(expected) {
+		t.Fatalf("wrong statements. want=%v, got=%v", expected, statements)
+	}
+	for i, name := range expected {
+		if statements[i] != name {
+			t.Errorf("wrong statement %d. want=%q, got=%q", i, name, statements[i])
+		}
+	}
+
+	instructions := map[string]int{}
+	for _, ev := range run(t, "vm", Options{Detail: true}) {
+		if ev.Category == "instruction" {
+			if ev.Phase != "X" || ev.Duration < 0 {
+				t.Errorf("wrong instruction event %+v", ev)
+			}
+			instructions[ev.Name]++
+		}
+	}
+	if instructions["OpCall"] != 3 || instructions["OpReturnValue"] != 3 || instructions["OpMul"] != 2 {
+		t.Errorf("wrong instructions. got=%v", instructions)
+	}
+}
@@ -0,0 +1,403 @@
+// viz/viz.go
+
+// Package viz renders programs as Graphviz DOT graphs: the syntax tree as
+// parsed and the control flow of the compiled bytecode, one basic block per
+// node
+package viz
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"strings"
+)
+
+// Graphs selects what a DOT document shows
+type Graphs int
+
+const (
+	AST      Graphs = 1 << iota // AST draws the syntax tree
+	Bytecode                    // Bytecode draws the control flow of every compiled function
+	All      = AST | Bytecode
+)
+
+// Dot renders the selected graphs of a program and its bytecode as a single
+// DOT digraph, each graph in a cluster of its own. The bytecode may be nil
+// when only the AST is drawn.
+func Dot(program *ast.Program, bytecode *compiler.Bytecode, graphs Graphs) string {
+	g := &graph{}
+
+	g.line("digraph program {")
+	g.line(`  node [shape=box, fontname="monospace"];`)
+	if graphs&AST != 0 {
+		g.ast(program)
+	}
+	if graphs&Bytecode != 0 && bytecode != nil {
+		g.bytecode(bytecode)
+	}
+	g.line("}")
+
+	return g.out.String()
+}
+
+// graph accumulates the lines of a DOT document
+type graph struct {
+	out strings.Builder
+	ids int
+}
+
+// line writes a formatted line of DOT
+func (g *graph) line(format string, a ...interface{}) {
+	fmt.Fprintf(&g.out, format+"\n", a...)
+}
+
+// node declares a node with the given label and returns its id
+func (g *graph) node(label string, attrs string) string {
+	g.ids++
+	id := fmt.Sprintf("n%d", g.ids)
+	if attrs != "" {
+		attrs = ", " + attrs
+	}
+	g.line("    %s [label=%s%s];", id, quote(label), attrs)
+	return id
+}
+
+// edge connects two nodes, labelling the edge unless label is empty
+func (g *graph) edge(from, to, label string, attrs string) {
+	if label != "" {
+		attrs = strings.TrimPrefix(attrs+", label="+quote(label), ", ")
+	}
+	if attrs != "" {
+		g.line("    %s -> %s [%s];", from, to, attrs)
+		return
+	}
+	g.line("    %s -> %s;", from, to)
+}
+
+// ast draws the syntax tree of the program in a cluster
+func (g *graph) ast(program *ast.Program) {
+	g.line("  subgraph cluster_ast {")
+	g.line(`    label="AST";`)
+
+	root := g.node("Program", "")
+	for i, stmt := range program.Statements {
+		g.edge(root, g.astNode(stmt), fmt.Sprintf("%d", i), "")
+	}
+
+	g.line("  }")
+}
+
+// astNode draws a node and its children, returning the id of the node
+func (g *graph) astNode(node ast.Node) string {
+	var label string
+	var children []astChild
+	child := func(name string, node ast.Node) {
+		if node != nil {
+			children = append(children, astChild{name, node})
+		}
+	}
+
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		label = "let " + node.Name.Value
+		child("value", node.Value)
+	case *ast.DestructureStatement:
+		label = "let " + node.Pattern()
+		child("value", node.Value)
+	case *ast.ExportStatement:
+		label = strings.TrimSuffix(node.String(), ";")
+	case *ast.AssignStatement:
+		label = node.Name.Value + " ="
+		child("value", node.Value)
+	case *ast.ReturnStatement:
+		label = "return"
+		child("value", node.ReturnValue)
+	case *ast.ExpressionStatement:
+		label = "ExpressionStatement"
+		child("", node.Expression)
+	case *ast.BlockStatement:
+		label = "Block"
+		for i, stmt := range node.Statements {
+			child(fmt.Sprintf("%d", i), stmt)
+		}
+	case *ast.WhileStatement:
+		label = "while"
+		child("condition", node.Condition)
+		child("body", node.Body)
+	case *ast.ForStatement:
+		label = "for " + node.Variable.Value
+		child("iterable", node.Iterable)
+		child("body", node.Body)
+	case *ast.Identifier:
+		label = node.Value
+	case *ast.IntegerLiteral:
+		label = node.Token.Literal
+	case *ast.FloatLiteral:
+		label = node.Token.Literal
+	case *ast.StringLiteral:
+		label = `"` + node.Value + `"`
+	case *ast.Boolean:
+		label = node.Token.Literal
+	case *ast.NullLiteral:
+		label = node.Token.Literal
+	case *ast.ImportLiteral:
+		label = "import " + node.String()
+	case *ast.PrefixExpression:
+		label = "Prefix " + node.Operator
+		child("right", node.Right)
+	case *ast.InfixExpression:
+		label = "Infix " + node.Operator
+		child("left", node.Left)
+		child("right", node.Right)
+	case *ast.TryExpression:
+		label = "try catch (" + node.Variable.Value + ")"
+		child("try", node.Block)
+		child("catch", node.Handler)
+	case *ast.IfExpression:
+		label = "if"
+		child("condition", node.Condition)
+		child("then", node.Consequence)
+		if node.Alternative != nil {
+			child("else", node.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		params := make([]string, len(node.Parameters))
+		for i, param := range node.Parameters {
+			params[i] = param.Value
+		}
+		label = fmt.Sprintf("fn %s(%s)", node.Name, strings.Join(params, ", "))
+		child("body", node.Body)
+	case *ast.CallExpression:
+		label = "Call"
+		child("function", node.Function)
+		for i, arg := range node.Arguments {
+			child(fmt.Sprintf("arg %d", i), arg)
+		}
+	case *ast.MemberCallExpression:
+		label = "." + node.Method + "()"
+		child("receiver", node.Receiver)
+		for i, arg := range node.Arguments {
+			child(fmt.Sprintf("arg %d", i), arg)
+		}
+	case *ast.MemberExpression:
+		label = "." + node.Field
+		child("left", node.Left)
+	case *ast.IndexExpression:
+		label = "Index"
+		child("left", node.Left)
+		child("index", node.Index)
+	case *ast.SliceExpression:
+		label = "Slice"
+		child("left", node.Left)
+		child("low", node.Low)
+		child("high", node.High)
+	case *ast.TupleLiteral:
+		label = "Tuple"
+		for i, element := range node.Elements {
+			child(fmt.Sprintf("%d", i), element)
+		}
+	case *ast.ArrayLiteral:
+		label = "Array"
+		for i, element := range node.Elements {
+			child(fmt.Sprintf("%d", i), element)
+		}
+	case *ast.HashLiteral:
+		label = "Hash"
+		for _, key := range node.Keys {
+			child("key", key)
+			child("value", node.Pairs[key])
+		}
+	case *ast.TensorLiteral:
+		label = "Tensor"
+		child("shape", node.Shape)
+		child("data", node.Data)
+	default:
+		label = fmt.Sprintf("%T", node)
+	}
+
+	id := g.node(label, "")
+	for _, c := range children {
+		g.edge(id, g.astNode(c.node), c.name, "")
+	}
+	return id
+}
+
+// astChild is a child of an AST node and the field it is found in
+type astChild struct {
+	name string
+	node ast.Node
+}
+
+// instruction is a decoded instruction of a function
+type instruction struct {
+	pos      int
+	op       code.Opcode
+	operands []int
+	next     int // next is the position of the following instruction
+}
+
+// basicBlock is a run of instructions that is only entered at its first
+// instruction and only left after its last one
+type basicBlock struct {
+	id           string
+	instructions []instruction
+}
+
+// closure is an OpClosure instruction, found in block, creating a closure
+// over the function at the given constant index
+type closure struct {
+	block    string
+	constant int
+}
+
+// bytecode draws the control flow graph of the main program and of every
+// compiled function in the constant pool
+func (g *graph) bytecode(bytecode *compiler.Bytecode) {
+	entries := map[int]string{} // entries maps a constant index to the entry block of its function
+	var closures []closure
+
+	draw := func(name string, ins code.Instructions, cluster string) string {
+		g.line("  subgraph %s {", cluster)
+		g.line("    label=%s;", quote(name))
+		entry := g.flowGraph(ins, &closures)
+		g.line("  }")
+		return entry
+	}
+
+	draw("<main>", bytecode.Instructions, "cluster_main")
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		name := fn.Name
+		if name == "" {
+			name = fmt.Sprintf("<anonymous %d>", i)
+		}
+		entries[i] = draw(name, fn.Instructions, fmt.Sprintf("cluster_fn%d", i))
+	}
+
+	// Link the blocks creating closures to the functions they close over
+	for _, c := range closures {
+		if entry, ok := entries[c.constant]; ok {
+			g.line("  %s -> %s [style=dashed, label=\"closure\"];", c.block, entry)
+		}
+	}
+}
+
+// flowGraph draws the basic blocks of a function and the jumps between them,
+// recording the OpClosure instructions it finds in closures. It returns the id
+// of the entry block.
+func (g *graph) flowGraph(ins code.Instructions, closures *[]closure) string {
+	blocks := basicBlocks(decode(ins))
+	if len(blocks) == 0 {
+		return g.node("(empty)", "")
+	}
+
+	starts := map[int]string{}
+	for _, block := range blocks {
+		lines := make([]string, len(block.instructions))
+		for i, in := range block.instructions {
+			lines[i] = fmt.Sprintf("%04d %s", in.pos, ins.Disassemble(in.pos))
+		}
+		block.id = g.node(strings.Join(lines, "\n")+"\n", "")
+		starts[block.instructions[0].pos] = block.id
+
+		for _, in := range block.instructions {
+			if in.op == code.OpClosure {
+				*closures = append(*closures, closure{block: block.id, constant: in.operands[0]})
+			}
+		}
+	}
+
+	for i, block := range blocks {
+		last := block.instructions[len(block.instructions)-1]
+		next, hasNext := "", i+1 < len(blocks)
+		if hasNext {
+			next = blocks[i+1].id
+		}
+
+		switch last.op {
+		case code.OpJump:
+			g.edge(block.id, starts[last.operands[0]], "", "")
+		case code.OpJumpNotTruthy:
+			if hasNext {
+				g.edge(block.id, next, "true", "")
+			}
+			g.edge(block.id, starts[last.operands[0]], "false", "")
+		case code.OpIterNext:
+			if hasNext {
+				g.edge(block.id, next, "next", "")
+			}
+			g.edge(block.id, starts[last.operands[0]], "done", "")
+		case code.OpTry:
+			if hasNext {
+				g.edge(block.id, next, "try", "")
+			}
+			g.edge(block.id, starts[last.operands[0]], "catch", "")
+		case code.OpReturnValue, code.OpReturn:
+		default:
+			if hasNext {
+				g.edge(block.id, next, "", "")
+			}
+		}
+	}
+
+	return blocks[0].id
+}
+
+// decode splits instructions into their opcodes and operands
+func decode(ins code.Instructions) []instruction {
+	var decoded []instruction
+	for pos := 0; pos < len(ins); {
+		def, err := code.Lookup(ins[pos])
+		if err != nil {
+			decoded = append(decoded, instruction{pos: pos, op: code.Opcode(ins[pos]), next: pos + 1})
+			pos++
+			continue
+		}
+
+		operands, read := code.ReadOperands(def, ins[pos+1:])
+		decoded = append(decoded, instruction{pos: pos, op: code.Opcode(ins[pos]), operands: operands, next: pos + 1 + read})
+		pos += 1 + read
+	}
+	return decoded
+}
+
+// basicBlocks groups instructions into basic blocks. A block starts at the
+// first instruction, at every jump target and after every jump or return.
+func basicBlocks(instructions []instruction) []*basicBlock {
+	leaders := map[int]bool{}
+	if len(instructions) > 0 {
+		leaders[instructions[0].pos] = true
+	}
+	for _, in := range instructions {
+		switch in.op {
+		case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext, code.OpTry:
+			leaders[in.operands[0]] = true
+			leaders[in.next] = true
+		case code.OpReturnValue, code.OpReturn:
+			leaders[in.next] = true
+		}
+	}
+
+	var blocks []*basicBlock
+	for _, in := range instructions {
+		if leaders[in.pos] || len(blocks) == 0 {
+			blocks = append(blocks, &basicBlock{})
+		}
+		current := blocks[len(blocks)-1]
+		current.instructions = append(current.instructions, in)
+	}
+	return blocks
+}
+
+// quote returns s as a DOT string with lines left aligned
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\l`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,105 @@
+package viz
+
+import (
+	"monkey/ast"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestDotAST(t *testing.T) {
+	program := parse(t, `let x = 1 + "a\b";`)
+
+	out := Dot(program, nil, All)
+
+	expected := []string{
+		"digraph program {",
+		"subgraph cluster_ast {",
+		`n2 [label="let x"];`,
+		`n3 [label="Infix +"];`,
+		`n5 [label="\"a\\b\""];`,
+		`n3 -> n4 [label="left"];`,
+		`n2 -> n3 [label="value"];`,
+		`n1 -> n2 [label="0"];`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "cluster_main") {
+		t.Errorf("bytecode drawn without bytecode:\n%s", out)
+	}
+}
+
+func TestDotBytecode(t *testing.T) {
+	program := parse(t, `let max = fn(a, b) { if (a > b) { a } else { b } }; max(1, 2);`)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	out := Dot(program, comp.Bytecode(), Bytecode)
+
+	expected := []string{
+		"subgraph cluster_main {",
+		"subgraph cluster_fn0 {",
+		`label="max";`,
+		`n2 -> n3 [label="true"];`,
+		`n2 -> n4 [label="false"];`,
+		"n3 -> n5;",
+		"n4 -> n5;",
+		`n1 -> n2 [style=dashed, label="closure"];`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "cluster_ast") {
+		t.Errorf("AST drawn when only bytecode was asked for:\n%s", out)
+	}
+}
+
+func TestBasicBlocks(t *testing.T) {
+	ins := code.Instructions{}
+	for _, in := range [][]byte{
+		code.Make(code.OpTrue),              // 0000
+		code.Make(code.OpJumpNotTruthy, 10), // 0001
+		code.Make(code.OpConstant, 0),       // 0004
+		code.Make(code.OpJump, 11),          // 0007
+		code.Make(code.OpNull),              // 0010
+		code.Make(code.OpPop),               // 0011
+	} {
+		ins = append(ins, in...)
+	}
+
+	blocks := basicBlocks(decode(ins))
+
+	expected := [][]int{{0, 1}, {4, 7}, {10}, {11}}
+	if len(blocks) != len(expected) {
+		t.Fatalf("wrong number of blocks. want=%d, got=%d", len(expected), len(blocks))
+	}
+	for i, positions := range expected {
+		if len(blocks[i].instructions) != len(positions) {
+			t.Fatalf("block %d has wrong length. want=%d, got=%d", i, len(positions), len(blocks[i].instructions))
+		}
+		for j, pos := range positions {
+			if got := blocks[i].instructions[j].pos; got != pos {
+				t.Errorf("block %d instruction %d at wrong position. want=%d, got=%d", i, j, pos, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// vm/call.go
+
+package vm
+
+import (
+	"context"
+	"errors"
+	"monkey/code"
+	"monkey/object"
+	"sync"
+)
+
+func init() {
+	object.RegisterCaller(object.CLOSURE_OBJ, callClosure)
+}
+
+// machines holds the VMs Call runs functions on, so calling back a function
+// many times does not allocate a stack for every call
+var machines = sync.Pool{New: func() interface{} {
+	return &VM{stack: make([]object.Object, StackeSize), frames: make([]*Frame, MaxFrames)}
+}}
+
+// Call calls fn, a closure or a builtin, with args on a VM and returns its
+// result. The VM runs program, the constants of the bytecode fn was compiled
+// in and the globals, which the call can change, and its builtins are called
+// in the context of program.
+func Call(ctx context.Context, program *object.Program, fn object.Object, args ...object.Object) (object.Object, error) {
+	builtins := program.Builtins
+	builtins.Engine = engine{}
+	return call(ctx, program, &builtins, fn, args)
+}
+
+// call is a helper function that calls fn with args like Call, with the
+// builtins called in builtins
+func call(ctx context.Context, program *object.Program, builtins *object.Context, fn object.Object, args []object.Object) (object.Object, error) {
+	if len(args) > 255 {
+		return nil, errors.New("too many arguments")
+	}
+
+	machine := machines.Get().(*VM)
+	defer machine.release()
+
+	machine.constants, machine.globals = program.Constants, program.Globals
+	machine.program = program
+	machine.builtins = *builtins
+
+	instructions := append(code.Make(code.OpCall, len(args)), code.Make(code.OpPop)...)
+	machine.frames[0] = NewFrame(&object.Closure{Fn: &object.CompiledFunction{Instructions: instructions, Name: "<main>"}}, 0)
+	machine.framesIndex = 1
+
+	machine.stack[0] = fn
+	copy(machine.stack[1:], args)
+	machine.sp = 1 + len(args)
+
+	if err := machine.RunContext(ctx); err != nil {
+		return nil, err
+	}
+	result := machine.LastPoppedStackElem()
+	if result == nil {
+		result = Null
+	}
+	return result, nil
+}
+
+// release is a helper function that clears a VM used by Call, so it keeps
+// no value alive, and puts it back in the pool
+func (vm *VM) release() {
+	for i := range vm.stack {
+		vm.stack[i] = nil
+	}
+	for i := range vm.frames {
+		vm.frames[i] = nil
+	}
+	vm.constants, vm.globals, vm.program, vm.hooks = nil, nil, nil, nil
+	vm.builtins = object.Context{}
+	vm.sp, vm.framesIndex = 0, 0
+	vm.handlers = vm.handlers[:0]
+	machines.Put(vm)
+}
+
+// callClosure calls back a closure passed out of the VM, such as to an
+// extension, on the program it was created by
+func callClosure(fn object.Object, args ...object.Object) object.Object {
+	cl := fn.(*object.Closure)
+	if cl.Program == nil {
+		return &object.Error{Message: "closure cannot be called outside of its program"}
+	}
+
+	result, err := Call(context.Background(), cl.Program, cl, args...)
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+// vm/engine.go
+
+package vm
+
+import (
+	"context"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+)
+
+// engine is the Engine of the contexts the VM calls builtins with. It runs
+// the calls of the builtins on VMs of their own, which share the program and
+// globals of the closures called.
+type engine struct{}
+
+// noProgram is the program builtins called back without a closure run in
+var noProgram = &object.Program{}
+
+// Call calls fn, a closure or a builtin, with args in ctx on a VM
+func (engine) Call(ctx *object.Context, fn object.Object, args ...object.Object) object.Object {
+	program := noProgram
+	if cl, ok := fn.(*object.Closure); ok {
+		if cl.Program == nil {
+			return &object.Error{Message: "closure cannot be called outside of its program"}
+		}
+		program = cl.Program
+	}
+
+	result, err := call(background(ctx), program, ctx, fn, args)
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+// Eval compiles src and runs it in ctx on a VM, with globals of its own
+func (engine) Eval(ctx *object.Context, src string) object.Object {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return &object.Error{Message: errors[0].Message}
+	}
+
+	comp := compiler.New()
+	comp.SetResolver(ctx.Resolver())
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	// The program has globals of its own, even when evaluated by pmap
+	own := *ctx
+	own.Parallel = false
+	machine := New(comp.Bytecode())
+	machine.SetContext(own)
+	if err := machine.RunContext(background(ctx)); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	if result := machine.LastPoppedStackElem(); result != nil {
+		return result
+	}
+	return Null
+}
+
+// background is a helper function that returns the cancellation context of
+// ctx, or the background context when it has none
+func background(ctx *object.Context) context.Context {
+	if ctx.Context == nil {
+		return context.Background()
+	}
+	return ctx.Context
+}
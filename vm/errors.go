@@ -0,0 +1,37 @@
+// vm/errors.go
+
+package vm
+
+import (
+	"fmt"
+	"monkey/object"
+)
+
+// RuntimeError is an error raised while executing bytecode
+type RuntimeError struct {
+	Message string
+	Line    int      // Line is the source line being executed, 0 when unknown
+	Stack   []string // Stack lists the active calls, innermost first
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Message
+}
+
+// runtimeError wraps err with the current line and call stack of the VM
+func (vm *VM) runtimeError(err error) *RuntimeError {
+	frame := vm.currentFrame()
+	rtErr := &RuntimeError{Message: err.Error(), Line: frame.cl.Fn.Lines.LineFor(frame.ip)}
+
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		name := vm.frames[i].cl.Fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		caller := vm.frames[i-1]
+		rtErr.Stack = append(rtErr.Stack, fmt.Sprintf("at %s (line %d)", name, caller.cl.Fn.Lines.LineFor(caller.ip)))
+	}
+	rtErr.Stack = object.CompactStack(rtErr.Stack)
+
+	return rtErr
+}
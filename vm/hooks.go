@@ -0,0 +1,58 @@
+// vm/hooks.go
+
+package vm
+
+import (
+	"monkey/code"
+	"monkey/object"
+)
+
+// InstructionEvent describes an instruction that is about to be executed
+type InstructionEvent struct {
+	Instructions code.Instructions // Instructions of the current frame
+	IP           int               // IP is the position of the instruction in Instructions
+	Op           code.Opcode
+	StackDepth   int // StackDepth is the number of values on the stack
+	FrameDepth   int // FrameDepth is the number of active frames, 1 for the main program
+
+	Function *object.CompiledFunction // Function is the function of the current frame
+}
+
+// Hooks lets callers observe a running VM. Nil hooks are skipped.
+type Hooks struct {
+	OnInstruction func(ev InstructionEvent)
+}
+
+// SetHooks installs the hooks called while the VM runs
+func (vm *VM) SetHooks(hooks *Hooks) {
+	vm.hooks = hooks
+}
+
+// Variables returns the locals and free variables of the current frame by
+// name, leaving out locals that have not been assigned yet. Hooks use it to
+// inspect a paused program.
+func (vm *VM) Variables() map[string]object.Object {
+	frame := vm.currentFrame()
+	variables := map[string]object.Object{}
+
+	for i, name := range frame.cl.Fn.FreeNames {
+		if i < len(frame.cl.Free) {
+			variables[name] = frame.cl.Free[i]
+		}
+	}
+	for i, name := range frame.cl.Fn.LocalNames {
+		if value := vm.stack[frame.basePointer+i]; name != "" && value != nil {
+			variables[name] = value
+		}
+	}
+
+	return variables
+}
+
+// Global returns the global variable at index, or nil if it is not set
+func (vm *VM) Global(index int) object.Object {
+	if index < 0 || index >= len(vm.globals) {
+		return nil
+	}
+	return vm.globals[index]
+}
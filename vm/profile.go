@@ -0,0 +1,145 @@
+// vm/profile.go
+
+package vm
+
+import (
+	"fmt"
+	"io"
+	"monkey/object"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// FunctionProfile is what a Profiler measured for a single function
+type FunctionProfile struct {
+	Name         string
+	Calls        int
+	Instructions int           // Instructions is the number executed by the function itself
+	Self         time.Duration // Self is the time spent in the function itself
+	Total        time.Duration // Total also includes the time spent in the functions it calls
+}
+
+// Profiler measures where a VM spends its time, per Monkey function. Install
+// its hooks with SetHooks before running the VM.
+type Profiler struct {
+	functions map[*object.CompiledFunction]*FunctionProfile
+	order     []*object.CompiledFunction // order is the order functions were first called in
+	stack     []profileFrame
+	active    map[*object.CompiledFunction]int // active counts the frames of each function on the stack
+	last      time.Time                        // last is the time of the previous instruction
+	now       func() time.Time
+}
+
+// profileFrame is a call the profiler has seen start but not return
+type profileFrame struct {
+	fn    *object.CompiledFunction
+	start time.Time
+}
+
+// NewProfiler returns a profiler that has not measured anything yet
+func NewProfiler() *Profiler {
+	return &Profiler{
+		functions: map[*object.CompiledFunction]*FunctionProfile{},
+		active:    map[*object.CompiledFunction]int{},
+		now:       time.Now,
+	}
+}
+
+// Hooks returns the VM hooks feeding the profiler
+func (p *Profiler) Hooks() *Hooks {
+	return &Hooks{OnInstruction: p.onInstruction}
+}
+
+// onInstruction charges the time since the previous instruction to the
+// function that ran it. Calls and returns are noticed from the frame depth,
+// which changes by one between two instructions.
+func (p *Profiler) onInstruction(ev InstructionEvent) {
+	now := p.now()
+	if len(p.stack) > 0 {
+		p.profile(p.stack[len(p.stack)-1].fn).Self += now.Sub(p.last)
+	}
+
+	for len(p.stack) > ev.FrameDepth {
+		p.leave(now)
+	}
+	for len(p.stack) < ev.FrameDepth {
+		p.enter(ev.Function, now)
+	}
+
+	p.profile(ev.Function).Instructions++
+	p.last = now
+}
+
+// enter records a call of fn
+func (p *Profiler) enter(fn *object.CompiledFunction, now time.Time) {
+	p.profile(fn).Calls++
+	p.active[fn]++
+	p.stack = append(p.stack, profileFrame{fn: fn, start: now})
+}
+
+// leave records the return of the innermost call. The time of a recursive
+// call is already part of the outermost call of the same function.
+func (p *Profiler) leave(now time.Time) {
+	frame := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	p.active[frame.fn]--
+	if p.active[frame.fn] == 0 {
+		p.profile(frame.fn).Total += now.Sub(frame.start)
+	}
+}
+
+// profile returns the profile of fn, creating it on the first call
+func (p *Profiler) profile(fn *object.CompiledFunction) *FunctionProfile {
+	profile, ok := p.functions[fn]
+	if !ok {
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		profile = &FunctionProfile{Name: name}
+		p.functions[fn] = profile
+		p.order = append(p.order, fn)
+	}
+	return profile
+}
+
+// Profiles returns the profile of every function called, the ones with the
+// most self time first. Calls still running, such as the main program, count
+// up to the last instruction executed.
+func (p *Profiler) Profiles() []FunctionProfile {
+	profiles := make([]FunctionProfile, len(p.order))
+	for i, fn := range p.order {
+		profiles[i] = *p.functions[fn]
+	}
+
+	counted := map[*object.CompiledFunction]bool{}
+	for _, frame := range p.stack {
+		if counted[frame.fn] {
+			continue
+		}
+		counted[frame.fn] = true
+		for i, fn := range p.order {
+			if fn == frame.fn {
+				profiles[i].Total += p.last.Sub(frame.start)
+			}
+		}
+	}
+
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].Self > profiles[j].Self
+	})
+	return profiles
+}
+
+// WriteReport writes the profiles as a table, hottest function first
+func (p *Profiler) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "calls\tinstructions\tself\ttotal\t\tfunction")
+	for _, profile := range p.Profiles() {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t\t%s\n", profile.Calls, profile.Instructions,
+			profile.Self.Round(time.Microsecond), profile.Total.Round(time.Microsecond), profile.Name)
+	}
+	return tw.Flush()
+}
@@ -3,10 +3,15 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
+	"strings"
 )
 
 const StackeSize = 8192
@@ -17,6 +22,14 @@ var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
 var Null = &object.Null{}
 
+// handler is a try block being run, which catches the errors raised until
+// it ends
+type handler struct {
+	framesIndex int // framesIndex is the number of frames when the block started
+	sp          int
+	catchIP     int // catchIP is the position of the catch clause in the frame
+}
+
 type VM struct {
 	constants []object.Object
 
@@ -24,29 +37,44 @@ type VM struct {
 	sp    int // Always points to the next value. Top of stack is stack[sp-1]
 
 	globals []object.Object
+	program *object.Program // program is the constants and globals closures are created with
 
 	frames      []*Frame
 	framesIndex int
+
+	handlers []handler // handlers are the try blocks being run, innermost last
+
+	hooks *Hooks
+
+	numbers  object.Arena   // numbers allocates the results of arithmetic, released after every run
+	builtins object.Context // builtins is the context builtins are called with
+
+	done <-chan struct{} // done stops the program when closed, set by RunContext
+	ctx  context.Context
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Lines: bytecode.Lines, Calls: bytecode.Calls, Name: "<main>"}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
 
+	globals := make([]object.Object, GlobalsSize)
 	return &VM{
 		constants: bytecode.Constants,
 
 		stack: make([]object.Object, StackeSize),
 		sp:    0,
 
-		globals: make([]object.Object, GlobalsSize),
+		globals: globals,
+		program: &object.Program{Constants: bytecode.Constants, Globals: globals},
 
 		frames:      frames,
 		framesIndex: 1,
+
+		builtins: object.Context{Engine: engine{}},
 	}
 }
 
@@ -54,9 +82,26 @@ func New(bytecode *compiler.Bytecode) *VM {
 func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	vm := New(bytecode)
 	vm.globals = s
+	vm.program.Globals = s
 	return vm
 }
 
+// SetOutput sets where the builtins called by the program write, including
+// from the closures it passes out, os.Stdout when w is nil
+func (vm *VM) SetOutput(w io.Writer) {
+	vm.program.Builtins.Out = w
+	vm.builtins.Out = w
+}
+
+// SetContext sets the context the builtins called by the program are called
+// in, including from the closures it passes out. The engine of ctx is
+// replaced by the VM, and its cancellation by the one of RunContext.
+func (vm *VM) SetContext(ctx object.Context) {
+	ctx.Engine = engine{}
+	vm.program.Builtins = ctx
+	vm.builtins = ctx
+}
+
 // StackTop
 func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
@@ -67,19 +112,94 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
-// Run
+// Run executes the bytecode. Errors are returned as a *RuntimeError
+// carrying the call stack at the point of failure.
 func (vm *VM) Run() error {
+	defer vm.numbers.Release()
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		if !vm.catch(err) {
+			return vm.runtimeError(err)
+		}
+	}
+}
+
+// catch is a helper function that unwinds the VM to the innermost try block
+// being run and resumes it at its catch clause with the message of err on the
+// stack. It reports false when no block catches err, as when the context of
+// RunContext is done.
+func (vm *VM) catch(err error) bool {
+	if len(vm.handlers) == 0 || (vm.ctx != nil && vm.ctx.Err() != nil) {
+		vm.handlers = vm.handlers[:0]
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex, vm.sp = h.framesIndex, h.sp
+	vm.currentFrame().ip = h.catchIP - 1
+	return vm.push(&object.String{Value: err.Error()}) == nil
+}
+
+// raise is a helper function that returns the error result of a builtin as
+// a Go error, a runtime error a try block can catch like the evaluator's
+func (vm *VM) raise(result object.Object) error {
+	if errObj, ok := result.(*object.Error); ok {
+		return errors.New(errObj.Message)
+	}
+	return nil
+}
+
+// dropHandlers is a helper function that ends the try blocks of the frames
+// returned from
+func (vm *VM) dropHandlers() {
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].framesIndex > vm.framesIndex {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+}
+
+// RunContext executes the bytecode like Run, stopping it with an error when
+// ctx is done
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.ctx, vm.done = ctx, ctx.Done()
+	defer func() { vm.ctx, vm.done = nil, nil }()
+	return vm.Run()
+}
+
+// checkInterval is the number of instructions executed between two checks
+// of the context of RunContext
+const checkInterval = 1024
+
+// run is a helper function that runs the fetch-decode-execute loop
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
+	var steps int
 
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if steps++; vm.done != nil && steps%checkInterval == 0 {
+			select {
+			case <-vm.done:
+				return fmt.Errorf("execution stopped: %s", vm.ctx.Err())
+			default:
+			}
+		}
+
 		vm.currentFrame().ip++
 
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
 
+		if vm.hooks != nil && vm.hooks.OnInstruction != nil {
+			vm.hooks.OnInstruction(InstructionEvent{Instructions: ins, IP: ip, Op: op, StackDepth: vm.sp, FrameDepth: vm.framesIndex, Function: vm.currentFrame().cl.Fn})
+		}
+
 		// fmt.Printf("ip: %d, ins length: %d\n", ip, len(ins))
 		// fmt.Printf("instruction: %s\n", ins)
 
@@ -92,16 +212,24 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpFloorDiv, code.OpPow:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
 			}
+		case code.OpConcat:
+			numOperands := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			err := vm.executeConcat(numOperands)
+			if err != nil {
+				return err
+			}
 		case code.OpPop:
 			vm.pop()
 		case code.OpTrue:
@@ -140,10 +268,37 @@ func (vm *VM) Run() error {
 			if !isTruthy(condition) {
 				vm.currentFrame().ip = pos - 1
 			}
+		case code.OpIter:
+			iterable := vm.pop()
+			values, ok := object.Iteration(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+
+			err := vm.push(&object.Array{Elements: values})
+			if err != nil {
+				return err
+			}
+			err = vm.push(object.NewInteger(0))
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			err := vm.executeIterNext(pos)
+			if err != nil {
+				return err
+			}
 		case code.OpSetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
+			// The calls pmap makes share the globals of the program
+			if vm.builtins.Parallel {
+				return fmt.Errorf("cannot assign to global variables inside pmap")
+			}
 			vm.globals[globalIndex] = vm.pop()
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
@@ -164,6 +319,32 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpTuple:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp = vm.sp - numElements
+
+			err := vm.push(&object.Tuple{Elements: elements})
+			if err != nil {
+				return err
+			}
+		case code.OpUnpack:
+			numNames := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			values, err := object.Unpack(vm.pop(), numNames)
+			if err != nil {
+				return err
+			}
+			for _, value := range values {
+				err = vm.push(value)
+				if err != nil {
+					return err
+				}
+			}
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -186,6 +367,20 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			left := vm.pop()
+
+			slice, err := object.Slice(left, low, high)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(slice)
+			if err != nil {
+				return err
+			}
 		case code.OpCall:
 			numArgs := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1 // not specifically called out in the book, but seems to fix an off by one error
@@ -195,11 +390,31 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpCallMethod:
+			method := code.ReadUint16(ins[ip+1:])
+			numArgs := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.executeMethodCall(vm.constants[method].(*object.String).Value, int(numArgs))
+			if err != nil {
+				return err
+			}
+
+		case code.OpTry:
+			catchIP := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, sp: vm.sp, catchIP: catchIP})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
+			vm.dropHandlers()
 
 			err := vm.push(returnValue)
 			if err != nil {
@@ -208,6 +423,7 @@ func (vm *VM) Run() error {
 		case code.OpReturn:
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
+			vm.dropHandlers()
 
 			err := vm.push(Null)
 			if err != nil {
@@ -240,6 +456,20 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpGetExtended:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			name := vm.constants[constIndex].(*object.String).Value
+			extended, ok := vm.builtins.Function(name)
+			if !ok {
+				return fmt.Errorf("identifier not found: %s", name)
+			}
+
+			err := vm.push(&extended)
+			if err != nil {
+				return err
+			}
 		case code.OpClosure:
 			constIndex := code.ReadUint16(ins[ip+1:])
 			numFree := code.ReadUint8(ins[ip+3:])
@@ -265,7 +495,13 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpImport:
-			vm.currentFrame().ip += 2
+			vm.currentFrame().ip += 1
+
+			// The module ran when it was linked, the import is only null
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
 		case code.OpTensor:
 			vm.currentFrame().ip += 2
 			data := vm.pop()  // Expect this to be an array
@@ -282,10 +518,40 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// executeIterNext pushes the next value of the loop whose values and index
+// are on top of the stack, advancing the index, or drops them and jumps to
+// pos once every value was iterated over
+func (vm *VM) executeIterNext(pos int) error {
+	values, ok := vm.stack[vm.sp-2].(*object.Array)
+	if !ok {
+		return fmt.Errorf("loop values are not an array: %s", vm.stack[vm.sp-2].Type())
+	}
+	counter, ok := vm.stack[vm.sp-1].(*object.Integer)
+	if !ok {
+		return fmt.Errorf("loop index is not an integer: %s", vm.stack[vm.sp-1].Type())
+	}
+	index := counter.Value
+
+	if index >= int64(len(values.Elements)) {
+		vm.sp -= 2
+		vm.currentFrame().ip = pos - 1
+		return nil
+	}
+
+	vm.stack[vm.sp-1] = object.NewInteger(index + 1)
+	return vm.push(values.Elements[index])
+}
+
 // createTensor
 func createTensor(shape object.Object, data object.Object) (object.Object, error) {
-	var dataElements []float64
-	var shapeElements []int64
+	// Literals of nested data have no shape
+	if shape == Null {
+		dataArray, ok := data.(*object.Array)
+		if !ok {
+			return nil, fmt.Errorf("data argument must be an array")
+		}
+		return object.NestedTensor(dataArray)
+	}
 
 	shapeArray, ok := shape.(*object.Array)
 	if !ok {
@@ -297,6 +563,7 @@ func createTensor(shape object.Object, data object.Object) (object.Object, error
 		return nil, fmt.Errorf("data argument must be an array")
 	}
 
+	dataElements := make([]float64, 0, len(dataArray.Elements))
 	for _, element := range dataArray.Elements {
 		// Convert integer to type float
 		if element.Type() == object.INTEGER_OBJ {
@@ -310,6 +577,7 @@ func createTensor(shape object.Object, data object.Object) (object.Object, error
 		dataElements = append(dataElements, element.(*object.Float).Value)
 	}
 
+	shapeElements := make([]int64, 0, len(shapeArray.Elements))
 	for _, element := range shapeArray.Elements {
 		// tensor shape must be an array of integers
 		if element.Type() != object.INTEGER_OBJ {
@@ -318,8 +586,11 @@ func createTensor(shape object.Object, data object.Object) (object.Object, error
 		shapeElements = append(shapeElements, element.(*object.Integer).Value)
 	}
 
-	return &object.Tensor{Data: dataElements, Shape: shapeElements}, nil
-
+	tensor := &object.Tensor{Data: dataElements, Shape: shapeElements}
+	if err := tensor.Validate(); err != nil {
+		return nil, err
+	}
+	return tensor, nil
 }
 
 // pushClosure
@@ -334,8 +605,9 @@ func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	for i := 0; i < numFree; i++ {
 		free[i] = vm.stack[vm.sp-numFree+i]
 	}
+	vm.sp = vm.sp - numFree
 
-	closure := &object.Closure{Fn: function, Free: free}
+	closure := &object.Closure{Fn: function, Free: free, Program: vm.program}
 	return vm.push(closure)
 }
 
@@ -345,11 +617,54 @@ func (vm *VM) executeCall(numArgs int) error {
 	switch callee := callee.(type) {
 	case *object.Closure:
 		return vm.callClosure(callee, numArgs)
-	case *object.Builtin:
+	case *object.Builtin, *object.Extended:
 		return vm.callBuiltin(callee, numArgs)
 	default:
-		return fmt.Errorf("calling non-function and non-built-in")
+		// The frame points at the operand of the call
+		return vm.notCallable(callee, vm.currentFrame().ip-1)
+	}
+}
+
+// executeMethodCall calls the function in the field name of the hash under
+// the arguments, or else the builtin method name of the value there, with
+// the value as its first argument
+func (vm *VM) executeMethodCall(name string, numArgs int) error {
+	receiver := vm.stack[vm.sp-1-numArgs]
+	if field, ok := object.Field(receiver, name); ok {
+		if !object.IsCallable(field) {
+			// The frame points at the last operand of the call
+			return vm.notCallable(field, vm.currentFrame().ip-3)
+		}
+		vm.stack[vm.sp-1-numArgs] = field
+		return vm.executeCall(numArgs)
+	}
+
+	method, ok := object.LookupMethod(receiver, name)
+	if !ok {
+		line := vm.currentFrame().cl.Fn.Lines.LineFor(vm.currentFrame().ip - 3)
+		return fmt.Errorf("unknown method %s of %s at line %d", name, receiver.Type(), line)
+	}
+
+	args := vm.stack[vm.sp-1-numArgs : vm.sp]
+	vm.builtins.Context = vm.ctx
+	result := method.Call(&vm.builtins, args...)
+	vm.sp = vm.sp - numArgs - 1
+	if err := vm.raise(result); err != nil {
+		return err
+	}
+
+	return vm.push(canonical(result))
+}
+
+// notCallable is a helper function that returns the error of calling value,
+// which is not a function, naming the callee of the call at pos
+func (vm *VM) notCallable(value object.Object, pos int) error {
+	frame := vm.currentFrame()
+	line := frame.cl.Fn.Lines.LineFor(pos)
+	if callee := frame.cl.Fn.Calls.CalleeAt(pos); callee != "" {
+		return fmt.Errorf("cannot call %s value `%s` at line %d", value.Type(), callee, line)
 	}
+	return fmt.Errorf("cannot call %s value at line %d", value.Type(), line)
 }
 
 // callClosure
@@ -363,23 +678,46 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
+	// Clear the locals past the arguments so nothing is left from earlier calls
+	for i := frame.basePointer + cl.Fn.NumParameters; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+
 	return nil
 }
 
-// callBuiltin
-func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+// callBuiltin calls a builtin, in the context of the VM, or an extended
+// function
+func (vm *VM) callBuiltin(callee object.Object, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
-	result := builtin.Fn(args...)
+	var result object.Object
+	switch callee := callee.(type) {
+	case *object.Builtin:
+		vm.builtins.Context = vm.ctx
+		result = callee.Call(&vm.builtins, args...)
+	case *object.Extended:
+		result = callee.Fn(args...)
+	}
 	vm.sp = vm.sp - numArgs - 1
-
-	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
+	if err := vm.raise(result); err != nil {
+		return err
 	}
 
-	return nil
+	return vm.push(canonical(result))
+}
+
+// canonical is a helper function that returns the result of a builtin with
+// booleans and nulls replaced by the values the VM compares them to
+func canonical(result object.Object) object.Object {
+	switch result := result.(type) {
+	case nil, *object.Null:
+		return Null
+	case *object.Boolean:
+		return nativeBoolToBooleanObject(result.Value)
+	default:
+		return result
+	}
 }
 
 // executeIndexExpression
@@ -387,6 +725,8 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(&object.Array{Elements: left.(*object.Tuple).Elements}, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
@@ -394,12 +734,16 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	}
 }
 
-// executeArrayIndex
+// executeArrayIndex pushes the element of array at index, counting from the
+// end when index is negative
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObject := array.(*object.Array)
 	idx := index.(*object.Integer).Value
 	max := int64(len(arrayObject.Elements) - 1)
 
+	if idx < 0 {
+		idx += max + 1
+	}
 	if idx < 0 || idx > max {
 		return vm.push(Null)
 	}
@@ -491,10 +835,10 @@ func (vm *VM) executeMinusOperator() error {
 	switch operand.Type() {
 	case object.INTEGER_OBJ:
 		value := operand.(*object.Integer).Value
-		return vm.push(&object.Integer{Value: -value})
+		return vm.push(vm.numbers.Integer(-value))
 	case object.FLOAT_OBJ:
 		value := operand.(*object.Float).Value
-		return vm.push(&object.Float{Value: -value})
+		return vm.push(vm.numbers.Float(-value))
 	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
@@ -512,6 +856,21 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if isNumber(leftType) && isNumber(rightType) {
+		return vm.executeFloatComparison(op, floatValue(left), floatValue(right))
+	}
+	if leftType == object.STRING_OBJ && rightType == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
+	ordering := op == code.OpGreaterThan || op == code.OpGreaterThanOrEqual
+	if leftType == object.TENSOR_OBJ && rightType == object.TENSOR_OBJ && !ordering {
+		equal := left.(*object.Tensor).Equal(right.(*object.Tensor))
+		return vm.push(nativeBoolToBooleanObject(equal == (op == code.OpEqual)))
+	}
+	if ordering && (leftType == object.TENSOR_OBJ && (rightType == object.TENSOR_OBJ || isNumber(rightType)) ||
+		isNumber(leftType) && rightType == object.TENSOR_OBJ) {
+		return vm.executeTensorComparison(op, left, right)
+	}
 
 	switch op {
 	case code.OpEqual:
@@ -523,6 +882,21 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	}
 }
 
+// executeTensorComparison compares the elements of two tensors, or a tensor
+// and a number, and pushes the mask of those of left greater than right, or
+// greater than or equal to it
+func (vm *VM) executeTensorComparison(op code.Opcode, left, right object.Object) error {
+	fn := func(x, y float64) bool { return x > y }
+	if op == code.OpGreaterThanOrEqual {
+		fn = func(x, y float64) bool { return x >= y }
+	}
+	result, err := object.Compare(asTensor(left), asTensor(right), fn)
+	if err != nil {
+		return err
+	}
+	return vm.push(result)
+}
+
 // executeIntegerComparison
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
 	leftVal := left.(*object.Integer).Value
@@ -535,11 +909,59 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBoolToBooleanObject(leftVal != rightVal))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 }
 
+// executeStringComparison compares two strings by value, ordered byte by
+// byte
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal == rightVal))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal != rightVal))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
+	default:
+		return fmt.Errorf("unknown string operator: %d", op)
+	}
+}
+
+// executeFloatComparison compares two numbers, either of which is a float,
+// by value
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right float64) error {
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(left == right))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(left != right))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(left > right))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(left >= right))
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+}
+
+// floatValue is a helper function that returns the value of a number as a
+// float
+func floatValue(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return float64(integer.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
 // nativeBoolToBooleanObject
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
@@ -561,7 +983,11 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 		return vm.executeBinaryIntegerOperation(op, left, right)
 	case leftType == object.FLOAT_OBJ && rightType == object.FLOAT_OBJ:
 		return vm.executeBinaryFloatOperation(op, left, right)
-	case leftType == object.TENSOR_OBJ && rightType == object.TENSOR_OBJ:
+	// An integer and a float floor divide and raise to a power as floats
+	case isNumber(leftType) && isNumber(rightType) && (op == code.OpFloorDiv || op == code.OpPow):
+		return vm.executeBinaryFloatOperation(op, vm.numbers.Float(floatValue(left)), vm.numbers.Float(floatValue(right)))
+	case leftType == object.TENSOR_OBJ && (rightType == object.TENSOR_OBJ || isNumber(rightType)),
+		isNumber(leftType) && rightType == object.TENSOR_OBJ:
 		return vm.executeBinaryTensorOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
@@ -570,6 +996,55 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	}
 }
 
+// executeConcat adds the n operands on top of the stack from left to right,
+// like a chain of OpAdd. Strings are concatenated in one builder, sized
+// beforehand, rather than into a new string for every addition.
+func (vm *VM) executeConcat(n int) error {
+	operands := make([]object.Object, n)
+	copy(operands, vm.stack[vm.sp-n:vm.sp])
+	vm.sp -= n
+
+	result := operands[0]
+	for i := 1; i < len(operands); {
+		// Concatenate the run of strings starting at result
+		if str, ok := result.(*object.String); ok {
+			end, size := i, len(str.Value)
+			for ; end < len(operands); end++ {
+				next, ok := operands[end].(*object.String)
+				if !ok {
+					break
+				}
+				size += len(next.Value)
+			}
+			if end > i {
+				var builder strings.Builder
+				builder.Grow(size)
+				builder.WriteString(str.Value)
+				for _, operand := range operands[i:end] {
+					builder.WriteString(operand.(*object.String).Value)
+				}
+				result, i = &object.String{Value: builder.String()}, end
+				continue
+			}
+		}
+
+		vm.push(result)
+		vm.push(operands[i])
+		if err := vm.executeBinaryOperation(code.OpAdd); err != nil {
+			return err
+		}
+		result = vm.pop()
+		i++
+	}
+	return vm.push(result)
+}
+
+// isNumber is a helper function that reports whether objects of type t are
+// numbers
+func isNumber(t object.ObjectType) bool {
+	return t == object.INTEGER_OBJ || t == object.FLOAT_OBJ
+}
+
 // shapesEqual is a helper function to quickly compare shapes
 func shapesEqual(shape1, shape2 []int64) bool {
 	if len(shape1) != len(shape2) {
@@ -596,39 +1071,38 @@ func dataEqual(data1, data2 []float64) bool {
 	return true
 }
 
-// executeBinaryTensorOperation
+// executeBinaryTensorOperation applies an arithmetic operator element-wise
+// to two tensors, or a tensor and a number, broadcasting them to a common
+// shape
 func (vm *VM) executeBinaryTensorOperation(op code.Opcode, left, right object.Object) error {
-	leftShape := left.(*object.Tensor).Shape
-	rightShape := right.(*object.Tensor).Shape
-
-	var resultData []float64
+	operator, ok := tensorOperators[op]
+	if !ok {
+		return fmt.Errorf("unknown tensor operator: %d", op)
+	}
 
-	switch op {
-	case code.OpAdd:
-		if !shapesEqual(leftShape, rightShape) {
-			return fmt.Errorf("shapes are not equal %+v %+v", leftShape, rightShape)
-		}
-		for index := range left.(*object.Tensor).Data {
-			resultData = append(resultData, left.(*object.Tensor).Data[index]+right.(*object.Tensor).Data[index])
-		}
-	case code.OpSub:
-		for index := range left.(*object.Tensor).Data {
-			resultData = append(resultData, left.(*object.Tensor).Data[index]-right.(*object.Tensor).Data[index])
-		}
-	case code.OpMul:
-		for index := range left.(*object.Tensor).Data {
-			resultData = append(resultData, left.(*object.Tensor).Data[index]*right.(*object.Tensor).Data[index])
-		}
-	case code.OpDiv:
-		for index := range left.(*object.Tensor).Data {
-			resultData = append(resultData, left.(*object.Tensor).Data[index]/right.(*object.Tensor).Data[index])
-		}
-	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+	result, err := object.Arithmetic(operator, asTensor(left), asTensor(right))
+	if err != nil {
+		return err
 	}
+	return vm.push(result)
+}
 
-	return vm.push(&object.Tensor{Shape: left.(*object.Tensor).Shape, Data: resultData})
+// tensorOperators maps the arithmetic opcodes to the operators they apply to
+// tensors
+var tensorOperators = map[code.Opcode]string{
+	code.OpAdd: "+",
+	code.OpSub: "-",
+	code.OpMul: "*",
+	code.OpDiv: "/",
+}
 
+// asTensor is a helper function that returns a tensor operand, turning
+// numbers into tensors of no dimension
+func asTensor(obj object.Object) *object.Tensor {
+	if scalar, ok := object.ScalarTensor(obj); ok {
+		return scalar
+	}
+	return obj.(*object.Tensor)
 }
 
 // executeBinaryStringOperation
@@ -658,12 +1132,26 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpMul:
 		result = leftVal * rightVal
 	case code.OpDiv:
+		if rightVal == 0 {
+			return fmt.Errorf("division by zero: %d / 0", leftVal)
+		}
 		result = leftVal / rightVal
+	case code.OpFloorDiv:
+		if rightVal == 0 {
+			return fmt.Errorf("division by zero: %d // 0", leftVal)
+		}
+		result = object.FloorDivide(leftVal, rightVal)
+	case code.OpPow:
+		// A negative exponent gives a fraction
+		if rightVal < 0 {
+			return vm.push(vm.numbers.Float(math.Pow(float64(leftVal), float64(rightVal))))
+		}
+		result = object.Power(leftVal, rightVal)
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(vm.numbers.Integer(result))
 }
 
 // executeBinaryFloatOperation
@@ -682,11 +1170,15 @@ func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Obj
 		result = leftVal * rightVal
 	case code.OpDiv:
 		result = leftVal / rightVal
+	case code.OpFloorDiv:
+		result = math.Floor(leftVal / rightVal)
+	case code.OpPow:
+		result = math.Pow(leftVal, rightVal)
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 
-	return vm.push(&object.Float{Value: result})
+	return vm.push(vm.numbers.Float(result))
 }
 
 // push
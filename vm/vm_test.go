@@ -5,11 +5,16 @@ package vm
 import (
 	"fmt"
 	"monkey/ast"
+	"monkey/code"
 	"monkey/compiler"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -49,6 +54,101 @@ func TestTensorMath(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTensorEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`@[1.0, 2.0] == @[2], [1, 2]`, true},
+		{`@[1.0, 2.0] == @[1.0, 3.0]`, false},
+		{`@[1.0, 2.0] != @[[1.0, 2.0]]`, true},
+		{`let a = @[1.0]; a == a`, true},
+		{`allclose(@[1.0, 2.0], @[1.0, 2.0] + 0.000001, 0.001) == true`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTensorGradients(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `let w = requires_grad(@[1.0, 2.0]); backward(sum(w * w)); grad(w)`,
+			expected: object.Tensor{Shape: []int64{2}, Data: []float64{2, 4}},
+		},
+		{
+			input:    `let w = requires_grad(@[[1.0, 2.0], [3.0, 4.0]]); backward(mean(matmul(@[[1.0, 1.0]], w))); grad(w)`,
+			expected: object.Tensor{Shape: []int64{2, 2}, Data: []float64{0.5, 0.5, 0.5, 0.5}},
+		},
+		{
+			input:    `let w = requires_grad(@[1.0, -2.0]); backward(sum(w * w)); sgd_step([w], [grad(w)], 0.25)[0]`,
+			expected: object.Tensor{Shape: []int64{2}, Data: []float64{0.5, -1}},
+		},
+		{
+			input:    `adam_step({}, [@[1.0, -2.0]], [@[2.0, -4.0]], {"lr": 0.1, "eps": 0})["params"][0]`,
+			expected: object.Tensor{Shape: []int64{2}, Data: []float64{0.9, -1.9}},
+		},
+		{
+			input:    `backward(sum(@[1.0]))`,
+			expected: &object.Error{Message: "loss was not computed from a tensor requiring gradients"},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTensorBroadcasting(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `let x = @[2,1],[1.0,2.0]; let y = @[2,2],[1.0,2.0,3.0,4.0]; x + y;`,
+			expected: object.Tensor{Shape: []int64{2, 2}, Data: []float64{2.0, 3.0, 5.0, 6.0}},
+		},
+		{
+			input:    `let x = @[2],[1.0,2.0]; 2 * x - 0.5;`,
+			expected: object.Tensor{Shape: []int64{2}, Data: []float64{1.5, 3.5}},
+		},
+		{
+			input:    `let x = @[[1.0, 2.0], [3.0, 4.0]]; x + @[1, 2];`,
+			expected: object.Tensor{Shape: []int64{2, 2}, Data: []float64{2.0, 4.0, 4.0, 6.0}},
+		},
+		{
+			input:    `let x = @[0.2, 0.7, 0.9]; where(x > 0.5, x, 0) + where(0.5 > x, -1, 0);`,
+			expected: object.Tensor{Shape: []int64{3}, Data: []float64{-1.0, 0.7, 0.9}},
+		},
+		{
+			input:    `let x = @[0.2, 0.7, 0.9]; where(x >= 0.7, 1, 0) + where(x <= 0.7, 2, 0);`,
+			expected: object.Tensor{Shape: []int64{3}, Data: []float64{2.0, 3.0, 1.0}},
+		},
+		{
+			input:    `let x = @[[1.0, 2.0], [3.0, 4.0]]; let y = x; add_(x, @[10, 20]); y`,
+			expected: object.Tensor{Shape: []int64{2, 2}, Data: []float64{11.0, 22.0, 13.0, 24.0}},
+		},
+	}
+
+	runVmTests(t, tests)
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`let x = @[2],[1.0,2.0]; let y = @[3],[1.0,2.0,3.0]; x + y;`, "shapes [2] and [3] cannot be broadcast together"},
+		{`let x = @[2],[1.0,2.0]; let y = @[3],[1.0,2.0,3.0]; x - y;`, "shapes [2] and [3] cannot be broadcast together"},
+		{`let x = @[2,2],[1.0,2.0,3.0,4.0]; let y = @[3],[1.0,2.0,3.0]; x * y;`, "shapes [2 2] and [3] cannot be broadcast together"},
+		{`let x = @[3],[1.0,2.0,3.0]; let y = @[2],[1.0,2.0]; x / y;`, "shapes [3] and [2] cannot be broadcast together"},
+		{`@[2,2],[1.0,2.0,3.0];`, "tensor of shape [2 2] holds 3 elements, want 4"},
+		{`@[[1.0, 2.0], [3.0]];`, "nested tensor data is not rectangular: got an array of length 1 where an array of length 2 is expected"},
+		{`@[[1.0, 2.0], 3.0];`, "nested tensor data is not rectangular: got FLOAT where an array of length 2 is expected"},
+		{`@[1.0, [2.0]];`, "nested tensor data is not rectangular: got an array of length 1 where a number is expected"},
+	}
+
+	for _, tt := range errors {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err := New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
 // TestTensorLiteral is a function to test the tensor literal bits
 func TestTensorLiteral(t *testing.T) {
 	tests := []vmTestCase{
@@ -74,6 +174,13 @@ func TestFloatLiteral(t *testing.T) {
 			`,
 			expected: 15.3,
 		},
+		{"7.5 // 2.0", 3.0},
+		{"-7.5 // 2.0", -4.0},
+		{"7 // 2.0", 3.0},
+		{"-7.0 // 2", -4.0},
+		{"2 ** -1", 0.5},
+		{"4.0 ** 0.5", 2.0},
+		{"1.5 ** 2", 2.25},
 	}
 
 	runVmTests(t, tests)
@@ -101,6 +208,109 @@ func TestImportLiteral(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// TestImportModules tests running programs linked with modules, compiled or
+// read from their cache
+func TestImportModules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, source string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("shared.mky", "let value = 1;")
+	write("left.mky", `import "./shared.mky"; let l = fn() { value };`)
+	write("right.mky", `import "./shared.mky"; let helper = fn(x) { x + 1 }; export let r = fn() { helper(value) };`)
+
+	input := `
+	let value = 5;
+	import "` + filepath.Join(dir, "left.mky") + `";
+	import "` + filepath.Join(dir, "right.mky") + `";
+	l() * 10 + r() + value;
+	`
+	runVmTests(t, []vmTestCase{{input, 13}, {input, 13}})
+
+	write("shared.mky", "let value = 2;")
+	runVmTests(t, []vmTestCase{{input, 25}})
+}
+
+// TestImportExportList tests that a module with an export list only exposes
+// the names listed
+func TestImportExportList(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib.mky")
+	source := "let helper = fn(x) { x * 2 };\nlet [one, two] = [1, 2];\nexport one, helper;"
+	if err := os.WriteFile(lib, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runVmTests(t, []vmTestCase{
+		{`import "` + lib + `"; helper(one);`, 2},
+		{`let two = 5; import "` + lib + `"; two + one;`, 6},
+	})
+}
+
+// TestImportCompiled tests importing a module from the bytecode it was
+// cached as, without its source
+func TestImportCompiled(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, source string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("shared.mky", "let base = 10;")
+	write("lib.mky", `import "./shared.mky"; let scale = fn(x) { x * base };`)
+
+	runVmTests(t, []vmTestCase{{`import "` + filepath.Join(dir, "lib.mky") + `"; scale(2);`, 20}})
+	if err := os.Remove(filepath.Join(dir, "lib.mky")); err != nil {
+		t.Fatal(err)
+	}
+	runVmTests(t, []vmTestCase{
+		{`import "` + filepath.Join(dir, "lib.mkyc") + `"; scale(3) + base;`, 40},
+		{`let f = fn() { import "` + filepath.Join(dir, "lib.mkyc") + `" as lib; lib.scale(4) }; f();`, 40},
+	})
+}
+
+// TestRunCompiled tests running a program from the bytecode file it was
+// compiled to, linked to the modules it imports
+func TestRunCompiled(t *testing.T) {
+	dir := t.TempDir()
+	lib, main := filepath.Join(dir, "lib.mky"), filepath.Join(dir, "main.mky")
+	if err := os.WriteFile(lib, []byte("let scale = fn(x) { x * 10 };"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(main, []byte(`import "./lib.mky"; let [a, b] = [1, 2]; scale(a + b);`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "main.mkyc")
+	if err := compiler.WriteModule(main, output, nil); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if err := os.Remove(main); err != nil {
+		t.Fatal(err)
+	}
+
+	bytecode, err := compiler.LoadProgram(output, nil)
+	if err != nil {
+		t.Fatalf("loading error: %s", err)
+	}
+	vm := New(bytecode)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 30, vm.LastPoppedStackElem())
+}
+
+func TestImportStd(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`import "std/math" as math; math.max(3, 7) + math.abs(-1);`, 8},
+		{`let f = fn(x) { import "std/math" as m; m.min(x, 4) }; f(9);`, 4},
+		{`import "std/math" as math; let max = 1; math.max(max, 2);`, 2},
+		{`import "std/arrays"; import "std/math"; sum(map(range(4), fn(x) { pow(x, 2) }));`, 14},
+		{`import "std/strings"; padLeft("7", 3, "0");`, "007"},
+	})
+}
+
 // TestRecursiveFunctions
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []vmTestCase{
@@ -250,11 +460,52 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([])`, Null},
 		{`push([],1)`, []int{1}},
 		{`push(1,1)`, &object.Error{Message: "argument to `push` must be ARRAY, got INTEGER"}},
+		{`args()`, []int{}},
 	}
 
 	runVmTests(t, tests)
 }
 
+// TestExtendedFunctions tests calling functions plugins registered with
+// object.RegisterFunction
+func TestExtendedFunctions(t *testing.T) {
+	object.RegisterFunction("triple", object.Extended{Fn: func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 3}
+	}})
+	object.RegisterFunction("nothing", object.Extended{Fn: func(args ...object.Object) object.Object { return nil }})
+
+	tests := []vmTestCase{
+		{`triple(2)`, 6},
+		{`let f = fn(x) { triple(x) + 1 }; f(3)`, 10},
+		{`let g = triple; g(4)`, 12},
+		{`nothing()`, Null},
+		{`let triple = fn(x) { x }; triple(5)`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallClosure(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(`let n = 10; let adder = fn(a) { fn(b) { n + a + b } }; adder(5);`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	add := machine.LastPoppedStackElem()
+	if err := testIntegerObject(18, object.Call(add, &object.Integer{Value: 3})); err != nil {
+		t.Errorf("wrong result of the callback: %s", err)
+	}
+
+	orphan := &object.Closure{Fn: &object.CompiledFunction{}}
+	if errObj, ok := object.Call(orphan).(*object.Error); !ok || errObj.Message != "closure cannot be called outside of its program" {
+		t.Errorf("wrong error for a closure without its program. got=%v", errObj)
+	}
+}
+
 // TestCallingFunctionWithErrors
 func TestCallingFunctionWithErrors(t *testing.T) {
 	tests := []vmTestCase{
@@ -468,14 +719,44 @@ func TestIndexExpressions(t *testing.T) {
 		{"[[1, 1, 1]][0][0]", 1},
 		{"[][0]", Null},
 		{"[1, 2, 3][99]", Null},
-		{"[1][-1]", Null},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1][-2]", Null},
 		{"{1: 1, 2: 2}[1]", 1},
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{`{"foo": 5}.foo`, 5},
+		{`{"foo": 5}.bar`, Null},
+		{`let config = {"db": {"port": 5}}; config.db.port`, 5},
+		{`let f = fn(r) { r.x * 2 }; f({"x": 3})`, 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3, 4][1:3]", []int{2, 3}},
+		{"[1, 2, 3, 4][:2]", []int{1, 2}},
+		{"[1, 2, 3, 4][2:]", []int{3, 4}},
+		{"[1, 2, 3][-5:99]", []int{1, 2, 3}},
+		{"[1, 2, 3][-2:]", []int{2, 3}},
+		{"[1, 2, 3][2:1]", []int{}},
+		{`"hello"[1:3]`, "el"},
+		{`let s = "hello"; let f = fn(n) { s[:n] }; f(2)`, "he"},
 	}
 
 	runVmTests(t, tests)
+
+	comp := compiler.New()
+	if err := comp.Compile(parse("5[1:2]")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	if err == nil || err.Error() != "slice operator not supported: INTEGER" {
+		t.Errorf("wrong error for slicing an integer. got=%v", err)
+	}
 }
 
 // TestHashLiterals is a function to test the hash literals
@@ -520,6 +801,9 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`let name = "key"; "mon" + name + "-" + name`, "monkey-key"},
+		{`"" + "mon" + "" + "key"`, "monkey"},
+		{`build(append(string_builder("mon"), "key", "banana"))`, "monkeybanana"},
 	}
 
 	runVmTests(t, tests)
@@ -565,6 +849,39 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if (false) { 5; })", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"2 == 2.5", false},
+		{"1 < 1.5", true},
+		{"2.5 > 3", false},
+		{"1.5 < 2.0", true},
+		{"2.5 >= 2.5", true},
+		{"-0.5 > -1.5", true},
+		{"3.0 != 3.0", false},
+		{"1 <= 1", true},
+		{"1 >= 2", false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"b" > "abc"`, true},
+		{`"a" <= "a"`, true},
+		{`"" >= "a"`, false},
+		{`"Z" < "a"`, true},
+		{`"ab" == "a" + "b"`, true},
+		{`"a" != "a"`, false},
+		{"2 >= 1", true},
+		{"1.5 <= 1", false},
+		{"1 >= 1.0", true},
+		{"[1, 2.0][0] == [1.0, 2][0]", true},
+		{"1 == true", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"1 > 2 || 2 > 1 && 3 > 2", true},
+		{"let f = fn() { 1 // 0 }; false && f()", false},
+		{"let f = fn() { 1 // 0 }; true || f()", true},
 	}
 
 	runVmTests(t, tests)
@@ -583,11 +900,158 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 }", Null},
 		{"if (false) { 10 }", Null},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (null) { 10 } else { 20 }", 20},
+		{"null", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { while (true) { return 3; } }; f()", 3},
+		{"let f = fn(n) { while (n > 0) { return n; }; 0 }; [f(2), f(0)]", []int{2, 0}},
+		{"let f = fn() { while (false) { 10 } }; f()", Null},
+		{"if (true) { while (false) { 10 } }", Null},
+		{"while (false) { 10 }; 20", 20},
+		{"let f = fn() { while (false) { } }; let v = f(); if (v == null) { 1 } else { 2 }", 1},
+		{"let f = fn() { while (false) { } }; len([f(), f()])", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = x + 1; x", 2},
+		{"let i = 0; while (i < 5) { i = i + 1; }; i", 5},
+		{"let total = 0; for (x in [1, 2, 3]) { total = total + x; }; total", 6},
+		{"let count = 0; let inc = fn() { count = count + 1; }; inc(); inc(); count", 2},
+		{"let f = fn(n) { let total = 0; while (n > 0) { total = total + n; n = n - 1; }; total }; f(4)", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTuples(t *testing.T) {
+	tests := []vmTestCase{
+		{"let divmod = fn(a, b) { return a // b, a - a // b * b; }; let q, r = divmod(7, 2); q * 10 + r", 31},
+		{"let f = fn() { return 1, 2, 3; }; let t = f(); t[0] + t[-1]", 4},
+		{"let f = fn() { return 1, 2; }; let g = fn() { let a, b = f(); b - a }; g()", 1},
+		{"let f = fn(x) { if (x) { return x, true; } return 0, false; }; let v, ok = f(5); let w, none = f(0); [v, w]", []int{5, 0}},
+		{"let f = fn() { return 1, 2; }; try { let a, b, c = f(); 0 } catch (e) { e }", "cannot unpack 2 values into 3 names"},
+		{"try { let a, b = [1, 2]; 0 } catch (e) { e }", "cannot unpack ARRAY into 2 names"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { -true } catch (e) { e }", "unsupported type for negation: BOOLEAN"},
+		{"let x = try { len(1) } catch (e) { 0 }; x + 1", 1},
+		{`try { "a".len(1) } catch (e) { e }`, "wrong number of arguments. got=2, want=1"},
+		{"let f = fn() { -true }; let g = fn() { f() }; try { g() } catch (e) { 7 }", 7},
+		{"let f = fn() { try { return 5; } catch (e) { 0 }; 10 }; f(); try { -true } catch (e) { 1 }", 1},
+		{"let f = fn(x) { try { -x } catch (e) { 0 } }; [f(1), f(true), f(2)]", []int{-1, 0, -2}},
+		{"try { try { -true } catch (e) { -e } } catch (e) { 3 }", 3},
+		{"let total = 0; for (x in [1, true, 3]) { total = total + try { x * 2 } catch (e) { 100 } }; total", 108},
+		{"len(try { [1, 2].map(fn(x) { -true }) } catch (e) { e })", 38},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDestructureStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b] = [1]; b", Null},
+		{`let {x, y} = {"x": 3, "y": 4}; x * y`, 12},
+		{`let {z} = {"x": 3}; z`, Null},
+		{"let f = fn(pair) { let [a, b] = pair; a - b }; f([5, 2])", 3},
+		{"let a = 1; let b = 2; let [a, b] = [b, a]; a * 10 + b", 21},
+		{`let f = fn(p) { let {x} = p; let g = fn() { x }; g() }; f({"x": 7})`, 7},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x; } }; 0 }; [f([1, 2, 3]), f([1])]", []int{2, 0}},
+		{"let f = fn(xs) { let last = 0; for (x in xs) { let last = x; }; last }; f([4, 5])", 5},
+		{`let f = fn(h) { for (k in h) { return k; } }; f({"a": 1})`, "a"},
+		{`let n = 0; for (k in {3: "c", 1: "a", 4: "d", 2: "b"}) { n = n * 10 + k; }; n`, 1234},
+		{"for (x in [1, 2]) { x }; x", 2},
+		{"let f = fn() { for (x in []) { x } }; f()", Null},
+		{"let f = fn() { for (x in []) { x } }; let v = f(); if (v == null) { 1 } else { 2 }", 1},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestClosuresInLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { let total = 0; for (i in [1, 2, 3]) { let g = fn() { i }; total = total + g(); }; total }; f()", 6},
+		{"let f = fn() { let total = 0; let i = 0; let n = 2; while (i < 3) { let g = fn(x) { x * n }; total = total + g(i); i = i + 1; }; total }; f()", 6},
+		{"let f = fn(xs) { for (x in xs) { let g = fn() { x * 2 }; if (g() > 2) { return g(); } }; 0 }; f([1, 2, 3])", 4},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3].len()", 3},
+		{`"abc".upper()`, "ABC"},
+		{`"ABC".lower().len()`, 3},
+		{`{"a": 1}.keys().first()`, "a"},
+		{`{"a": 1, "b": 2}.values().len()`, 2},
+		{`let r = {"double": fn(x) { x * 2 }}; r.double(4)`, 8},
+		{`let r = {"keys": fn() { 7 }}; r.keys()`, 7},
+		{"[3, 1, 2].sort().first()", 1},
+		{"[1, 2].map(fn(x) { x * 10 }).last()", 20},
+		{`"a".len(1)`, &object.Error{Message: "wrong number of arguments. got=2, want=1"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMethodCallErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 5; x.len()", "unknown method len of INTEGER at line 1"},
+		{`let h = {"a": 1};` + "\nh.a()", "cannot call INTEGER value `h.a` at line 2"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestIterateNonIterable(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("for (x in 5) { x }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err := New(comp.Bytecode()).Run()
+	if expected := "cannot iterate over INTEGER"; err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"1", 1},
@@ -604,11 +1068,238 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 // 2", 3},
+		{"-7 // 2", -4},
+		{"7 // -2", -4},
+		{"-7 // -2", 3},
+		{"-7 / 2", -3},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"let f = fn(x) { x ** 2 + 1 }; f(3)", 10},
 	}
 
 	runVmTests(t, tests)
 }
 
+// TestInstructionHook checks the hook sees every executed instruction
+func TestInstructionHook(t *testing.T) {
+	program := parse("let f = fn(x) { x }; f(1);")
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ops := []code.Opcode{}
+	maxDepth := 0
+
+	vm := New(comp.Bytecode())
+	vm.SetHooks(&Hooks{OnInstruction: func(ev InstructionEvent) {
+		ops = append(ops, ev.Op)
+		if ev.FrameDepth > maxDepth {
+			maxDepth = ev.FrameDepth
+		}
+	}})
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := []code.Opcode{
+		code.OpClosure, code.OpSetGlobal,
+		code.OpGetGlobal, code.OpConstant, code.OpCall,
+		code.OpGetLocal, code.OpReturnValue,
+		code.OpPop,
+	}
+	if len(ops) != len(expected) {
+		t.Fatalf("wrong number of instructions. want=%d, got=%d", len(expected), len(ops))
+	}
+	for i, op := range expected {
+		if ops[i] != op {
+			t.Errorf("wrong opcode at %d. want=%d, got=%d", i, op, ops[i])
+		}
+	}
+	if maxDepth != 2 {
+		t.Errorf("wrong max frame depth. want=2, got=%d", maxDepth)
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	program := parse(`let f = fn(n) { if (n < 1) { 0 } else { f(n - 1) } };
+let g = fn() { f(2) };
+g();`)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// Every instruction takes a millisecond
+	clock := time.Time{}
+	profiler := NewProfiler()
+	profiler.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+
+	instructions := 0
+	hooks := profiler.Hooks()
+	vm := New(comp.Bytecode())
+	vm.SetHooks(&Hooks{OnInstruction: func(ev InstructionEvent) {
+		instructions++
+		hooks.OnInstruction(ev)
+	}})
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	profiles := map[string]FunctionProfile{}
+	counted := 0
+	for _, profile := range profiler.Profiles() {
+		profiles[profile.Name] = profile
+		counted += profile.Instructions
+	}
+	if counted != instructions {
+		t.Errorf("wrong number of instructions. want=%d, got=%d", instructions, counted)
+	}
+
+	calls := map[string]int{"<main>": 1, "g": 1, "f": 3}
+	for name, want := range calls {
+		if got := profiles[name].Calls; got != want {
+			t.Errorf("wrong number of calls of %s. want=%d, got=%d", name, want, got)
+		}
+	}
+
+	if profiler.Profiles()[0].Name != "f" {
+		t.Errorf("wrong hottest function. want=f, got=%s", profiler.Profiles()[0].Name)
+	}
+	if f := profiles["f"]; f.Total != f.Self {
+		t.Errorf("recursive calls counted twice. self=%s, total=%s", f.Self, f.Total)
+	}
+	if g := profiles["g"]; g.Total != g.Self+profiles["f"].Total {
+		t.Errorf("wrong total for g. want=%s, got=%s", g.Self+profiles["f"].Total, g.Total)
+	}
+	if main := profiles["<main>"]; main.Total != time.Duration(instructions-1)*time.Millisecond {
+		t.Errorf("wrong total for <main>. want=%dms, got=%s", instructions-1, main.Total)
+	}
+}
+
+func TestRuntimeErrorStack(t *testing.T) {
+	program := parse(`let inner = fn(x) {
+  x + true;
+};
+let outer = fn(y) {
+  inner(y);
+};
+outer(1);`)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err = New(comp.Bytecode()).Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+	}
+
+	if rtErr.Line != 2 {
+		t.Errorf("wrong line. want=2, got=%d", rtErr.Line)
+	}
+
+	expected := []string{"at inner (line 5)", "at outer (line 7)"}
+	if len(rtErr.Stack) != len(expected) {
+		t.Fatalf("wrong stack. want=%q, got=%q", expected, rtErr.Stack)
+	}
+	for i, frame := range expected {
+		if rtErr.Stack[i] != frame {
+			t.Errorf("wrong stack frame %d. want=%q, got=%q", i, frame, rtErr.Stack[i])
+		}
+	}
+}
+
+func TestCallingNonFunction(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 5;\nx(1);", "cannot call INTEGER value `x` at line 2"},
+		{"let f = fn() {\n  let s = \"f\";\n  s()\n};\nf();", "cannot call STRING value `s` at line 3"},
+		{`let f = fn() { "f" }; f()();`, "cannot call STRING value `f()` at line 1"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestIncrementalCompilation(t *testing.T) {
+	versions := []struct {
+		source   string
+		expected int64
+	}{
+		{"let base = 10;\nlet add = fn(x) { x + base };\nlet twice = fn(x) { add(x) * 2 };\ntwice(1);", 22},
+		{"let base = 10;\nlet add = fn(x) { x + base };\nlet twice = fn(x) { add(add(x)) };\ntwice(1);", 21},
+		{"let base = 20;\nlet add = fn(x) { x + base };\nlet twice = fn(x) { add(add(x)) };\ntwice(1);", 41},
+		{"let step = 1;\nlet base = 20;\nlet add = fn(x) { x + base };\nlet twice = fn(x) { add(add(x)) };\ntwice(step);", 41},
+	}
+
+	inc := compiler.NewIncremental()
+	for _, v := range versions {
+		bytecode, err := inc.Compile(parse(v.source), v.source)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(bytecode)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if err := testIntegerObject(v.expected, vm.LastPoppedStackElem()); err != nil {
+			t.Errorf("wrong result of %q: %s", v.source, err)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 // 0", "division by zero: 1 // 0"},
+		{"let f = fn(x) { 10 / x }; f(0);", "division by zero: 10 / 0"},
+		{`astype(@[1.0, 2.0], "int64") / 0`, "division by zero: int64 tensor / 0"},
+		{`astype(@[1.0, 2.0], "int64") / astype(@[1.0, 0.0], "int64")`, "division by zero: int64 tensor / 0"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err := New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
@@ -623,6 +1314,13 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 
 		vm := New(comp.Bytecode())
 		err = vm.Run()
+		// Errors of builtins are raised as runtime errors
+		if expected, ok := tt.expected.(*object.Error); ok {
+			if err == nil || err.Error() != expected.Message {
+				t.Errorf("wrong error of %q. want=%q, got=%v", tt.input, expected.Message, err)
+			}
+			continue
+		}
 		if err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
@@ -785,3 +1483,64 @@ func testIntegerObject(expected int64, actual object.Object) error {
 
 	return nil
 }
+
+// TestParallelMap tests mapping closures over arrays with pmap
+func TestParallelMap(t *testing.T) {
+	tests := []vmTestCase{
+		{`pmap([1, 2, 3], fn(x) { x * 2 }, 2)`, []int{2, 4, 6}},
+		{`let k = 10; pmap([1, 2, 3, 4, 5], fn(x) { let y = x + k; y * y })`, []int{121, 144, 169, 196, 225}},
+		{`pmap([], fn(x) { x }, 4)`, []int{}},
+		{`pmap([[1], 2, 3], fn(x) { first(x) }, 3)`, &object.Error{Message: "argument to `first` must be ARRAY, got INTEGER"}},
+		{`pmap([1], fn(x) { x }, 0)`, &object.Error{Message: "number of workers given to `pmap` must be positive, got 0"}},
+		{`pmap([1], 1)`, &object.Error{Message: "second argument to `pmap` must be a function, got INTEGER"}},
+		{`let total = 0; pmap([1, 2, 3], fn(x) { total = total + x; }, 3)`, &object.Error{Message: "cannot assign to global variables inside pmap"}},
+		{`pmap([1, 2], fn(x) { let y = x; y = y * 10; eval("let z = 1; z = z + 1; z") + y })`, []int{12, 22}},
+	}
+
+	runVmTests(t, tests)
+
+	// exit() in a call unwinds the caller of pmap
+	comp := compiler.New()
+	if err := comp.Compile(parse(`pmap([1, 2, 3, 4], fn(x) { exit(3) }, 2)`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	defer func() {
+		if exit, ok := recover().(*object.ExitRequest); !ok || exit.Code != 3 {
+			t.Errorf("exit() in pmap did not unwind the caller. got=%v", exit)
+		}
+	}()
+	New(comp.Bytecode()).Run()
+}
+
+func TestContextBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`let k = 10; map([1, 2, 3], fn(x) { x + k })`, []int{11, 12, 13}},
+		{`map([], 1)`, &object.Error{Message: "second argument to `map` must be a function, got INTEGER"}},
+		{`map([[1], 2], first)`, &object.Error{Message: "argument to `first` must be ARRAY, got INTEGER"}},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort([1, 3, 2], fn(x, y) { x > y })`, []int{3, 2, 1}},
+		{`sort([1, "a"])`, &object.Error{Message: "cannot compare STRING and INTEGER in `sort` without a function"}},
+		{`sort([1, 2], fn(x, y) { x })`, &object.Error{Message: "function given to `sort` must return BOOLEAN, got INTEGER"}},
+		{`let x = 1; eval("let x = 20; x + 1") + x`, 22},
+		{`eval("map([1], fn(x) { x * 3 })")`, []int{3}},
+		{`eval("let")`, &object.Error{Message: "On line 1, expected next token to be IDENT, got EOF instead"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStackOverflowTrace(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let inner = fn(n) { 1 + inner(n + 1) }; inner(0);")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err := New(comp.Bytecode()).Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok || rtErr.Message != "stack overflow" {
+		t.Fatalf("expected a stack overflow. got=%v", err)
+	}
+	if len(rtErr.Stack) != 2 || rtErr.Stack[0] != "at inner (line 1)" || !strings.HasPrefix(rtErr.Stack[1], "... ") {
+		t.Errorf("repeated frames not collapsed. got=%q", rtErr.Stack)
+	}
+}
@@ -0,0 +1,76 @@
+// wasmext/guest/guest.go
+
+//go:build wasip1
+
+// Package guest implements the exports of WebAssembly extensions written in
+// Go, described in package wasmext. An extension registers its functions in
+// an init function and is built as a reactor:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o ext.wasm
+package guest
+
+import (
+	"monkey/exthost"
+	"monkey/object"
+	"unsafe"
+)
+
+var (
+	handler = exthost.NewHandler(nil)
+	buffers = map[uint32][]byte{} // buffers keeps the buffers handed to the interpreter alive
+)
+
+// Register sets the functions of the extension
+func Register(fns []object.Plugin) {
+	handler = exthost.NewHandler(fns)
+}
+
+// keep is a helper function that keeps a buffer until it is released and
+// returns its address
+func keep(buf []byte) uint32 {
+	if len(buf) == 0 {
+		buf = make([]byte, 1)
+	}
+	ptr := uint32(uintptr(unsafe.Pointer(&buf[0])))
+	buffers[ptr] = buf
+	return ptr
+}
+
+//go:wasmexport monkey_alloc
+func alloc(size uint32) uint32 {
+	return keep(make([]byte, size))
+}
+
+//go:wasmexport monkey_free
+func free(ptr uint32) {
+	delete(buffers, ptr)
+}
+
+//go:wasmimport monkey callback
+func hostCallback(ptr, size uint32) uint64
+
+// exchange is a helper function that passes a callback request to the
+// interpreter and returns its answer
+func exchange(req object.FunctionResponse) (object.FunctionCall, error) {
+	data, err := object.SerializeFunctionResponse(req)
+	if err != nil {
+		return object.FunctionCall{}, err
+	}
+	ptr := keep(data)
+	defer free(ptr)
+
+	result := hostCallback(ptr, uint32(len(data)))
+	answerPtr, answerSize := uint32(result>>32), uint32(result)
+	defer free(answerPtr)
+	return object.DeserializeFunctionCall(buffers[answerPtr][:answerSize])
+}
+
+//go:wasmexport monkey_call
+func call(ptr, size uint32) uint64 {
+	resp, err := handler.Handle(buffers[ptr][:size], exchange)
+	if err != nil {
+		message := err.Error()
+		resp, _ = object.SerializeFunctionResponse(object.FunctionResponse{Type: "result", Error: &message})
+	}
+	return uint64(keep(resp))<<32 | uint64(len(resp))
+}
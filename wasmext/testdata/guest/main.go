@@ -0,0 +1,30 @@
+//go:build wasip1
+
+package main
+
+import (
+	"monkey/object"
+	"monkey/wasmext/guest"
+)
+
+func init() {
+	guest.Register([]object.Plugin{
+		{Name: "double", Params: []object.Param{{Name: "n", Type: object.INTEGER_OBJ}}, Doc: "Doubles n.", Fn: func(args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+		}},
+		{Name: "wrap", Params: []object.Param{{Name: "values"}}, Variadic: true, Fn: func(args ...object.Object) object.Object {
+			return &object.Array{Elements: args}
+		}},
+		{Name: "apply", Params: []object.Param{{Name: "fn"}, {Name: "args"}}, Variadic: true, Fn: func(args ...object.Object) object.Object {
+			return object.Call(args[0], args[1:]...)
+		}},
+		{Name: "fail", Fn: func(args ...object.Object) object.Object {
+			return &object.Error{Message: "failed on purpose"}
+		}},
+		{Name: "trap", Fn: func(args ...object.Object) object.Object {
+			panic("trapped")
+		}},
+	})
+}
+
+func main() {}
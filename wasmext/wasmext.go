@@ -0,0 +1,230 @@
+// wasmext/wasmext.go
+
+// Package wasmext loads extensions compiled to WebAssembly. They run on any
+// platform without cgo and in a sandbox: they see no files, no environment
+// and no network, and only write to the standard output and error.
+//
+// A module exports its memory and the functions
+//
+//	monkey_alloc(size i32) i32          returns a buffer of size bytes
+//	monkey_call(ptr i32, size i32) i64  answers a message
+//	monkey_free(ptr i32)                releases a buffer, optional
+//
+// The interpreter writes a message of the protocol of package exthost, a
+// serialized FunctionCall, to a buffer from monkey_alloc and passes it to
+// monkey_call. The answer, a serialized FunctionResponse, is returned as its
+// address in the upper 32 bits of the result and its length in the lower 32
+// bits. Both buffers are then released with monkey_free. Values such as
+// strings, numbers and arrays are passed as msgpack values like they are to
+// extensions run as processes. Reactor modules are initialized by calling
+// their _initialize function; package guest implements the exports for
+// modules written in Go.
+//
+// While it answers a call, a module calls back the functions passed to it
+// through the function it imports as
+//
+//	monkey.callback(ptr i32, size i32) i64
+//
+// passing a callback request, a serialized FunctionResponse, and receiving
+// the answer, a serialized FunctionCall in a buffer from monkey_alloc that
+// it releases, the way monkey_call returns its answers.
+package wasmext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"monkey/exthost"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// memoryLimitPages bounds the memory of a module, 64KiB pages making 256MiB
+const memoryLimitPages = 4096
+
+// Module is a loaded WebAssembly extension
+type Module struct {
+	name    string
+	runtime wazero.Runtime
+	mod     api.Module
+
+	alloc, call, free api.Function
+
+	mu       sync.Mutex       // mu serializes the calls, modules are not reentrant
+	err      error            // err is set once the module can no longer be called
+	callback exthost.Callback // callback answers the callback requests of the call in progress
+
+	// callingBack is set while a function the module asked for is called
+	// back, the module waiting for its result cannot be called
+	callingBack atomic.Bool
+}
+
+// Load compiles and instantiates the module in the file path
+func Load(path string) (*Module, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithMemoryLimitPages(memoryLimitPages))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+
+	m := &Module{name: filepath.Base(path), runtime: r}
+	_, err = r.NewHostModuleBuilder("monkey").
+		NewFunctionBuilder().WithFunc(m.hostCallback).Export("callback").
+		Instantiate(ctx)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+
+	config := wazero.NewModuleConfig().
+		WithName(filepath.Base(path)).
+		WithStartFunctions("_initialize").
+		WithStdout(os.Stdout).
+		WithStderr(os.Stderr)
+	mod, err := r.InstantiateWithConfig(ctx, code, config)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+
+	m.mod = mod
+	m.alloc = mod.ExportedFunction("monkey_alloc")
+	m.call = mod.ExportedFunction("monkey_call")
+	m.free = mod.ExportedFunction("monkey_free")
+	if m.alloc == nil || m.call == nil || mod.Memory() == nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("%s does not export memory, monkey_alloc and monkey_call", m.name)
+	}
+	return m, nil
+}
+
+// Functions asks the module for its functions and returns them as plugin
+// functions, which call the module
+func (m *Module) Functions() ([]object.Plugin, error) {
+	return exthost.Functions(m.name, m)
+}
+
+// RoundTrip passes a message to the module and returns its answer, its
+// callback requests being answered by callback. A module that traps fails
+// every later call. The module cannot be called while it calls back a
+// function.
+func (m *Module) RoundTrip(call object.FunctionCall, callback exthost.Callback) (object.FunctionResponse, error) {
+	if m.callingBack.Load() {
+		return object.FunctionResponse{}, fmt.Errorf("extension %s is waiting for a callback", m.name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return object.FunctionResponse{}, m.err
+	}
+	m.callback = callback
+	defer func() { m.callback = nil }()
+
+	data, err := object.SerializeFunctionCall(call)
+	if err != nil {
+		return object.FunctionResponse{}, err
+	}
+	data, err = m.exchange(data)
+	if err != nil {
+		m.err = fmt.Errorf("extension %s stopped: %s", m.name, err)
+		return object.FunctionResponse{}, m.err
+	}
+	return object.DeserializeFunctionResponse(data)
+}
+
+// exchange is a helper function that passes a message to monkey_call and
+// returns the answer
+func (m *Module) exchange(data []byte) ([]byte, error) {
+	ctx := context.Background()
+	memory := m.mod.Memory()
+
+	results, err := m.alloc.Call(ctx, api.EncodeU32(uint32(len(data))))
+	if err != nil {
+		return nil, err
+	}
+	ptr := api.DecodeU32(results[0])
+	defer m.release(ptr)
+	if !memory.Write(ptr, data) {
+		return nil, errors.New("monkey_alloc returned a buffer out of memory")
+	}
+
+	results, err = m.call.Call(ctx, api.EncodeU32(ptr), api.EncodeU32(uint32(len(data))))
+	if err != nil {
+		return nil, err
+	}
+	respPtr, respSize := uint32(results[0]>>32), uint32(results[0])
+	defer m.release(respPtr)
+
+	resp, ok := memory.Read(respPtr, respSize)
+	if !ok {
+		return nil, errors.New("monkey_call returned an answer out of memory")
+	}
+	// The memory is reused once the buffer is released
+	return append([]byte{}, resp...), nil
+}
+
+// hostCallback implements the callback function modules import. It answers
+// the callback request at ptr with a buffer of the module holding the
+// answer.
+func (m *Module) hostCallback(ctx context.Context, mod api.Module, ptr, size uint32) uint64 {
+	answer := func(call object.FunctionCall) uint64 {
+		data, err := object.SerializeFunctionCall(call)
+		if err != nil {
+			panic(err)
+		}
+		results, err := m.alloc.Call(ctx, api.EncodeU32(uint32(len(data))))
+		if err != nil {
+			panic(err)
+		}
+		answerPtr := api.DecodeU32(results[0])
+		if !mod.Memory().Write(answerPtr, data) {
+			panic("monkey_alloc returned a buffer out of memory")
+		}
+		return uint64(answerPtr)<<32 | uint64(len(data))
+	}
+
+	message := "unexpected callback request"
+	data, ok := mod.Memory().Read(ptr, size)
+	if !ok || m.callback == nil {
+		return answer(object.FunctionCall{Type: "result", Error: &message})
+	}
+	req, err := object.DeserializeFunctionResponse(append([]byte{}, data...))
+	if err != nil {
+		message = err.Error()
+		return answer(object.FunctionCall{Type: "result", Error: &message})
+	}
+	m.callingBack.Store(true)
+	defer m.callingBack.Store(false)
+	return answer(m.callback(req))
+}
+
+// release is a helper function that releases a buffer of the module, when
+// it exports monkey_free
+func (m *Module) release(ptr uint32) {
+	if m.free != nil {
+		_, _ = m.free.Call(context.Background(), api.EncodeU32(ptr))
+	}
+}
+
+// Close releases the module
+func (m *Module) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = fmt.Errorf("extension %s was closed", m.name)
+	return m.runtime.Close(context.Background())
+}
@@ -0,0 +1,89 @@
+package wasmext
+
+import (
+	"monkey/object"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// build is a helper function that compiles the guest of testdata to WebAssembly
+func build(t *testing.T) string {
+	t.Helper()
+
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	wasm := filepath.Join(t.TempDir(), "guest.wasm")
+	cmd := exec.Command(gocmd, "build", "-buildmode=c-shared", "-o", wasm, "./testdata/guest")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building the guest failed: %s\n%s", err, out)
+	}
+	return wasm
+}
+
+func TestModule(t *testing.T) {
+	m, err := Load(build(t))
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	defer m.Close()
+
+	fns, err := m.Functions()
+	if err != nil {
+		t.Fatalf("Functions failed: %s", err)
+	}
+	byName := map[string]object.Plugin{}
+	for _, fn := range fns {
+		byName[fn.Name] = fn
+	}
+	if len(byName) != 5 || byName["double"].Usage() != "double(n)" || byName["double"].Doc != "Doubles n." {
+		t.Fatalf("wrong functions. got=%+v", fns)
+	}
+
+	if result, ok := byName["double"].Fn(&object.Integer{Value: 21}).(*object.Integer); !ok || result.Value != 42 {
+		t.Errorf("wrong result of double. got=%v", result)
+	}
+
+	result := byName["wrap"].Fn(&object.String{Value: "a"}, &object.Float{Value: 0.5}, &object.Boolean{Value: true})
+	if result.Inspect() != "[a, 0.500000, true]" {
+		t.Errorf("wrong result of wrap. got=%s", result.Inspect())
+	}
+
+	if errObj, ok := byName["fail"].Fn().(*object.Error); !ok || errObj.Message != "failed on purpose" {
+		t.Errorf("wrong error. got=%v", errObj)
+	}
+
+	negate := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.Integer{Value: -args[0].(*object.Integer).Value}
+	}}
+	if result := byName["apply"].Fn(negate, &object.Integer{Value: 4}); result.Inspect() != "-4" {
+		t.Errorf("wrong result of the callback. got=%s", result.Inspect())
+	}
+
+	for i := 0; i < 2; i++ {
+		errObj, ok := byName["trap"].Fn().(*object.Error)
+		if !ok || !strings.Contains(errObj.Message, "stopped") {
+			t.Errorf("wrong error after a trap. got=%v", errObj)
+		}
+	}
+	if _, ok := byName["double"].Fn(&object.Integer{Value: 1}).(*object.Error); !ok {
+		t.Errorf("trapped module still called")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "empty.wasm")
+	// The smallest valid module, exporting nothing
+	if err := os.WriteFile(file, []byte("\x00asm\x01\x00\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil || !strings.Contains(err.Error(), "does not export") {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}